@@ -9,17 +9,23 @@ import (
 	"time"
 
 	"fiber-backend/internal/alerter"
+	"fiber-backend/internal/auth"
 	"fiber-backend/internal/collector"
 	"fiber-backend/internal/config"
 	"fiber-backend/internal/database"
 	"fiber-backend/internal/exporter"
+	"fiber-backend/internal/influxsink"
+	"fiber-backend/internal/kafka"
 	"fiber-backend/internal/middleware"
 	"fiber-backend/internal/modules/apikey"
 	"fiber-backend/internal/modules/approval"
 	"fiber-backend/internal/modules/audit"
 	"fiber-backend/internal/modules/influx"
 	"fiber-backend/internal/modules/machine_config"
+	"fiber-backend/internal/modules/plc"
+	"fiber-backend/internal/modules/runs"
 	"fiber-backend/internal/modules/user"
+	"fiber-backend/internal/modules/write_journal"
 	"fiber-backend/internal/plcengine"
 	"fiber-backend/internal/streamer"
 
@@ -85,8 +91,86 @@ func main() {
 	// Data channel for cross-component values (PLC -> Engine -> Collector -> Kafka/UI)
 	dataChan := make(chan plcengine.PLCValue, 10000)
 	engine := plcengine.NewEngine(dataChan)
+	engine.SourceNetID = cfg.PLCSourceNetID
+	if cfg.PLCRouteUser != "" {
+		engine.Route = &plcengine.RouteConfig{
+			Name:     cfg.PLCRouteName,
+			Username: cfg.PLCRouteUser,
+			Password: cfg.PLCRoutePassword,
+		}
+	}
+
+	// PLC_DRIVER=simulator runs the whole stack against simulated PLCs
+	var simulation *plcengine.Simulation
+	if cfg.PLCDriver == "simulator" {
+		simFile, err := plcengine.LoadSimulationFile(cfg.PLCSimFile)
+		if err != nil {
+			log.Fatalf("PLC simulator: %v", err)
+		}
+		simulation = plcengine.NewSimulation(simFile)
+		engine.ClientFactory = simulation.ClientFactory
+		log.Printf("PLC driver: simulator (file=%q)", cfg.PLCSimFile)
+	}
 
 	col := collector.NewCollector(engine, hub)
+	scanRates, err := collector.LoadScanRates(cfg.PLCConfigFile)
+	if err != nil {
+		log.Fatalf("PLC scan rates: %v", err)
+	}
+	col.ScanRates = scanRates
+	recipes, err := collector.LoadRecipeConfig(cfg.PLCDataConfigFile)
+	if err != nil {
+		log.Fatalf("PLC recipe fields: %v", err)
+	}
+	col.Recipes = recipes
+	runRepo := runs.PgRepo{DB: db}
+	runService := runs.NewService(runRepo)
+	col.Runs = runService
+	producer, err := kafka.NewProducer(kafka.Config{
+		Brokers:     cfg.KafkaBrokers,
+		Topic:       cfg.KafkaTopic,
+		Compression: cfg.KafkaCompression,
+	})
+	if err != nil {
+		log.Fatalf("Kafka producer: %v", err)
+	}
+
+	// PLC data waits on disk while Kafka is down
+	var sink kafka.Producer = producer
+	var spool *kafka.Spool
+	if cfg.KafkaSpoolDir != "" {
+		spool, err = kafka.OpenSpool(kafka.SpoolConfig{
+			Dir:      cfg.KafkaSpoolDir,
+			MaxBytes: int64(cfg.KafkaSpoolMaxMB) << 20,
+			Fsync:    kafka.FsyncPolicy(cfg.KafkaSpoolFsync),
+		})
+		if err != nil {
+			log.Fatalf("Kafka spool: %v", err)
+		}
+		spool.OnNearCap = func(stats kafka.SpoolStats) {
+			log.Printf("Kafka spool at %.0f%% of its cap: %d batches, oldest %s ago",
+				stats.UsedPercent(), stats.Batches, stats.Age.Round(time.Second))
+			hub.Broadcast(streamer.BroadcastMsg{
+				Type: streamer.MsgTypeSpool,
+				Data: map[string]interface{}{
+					"used_percent": stats.UsedPercent(),
+					"bytes":        stats.Bytes,
+					"max_bytes":    stats.MaxBytes,
+					"batches":      stats.Batches,
+					"age_ms":       stats.Age.Milliseconds(),
+				},
+				Timestamp: time.Now(),
+			})
+		}
+		sink = kafka.NewSpoolingProducer(producer, spool, 5*time.Second)
+	}
+	col.Producer = sink
+
+	// Machines with influx_enabled are also written to InfluxDB directly
+	influxSinkConfig := influxsink.Config{}
+	influxSinkClient, _, _ := database.ConnectInfluxWithOptions(influxURL, influxToken, influxUser, influxPass, influxSinkConfig.Options())
+	influxSink := influxsink.NewSink(influxSinkClient, influxOrg, influxBucket, influxSinkConfig)
+	col.Influx = influxSink
 
 	// --- Storage Monitoring Initialization ---
 	storageConfig := alerter.AlerterConfig{
@@ -122,40 +206,20 @@ func main() {
 	// Fetch initial configs from DB to bootstrap the collector
 	machineRepo := machine_config.PgRepo{DB: db}
 	dbMachines, err := machineRepo.GetMachines(context.Background())
-	if err == nil {
-		var collectorConfigs []collector.MachineConfig
-		for _, m := range dbMachines {
-			// Map DB model to Collector config
-			c := collector.MachineConfig{
-				ID:       m.ID,
-				Name:     m.Name,
-				IP:       m.IP,
-				AmsNetID: m.AmsNetID,
-				Port:     m.Port,
-			}
-			for _, ch := range m.Chambers {
-				cc := collector.ChamberConfig{
-					ID:   ch.ID,
-					Name: ch.Name,
-				}
-				for _, s := range ch.Symbols {
-					cc.Symbols = append(cc.Symbols, collector.SymbolConfig{
-						Name:     s.Name,
-						DataType: s.DataType,
-					})
-				}
-				c.Chambers = append(c.Chambers, cc)
-			}
-			collectorConfigs = append(collectorConfigs, c)
-		}
-
-		if len(collectorConfigs) > 0 {
-			if err := col.Start(collectorConfigs); err != nil {
-				log.Printf("Failed to start collector: %v", err)
-			} else {
-				log.Printf("Collector started with %d machines", len(collectorConfigs))
-			}
-		}
+	if err != nil {
+		log.Printf("Failed to load machine configuration: %v", err)
+	}
+	simulateMachines(simulation, dbMachines)
+	// Runs still open were cut short by the last shutdown
+	if err := runService.InterruptOpen(context.Background()); err != nil {
+		log.Printf("Failed to close open recipe runs: %v", err)
+	}
+	// The collector also starts without machines, approved configuration
+	// changes are applied to it at runtime
+	if err := col.Start(collectorConfigs(dbMachines)); err != nil {
+		log.Printf("Failed to start collector: %v", err)
+	} else {
+		log.Printf("Collector started with %d machines", len(dbMachines))
 	}
 
 	// ✅ create app ONLY ONCE — with error handler
@@ -220,8 +284,17 @@ func main() {
 
 	approvalRepo := approval.PgRepo{DB: db}
 	approvalSvc := approval.NewService(approvalRepo)
+	approvalSvc.OnApprove("machine_config", machine_config.ApplyApproved(machineRepo, func(machines []machine_config.Machine) error {
+		simulateMachines(simulation, machines)
+		return col.Sync(collectorConfigs(machines))
+	}))
 	approvalHandler := approval.Handler{Service: approvalSvc}
 
+	// Every PLC write attempt is journaled for compliance
+	writeJournalRepo := write_journal.PgRepo{DB: db}
+	writeJournal := write_journal.NewService(writeJournalRepo)
+	engine.SetWriteRecorder(writeJournal)
+
 	apiKeyRepo := apikey.PgRepo{DB: db}
 	apiKeyHandler := apikey.Handler{Repo: apiKeyRepo}
 
@@ -240,6 +313,30 @@ func main() {
 	// ✅ audit routes (protected)
 	audit.Routes(api.Group("/audit"), auditRepo)
 
+	// ✅ PLC write journal routes (protected)
+	write_journal.Routes(api.Group("/plc/writes"), writeJournalRepo, auth.NewAuthMiddleware(getEnv("JWT_SECRET", "")))
+
+	// ✅ recipe run history routes (protected)
+	runs.Routes(api.Group("/runs"), runs.Handler{Repo: runRepo, Influx: influxClient, Org: influxOrg, Bucket: influxBucket}, auth.NewAuthMiddleware(getEnv("JWT_SECRET", "")))
+
+	// ✅ PLC read/write REST and WebSocket routes (protected)
+	plc.Routes(api.Group("/plc"), engine, auth.NewAuthMiddleware(getEnv("JWT_SECRET", "")))
+
+	// @Summary Kafka spool depth
+	// @Description Batches waiting on disk for Kafka and the age of the oldest
+	// @Tags system
+	// @Security BearerAuth
+	// @Produce json
+	// @Success 200 {object} kafka.SpoolStats
+	// @Failure 404 {object} map[string]interface{}
+	// @Router /kafka/spool [get]
+	api.Get("/kafka/spool", func(c fiber.Ctx) error {
+		if spool == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "kafka spool disabled"})
+		}
+		return c.JSON(spool.Stats())
+	})
+
 	// ✅ api_key routes (protected)
 	apikey.Routes(api.Group("/keys"), &apiKeyHandler)
 
@@ -298,6 +395,98 @@ func main() {
 	exportSystem.Stop()
 	storageMon.Stop()
 	col.Stop()
+	// the engine executes no more writes once stopped, the journal then
+	// holds all of them
 	engine.Stop()
+	runService.Close()
+	if err := sink.Close(); err != nil {
+		log.Printf("Kafka producer: %v", err)
+	}
+	influxSink.Close()
+	influxSinkClient.Close()
+	writeJournal.Close()
 	db.Close()
 }
+
+// collectorConfigs maps the stored machine configuration to collector configs
+func collectorConfigs(machines []machine_config.Machine) []collector.MachineConfig {
+	var configs []collector.MachineConfig
+	for _, m := range machines {
+		c := collector.MachineConfig{
+			ID:       m.ID,
+			Name:     m.Name,
+			IP:       m.IP,
+			AmsNetID: m.AmsNetID,
+			Port:     m.Port,
+			Gateway:  m.Gateway,
+			Protocol: m.Protocol,
+
+			InfluxEnabled: m.InfluxEnabled,
+		}
+		for _, ch := range m.Chambers {
+			cc := collector.ChamberConfig{
+				ID:         ch.ID,
+				Name:       ch.Name,
+				ScanRateMs: ch.ScanRateMs,
+				ScanClass:  ch.ScanClass,
+			}
+			for _, s := range ch.Symbols {
+				sc := collector.SymbolConfig{
+					Name:     s.Name,
+					DataType: s.DataType,
+					Unit:     s.Unit,
+					MinValue: s.MinValue,
+					MaxValue: s.MaxValue,
+					Writable: s.Writable,
+					WriteMin: s.WriteMin,
+					WriteMax: s.WriteMax,
+
+					Deadband:        s.Deadband,
+					DeadbandPercent: s.DeadbandPercent,
+					ChangeOnly:      s.ChangeOnly,
+					HeartbeatMs:     s.HeartbeatMs,
+					ScanRateMs:      s.ScanRateMs,
+					ScanClass:       s.ScanClass,
+				}
+				if r := s.Modbus; r != nil {
+					sc.Modbus = &plcengine.ModbusRegister{
+						Table:     plcengine.ModbusTable(r.Table),
+						Address:   r.Address,
+						Unit:      r.Unit,
+						Type:      r.Type,
+						Length:    r.Length,
+						WordOrder: plcengine.WordOrder(r.WordOrder),
+						Scale:     r.Scale,
+						Offset:    r.Offset,
+					}
+				}
+				cc.Symbols = append(cc.Symbols, sc)
+			}
+			c.Chambers = append(c.Chambers, cc)
+		}
+		configs = append(configs, c)
+	}
+	return configs
+}
+
+// simulateMachines gives every machine a simulated PLC with its chamber
+// symbols when the simulator driver is selected
+func simulateMachines(sim *plcengine.Simulation, machines []machine_config.Machine) {
+	if sim == nil {
+		return
+	}
+	for _, m := range machines {
+		var symbols []plcengine.SimSymbol
+		for _, ch := range m.Chambers {
+			for _, s := range ch.Symbols {
+				symbols = append(symbols, plcengine.SimSymbol{Name: s.Name, TypeName: s.DataType})
+			}
+		}
+		sim.SetMachine(plcengine.MachineConfig{
+			ID:       m.ID,
+			IP:       m.IP,
+			AmsNetID: m.AmsNetID,
+			Port:     m.Port,
+		}, symbols)
+	}
+}
@@ -63,7 +63,7 @@ func (m *AuthMiddleware) RequirePermission(resource, action string) fiber.Handle
 			return c.Status(500).JSON(fiber.Map{"error": "user context missing"})
 		}
 
-		if !m.hasPermission(user, resource, action) {
+		if !HasPermission(user, resource, action) {
 			return c.Status(403).JSON(fiber.Map{
 				"error": "insufficient permissions",
 				"required": fiber.Map{
@@ -77,7 +77,8 @@ func (m *AuthMiddleware) RequirePermission(resource, action string) fiber.Handle
 	}
 }
 
-func (m *AuthMiddleware) hasPermission(user *Claims, resource, action string) bool {
+// HasPermission reports whether the user may perform action on resource
+func HasPermission(user *Claims, resource, action string) bool {
 	// Admin has all permissions
 	for _, role := range user.Roles {
 		if role == "admin" {
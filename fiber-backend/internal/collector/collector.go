@@ -2,8 +2,11 @@ package collector
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"reflect"
+	"sort"
 	"sync"
 	"time"
 
@@ -20,18 +23,43 @@ type Collector struct {
 	wg       sync.WaitGroup
 	mu       sync.RWMutex
 	machines map[string]*MachineCollector
+
+	// ScanRates applies to machines started after it is set
+	ScanRates ScanRates
+	// Producer is shared by the Kafka workers, set it before Start. The
+	// collector does not close it.
+	Producer kafka.Producer
+	// Influx receives the values of machines with InfluxEnabled, nil
+	// disables it. Set it before Start.
+	Influx Sink
+	// Recipes applies to machines started after it is set, Runs records
+	// the recipe runs if not nil
+	Recipes RecipeConfig
+	Runs    RunRecorder
+}
+
+// Sink stores the published values of a chamber, tags are added to every
+// value. Write must not block the poller.
+type Sink interface {
+	Write(machineID, chamberID string, tags map[string]string, values []plcengine.PLCValue)
 }
 
 type MachineCollector struct {
-	config MachineConfig
-	stop   chan struct{}
+	config  MachineConfig
+	groups  []*scanGroup
+	recipes map[string]*RecipeTracker // by chamber ID
+	stop    chan struct{}
+	wg      sync.WaitGroup
 }
 
 func NewCollector(engine *plcengine.PLCReadWriteEngine, hub *streamer.StreamHub) *Collector {
 	return &Collector{
-		engine:   engine,
-		hub:      hub,
-		machines: make(map[string]*MachineCollector),
+		engine:    engine,
+		hub:       hub,
+		machines:  make(map[string]*MachineCollector),
+		ScanRates: DefaultScanRates(),
+		Producer:  kafka.NopProducer{},
+		Recipes:   RecipeConfig{Interval: 100 * time.Millisecond},
 	}
 }
 
@@ -46,12 +74,7 @@ func (c *Collector) Start(configs []MachineConfig) error {
 	// 0. Connect engine to PLCs
 	var engineConfigs []plcengine.MachineConfig
 	for _, cfg := range configs {
-		engineConfigs = append(engineConfigs, plcengine.MachineConfig{
-			ID:       cfg.ID,
-			IP:       cfg.IP,
-			AmsNetID: cfg.AmsNetID,
-			Port:     cfg.Port,
-		})
+		engineConfigs = append(engineConfigs, engineConfig(cfg))
 	}
 	if err := c.engine.Start(engineConfigs); err != nil {
 		return err
@@ -67,64 +90,416 @@ func (c *Collector) Start(configs []MachineConfig) error {
 	c.wg.Add(1)
 	go c.streamerWorker()
 
-	// 3. Start poller goroutine per chamber (using engine)
+	// 3. Start poller goroutines per chamber read group (using engine)
+	for _, cfg := range configs {
+		c.startMachineLocked(cfg, nil)
+	}
+
+	return nil
+}
+
+// AddMachine connects a machine and starts its chamber pollers while the
+// collector is running
+func (c *Collector) AddMachine(cfg MachineConfig) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopChan == nil {
+		return fmt.Errorf("collector not started")
+	}
+	if _, ok := c.machines[cfg.ID]; ok {
+		return fmt.Errorf("machine %s already collected", cfg.ID)
+	}
+	if err := c.engine.AddMachine(engineConfig(cfg)); err != nil {
+		return err
+	}
+	c.startMachineLocked(cfg, nil)
+	return nil
+}
+
+// RemoveMachine stops the chamber pollers of a machine and disconnects it
+func (c *Collector) RemoveMachine(machineID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	mc, ok := c.machines[machineID]
+	if !ok {
+		return fmt.Errorf("machine %s not collected", machineID)
+	}
+	c.stopMachineLocked(mc)
+	c.endRuns(mc.recipes)
+	return c.engine.RemoveMachine(machineID)
+}
+
+// UpdateMachine restarts the chamber pollers of a machine with a changed
+// configuration. The PLC connection is only replaced if its address changed.
+// Recipe runs of chambers that are still tracked carry on.
+func (c *Collector) UpdateMachine(cfg MachineConfig) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	mc, ok := c.machines[cfg.ID]
+	if !ok {
+		return fmt.Errorf("machine %s not collected", cfg.ID)
+	}
+	// A configuration the engine refuses keeps the machine collected as it was
+	if err := c.engine.CheckMachine(engineConfig(cfg)); err != nil {
+		return err
+	}
+	c.stopMachineLocked(mc)
+	if err := c.engine.UpdateMachine(engineConfig(cfg)); err != nil {
+		c.startMachineLocked(mc.config, mc.recipes)
+		return err
+	}
+	c.startMachineLocked(cfg, mc.recipes)
+	return nil
+}
+
+// Sync brings the collected machines in line with configs: new machines are
+// added, changed ones updated and machines missing from configs removed.
+func (c *Collector) Sync(configs []MachineConfig) error {
+	c.mu.RLock()
+	current := make(map[string]MachineConfig, len(c.machines))
+	for id, mc := range c.machines {
+		current[id] = mc.config
+	}
+	c.mu.RUnlock()
+
+	var errs []error
+	seen := make(map[string]bool, len(configs))
 	for _, cfg := range configs {
-		for _, chamberCfg := range cfg.Chambers {
+		seen[cfg.ID] = true
+		old, ok := current[cfg.ID]
+		switch {
+		case !ok:
+			errs = append(errs, c.AddMachine(cfg))
+		case !sameMachine(old, cfg):
+			errs = append(errs, c.UpdateMachine(cfg))
+		}
+	}
+	for id := range current {
+		if !seen[id] {
+			errs = append(errs, c.RemoveMachine(id))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// startMachineLocked starts one poller per read group of every chamber and
+// the recipe trackers. Trackers of a previous start of the machine are taken
+// over with their running runs, the runs of chambers no longer tracked end.
+// c.mu must be held.
+func (c *Collector) startMachineLocked(cfg MachineConfig, trackers map[string]*RecipeTracker) {
+	mc := &MachineCollector{config: cfg, recipes: make(map[string]*RecipeTracker), stop: make(chan struct{})}
+	c.machines[cfg.ID] = mc
+
+	previous := make(map[string]*RecipeTracker, len(trackers))
+	for id, t := range trackers {
+		previous[id] = t
+	}
+	defer c.endRuns(previous)
+
+	for _, chamberCfg := range cfg.Chambers {
+		if c.Recipes.tracks(chamberCfg) {
+			tracker, ok := previous[chamberCfg.ID]
+			if ok {
+				delete(previous, chamberCfg.ID)
+			} else {
+				tracker = NewRecipeTracker(c.Recipes, cfg.ID, chamberCfg.ID)
+			}
+			mc.recipes[chamberCfg.ID] = tracker
+
 			c.wg.Add(1)
-			go c.runChamberPoller(cfg.ID, chamberCfg)
+			mc.wg.Add(1)
+			go c.runRecipeTracker(mc, tracker)
+		}
+
+		for _, group := range c.ScanRates.ReadGroups(chamberCfg) {
+			g := &scanGroup{chamber: chamberCfg, group: group}
+			g.stats = ScanStats{
+				MachineID: cfg.ID,
+				ChamberID: chamberCfg.ID,
+				Group:     group.Name,
+				Interval:  group.Interval,
+				Symbols:   len(group.Symbols),
+			}
+			mc.groups = append(mc.groups, g)
+
+			c.wg.Add(1)
+			mc.wg.Add(1)
+			go c.runReadGroup(mc, g)
 		}
 	}
+}
 
-	return nil
+// ScanStats returns the read counters of every running read group
+func (c *Collector) ScanStats() []ScanStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var out []ScanStats
+	for _, mc := range c.machines {
+		for _, g := range mc.groups {
+			out = append(out, g.snapshot())
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].MachineID != out[j].MachineID {
+			return out[i].MachineID < out[j].MachineID
+		}
+		return out[i].Group < out[j].Group
+	})
+	return out
+}
+
+// stopMachineLocked stops the pollers of mc and waits for them, the recipe
+// runs of its chambers are left running. c.mu must be held.
+func (c *Collector) stopMachineLocked(mc *MachineCollector) {
+	close(mc.stop)
+	mc.wg.Wait()
+	delete(c.machines, mc.config.ID)
+}
+
+// endRuns ends the running runs of trackers as interrupted
+func (c *Collector) endRuns(trackers map[string]*RecipeTracker) {
+	for _, t := range trackers {
+		c.recordRunEnd(t.Stop(time.Now()))
+	}
+}
+
+func engineConfig(cfg MachineConfig) plcengine.MachineConfig {
+	ec := plcengine.MachineConfig{
+		ID:       cfg.ID,
+		IP:       cfg.IP,
+		AmsNetID: cfg.AmsNetID,
+		Port:     cfg.Port,
+		Gateway:  cfg.Gateway,
+		Protocol: plcengine.Protocol(cfg.Protocol),
+	}
+	for _, ch := range cfg.Chambers {
+		for _, s := range ch.Symbols {
+			ec.Symbols = append(ec.Symbols, s.writeInfo())
+			if s.Modbus == nil {
+				continue
+			}
+			if ec.Registers == nil {
+				ec.Registers = make(map[string]plcengine.ModbusRegister)
+			}
+			ec.Registers[s.Name] = *s.Modbus
+		}
+	}
+	return ec
+}
+
+// sameMachine compares the collected parts of two configurations
+func sameMachine(a, b MachineConfig) bool {
+	a.CreatedAt, a.UpdatedAt = time.Time{}, time.Time{}
+	b.CreatedAt, b.UpdatedAt = time.Time{}, time.Time{}
+	return reflect.DeepEqual(a, b)
 }
 
-func (c *Collector) runChamberPoller(machineID string, cfg ChamberConfig) {
+// runReadGroup polls the symbols of a read group with one batch read per
+// interval. The ticker drops ticks while a read overruns the interval.
+func (c *Collector) runReadGroup(mc *MachineCollector, g *scanGroup) {
 	defer c.wg.Done()
+	defer mc.wg.Done()
+
+	machineID := mc.config.ID
+	cfg := g.chamber
+	group := g.group
 
-	ticker := time.NewTicker(10 * time.Millisecond)
+	ticker := time.NewTicker(group.Interval)
 	defer ticker.Stop()
 
-	var lastErrorLog time.Time
+	var lastErrorLog, lastOverrunLog time.Time
 
-	symbols := make([]string, len(cfg.Symbols))
-	for i, s := range cfg.Symbols {
+	symbols := make([]string, len(group.Symbols))
+	filters := make([]plcengine.ReportFilter, len(group.Symbols))
+	for i, s := range group.Symbols {
 		symbols[i] = s.Name
+		filters[i] = s.filter()
 	}
+	// Last value sent per symbol, restated with a lower quality when reads
+	// fail, and when it was sent
+	last := make(map[string]plcengine.PLCValue, len(symbols))
+	sentAt := make(map[string]time.Time, len(symbols))
 
 	for {
 		select {
 		case <-c.stopChan:
 			return
+		case <-mc.stop:
+			return
 		case <-ticker.C:
+			start := time.Now()
 			vals, err := c.engine.ReadSymbols(machineID, symbols)
+			if elapsed := time.Since(start); g.observe(elapsed) && time.Since(lastOverrunLog) > 10*time.Second {
+				c.reportOverrun(g, elapsed)
+				lastOverrunLog = time.Now()
+			}
 			if err != nil {
 				// Throttle error logging to avoid flooding
 				if time.Since(lastErrorLog) > 10*time.Second {
 					log.Printf("Poller error on machine %s, chamber %s: %v", machineID, cfg.Name, err)
 					lastErrorLog = time.Now()
 				}
+				quality, reason := plcengine.ReadFailure(err)
+				c.publish(mc, cfg.ID, degrade(last, group.Symbols, quality, reason, time.Now()))
 				continue
 			}
 
-			// Broadcast each symbol update if needed, or group by chamber
-			// For now, we group by chamber for the streamer
-			data := streamer.BroadcastMsg{
-				Type:      streamer.MsgTypeData,
-				MachineID: machineID,
-				ChamberID: cfg.ID,
-				Data:      make(map[string]interface{}),
-				Timestamp: time.Now(),
+			now := time.Now()
+			out := make([]plcengine.PLCValue, 0, len(group.Symbols))
+			for i, s := range group.Symbols {
+				v, ok := vals[s.Name]
+				if !ok {
+					// Only this symbol failed, the PLC is reachable
+					out = append(out, degrade(last, []SymbolConfig{s}, plcengine.QualityBad, plcengine.ReasonCommFailure, now)...)
+					continue
+				}
+				plcengine.AssessQuality(v, s.info())
+				if prev, ok := last[s.Name]; ok && !filters[i].Report(&prev, sentAt[s.Name], *v, now) {
+					continue
+				}
+				last[s.Name] = *v
+				sentAt[s.Name] = now
+				out = append(out, *v)
 			}
+			c.publish(mc, cfg.ID, out)
+		}
+	}
+}
 
-			for sym, v := range vals {
-				data.Data[sym] = v.Value
-				// Also send individual symbols to the main dataChan for Kafka
-				c.dataChan <- *v
+// runRecipeTracker polls the recipe fields of a chamber and records the
+// runs it sees start and end. A run still active when the collector stops
+// ends interrupted, when only the machine's pollers stop it carries on.
+func (c *Collector) runRecipeTracker(mc *MachineCollector, t *RecipeTracker) {
+	defer c.wg.Done()
+	defer mc.wg.Done()
+
+	interval := c.Recipes.Interval
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	symbols := c.Recipes.symbols()
+	var lastErrorLog time.Time
+	for {
+		select {
+		case <-c.stopChan:
+			c.recordRunEnd(t.Stop(time.Now()))
+			return
+		case <-mc.stop:
+			return
+		case <-ticker.C:
+			vals, err := c.engine.ReadSymbols(t.machineID, symbols)
+			if err != nil {
+				if time.Since(lastErrorLog) > 10*time.Second {
+					log.Printf("Recipe tracker error on machine %s, chamber %s: %v", t.machineID, t.chamberID, err)
+					lastErrorLog = time.Now()
+				}
+				continue
+			}
+			started, ended := t.Observe(vals, time.Now())
+			c.recordRunEnd(ended)
+			if started != nil {
+				log.Printf("Recipe %s started on machine %s, chamber %s (job %s, substrate %s)",
+					started.RecipeID, started.MachineID, started.ChamberID, started.ProcessJob, started.SubstrateID)
+				if c.Runs != nil {
+					c.Runs.RunStarted(*started)
+				}
 			}
+		}
+	}
+}
+
+func (c *Collector) recordRunEnd(run *RecipeContext) {
+	if run == nil {
+		return
+	}
+	log.Printf("Recipe %s on machine %s, chamber %s ended: %s", run.RecipeID, run.MachineID, run.ChamberID, run.Status)
+	if c.Runs != nil {
+		c.Runs.RunEnded(*run)
+	}
+}
 
-			c.hub.Broadcast(data)
+// reportOverrun logs a read that took longer than its interval and lets the
+// UI know, the read group falls behind its scan rate
+func (c *Collector) reportOverrun(g *scanGroup, elapsed time.Duration) {
+	stats := g.snapshot()
+	log.Printf("Read group %s of machine %s overran: read took %s, interval %s (%d overruns in %d reads)",
+		stats.Group, stats.MachineID, elapsed, stats.Interval, stats.Overruns, stats.Reads)
+	c.hub.Broadcast(streamer.BroadcastMsg{
+		Type:      streamer.MsgTypeOverrun,
+		MachineID: stats.MachineID,
+		ChamberID: stats.ChamberID,
+		Data: map[string]interface{}{
+			"group":       stats.Group,
+			"interval_ms": stats.Interval.Milliseconds(),
+			"duration_ms": elapsed.Milliseconds(),
+			"overruns":    stats.Overruns,
+			"reads":       stats.Reads,
+		},
+		Timestamp: time.Now(),
+	})
+}
+
+// degrade restates the last values of symbols whose read failed with the
+// given quality; symbols never read have no value. A symbol is only reported
+// when its quality changes, so an offline PLC does not flood the sinks.
+func degrade(last map[string]plcengine.PLCValue, symbols []SymbolConfig, quality plcengine.Quality, reason plcengine.QualityReason, now time.Time) []plcengine.PLCValue {
+	var out []plcengine.PLCValue
+	for _, s := range symbols {
+		v, ok := last[s.Name]
+		if ok && v.Quality == quality && v.QualityReason == reason {
+			continue
+		}
+		if !ok {
+			v = plcengine.PLCValue{Symbol: s.Name, Type: plcengine.ParsePLCType(s.DataType)}
 		}
+		v.Quality, v.QualityReason = quality, reason
+		v.Timestamp = now
+		last[s.Name] = v
+		out = append(out, v)
 	}
+	return out
+}
+
+// publish sends the values of one chamber, tagged with its recipe context,
+// to Kafka, to InfluxDB if enabled for the machine and, grouped, to the
+// streamer with the quality of every symbol
+func (c *Collector) publish(mc *MachineCollector, chamberID string, values []plcengine.PLCValue) {
+	if len(values) == 0 {
+		return
+	}
+	machineID := mc.config.ID
+	var tags map[string]string
+	if tracker, ok := mc.recipes[chamberID]; ok {
+		tags = tracker.Tags()
+	}
+	data := streamer.BroadcastMsg{
+		Type:      streamer.MsgTypeData,
+		MachineID: machineID,
+		ChamberID: chamberID,
+		Data:      make(map[string]interface{}, len(values)),
+		Quality:   make(map[string]streamer.SymbolQuality, len(values)),
+		Tags:      tags,
+		Timestamp: time.Now(),
+	}
+	for _, v := range values {
+		v.Source = machineID
+		v.Tags = tags
+		data.Data[v.Symbol] = v.Value
+		data.Quality[v.Symbol] = streamer.SymbolQuality{Quality: string(v.Quality), Reason: string(v.QualityReason)}
+		// Also send individual symbols to the main dataChan for Kafka
+		c.dataChan <- v
+	}
+	if c.Influx != nil && mc.config.InfluxEnabled {
+		c.Influx.Write(machineID, chamberID, tags, values)
+	}
+	c.hub.Broadcast(data)
 }
 
 func (c *Collector) streamerWorker() {
@@ -132,8 +507,27 @@ func (c *Collector) streamerWorker() {
 	// The StreamHub runs its own loop, we just need to manage its stop signal if needed
 	// or perform additional data transformations here.
 	log.Println("Streamer worker started")
-	<-c.stopChan
-	log.Println("Streamer worker stopped")
+	events := c.engine.ConnectionEvents()
+	for {
+		select {
+		case ev := <-events:
+			// Let the UI and alerting react to PLCs going on- or offline
+			log.Printf("PLC %s is %s (circuit %s): %s", ev.MachineID, ev.State, ev.Circuit, ev.Reason)
+			c.hub.Broadcast(streamer.BroadcastMsg{
+				Type:      streamer.MsgTypeConnection,
+				MachineID: ev.MachineID,
+				Data: map[string]interface{}{
+					"state":   ev.State.String(),
+					"circuit": string(ev.Circuit),
+					"reason":  ev.Reason,
+				},
+				Timestamp: ev.Timestamp,
+			})
+		case <-c.stopChan:
+			log.Println("Streamer worker stopped")
+			return
+		}
+	}
 }
 
 func (c *Collector) kafkaWorker(workerID int) {
@@ -143,8 +537,7 @@ func (c *Collector) kafkaWorker(workerID int) {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
-	producer := kafka.NewProducer("localhost:9092", "plc-data")
-	defer producer.Close()
+	producer := c.Producer
 
 	for {
 		select {
@@ -1,6 +1,8 @@
 package collector
 
 import (
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -8,6 +10,7 @@ import (
 	"fiber-backend/internal/streamer"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCollector_ChannelBackpressure(t *testing.T) {
@@ -42,9 +45,18 @@ func TestCollector_ChannelBackpressure(t *testing.T) {
 	}
 }
 
+// newMockEngine returns an engine whose machines answer every read with 42
+func newMockEngine() *plcengine.PLCReadWriteEngine {
+	engine := plcengine.NewEngine(make(chan plcengine.PLCValue, 10))
+	engine.ClientFactory = func(ip, amsID string, port int) (plcengine.ADSClient, error) {
+		return plcengine.NewMockADSClient(ip), nil
+	}
+	return engine
+}
+
 func TestCollector_StartStop(t *testing.T) {
-	engine := &plcengine.PLCReadWriteEngine{}
-	hub := &streamer.StreamHub{}
+	engine := newMockEngine()
+	hub := streamer.NewHub()
 	c := NewCollector(engine, hub)
 	configs := []MachineConfig{
 		{
@@ -74,3 +86,235 @@ func TestCollector_StartStop(t *testing.T) {
 	err = c.Stop()
 	assert.NoError(t, err)
 }
+
+// countingClient counts the batch reads of a chamber poller
+type countingClient struct {
+	*plcengine.MockADSClient
+	reads *atomic.Int64
+}
+
+func (c countingClient) ReadSymbols(names []string) (map[string]interface{}, error) {
+	c.reads.Add(1)
+	return c.MockADSClient.ReadSymbols(names)
+}
+
+func TestCollector_HotAddUpdateRemove(t *testing.T) {
+	reads := map[string]*atomic.Int64{"10.0.0.1": {}, "10.0.0.2": {}}
+	engine := plcengine.NewEngine(make(chan plcengine.PLCValue, 10))
+	engine.ClientFactory = func(ip, amsID string, port int) (plcengine.ADSClient, error) {
+		return countingClient{plcengine.NewMockADSClient(ip), reads[ip]}, nil
+	}
+	c := NewCollector(engine, streamer.NewHub())
+
+	m1 := MachineConfig{ID: "m1", IP: "10.0.0.1", AmsNetID: "10.0.0.1.1.1", Port: 851, Chambers: []ChamberConfig{
+		{ID: "c1", Name: "Chamber 1", Symbols: []SymbolConfig{{Name: "GVL.temp", DataType: "float"}}},
+	}}
+	assert.Error(t, c.AddMachine(m1), "collector not started")
+
+	require.NoError(t, c.Start(nil))
+	defer c.Stop()
+
+	require.NoError(t, c.AddMachine(m1))
+	assert.Error(t, c.AddMachine(m1))
+	require.Eventually(t, func() bool { return reads["10.0.0.1"].Load() > 0 }, time.Second, 10*time.Millisecond)
+
+	// Moving the machine to another address polls the new PLC
+	m1.IP = "10.0.0.2"
+	require.NoError(t, c.Sync([]MachineConfig{m1}))
+	require.Eventually(t, func() bool { return reads["10.0.0.2"].Load() > 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "10.0.0.2", engine.Machines()[0].IP)
+
+	// A configuration the engine refuses keeps the machine collected, without
+	// a client factory the engine only knows its protocol drivers
+	engine.ClientFactory = nil
+	bad := m1
+	bad.IP, bad.Protocol = "10.0.0.1", "profinet"
+	assert.Error(t, c.UpdateMachine(bad))
+	assert.Equal(t, m1, c.machines["m1"].config)
+	polled := reads["10.0.0.2"].Load()
+	require.Eventually(t, func() bool { return reads["10.0.0.2"].Load() > polled }, time.Second, 10*time.Millisecond)
+
+	// Dropping it from the configuration stops its pollers
+	require.NoError(t, c.Sync(nil))
+	assert.Empty(t, engine.GetStatus())
+	stopped := reads["10.0.0.2"].Load()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, stopped, reads["10.0.0.2"].Load())
+
+	assert.Error(t, c.RemoveMachine("m1"))
+	assert.Error(t, c.UpdateMachine(m1))
+}
+
+func TestDegrade(t *testing.T) {
+	now := time.Now()
+	symbols := []SymbolConfig{{Name: "GVL.temp", DataType: "float"}, {Name: "GVL.ready", DataType: "BOOL"}}
+	last := map[string]plcengine.PLCValue{
+		"GVL.temp": {Symbol: "GVL.temp", Value: 21.5, Quality: plcengine.QualityGood, Source: "m1"},
+	}
+
+	// The last value is restated as stale, a symbol never read has no value
+	out := degrade(last, symbols, plcengine.QualityUncertain, plcengine.ReasonStale, now)
+	require.Len(t, out, 2)
+	assert.Equal(t, 21.5, out[0].Value)
+	assert.Equal(t, plcengine.QualityUncertain, out[0].Quality)
+	assert.Equal(t, plcengine.ReasonStale, out[0].QualityReason)
+	assert.Equal(t, now, out[0].Timestamp)
+	assert.Nil(t, out[1].Value)
+	assert.Equal(t, plcengine.TypeBool, out[1].Type)
+
+	// Unchanged quality is not repeated
+	assert.Empty(t, degrade(last, symbols, plcengine.QualityUncertain, plcengine.ReasonStale, now))
+
+	out = degrade(last, symbols, plcengine.QualityBad, plcengine.ReasonNotConnected, now)
+	require.Len(t, out, 2)
+	assert.Equal(t, plcengine.QualityBad, out[0].Quality)
+	assert.Equal(t, 21.5, out[0].Value)
+}
+
+func TestSymbolConfig_Quality(t *testing.T) {
+	low, high := 0.0, 100.0
+	s := SymbolConfig{Name: "GVL.temp", DataType: "float", MinValue: &low, MaxValue: &high}
+
+	v := plcengine.PLCValue{Value: float32(150), Type: plcengine.TypeReal, Quality: plcengine.QualityGood}
+	plcengine.AssessQuality(&v, s.info())
+	assert.Equal(t, plcengine.QualityBad, v.Quality)
+	assert.Equal(t, plcengine.ReasonOutOfRange, v.QualityReason)
+
+	// An open upper bound
+	s.MaxValue = nil
+	v = plcengine.PLCValue{Value: float32(150), Type: plcengine.TypeReal, Quality: plcengine.QualityGood}
+	plcengine.AssessQuality(&v, s.info())
+	assert.Equal(t, plcengine.QualityGood, v.Quality)
+
+	v = plcengine.PLCValue{Value: int16(5), Type: plcengine.TypeInt16, Quality: plcengine.QualityGood}
+	plcengine.AssessQuality(&v, s.info())
+	assert.Equal(t, plcengine.ReasonTypeMismatch, v.QualityReason)
+}
+
+func TestEngineConfig_Registers(t *testing.T) {
+	cfg := MachineConfig{ID: "chiller1", IP: "10.0.0.2", Port: 502, Protocol: "modbus", Chambers: []ChamberConfig{
+		{ID: "c1", Symbols: []SymbolConfig{
+			{Name: "Chiller.Setpoint", Modbus: &plcengine.ModbusRegister{Table: plcengine.ModbusHolding, Type: "int16", Scale: 0.1}},
+			{Name: "Chiller.Comment"},
+		}},
+		{ID: "c2", Symbols: []SymbolConfig{
+			{Name: "Pump.Run", Modbus: &plcengine.ModbusRegister{Table: plcengine.ModbusCoil, Address: 4}},
+		}},
+	}}
+
+	ec := engineConfig(cfg)
+	assert.Equal(t, plcengine.ProtocolModbus, ec.Protocol)
+	assert.Equal(t, map[string]plcengine.ModbusRegister{
+		"Chiller.Setpoint": {Table: plcengine.ModbusHolding, Type: "int16", Scale: 0.1},
+		"Pump.Run":         {Table: plcengine.ModbusCoil, Address: 4},
+	}, ec.Registers)
+
+	assert.Nil(t, engineConfig(MachineConfig{ID: "m1"}).Registers)
+}
+
+func TestEngineConfig_WriteAllowlist(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	cfg := MachineConfig{ID: "m1", IP: "127.0.0.1", Port: 851, Chambers: []ChamberConfig{
+		{ID: "c1", Symbols: []SymbolConfig{
+			{Name: "GVL.Setpoint", DataType: "REAL", MinValue: f(0), MaxValue: f(500), Writable: true, WriteMax: f(200)},
+			{Name: "GVL.Speed", DataType: "INT", Writable: true},
+			{Name: "GVL.Temp", DataType: "REAL", MinValue: f(0), MaxValue: f(100)},
+		}},
+	}}
+
+	engine := plcengine.NewEngine(make(chan plcengine.PLCValue, 10))
+	engine.ClientFactory = func(ip, amsID string, port int) (plcengine.ADSClient, error) {
+		return plcengine.NewMockADSClient(ip), nil
+	}
+	require.NoError(t, engine.Start([]plcengine.MachineConfig{engineConfig(cfg)}))
+	defer engine.Stop()
+
+	check := func(symbol string, value interface{}) plcengine.RejectReason {
+		_, rej := engine.CheckWrite(plcengine.WriteRequest{MachineID: "m1", Symbol: symbol, Value: value})
+		if rej == nil {
+			return ""
+		}
+		return rej.Reason
+	}
+	assert.Equal(t, plcengine.RejectReason(""), check("GVL.Setpoint", 150.0))
+	assert.Equal(t, plcengine.RejectOutOfRange, check("GVL.Setpoint", 300.0), "write bounds narrow the valid range")
+	assert.Equal(t, plcengine.RejectOutOfRange, check("GVL.Setpoint", -1.0), "write bounds default to the valid range")
+	assert.Equal(t, plcengine.RejectReason(""), check("GVL.Speed", 1000.0))
+	assert.Equal(t, plcengine.RejectReadOnly, check("GVL.Temp", 20.0))
+	assert.Equal(t, plcengine.RejectNotAllowed, check("GVL.Other", 1.0))
+}
+
+func TestCollector_ChangeOnlyAndHeartbeat(t *testing.T) {
+	engine := newMockEngine()
+	cfg := MachineConfig{ID: "m1", IP: "127.0.0.1", AmsNetID: "1.2.3.4.1.1", Port: 851, Chambers: []ChamberConfig{
+		{ID: "c1", Name: "Chamber 1", Symbols: []SymbolConfig{
+			{Name: "GVL.temp", DataType: "float"},
+			{Name: "GVL.setpoint", DataType: "float", ChangeOnly: true},
+			{Name: "GVL.pressure", DataType: "float", Deadband: 0.5, HeartbeatMs: 50},
+		}},
+	}}
+	require.NoError(t, engine.Start([]plcengine.MachineConfig{engineConfig(cfg)}))
+	defer engine.Stop()
+
+	c := NewCollector(engine, streamer.NewHub())
+	c.dataChan = make(chan plcengine.PLCValue, 10000)
+	c.stopChan = make(chan struct{})
+	c.startMachineLocked(cfg, nil)
+
+	time.Sleep(300 * time.Millisecond)
+	c.stopMachineLocked(c.machines["m1"])
+	close(c.dataChan)
+
+	// The mock PLC always answers 42
+	good := make(map[string]int)
+	for v := range c.dataChan {
+		if v.Quality == plcengine.QualityGood {
+			good[v.Symbol]++
+		}
+	}
+	assert.Greater(t, good["GVL.temp"], 10)
+	assert.Equal(t, 1, good["GVL.setpoint"])
+	assert.GreaterOrEqual(t, good["GVL.pressure"], 2)
+	assert.Less(t, good["GVL.pressure"], 10)
+}
+
+// recordingSink counts the values written per machine
+type recordingSink struct {
+	mu     sync.Mutex
+	values map[string]int
+}
+
+func (s *recordingSink) Write(machineID, chamberID string, tags map[string]string, values []plcengine.PLCValue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[machineID] += len(values)
+}
+
+func TestCollector_InfluxPerMachine(t *testing.T) {
+	engine := newMockEngine()
+	machine := func(id string, influx bool) MachineConfig {
+		return MachineConfig{ID: id, IP: "127.0.0.1", AmsNetID: "1.2.3.4.1.1", Port: 851, InfluxEnabled: influx, Chambers: []ChamberConfig{
+			{ID: id + "-c1", Name: "Chamber 1", Symbols: []SymbolConfig{{Name: "GVL.temp", DataType: "float"}}},
+		}}
+	}
+	on, off := machine("m1", true), machine("m2", false)
+	require.NoError(t, engine.Start([]plcengine.MachineConfig{engineConfig(on), engineConfig(off)}))
+	defer engine.Stop()
+
+	sink := &recordingSink{values: make(map[string]int)}
+	c := NewCollector(engine, streamer.NewHub())
+	c.Influx = sink
+	c.dataChan = make(chan plcengine.PLCValue, 10000)
+	c.stopChan = make(chan struct{})
+	c.startMachineLocked(on, nil)
+	c.startMachineLocked(off, nil)
+
+	time.Sleep(100 * time.Millisecond)
+	c.stopMachineLocked(c.machines["m1"])
+	c.stopMachineLocked(c.machines["m2"])
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	assert.Greater(t, sink.values["m1"], 0)
+	assert.Zero(t, sink.values["m2"])
+}
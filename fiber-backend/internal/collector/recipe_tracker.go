@@ -0,0 +1,306 @@
+package collector
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"fiber-backend/internal/plcengine"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// Roles of recipe fields, a field without a role is only recorded with the
+// run
+const (
+	RecipeRoleActive      = "active" // rising edge starts a run, falling edge ends it
+	RecipeRoleProcessJob  = "process_job"
+	RecipeRoleSubstrateID = "substrate_id"
+	RecipeRoleRecipeID    = "recipe_id"
+	RecipeRoleStep        = "step"
+	RecipeRoleStatus      = "status"
+	RecipeRoleStartTime   = "start_time"
+	RecipeRoleEndTime     = "end_time"
+)
+
+// Run states, a run still active when its chamber stops being collected is
+// interrupted
+const (
+	RunRunning     = "running"
+	RunCompleted   = "completed"
+	RunInterrupted = "interrupted"
+)
+
+// recipeTimeLayout is the format of the PLC's recipe start and end times
+const recipeTimeLayout = "2006-01-02 15:04:05"
+
+// RecipeField is a recipe_fields entry of plc_data_config.yaml
+type RecipeField struct {
+	Name         string `yaml:"name"`
+	DataType     string `yaml:"data_type"`
+	IsIdentifier bool   `yaml:"is_identifier"`
+	Role         string `yaml:"role"`
+}
+
+// RecipeConfig are the PLC symbols describing the recipe of a chamber. Only
+// chambers collecting the active field are tracked.
+type RecipeConfig struct {
+	Fields   []RecipeField
+	Interval time.Duration
+}
+
+// LoadRecipeConfig reads data_collection.recipe_fields of
+// plc_data_config.yaml, an empty path disables recipe tracking
+func LoadRecipeConfig(path string) (RecipeConfig, error) {
+	cfg := RecipeConfig{Interval: 100 * time.Millisecond}
+	if path == "" {
+		return cfg, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	var f struct {
+		DataCollection struct {
+			RecipeFields []RecipeField `yaml:"recipe_fields"`
+		} `yaml:"data_collection"`
+	}
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	cfg.Fields = f.DataCollection.RecipeFields
+	if len(cfg.Fields) > 0 && cfg.field(RecipeRoleActive) == "" {
+		return cfg, fmt.Errorf("parse %s: recipe_fields need a field with role %s", path, RecipeRoleActive)
+	}
+	return cfg, nil
+}
+
+// field returns the symbol with a role, empty if there is none
+func (c RecipeConfig) field(role string) string {
+	for _, f := range c.Fields {
+		if f.Role == role {
+			return f.Name
+		}
+	}
+	return ""
+}
+
+func (c RecipeConfig) symbols() []string {
+	names := make([]string, len(c.Fields))
+	for i, f := range c.Fields {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// tracks reports whether the recipes of a chamber are tracked
+func (c RecipeConfig) tracks(ch ChamberConfig) bool {
+	active := c.field(RecipeRoleActive)
+	if active == "" {
+		return false
+	}
+	for _, s := range ch.Symbols {
+		if s.Name == active {
+			return true
+		}
+	}
+	return false
+}
+
+// RecipeContext is a recipe run of a chamber
+type RecipeContext struct {
+	RunID       string
+	MachineID   string
+	ChamberID   string
+	RecipeID    string
+	ProcessJob  string
+	SubstrateID string
+	Step        int
+	Status      string
+	StartTime   time.Time
+	EndTime     time.Time
+	Fields      map[string]interface{}
+}
+
+// Tags are the tags of the values collected during the run
+func (rc *RecipeContext) Tags() map[string]string {
+	return map[string]string{
+		"process_job":  rc.ProcessJob,
+		"substrate_id": rc.SubstrateID,
+		"recipe_id":    rc.RecipeID,
+		"step":         strconv.Itoa(rc.Step),
+	}
+}
+
+// RunRecorder persists recipe runs, it must not block the collector
+type RunRecorder interface {
+	RunStarted(run RecipeContext)
+	RunEnded(run RecipeContext)
+}
+
+// RecipeTracker follows the recipe runs of one chamber from polled recipe
+// fields
+type RecipeTracker struct {
+	cfg       RecipeConfig
+	machineID string
+	chamberID string
+
+	mu      sync.RWMutex
+	current *RecipeContext
+	tags    map[string]string
+	active  bool
+}
+
+func NewRecipeTracker(cfg RecipeConfig, machineID, chamberID string) *RecipeTracker {
+	return &RecipeTracker{cfg: cfg, machineID: machineID, chamberID: chamberID}
+}
+
+// Observe updates the run from a read of the recipe fields and returns the
+// run that started or ended with it
+func (t *RecipeTracker) Observe(vals map[string]*plcengine.PLCValue, now time.Time) (started, ended *RecipeContext) {
+	active, ok := t.boolField(vals, RecipeRoleActive)
+	if !ok {
+		return nil, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case active && !t.active:
+		run := &RecipeContext{
+			RunID:       uuid.New().String(),
+			MachineID:   t.machineID,
+			ChamberID:   t.chamberID,
+			RecipeID:    t.stringField(vals, RecipeRoleRecipeID),
+			ProcessJob:  t.stringField(vals, RecipeRoleProcessJob),
+			SubstrateID: t.stringField(vals, RecipeRoleSubstrateID),
+			Step:        t.intField(vals, RecipeRoleStep),
+			Status:      RunRunning,
+			StartTime:   t.timeField(vals, RecipeRoleStartTime, now),
+			Fields:      make(map[string]interface{}, len(vals)),
+		}
+		for name, v := range vals {
+			if v != nil && v.Quality.Usable() {
+				run.Fields[name] = v.Value
+			}
+		}
+		t.current = run
+		t.tags = run.Tags()
+		c := *run
+		started = &c
+
+	case !active && t.active && t.current != nil:
+		run := t.current
+		run.EndTime = t.timeField(vals, RecipeRoleEndTime, now)
+		run.Status = t.stringField(vals, RecipeRoleStatus)
+		if run.Status == "" {
+			run.Status = RunCompleted
+		}
+		t.current, t.tags = nil, nil
+		ended = run
+
+	case active && t.current != nil:
+		if step := t.intField(vals, RecipeRoleStep); step != t.current.Step {
+			t.current.Step = step
+			t.tags = t.current.Tags()
+		}
+	}
+	t.active = active
+	return started, ended
+}
+
+// Stop ends a running run as interrupted
+func (t *RecipeTracker) Stop(now time.Time) *RecipeContext {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	run := t.current
+	if run == nil {
+		return nil
+	}
+	run.EndTime, run.Status = now, RunInterrupted
+	t.current, t.tags, t.active = nil, nil, false
+	return run
+}
+
+// Current returns a copy of the running run, nil between runs
+func (t *RecipeTracker) Current() *RecipeContext {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.current == nil {
+		return nil
+	}
+	c := *t.current
+	return &c
+}
+
+// Tags of the values collected now, nil between runs. The map is shared
+// and must not be modified.
+func (t *RecipeTracker) Tags() map[string]string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tags
+}
+
+func (t *RecipeTracker) value(vals map[string]*plcengine.PLCValue, role string) (interface{}, bool) {
+	name := t.cfg.field(role)
+	if name == "" {
+		return nil, false
+	}
+	v, ok := vals[name]
+	if !ok || v == nil || !v.Quality.Usable() {
+		return nil, false
+	}
+	return v.Value, true
+}
+
+func (t *RecipeTracker) boolField(vals map[string]*plcengine.PLCValue, role string) (bool, bool) {
+	v, ok := t.value(vals, role)
+	if !ok {
+		return false, false
+	}
+	f, err := plcengine.ToFloat64(v)
+	if err != nil {
+		return false, false
+	}
+	return f != 0, true
+}
+
+func (t *RecipeTracker) stringField(vals map[string]*plcengine.PLCValue, role string) string {
+	v, ok := t.value(vals, role)
+	if !ok {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+func (t *RecipeTracker) intField(vals map[string]*plcengine.PLCValue, role string) int {
+	v, ok := t.value(vals, role)
+	if !ok {
+		return 0
+	}
+	f, err := plcengine.ToFloat64(v)
+	if err != nil {
+		return 0
+	}
+	return int(f)
+}
+
+// timeField parses a PLC time, def if it is missing or invalid
+func (t *RecipeTracker) timeField(vals map[string]*plcengine.PLCValue, role string, def time.Time) time.Time {
+	s := t.stringField(vals, role)
+	if s == "" {
+		return def
+	}
+	ts, err := time.ParseInLocation(recipeTimeLayout, s, time.Local)
+	if err != nil {
+		return def
+	}
+	return ts
+}
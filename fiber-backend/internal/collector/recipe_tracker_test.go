@@ -0,0 +1,258 @@
+package collector
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fiber-backend/internal/plcengine"
+	"fiber-backend/internal/streamer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRecipeConfig() RecipeConfig {
+	return RecipeConfig{
+		Interval: 10 * time.Millisecond,
+		Fields: []RecipeField{
+			{Name: "Recipe.Done", Role: RecipeRoleActive},
+			{Name: "Recipe.Job", Role: RecipeRoleProcessJob},
+			{Name: "Recipe.Substrate", Role: RecipeRoleSubstrateID},
+			{Name: "Recipe.File", Role: RecipeRoleRecipeID},
+			{Name: "Recipe.Step", Role: RecipeRoleStep},
+			{Name: "Recipe.Status", Role: RecipeRoleStatus},
+			{Name: "Recipe.Start", Role: RecipeRoleStartTime},
+			{Name: "Recipe.Algo"},
+		},
+	}
+}
+
+func recipeValues(m map[string]interface{}) map[string]*plcengine.PLCValue {
+	vals := make(map[string]*plcengine.PLCValue, len(m))
+	for name, v := range m {
+		vals[name] = &plcengine.PLCValue{Symbol: name, Value: v, Quality: plcengine.QualityGood}
+	}
+	return vals
+}
+
+func TestLoadRecipeConfig(t *testing.T) {
+	cfg, err := LoadRecipeConfig("../../../config/plc_data_config.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "Recipe.recipe_exe.recipeexecute.Done", cfg.field(RecipeRoleActive))
+	assert.Equal(t, "Recipe.recipe_exe.Process_Job", cfg.field(RecipeRoleProcessJob))
+	assert.Equal(t, "Recipe.recipe_exe.Substrate_ID", cfg.field(RecipeRoleSubstrateID))
+	assert.Contains(t, cfg.symbols(), "Recipe.fSine")
+
+	// the simulated recipe drives the recipe fields
+	sim, err := plcengine.LoadSimulationFile("../../../config/plc_simulator.yaml")
+	require.NoError(t, err)
+	require.NotNil(t, sim.Recipe)
+	assert.Equal(t, cfg.field(RecipeRoleActive), sim.Recipe.ActiveSymbol)
+	assert.Equal(t, cfg.field(RecipeRoleStep), sim.Recipe.StepSymbol)
+	for _, st := range sim.Recipe.Steps {
+		assert.Contains(t, st.Values, cfg.field(RecipeRoleRecipeID), st.Name)
+	}
+
+	cfg, err = LoadRecipeConfig("")
+	require.NoError(t, err)
+	assert.False(t, cfg.tracks(ChamberConfig{Symbols: []SymbolConfig{{Name: "Recipe.recipe_exe.recipeexecute.Done"}}}))
+}
+
+func TestRecipeTracker_Observe(t *testing.T) {
+	tracker := NewRecipeTracker(testRecipeConfig(), "m1", "c1")
+	now := time.Now()
+	base := map[string]interface{}{
+		"Recipe.Done": false, "Recipe.Job": "PJ-1", "Recipe.Substrate": "W07",
+		"Recipe.File": "etch.rcp", "Recipe.Step": int16(1), "Recipe.Algo": "ratio",
+		"Recipe.Start": "2026-01-02 03:04:05",
+	}
+	read := func(changes map[string]interface{}) map[string]*plcengine.PLCValue {
+		for k, v := range changes {
+			base[k] = v
+		}
+		return recipeValues(base)
+	}
+
+	started, ended := tracker.Observe(read(nil), now)
+	assert.Nil(t, started)
+	assert.Nil(t, ended)
+	assert.Nil(t, tracker.Tags())
+
+	started, ended = tracker.Observe(read(map[string]interface{}{"Recipe.Done": true}), now)
+	require.NotNil(t, started)
+	assert.Nil(t, ended)
+	assert.NotEmpty(t, started.RunID)
+	assert.Equal(t, "etch.rcp", started.RecipeID)
+	assert.Equal(t, "PJ-1", started.ProcessJob)
+	assert.Equal(t, "W07", started.SubstrateID)
+	assert.Equal(t, RunRunning, started.Status)
+	assert.Equal(t, time.Date(2026, 1, 2, 3, 4, 5, 0, time.Local), started.StartTime)
+	assert.Equal(t, "ratio", started.Fields["Recipe.Algo"])
+	assert.Equal(t, map[string]string{"process_job": "PJ-1", "substrate_id": "W07", "recipe_id": "etch.rcp", "step": "1"}, tracker.Tags())
+
+	started, _ = tracker.Observe(read(map[string]interface{}{"Recipe.Step": int16(2)}), now)
+	assert.Nil(t, started)
+	assert.Equal(t, "2", tracker.Tags()["step"])
+	assert.Equal(t, 2, tracker.Current().Step)
+
+	// a failed read of the active field changes nothing
+	missing := read(nil)
+	missing["Recipe.Done"].Quality = plcengine.QualityBad
+	started, ended = tracker.Observe(missing, now)
+	assert.Nil(t, started)
+	assert.Nil(t, ended)
+
+	started, ended = tracker.Observe(read(map[string]interface{}{"Recipe.Done": false, "Recipe.Status": "Done"}), now.Add(time.Minute))
+	assert.Nil(t, started)
+	require.NotNil(t, ended)
+	assert.Equal(t, "Done", ended.Status)
+	assert.Equal(t, now.Add(time.Minute), ended.EndTime)
+	assert.Nil(t, tracker.Tags())
+	assert.Nil(t, tracker.Current())
+}
+
+func TestRecipeTracker_Stop(t *testing.T) {
+	tracker := NewRecipeTracker(testRecipeConfig(), "m1", "c1")
+	assert.Nil(t, tracker.Stop(time.Now()))
+
+	tracker.Observe(recipeValues(map[string]interface{}{"Recipe.Done": true}), time.Now())
+	run := tracker.Stop(time.Now())
+	require.NotNil(t, run)
+	assert.Equal(t, RunInterrupted, run.Status)
+	assert.Nil(t, tracker.Tags())
+}
+
+// recipeClient answers the recipe fields from a map the test changes, other
+// symbols read 42
+type recipeClient struct {
+	*plcengine.MockADSClient
+	mu     *sync.Mutex
+	values map[string]interface{}
+}
+
+func (c *recipeClient) ReadSymbols(names []string) (map[string]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]interface{}, len(names))
+	for _, n := range names {
+		if v, ok := c.values[n]; ok {
+			out[n] = v
+		} else if !strings.HasPrefix(n, "Recipe.") {
+			out[n] = float32(42)
+		}
+	}
+	return out, nil
+}
+
+// runLog records the runs of a collector
+type runLog struct {
+	mu      sync.Mutex
+	started []RecipeContext
+	ended   []RecipeContext
+}
+
+func (l *runLog) RunStarted(run RecipeContext) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.started = append(l.started, run)
+}
+
+func (l *runLog) RunEnded(run RecipeContext) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ended = append(l.ended, run)
+}
+
+// tagSink records the tags values were written with
+type tagSink struct {
+	mu   sync.Mutex
+	tags []map[string]string
+}
+
+func (s *tagSink) Write(machineID, chamberID string, tags map[string]string, values []plcengine.PLCValue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tags = append(s.tags, tags)
+}
+
+func TestCollector_RecipeRuns(t *testing.T) {
+	var mu sync.Mutex
+	values := map[string]interface{}{"Recipe.Done": false, "Recipe.Job": "PJ-9", "Recipe.Substrate": "W01", "Recipe.File": "dep.rcp", "Recipe.Step": int16(3)}
+	set := func(k string, v interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		values[k] = v
+	}
+
+	engine := plcengine.NewEngine(make(chan plcengine.PLCValue, 10))
+	engine.ClientFactory = func(ip, amsID string, port int) (plcengine.ADSClient, error) {
+		return &recipeClient{plcengine.NewMockADSClient(ip), &mu, values}, nil
+	}
+	cfg := MachineConfig{ID: "m1", IP: "127.0.0.1", AmsNetID: "1.2.3.4.1.1", Port: 851, InfluxEnabled: true, Chambers: []ChamberConfig{
+		{ID: "c1", Name: "Chamber 1", Symbols: []SymbolConfig{{Name: "GVL.temp", DataType: "float"}, {Name: "Recipe.Done", DataType: "bool"}}},
+		{ID: "c2", Name: "Chamber 2", Symbols: []SymbolConfig{{Name: "GVL.temp", DataType: "float"}}},
+	}}
+	require.NoError(t, engine.Start([]plcengine.MachineConfig{engineConfig(cfg)}))
+	defer engine.Stop()
+
+	runs, sink := &runLog{}, &tagSink{}
+	c := NewCollector(engine, streamer.NewHub())
+	c.Recipes = testRecipeConfig()
+	c.Runs = runs
+	c.Influx = sink
+	c.dataChan = make(chan plcengine.PLCValue, 100000)
+	c.stopChan = make(chan struct{})
+	c.startMachineLocked(cfg, nil)
+	assert.Len(t, c.machines["m1"].recipes, 1, "only chambers collecting the active field are tracked")
+
+	time.Sleep(50 * time.Millisecond)
+	set("Recipe.Done", true)
+	time.Sleep(100 * time.Millisecond)
+	set("Recipe.Done", false)
+	time.Sleep(50 * time.Millisecond)
+	set("Recipe.Done", true)
+	time.Sleep(50 * time.Millisecond)
+
+	// a configuration change restarts the pollers, the run carries on
+	cfg.Chambers[0].Symbols[0].Deadband = 0.5
+	require.NoError(t, c.UpdateMachine(cfg))
+	time.Sleep(50 * time.Millisecond)
+	runs.mu.Lock()
+	assert.Len(t, runs.started, 2)
+	assert.Len(t, runs.ended, 1)
+	runs.mu.Unlock()
+
+	require.NoError(t, c.RemoveMachine("m1"))
+	close(c.dataChan)
+
+	runs.mu.Lock()
+	require.Len(t, runs.started, 2)
+	require.Len(t, runs.ended, 2)
+	assert.Equal(t, "dep.rcp", runs.started[0].RecipeID)
+	assert.Equal(t, runs.started[0].RunID, runs.ended[0].RunID)
+	assert.Equal(t, RunCompleted, runs.ended[0].Status)
+	assert.Equal(t, runs.started[1].RunID, runs.ended[1].RunID)
+	assert.Equal(t, RunInterrupted, runs.ended[1].Status)
+	runs.mu.Unlock()
+
+	tagged := 0
+	for v := range c.dataChan {
+		if v.Tags != nil {
+			tagged++
+			assert.Equal(t, map[string]string{"process_job": "PJ-9", "substrate_id": "W01", "recipe_id": "dep.rcp", "step": "3"}, v.Tags)
+		}
+	}
+	assert.Greater(t, tagged, 0)
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sinkTagged := 0
+	for _, tags := range sink.tags {
+		if tags != nil {
+			sinkTagged++
+		}
+	}
+	assert.Greater(t, sinkTagged, 0)
+}
@@ -0,0 +1,150 @@
+package collector
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ScanRates are the poll intervals of chambers and symbols. Symbols are
+// polled at their own scan rate, else at their chamber's, else at Default.
+type ScanRates struct {
+	Default time.Duration
+	Classes map[string]time.Duration // e.g. fast, medium, slow
+}
+
+func DefaultScanRates() ScanRates {
+	return ScanRates{
+		Default: 10 * time.Millisecond,
+		Classes: map[string]time.Duration{
+			"fast":   10 * time.Millisecond,
+			"medium": 100 * time.Millisecond,
+			"slow":   time.Second,
+		},
+	}
+}
+
+// LoadScanRates reads plc.scan_rate_ms and plc.scan_classes from
+// config.yaml on top of the defaults, an empty path returns the defaults
+func LoadScanRates(path string) (ScanRates, error) {
+	rates := DefaultScanRates()
+	if path == "" {
+		return rates, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return rates, err
+	}
+	var f struct {
+		PLC struct {
+			ScanRateMs  int            `yaml:"scan_rate_ms"`
+			ScanClasses map[string]int `yaml:"scan_classes"`
+		} `yaml:"plc"`
+	}
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return rates, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if f.PLC.ScanRateMs > 0 {
+		rates.Default = time.Duration(f.PLC.ScanRateMs) * time.Millisecond
+	}
+	for class, ms := range f.PLC.ScanClasses {
+		if ms <= 0 {
+			return rates, fmt.Errorf("parse %s: scan class %s needs a positive interval", path, class)
+		}
+		rates.Classes[class] = time.Duration(ms) * time.Millisecond
+	}
+	return rates, nil
+}
+
+// interval resolves an explicit scan rate or a class, ok is false if
+// neither is set or the class is unknown
+func (r ScanRates) interval(scanRateMs int, class string) (time.Duration, bool) {
+	if scanRateMs > 0 {
+		return time.Duration(scanRateMs) * time.Millisecond, true
+	}
+	d, ok := r.Classes[class]
+	return d, ok && d > 0
+}
+
+// ReadGroup is a set of symbols of a chamber read with one batch read per
+// interval
+type ReadGroup struct {
+	Name     string
+	Interval time.Duration
+	Symbols  []SymbolConfig
+}
+
+// ReadGroups splits the symbols of a chamber by scan rate, fastest first
+func (r ScanRates) ReadGroups(ch ChamberConfig) []ReadGroup {
+	chamberRate := r.Default
+	if d, ok := r.interval(ch.ScanRateMs, ch.ScanClass); ok {
+		chamberRate = d
+	}
+
+	byInterval := make(map[time.Duration][]SymbolConfig)
+	for _, s := range ch.Symbols {
+		d, ok := r.interval(s.ScanRateMs, s.ScanClass)
+		if !ok {
+			d = chamberRate
+		}
+		byInterval[d] = append(byInterval[d], s)
+	}
+
+	groups := make([]ReadGroup, 0, len(byInterval))
+	for d, symbols := range byInterval {
+		groups = append(groups, ReadGroup{
+			Name:     fmt.Sprintf("%s/%s", ch.Name, d),
+			Interval: d,
+			Symbols:  symbols,
+		})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Interval < groups[j].Interval })
+	return groups
+}
+
+// ScanStats are the counters of a read group, an overrun is a batch read
+// that took longer than the group's interval
+type ScanStats struct {
+	MachineID    string        `json:"machine_id"`
+	ChamberID    string        `json:"chamber_id"`
+	Group        string        `json:"group"`
+	Interval     time.Duration `json:"interval"`
+	Symbols      int           `json:"symbols"`
+	Reads        uint64        `json:"reads"`
+	Overruns     uint64        `json:"overruns"`
+	LastDuration time.Duration `json:"last_duration"`
+	MaxDuration  time.Duration `json:"max_duration"`
+}
+
+// scanGroup is a running read group
+type scanGroup struct {
+	chamber ChamberConfig
+	group   ReadGroup
+
+	mu    sync.Mutex
+	stats ScanStats
+}
+
+// observe records the duration of a read and reports whether it overran
+func (g *scanGroup) observe(d time.Duration) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stats.Reads++
+	g.stats.LastDuration = d
+	g.stats.MaxDuration = max(g.stats.MaxDuration, d)
+	if d <= g.group.Interval {
+		return false
+	}
+	g.stats.Overruns++
+	return true
+}
+
+func (g *scanGroup) snapshot() ScanStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stats
+}
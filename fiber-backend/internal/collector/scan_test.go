@@ -0,0 +1,102 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"fiber-backend/internal/plcengine"
+	"fiber-backend/internal/streamer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanRates_ReadGroups(t *testing.T) {
+	rates := DefaultScanRates()
+	ch := ChamberConfig{ID: "c1", Name: "PM1", ScanClass: "medium", Symbols: []SymbolConfig{
+		{Name: "GVL.rf_power", ScanClass: "fast"},
+		{Name: "GVL.pressure", ScanRateMs: 10},
+		{Name: "GVL.temp"},
+		{Name: "GVL.recipe", ScanClass: "slow"},
+		{Name: "GVL.flow", ScanRateMs: 250},
+		{Name: "GVL.valve", ScanClass: "unknown"},
+	}}
+
+	groups := rates.ReadGroups(ch)
+	require.Len(t, groups, 4)
+	assert.Equal(t, "PM1/10ms", groups[0].Name)
+	assert.Equal(t, []SymbolConfig{ch.Symbols[0], ch.Symbols[1]}, groups[0].Symbols)
+	assert.Equal(t, 100*time.Millisecond, groups[1].Interval)
+	assert.Equal(t, []SymbolConfig{ch.Symbols[2], ch.Symbols[5]}, groups[1].Symbols, "symbols fall back to the chamber's class")
+	assert.Equal(t, 250*time.Millisecond, groups[2].Interval)
+	assert.Equal(t, time.Second, groups[3].Interval)
+
+	// Without a chamber scan rate the default applies
+	rates.Default = 50 * time.Millisecond
+	groups = rates.ReadGroups(ChamberConfig{Name: "PM2", Symbols: []SymbolConfig{{Name: "GVL.temp"}}})
+	require.Len(t, groups, 1)
+	assert.Equal(t, 50*time.Millisecond, groups[0].Interval)
+}
+
+func TestLoadScanRates(t *testing.T) {
+	rates, err := LoadScanRates("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultScanRates(), rates)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("plc:\n  scan_rate_ms: 20\n  scan_classes: { slow: 5000, trend: 60000 }\n"), 0o644))
+	rates, err = LoadScanRates(path)
+	require.NoError(t, err)
+	assert.Equal(t, 20*time.Millisecond, rates.Default)
+	assert.Equal(t, 5*time.Second, rates.Classes["slow"])
+	assert.Equal(t, time.Minute, rates.Classes["trend"])
+	assert.Equal(t, 100*time.Millisecond, rates.Classes["medium"])
+
+	require.NoError(t, os.WriteFile(path, []byte("plc:\n  scan_classes: { fast: 0 }\n"), 0o644))
+	_, err = LoadScanRates(path)
+	assert.Error(t, err)
+	_, err = LoadScanRates(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
+
+// slowClient answers batch reads after a delay
+type slowClient struct {
+	*plcengine.MockADSClient
+	delay time.Duration
+}
+
+func (c slowClient) ReadSymbols(names []string) (map[string]interface{}, error) {
+	time.Sleep(c.delay)
+	return c.MockADSClient.ReadSymbols(names)
+}
+
+func TestCollector_ScanOverrun(t *testing.T) {
+	engine := plcengine.NewEngine(make(chan plcengine.PLCValue, 10))
+	engine.ClientFactory = func(ip, amsID string, port int) (plcengine.ADSClient, error) {
+		return slowClient{plcengine.NewMockADSClient(ip), 30 * time.Millisecond}, nil
+	}
+	c := NewCollector(engine, streamer.NewHub())
+	require.NoError(t, c.Start(nil))
+	defer c.Stop()
+
+	require.NoError(t, c.AddMachine(MachineConfig{ID: "m1", IP: "127.0.0.1", Port: 851, Chambers: []ChamberConfig{
+		{ID: "c1", Name: "PM1", Symbols: []SymbolConfig{
+			{Name: "GVL.pressure", ScanRateMs: 10},
+			{Name: "GVL.recipe", ScanRateMs: 500},
+		}},
+	}}))
+
+	require.Eventually(t, func() bool {
+		stats := c.ScanStats()
+		return len(stats) == 2 && stats[0].Overruns >= 2 && stats[1].Reads >= 1
+	}, 3*time.Second, 20*time.Millisecond)
+
+	stats := c.ScanStats()
+	assert.Equal(t, "PM1/10ms", stats[0].Group)
+	assert.Equal(t, 1, stats[0].Symbols)
+	assert.GreaterOrEqual(t, stats[0].MaxDuration, 30*time.Millisecond)
+	assert.Equal(t, "PM1/500ms", stats[1].Group)
+	assert.Zero(t, stats[1].Overruns)
+}
@@ -1,6 +1,11 @@
 package collector
 
-import "time"
+import (
+    "math"
+    "time"
+
+    "fiber-backend/internal/plcengine"
+)
 
 type MachineConfig struct {
     ID        string          `json:"id" gorm:"primaryKey"`
@@ -8,9 +13,14 @@ type MachineConfig struct {
     IP        string          `json:"ip"`
     AmsNetID  string          `json:"ams_net_id"`
     Port      int             `json:"port"`
+    Gateway   string          `json:"gateway,omitempty"`
+    Protocol  string          `json:"protocol,omitempty"` // "ads" (default), "opcua" or "modbus"
     Chambers  []ChamberConfig `json:"chambers" gorm:"foreignKey:MachineID"`
     CreatedAt time.Time       `json:"created_at"`
     UpdatedAt time.Time       `json:"updated_at"`
+
+    // InfluxEnabled sends the machine's values to Collector.Influx too
+    InfluxEnabled bool `json:"influx_enabled,omitempty"`
 }
 
 type ChamberConfig struct {
@@ -18,6 +28,11 @@ type ChamberConfig struct {
     MachineID string         `json:"machine_id"`
     Name      string         `json:"name"`
     Symbols   []SymbolConfig `json:"symbols" gorm:"foreignKey:ChamberID"`
+
+    // Scan rate of the chamber's symbols, an explicit interval or a class
+    // of ScanRates; see ScanRates.ReadGroups
+    ScanRateMs int    `json:"scan_rate_ms,omitempty"`
+    ScanClass  string `json:"scan_class,omitempty"`
 }
 
 type SymbolConfig struct {
@@ -26,6 +41,83 @@ type SymbolConfig struct {
     Name      string `json:"name"` // PLC variable name (e.g., "GVL.temperature")
     DataType  string `json:"data_type"`
     Unit      string `json:"unit,omitempty"`
+
+    // Values outside [MinValue, MaxValue] are collected with bad quality,
+    // either bound may be left open
+    MinValue *float64 `json:"min_value,omitempty"`
+    MaxValue *float64 `json:"max_value,omitempty"`
+
+    // Writable symbols are on the machine's write allowlist, writes outside
+    // [WriteMin, WriteMax] are rejected. The bounds default to the valid range.
+    Writable bool     `json:"writable,omitempty"`
+    WriteMin *float64 `json:"write_min,omitempty"`
+    WriteMax *float64 `json:"write_max,omitempty"`
+
+    // Register map of the symbol on Modbus machines
+    Modbus *plcengine.ModbusRegister `json:"modbus,omitempty"`
+
+    // Reporting of polled values, see plcengine.ReportFilter. Without
+    // deadband or change-only every poll is reported.
+    Deadband        float64 `json:"deadband,omitempty"`         // absolute
+    DeadbandPercent float64 `json:"deadband_percent,omitempty"` // of the configured range, or of the last value
+    ChangeOnly      bool    `json:"change_only,omitempty"`
+    HeartbeatMs     int     `json:"heartbeat_ms,omitempty"` // report unchanged values again after this long
+
+    // Scan rate of the symbol, overrides the chamber's
+    ScanRateMs int    `json:"scan_rate_ms,omitempty"`
+    ScanClass  string `json:"scan_class,omitempty"`
+}
+
+// info is the configuration values are assessed against, see
+// plcengine.AssessQuality
+func (s SymbolConfig) info() plcengine.SymbolInfo {
+    info := plcengine.SymbolInfo{Name: s.Name, TypeName: s.DataType, Unit: s.Unit}
+    if s.MinValue == nil && s.MaxValue == nil {
+        return info
+    }
+    info.MinValue, info.MaxValue = math.Inf(-1), math.Inf(1)
+    if s.MinValue != nil {
+        info.MinValue = *s.MinValue
+    }
+    if s.MaxValue != nil {
+        info.MaxValue = *s.MaxValue
+    }
+    return info
+}
+
+// writeInfo is the write allowlist entry of the symbol, see
+// plcengine.MachineConfig.Symbols
+func (s SymbolConfig) writeInfo() plcengine.SymbolInfo {
+    info := s.info()
+    info.IsWritable = s.Writable
+    if s.WriteMin == nil && s.WriteMax == nil {
+        return info
+    }
+    info.WriteMin, info.WriteMax = info.MinValue, info.MaxValue
+    if info.WriteMin == info.WriteMax {
+        info.WriteMin, info.WriteMax = math.Inf(-1), math.Inf(1)
+    }
+    if s.WriteMin != nil {
+        info.WriteMin = *s.WriteMin
+    }
+    if s.WriteMax != nil {
+        info.WriteMax = *s.WriteMax
+    }
+    return info
+}
+
+// filter is the reporting filter of the symbol's polled values
+func (s SymbolConfig) filter() plcengine.ReportFilter {
+    f := plcengine.ReportFilter{
+        Deadband:        s.Deadband,
+        DeadbandPercent: s.DeadbandPercent,
+        ChangeOnly:      s.ChangeOnly,
+        Heartbeat:       time.Duration(s.HeartbeatMs) * time.Millisecond,
+    }
+    if s.MinValue != nil && s.MaxValue != nil {
+        f.Span = *s.MaxValue - *s.MinValue
+    }
+    return f
 }
 
 type PLCData struct {
@@ -33,7 +125,7 @@ type PLCData struct {
     ChamberID   string      `json:"chamber_id"`
     Symbol      string      `json:"symbol"`
     Value       interface{} `json:"value"`
-    Quality     int         `json:"quality"`
+    Quality     plcengine.Quality `json:"quality"`
     Timestamp   time.Time   `json:"timestamp"`
     SequenceNum uint64      `json:"sequence_num"`
 }
@@ -3,6 +3,8 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -10,6 +12,40 @@ import (
 type Config struct {
 	AppPort string
 	DBUrl   string
+
+	// PLCDriver is "ads" for real PLCs or "simulator" to run without
+	// hardware, PLCSimFile optionally describes the simulated signals
+	PLCDriver  string
+	PLCSimFile string
+
+	// PLCConfigFile is config.yaml, its plc section sets the default scan
+	// rate and the scan rate classes of the chamber pollers
+	PLCConfigFile string
+
+	// PLCDataConfigFile is plc_data_config.yaml, its recipe_fields describe
+	// the recipe runs the collector tracks
+	PLCDataConfigFile string
+
+	// PLCSourceNetID is the local AMS Net ID. With PLCRouteUser set the
+	// backend adds a route for it on every AMS router it connects to.
+	PLCSourceNetID   string
+	PLCRouteName     string
+	PLCRouteUser     string
+	PLCRoutePassword string
+
+	// KafkaBrokers is the comma separated KAFKA_BROKERS list, the collector
+	// sends PLC values to KafkaTopic
+	KafkaBrokers     []string
+	KafkaTopic       string
+	KafkaCompression string
+
+	// KafkaSpoolDir keeps the batches Kafka could not take until it is back,
+	// ./data/kafka-spool by default, "off" disables the spool.
+	// KafkaSpoolMaxMB caps the pending batches, disk use can exceed it by one
+	// 16 MB segment. KafkaSpoolFsync is always, interval or never.
+	KafkaSpoolDir   string
+	KafkaSpoolMaxMB int
+	KafkaSpoolFsync string
 }
 
 func Load() Config {
@@ -28,8 +64,51 @@ func Load() Config {
 			"?sslmode=disable"
 	}
 
+	driver := os.Getenv("PLC_DRIVER")
+	if driver == "" {
+		driver = "ads"
+	}
+
+	spoolDir := os.Getenv("KAFKA_SPOOL_DIR")
+	switch spoolDir {
+	case "":
+		spoolDir = "./data/kafka-spool"
+	case "off":
+		spoolDir = ""
+	}
+	spoolMaxMB, _ := strconv.Atoi(os.Getenv("KAFKA_SPOOL_MAX_MB"))
+
 	return Config{
-		AppPort: os.Getenv("APP_PORT"),
-		DBUrl:   db,
+		AppPort:    os.Getenv("APP_PORT"),
+		DBUrl:      db,
+		PLCDriver:  driver,
+		PLCSimFile: os.Getenv("PLC_SIM_FILE"),
+
+		PLCConfigFile:     os.Getenv("PLC_CONFIG_FILE"),
+		PLCDataConfigFile: os.Getenv("PLC_DATA_CONFIG_FILE"),
+
+		PLCSourceNetID:   os.Getenv("PLC_SOURCE_NET_ID"),
+		PLCRouteName:     os.Getenv("PLC_ROUTE_NAME"),
+		PLCRouteUser:     os.Getenv("PLC_ROUTE_USER"),
+		PLCRoutePassword: os.Getenv("PLC_ROUTE_PASSWORD"),
+
+		KafkaBrokers:     parseList(os.Getenv("KAFKA_BROKERS")),
+		KafkaTopic:       os.Getenv("KAFKA_TOPIC"),
+		KafkaCompression: os.Getenv("KAFKA_COMPRESSION"),
+
+		KafkaSpoolDir:   spoolDir,
+		KafkaSpoolMaxMB: spoolMaxMB,
+		KafkaSpoolFsync: os.Getenv("KAFKA_SPOOL_FSYNC"),
+	}
+}
+
+// parseList splits a comma separated list, dropping empty entries
+func parseList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
@@ -0,0 +1,12 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseList(t *testing.T) {
+	assert.Equal(t, []string{"k1:9092", "k2:9092"}, parseList(" k1:9092, ,k2:9092 "))
+	assert.Nil(t, parseList(""))
+}
@@ -8,6 +8,12 @@ import (
 )
 
 func ConnectInflux(url, token, username, password string) (influxdb2.Client, string, string) {
+	return ConnectInfluxWithOptions(url, token, username, password, influxdb2.DefaultOptions())
+}
+
+// ConnectInfluxWithOptions is ConnectInflux with client options, e.g. the
+// batching and retry of a WriteAPI
+func ConnectInfluxWithOptions(url, token, username, password string, options *influxdb2.Options) (influxdb2.Client, string, string) {
 	url = strings.TrimSpace(url)
 	token = strings.TrimSpace(token)
 	username = strings.TrimSpace(username)
@@ -29,6 +35,6 @@ func ConnectInflux(url, token, username, password string) (influxdb2.Client, str
 		masked = "too_short"
 	}
 
-	client := influxdb2.NewClient(url, token)
+	client := influxdb2.NewClientWithOptions(url, token, options)
 	return client, method, fmt.Sprintf("%s (len:%d)", masked, tLen)
 }
@@ -0,0 +1,242 @@
+package influxsink
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"fiber-backend/internal/plcengine"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// Measurement is the measurement the influx module and the exporter read
+const Measurement = "plc_data"
+
+// Config of the sink, zero values take the defaults below. Batching and
+// retry are done by the client's WriteAPI, see Options.
+type Config struct {
+	BatchSize     uint
+	FlushInterval time.Duration
+	// MaxRetries of a failed batch, RetryInterval is the first delay and
+	// grows exponentially up to MaxRetryInterval
+	MaxRetries       uint
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+	// RetryBufferLimit is how many points are kept for retries, the oldest
+	// are dropped beyond it
+	RetryBufferLimit uint
+	// QueueSize is how many chamber writes wait for the WriteAPI before
+	// new ones are dropped
+	QueueSize int
+}
+
+const (
+	DefaultBatchSize        = 5000
+	DefaultFlushInterval    = time.Second
+	DefaultMaxRetries       = 5
+	DefaultRetryInterval    = 5 * time.Second
+	DefaultMaxRetryInterval = 2 * time.Minute
+	DefaultRetryBufferLimit = 50000
+	DefaultQueueSize        = 1000
+)
+
+func (c Config) withDefaults() Config {
+	if c.BatchSize == 0 {
+		c.BatchSize = DefaultBatchSize
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = DefaultFlushInterval
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = DefaultMaxRetries
+	}
+	if c.RetryInterval <= 0 {
+		c.RetryInterval = DefaultRetryInterval
+	}
+	if c.MaxRetryInterval <= 0 {
+		c.MaxRetryInterval = DefaultMaxRetryInterval
+	}
+	if c.RetryBufferLimit == 0 {
+		c.RetryBufferLimit = DefaultRetryBufferLimit
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = DefaultQueueSize
+	}
+	return c
+}
+
+// Options are the client options with the batching and retry of cfg, the
+// client of a Sink must be created with them
+func (c Config) Options() *influxdb2.Options {
+	c = c.withDefaults()
+	return influxdb2.DefaultOptions().
+		SetBatchSize(c.BatchSize).
+		SetFlushInterval(uint(c.FlushInterval.Milliseconds())).
+		SetMaxRetries(c.MaxRetries).
+		SetRetryInterval(uint(c.RetryInterval.Milliseconds())).
+		SetMaxRetryInterval(uint(c.MaxRetryInterval.Milliseconds())).
+		SetRetryBufferLimit(c.RetryBufferLimit)
+}
+
+// Stats are the counters of the sink. Retries counts batch writes that
+// failed and are tried again, Failed the batches given up on after
+// MaxRetries and Errors all failed writes.
+type Stats struct {
+	Points  uint64 `json:"points"`
+	Dropped uint64 `json:"dropped"`
+	Retries uint64 `json:"retries"`
+	Failed  uint64 `json:"failed"`
+	Errors  uint64 `json:"errors"`
+}
+
+type chamberWrite struct {
+	machineID string
+	chamberID string
+	tags      map[string]string
+	values    []plcengine.PLCValue
+}
+
+// Sink writes collected values as plc_data points, one field per symbol,
+// tagged with machine_id, chamber_id, quality and the recipe context of the
+// chamber. Writes are queued so a slow InfluxDB never holds up the pollers.
+type Sink struct {
+	write api.WriteAPI
+	queue chan chamberWrite
+	done  chan struct{}
+	wg    sync.WaitGroup
+	once  sync.Once
+
+	points  atomic.Uint64
+	dropped atomic.Uint64
+	retries atomic.Uint64
+	failed  atomic.Uint64
+	errors  atomic.Uint64
+}
+
+// NewSink writes to org/bucket with client, which should be created with
+// cfg.Options()
+func NewSink(client influxdb2.Client, org, bucket string, cfg Config) *Sink {
+	cfg = cfg.withDefaults()
+	s := &Sink{
+		write: client.WriteAPI(org, bucket),
+		queue: make(chan chamberWrite, cfg.QueueSize),
+		done:  make(chan struct{}),
+	}
+	s.write.SetWriteFailedCallback(func(_ string, _ http.Error, attempts uint) bool {
+		// the WriteAPI drops the batch after its last attempt
+		if attempts < cfg.MaxRetries {
+			s.retries.Add(1)
+		} else {
+			s.failed.Add(1)
+		}
+		return true
+	})
+
+	s.wg.Add(2)
+	go s.run()
+	go s.logErrors()
+	return s
+}
+
+// Write queues the values of one chamber, tags are added to every point.
+// Values are dropped if the queue is full.
+func (s *Sink) Write(machineID, chamberID string, tags map[string]string, values []plcengine.PLCValue) {
+	if len(values) == 0 {
+		return
+	}
+	select {
+	case s.queue <- chamberWrite{machineID, chamberID, tags, values}:
+	default:
+		s.dropped.Add(uint64(len(values)))
+	}
+}
+
+func (s *Sink) run() {
+	defer s.wg.Done()
+	for {
+		select {
+		case w := <-s.queue:
+			s.writePoints(w)
+		case <-s.done:
+			for {
+				select {
+				case w := <-s.queue:
+					s.writePoints(w)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Sink) writePoints(w chamberWrite) {
+	for _, v := range w.values {
+		if v.Value == nil {
+			continue
+		}
+		s.write.WritePoint(Point(w.machineID, w.chamberID, w.tags, v))
+		s.points.Add(1)
+	}
+}
+
+// logErrors logs write errors at most every ten seconds
+func (s *Sink) logErrors() {
+	defer s.wg.Done()
+	var last time.Time
+	var suppressed int
+	errs := s.write.Errors()
+	for {
+		select {
+		case err := <-errs:
+			s.errors.Add(1)
+			if time.Since(last) < 10*time.Second {
+				suppressed++
+				continue
+			}
+			log.Printf("InfluxDB sink: write failed: %v (%d more errors)", err, suppressed)
+			last, suppressed = time.Now(), 0
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Point is the plc_data point of a collected value
+func Point(machineID, chamberID string, tags map[string]string, v plcengine.PLCValue) *write.Point {
+	pointTags := map[string]string{
+		"machine_id": machineID,
+		"chamber_id": chamberID,
+		"quality":    string(v.Quality),
+	}
+	for k, t := range tags {
+		if t != "" {
+			pointTags[k] = t
+		}
+	}
+	return influxdb2.NewPoint(Measurement, pointTags, map[string]interface{}{v.Symbol: v.Value}, v.Timestamp)
+}
+
+func (s *Sink) Stats() Stats {
+	return Stats{
+		Points:  s.points.Load(),
+		Dropped: s.dropped.Load(),
+		Retries: s.retries.Load(),
+		Failed:  s.failed.Load(),
+		Errors:  s.errors.Load(),
+	}
+}
+
+// Close writes the queued values and flushes the WriteAPI
+func (s *Sink) Close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+		s.write.Flush()
+	})
+}
@@ -0,0 +1,102 @@
+package influxsink
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fiber-backend/internal/plcengine"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeInflux accepts writes after failing the first ones
+type fakeInflux struct {
+	mu    sync.Mutex
+	fail  int
+	calls int
+	lines []string
+}
+
+func (f *fakeInflux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/v2/write" {
+		http.NotFound(w, r)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.fail > 0 {
+		f.fail--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	for _, l := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+		f.lines = append(f.lines, l)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeInflux) written() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.lines...)
+}
+
+func newSink(t *testing.T, fake *fakeInflux, cfg Config) *Sink {
+	t.Helper()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	client := influxdb2.NewClientWithOptions(srv.URL, "token", cfg.Options())
+	t.Cleanup(client.Close)
+	return NewSink(client, "org", "bucket", cfg)
+}
+
+func TestSink_Write(t *testing.T) {
+	fake := &fakeInflux{}
+	s := newSink(t, fake, Config{FlushInterval: 20 * time.Millisecond})
+
+	ts := time.Unix(1700000000, 0)
+	s.Write("m1", "c1", map[string]string{"recipe_id": "R7", "step": ""}, []plcengine.PLCValue{
+		{Symbol: "Temp", Value: 21.5, Quality: plcengine.QualityGood, Timestamp: ts},
+		{Symbol: "Valve", Value: true, Quality: plcengine.QualityBad, Timestamp: ts},
+		{Symbol: "Never", Value: nil, Quality: plcengine.QualityBad, Timestamp: ts},
+	})
+	s.Close()
+
+	assert.ElementsMatch(t, []string{
+		"plc_data,chamber_id=c1,machine_id=m1,quality=good,recipe_id=R7 Temp=21.5 1700000000000000000",
+		"plc_data,chamber_id=c1,machine_id=m1,quality=bad,recipe_id=R7 Valve=true 1700000000000000000",
+	}, fake.written())
+	assert.Equal(t, uint64(2), s.Stats().Points)
+}
+
+func TestSink_Retry(t *testing.T) {
+	fake := &fakeInflux{fail: 1}
+	s := newSink(t, fake, Config{FlushInterval: 20 * time.Millisecond, RetryInterval: 20 * time.Millisecond})
+
+	value := func(v float64) []plcengine.PLCValue {
+		return []plcengine.PLCValue{{Symbol: "Temp", Value: v, Quality: plcengine.QualityGood, Timestamp: time.Unix(1700000000, int64(v))}}
+	}
+	s.Write("m1", "c1", nil, value(1))
+	require.Eventually(t, func() bool { return s.Stats().Retries == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	// the failed batch is retried ahead of the next one
+	time.Sleep(30 * time.Millisecond)
+	s.Write("m1", "c1", nil, value(2))
+	require.Eventually(t, func() bool { return len(fake.written()) == 2 }, 2*time.Second, 10*time.Millisecond)
+	s.Close()
+
+	assert.Equal(t, []string{
+		"plc_data,chamber_id=c1,machine_id=m1,quality=good Temp=1 1700000000000000001",
+		"plc_data,chamber_id=c1,machine_id=m1,quality=good Temp=2 1700000000000000002",
+	}, fake.written())
+	assert.Equal(t, uint64(0), s.Stats().Failed)
+}
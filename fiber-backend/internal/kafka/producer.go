@@ -1,7 +1,14 @@
 package kafka
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
 )
 
 type Message struct {
@@ -14,29 +21,207 @@ type Producer interface {
 	Close() error
 }
 
+// Config of the producer, zero values take the defaults below
+type Config struct {
+	Brokers  []string
+	Topic    string
+	ClientID string
+
+	// Compression is zstd, lz4, snappy, gzip or none
+	Compression string
+	// Linger is how long a partition batch waits for more records
+	Linger time.Duration
+	// BatchBytes caps the size of a partition batch
+	BatchBytes int32
+	// Timeout is how long a record may take to be acknowledged, including
+	// retries, before it is reported as failed, at least a second. The
+	// collector waits this long for the first batch of a Kafka outage
+	// before it spools, so it is kept short.
+	Timeout time.Duration
+}
+
+const (
+	DefaultBroker      = "localhost:9092"
+	DefaultTopic       = "plc-data"
+	DefaultClientID    = "fiber-backend"
+	DefaultCompression = "zstd"
+	DefaultLinger      = 5 * time.Millisecond
+	DefaultBatchBytes  = 1 << 20
+	DefaultTimeout     = 2 * time.Second
+)
+
+func (c Config) withDefaults() Config {
+	if len(c.Brokers) == 0 {
+		c.Brokers = []string{DefaultBroker}
+	}
+	if c.Topic == "" {
+		c.Topic = DefaultTopic
+	}
+	if c.ClientID == "" {
+		c.ClientID = DefaultClientID
+	}
+	if c.Compression == "" {
+		c.Compression = DefaultCompression
+	}
+	if c.Linger <= 0 {
+		c.Linger = DefaultLinger
+	}
+	if c.BatchBytes <= 0 {
+		c.BatchBytes = DefaultBatchBytes
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultTimeout
+	}
+	return c
+}
+
+func compressionCodec(name string) (kgo.CompressionCodec, error) {
+	switch strings.ToLower(name) {
+	case "zstd":
+		return kgo.ZstdCompression(), nil
+	case "lz4":
+		return kgo.Lz4Compression(), nil
+	case "snappy":
+		return kgo.SnappyCompression(), nil
+	case "gzip":
+		return kgo.GzipCompression(), nil
+	case "none":
+		return kgo.NoCompression(), nil
+	}
+	return kgo.CompressionCodec{}, fmt.Errorf("unknown kafka compression %q", name)
+}
+
+// DeliveryError reports the messages of a batch that were not acknowledged,
+// Err is the error of the first one
+type DeliveryError struct {
+	Failed []Message
+	Total  int
+	Err    error
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("kafka: %d of %d messages not delivered: %v", len(e.Failed), e.Total, e.Err)
+}
+
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// ProducerStats are the delivery counters since the producer was created
+type ProducerStats struct {
+	Delivered uint64 `json:"delivered"`
+	Failed    uint64 `json:"failed"`
+}
+
+// KafkaProducer writes messages to one topic with idempotent, acks=all
+// delivery. Messages with the same key (machine and symbol) always go to the
+// same partition, so they stay in order.
 type KafkaProducer struct {
-	broker string
+	client *kgo.Client
 	topic  string
+
+	delivered atomic.Uint64
+	failed    atomic.Uint64
 }
 
-func NewProducer(broker, topic string) Producer {
-	return &KafkaProducer{
-		broker: broker,
-		topic:  topic,
+var _ Producer = (*KafkaProducer)(nil)
+
+// NewProducer creates the client, brokers are connected lazily so a broker
+// that is down only shows up as delivery errors
+func NewProducer(cfg Config) (*KafkaProducer, error) {
+	cfg = cfg.withDefaults()
+	codec, err := compressionCodec(cfg.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(cfg.Brokers...),
+		kgo.ClientID(cfg.ClientID),
+		kgo.DefaultProduceTopic(cfg.Topic),
+		// idempotent writes are the client default and need acks=all
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+		kgo.ProducerLinger(cfg.Linger),
+		kgo.ProducerBatchCompression(codec),
+		kgo.ProducerBatchMaxBytes(cfg.BatchBytes),
+		kgo.RecordDeliveryTimeout(cfg.Timeout),
+		kgo.RecordPartitioner(kgo.StickyKeyPartitioner(nil)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("kafka client: %w", err)
 	}
+	return &KafkaProducer{client: client, topic: cfg.Topic}, nil
 }
 
+// ProduceBatch sends all messages and waits until each one is acknowledged
+// or failed, failures are returned as a *DeliveryError
 func (p *KafkaProducer) ProduceBatch(messages []Message) error {
-	// In a real implementation, this would send to Kafka
-	// For now, we simulate the production
-	for _, msg := range messages {
-		var data interface{}
-		json.Unmarshal(msg.Value, &data)
-		// log.Printf("Producing to %s: %s", p.topic, string(msg.Key))
+	if len(messages) == 0 {
+		return nil
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errs   = make([]error, len(messages))
+		failed bool
+	)
+	wg.Add(len(messages))
+	for i, m := range messages {
+		rec := &kgo.Record{Topic: p.topic, Key: m.Key, Value: m.Value}
+		p.client.Produce(context.Background(), rec, func(_ *kgo.Record, err error) {
+			defer wg.Done()
+			if err == nil {
+				p.delivered.Add(1)
+				return
+			}
+			p.failed.Add(1)
+			mu.Lock()
+			errs[i] = err
+			failed = true
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+
+	if !failed {
+		return nil
+	}
+	derr := &DeliveryError{Total: len(messages)}
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if derr.Err == nil {
+			derr.Err = err
+		}
+		derr.Failed = append(derr.Failed, messages[i])
+	}
+	return derr
+}
+
+func (p *KafkaProducer) Stats() ProducerStats {
+	return ProducerStats{
+		Delivered: p.delivered.Load(),
+		Failed:    p.failed.Load(),
 	}
-	return nil
 }
 
+// Close flushes buffered messages for at most five seconds
 func (p *KafkaProducer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := p.client.Flush(ctx)
+	p.client.Close()
+	if err != nil {
+		return fmt.Errorf("kafka: flush on close: %w", err)
+	}
 	return nil
 }
+
+// NopProducer drops all messages, it is the producer of a collector that
+// has no Kafka configured
+type NopProducer struct{}
+
+func (NopProducer) ProduceBatch([]Message) error { return nil }
+func (NopProducer) Close() error                 { return nil }
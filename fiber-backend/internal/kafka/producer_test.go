@@ -0,0 +1,128 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func newCluster(t *testing.T) *kfake.Cluster {
+	t.Helper()
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(3, "plc-data"))
+	require.NoError(t, err)
+	t.Cleanup(cluster.Close)
+	return cluster
+}
+
+// consume reads n records of the plc-data topic from the start
+func consume(t *testing.T, brokers []string, n int) []*kgo.Record {
+	t.Helper()
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(brokers...),
+		kgo.ConsumeTopics("plc-data"),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+	)
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var records []*kgo.Record
+	for len(records) < n {
+		fetches := client.PollFetches(ctx)
+		require.NoError(t, ctx.Err(), "consumed %d of %d records", len(records), n)
+		fetches.EachRecord(func(r *kgo.Record) { records = append(records, r) })
+	}
+	return records
+}
+
+func TestProducer_ProduceBatch(t *testing.T) {
+	cluster := newCluster(t)
+	brokers := cluster.ListenAddrs()
+
+	p, err := NewProducer(Config{Brokers: brokers, Compression: "lz4"})
+	require.NoError(t, err)
+	defer p.Close()
+
+	var batch []Message
+	for i := 0; i < 60; i++ {
+		batch = append(batch, Message{
+			Key:   []byte(fmt.Sprintf("m%d-Temp", i%6)),
+			Value: []byte(fmt.Sprintf("%d", i)),
+		})
+	}
+	require.NoError(t, p.ProduceBatch(batch))
+	assert.Equal(t, ProducerStats{Delivered: 60}, p.Stats())
+
+	records := consume(t, brokers, 60)
+	require.Len(t, records, 60)
+
+	// one partition per key, values of a key in produce order
+	partitions := make(map[string]int32)
+	values := make(map[string][]string)
+	used := make(map[int32]bool)
+	for _, r := range records {
+		key := string(r.Key)
+		if p, ok := partitions[key]; ok {
+			assert.Equal(t, p, r.Partition, "key %s", key)
+		}
+		partitions[key] = r.Partition
+		values[key] = append(values[key], string(r.Value))
+		used[r.Partition] = true
+	}
+	assert.Len(t, partitions, 6)
+	assert.Greater(t, len(used), 1, "keys should spread over partitions")
+	assert.Equal(t, []string{"0", "6", "12", "18", "24", "30", "36", "42", "48", "54"}, values["m0-Temp"])
+}
+
+func TestProducer_DeliveryError(t *testing.T) {
+	cluster := newCluster(t)
+
+	p, err := NewProducer(Config{
+		Brokers: cluster.ListenAddrs(),
+		Topic:   "missing",
+		Timeout: time.Second,
+	})
+	require.NoError(t, err)
+	defer p.Close()
+
+	batch := []Message{{Key: []byte("m1-Temp"), Value: []byte("1")}, {Key: []byte("m1-Pres"), Value: []byte("2")}}
+	err = p.ProduceBatch(batch)
+	require.Error(t, err)
+
+	var derr *DeliveryError
+	require.True(t, errors.As(err, &derr))
+	assert.Equal(t, 2, derr.Total)
+	assert.Equal(t, batch, derr.Failed)
+	assert.Equal(t, ProducerStats{Failed: 2}, p.Stats())
+}
+
+func TestProducer_BrokerDown(t *testing.T) {
+	p, err := NewProducer(Config{Brokers: []string{"127.0.0.1:1"}})
+	require.NoError(t, err)
+	defer p.client.Close()
+
+	// a batch fails fast enough for the collector to spool it
+	start := time.Now()
+	require.Error(t, p.ProduceBatch([]Message{{Key: []byte("m1-Temp"), Value: []byte("1")}}))
+	assert.Less(t, time.Since(start), DefaultTimeout+time.Second)
+}
+
+func TestNewProducer_Compression(t *testing.T) {
+	_, err := NewProducer(Config{Compression: "brotli"})
+	assert.Error(t, err)
+
+	for _, c := range []string{"", "zstd", "lz4", "snappy", "gzip", "none"} {
+		p, err := NewProducer(Config{Compression: c})
+		require.NoError(t, err, c)
+		p.client.Close()
+	}
+}
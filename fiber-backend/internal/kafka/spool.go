@@ -0,0 +1,728 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FsyncPolicy is when spooled batches are synced to disk
+type FsyncPolicy string
+
+const (
+	FsyncAlways   FsyncPolicy = "always"   // after every batch
+	FsyncInterval FsyncPolicy = "interval" // every FsyncInterval
+	FsyncNever    FsyncPolicy = "never"    // left to the OS
+)
+
+var ErrSpoolFull = errors.New("kafka spool full")
+
+// SpoolConfig of the disk spool, zero values take the defaults below
+type SpoolConfig struct {
+	Dir string
+	// SegmentBytes is the size at which a new segment file is started
+	SegmentBytes int64
+	// MaxBytes caps the spooled data, batches beyond it are dropped. Only
+	// batches not acknowledged yet count: acknowledged ones stay on disk
+	// until their segment is drained, so disk use can exceed MaxBytes by
+	// up to SegmentBytes.
+	MaxBytes      int64
+	Fsync         FsyncPolicy
+	FsyncInterval time.Duration
+	// AlertPercent of MaxBytes calls OnNearCap
+	AlertPercent float64
+}
+
+const (
+	DefaultSegmentBytes  = 16 << 20
+	DefaultSpoolMaxBytes = 1 << 30
+	DefaultFsyncInterval = time.Second
+	DefaultAlertPercent  = 80
+)
+
+func (c SpoolConfig) withDefaults() SpoolConfig {
+	if c.SegmentBytes <= 0 {
+		c.SegmentBytes = DefaultSegmentBytes
+	}
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = DefaultSpoolMaxBytes
+	}
+	if c.Fsync == "" {
+		c.Fsync = FsyncInterval
+	}
+	if c.FsyncInterval <= 0 {
+		c.FsyncInterval = DefaultFsyncInterval
+	}
+	if c.AlertPercent <= 0 {
+		c.AlertPercent = DefaultAlertPercent
+	}
+	return c
+}
+
+// SpoolStats are the depth and age of the spool, Dropped counts batches
+// rejected because the spool was full. Bytes are the batches not
+// acknowledged yet, see SpoolConfig.MaxBytes.
+type SpoolStats struct {
+	Batches  int           `json:"batches"`
+	Messages int           `json:"messages"`
+	Bytes    int64         `json:"bytes"`
+	MaxBytes int64         `json:"max_bytes"`
+	Segments int           `json:"segments"`
+	Oldest   time.Time     `json:"oldest"`
+	Age      time.Duration `json:"age"`
+	Dropped  uint64        `json:"dropped"`
+}
+
+func (s SpoolStats) UsedPercent() float64 {
+	if s.MaxBytes <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / float64(s.MaxBytes) * 100
+}
+
+const (
+	segmentExt   = ".seg"
+	cursorFile   = "cursor"
+	recordHeader = 8 // payload length, crc32 of the payload
+)
+
+// spoolEntry is the position of a spooled batch
+type spoolEntry struct {
+	seg    uint64
+	offset int64
+	size   int64
+	count  int
+	at     time.Time
+}
+
+// Spool is a write-ahead queue of message batches in segment files. Batches
+// are read back in the order they were appended; the cursor file keeps the
+// position of the oldest batch not acknowledged yet across restarts.
+type Spool struct {
+	cfg SpoolConfig
+
+	// OnNearCap is called once when the spool fills past AlertPercent, and
+	// again after it drained below it. Set it before the first Append.
+	OnNearCap func(SpoolStats)
+
+	mu       sync.Mutex
+	entries  []spoolEntry
+	segments []uint64
+	nextSeq  uint64
+	w        *os.File
+	wSize    int64
+	bytes    int64
+	messages int
+	dropped  uint64
+	dirty    bool
+	nearCap  bool
+	closed   bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// OpenSpool opens or creates the spool in cfg.Dir. Records torn by a crash
+// are cut off the end of their segment.
+func OpenSpool(cfg SpoolConfig) (*Spool, error) {
+	cfg = cfg.withDefaults()
+	switch cfg.Fsync {
+	case FsyncAlways, FsyncInterval, FsyncNever:
+	default:
+		return nil, fmt.Errorf("unknown spool fsync policy %q", cfg.Fsync)
+	}
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("spool dir required")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &Spool{cfg: cfg, nextSeq: 1, stop: make(chan struct{})}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if cfg.Fsync == FsyncInterval {
+		s.wg.Add(1)
+		go s.syncLoop()
+	}
+	return s, nil
+}
+
+func (s *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.cfg.Dir, fmt.Sprintf("%016d%s", seq, segmentExt))
+}
+
+func (s *Spool) load() error {
+	names, err := filepath.Glob(filepath.Join(s.cfg.Dir, "*"+segmentExt))
+	if err != nil {
+		return err
+	}
+	var seqs []uint64
+	for _, name := range names {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	headSeq, headOffset, err := s.readCursor()
+	if err != nil {
+		return err
+	}
+
+	for _, seq := range seqs {
+		s.nextSeq = seq + 1
+		if seq < headSeq {
+			// fully replayed before a restart
+			if err := os.Remove(s.segmentPath(seq)); err != nil {
+				return err
+			}
+			continue
+		}
+		start := int64(0)
+		if seq == headSeq {
+			start = headOffset
+		}
+		n, err := s.scanSegment(seq, start)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			if err := os.Remove(s.segmentPath(seq)); err != nil {
+				return err
+			}
+			continue
+		}
+		s.segments = append(s.segments, seq)
+	}
+	if err := s.writeCursorLocked(); err != nil {
+		return err
+	}
+
+	if len(s.segments) == 0 {
+		return nil
+	}
+	last := s.segments[len(s.segments)-1]
+	f, err := os.OpenFile(s.segmentPath(last), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.w, s.wSize = f, st.Size()
+	return nil
+}
+
+// scanSegment indexes the records of a segment from start on and returns
+// how many it found
+func (s *Spool) scanSegment(seq uint64, start int64) (int, error) {
+	path := s.segmentPath(seq)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	found := 0
+	offset := start
+	for offset < int64(len(b)) {
+		size, at, count, err := decodeHeader(b[offset:])
+		if err != nil {
+			log.Printf("Kafka spool: %s: %v at offset %d, truncating", path, err, offset)
+			if err := os.Truncate(path, offset); err != nil {
+				return 0, err
+			}
+			break
+		}
+		found++
+		s.entries = append(s.entries, spoolEntry{seq, offset, size, count, at})
+		s.bytes += size
+		s.messages += count
+		offset += size
+	}
+	return found, nil
+}
+
+func (s *Spool) readCursor() (uint64, int64, error) {
+	b, err := os.ReadFile(filepath.Join(s.cfg.Dir, cursorFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	var seq uint64
+	var offset int64
+	if _, err := fmt.Sscanf(string(b), "%d %d", &seq, &offset); err != nil {
+		return 0, 0, fmt.Errorf("spool cursor: %w", err)
+	}
+	return seq, offset, nil
+}
+
+func (s *Spool) writeCursorLocked() error {
+	path := filepath.Join(s.cfg.Dir, cursorFile)
+	if len(s.entries) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	head := s.entries[0]
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%d %d", head.seg, head.offset); err != nil {
+		f.Close()
+		return err
+	}
+	if s.cfg.Fsync == FsyncAlways {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Append spools a batch, ErrSpoolFull if it would exceed MaxBytes
+func (s *Spool) Append(batch []Message) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	now := time.Now()
+	rec := encodeRecord(batch, now)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return fmt.Errorf("kafka spool closed")
+	}
+	if s.bytes+int64(len(rec)) > s.cfg.MaxBytes {
+		s.dropped++
+		s.mu.Unlock()
+		return ErrSpoolFull
+	}
+	if err := s.appendLocked(rec, len(batch), now); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	alert, stats := s.checkCapLocked()
+	s.mu.Unlock()
+
+	if alert && s.OnNearCap != nil {
+		s.OnNearCap(stats)
+	}
+	return nil
+}
+
+func (s *Spool) appendLocked(rec []byte, count int, at time.Time) error {
+	if s.w == nil || s.wSize >= s.cfg.SegmentBytes {
+		if err := s.rollLocked(); err != nil {
+			return err
+		}
+	}
+	if err := s.writeLocked(rec); err != nil {
+		s.discardLocked()
+		return err
+	}
+
+	s.entries = append(s.entries, spoolEntry{
+		seg:    s.segments[len(s.segments)-1],
+		offset: s.wSize,
+		size:   int64(len(rec)),
+		count:  count,
+		at:     at,
+	})
+	s.wSize += int64(len(rec))
+	s.bytes += int64(len(rec))
+	s.messages += count
+	if len(s.entries) == 1 {
+		return s.writeCursorLocked()
+	}
+	return nil
+}
+
+func (s *Spool) writeLocked(rec []byte) error {
+	if _, err := s.w.Write(rec); err != nil {
+		return err
+	}
+	if s.cfg.Fsync == FsyncAlways {
+		return s.w.Sync()
+	}
+	s.dirty = true
+	return nil
+}
+
+// discardLocked cuts what a failed write left behind wSize, the next record
+// has to start there. If that fails too the next record goes to a new
+// segment, a restart truncates the torn record.
+func (s *Spool) discardLocked() {
+	err := s.w.Truncate(s.wSize)
+	if err == nil {
+		return
+	}
+	log.Printf("Kafka spool: truncating segment %d: %v, starting a new one", s.segments[len(s.segments)-1], err)
+	s.w.Close()
+	s.w = nil
+}
+
+// rollLocked closes the write segment and starts the next one
+func (s *Spool) rollLocked() error {
+	if s.w != nil {
+		if err := s.w.Sync(); err != nil {
+			return err
+		}
+		if err := s.w.Close(); err != nil {
+			return err
+		}
+		s.w = nil
+	}
+	seq := s.nextSeq
+	f, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.nextSeq++
+	s.segments = append(s.segments, seq)
+	s.w, s.wSize, s.dirty = f, 0, false
+	return nil
+}
+
+// checkCapLocked reports whether the near cap alert should fire now
+func (s *Spool) checkCapLocked() (bool, SpoolStats) {
+	stats := s.statsLocked()
+	near := stats.UsedPercent() >= s.cfg.AlertPercent
+	fire := near && !s.nearCap
+	s.nearCap = near
+	return fire, stats
+}
+
+// Peek returns the oldest batch, ok is false if the spool is empty
+func (s *Spool) Peek() (batch []Message, ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.entries) == 0 {
+		return nil, false, nil
+	}
+	head := s.entries[0]
+
+	f, err := os.Open(s.segmentPath(head.seg))
+	if err != nil {
+		return nil, true, err
+	}
+	defer f.Close()
+	rec := make([]byte, head.size)
+	if _, err := f.ReadAt(rec, head.offset); err != nil {
+		return nil, true, err
+	}
+	batch, err = decodeRecord(rec)
+	return batch, true, err
+}
+
+// Ack drops the oldest batch after it was delivered, segments are removed
+// once all of their batches are
+func (s *Spool) Ack() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.entries) == 0 {
+		return nil
+	}
+	head := s.entries[0]
+	s.entries = s.entries[1:]
+	s.bytes -= head.size
+	s.messages -= head.count
+	if s.statsLocked().UsedPercent() < s.cfg.AlertPercent {
+		s.nearCap = false
+	}
+
+	if len(s.entries) == 0 || s.entries[0].seg != head.seg {
+		if head.seg == s.segments[len(s.segments)-1] && s.w != nil {
+			if err := s.w.Close(); err != nil {
+				return err
+			}
+			s.w, s.wSize = nil, 0
+		}
+		s.segments = s.segments[1:]
+		if err := os.Remove(s.segmentPath(head.seg)); err != nil {
+			return err
+		}
+	}
+	return s.writeCursorLocked()
+}
+
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+func (s *Spool) Stats() SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.statsLocked()
+}
+
+func (s *Spool) statsLocked() SpoolStats {
+	stats := SpoolStats{
+		Batches:  len(s.entries),
+		Messages: s.messages,
+		Bytes:    s.bytes,
+		MaxBytes: s.cfg.MaxBytes,
+		Segments: len(s.segments),
+		Dropped:  s.dropped,
+	}
+	if len(s.entries) > 0 {
+		stats.Oldest = s.entries[0].at
+		stats.Age = time.Since(stats.Oldest)
+	}
+	return stats
+}
+
+func (s *Spool) syncLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.cfg.FsyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.dirty && s.w != nil {
+				if err := s.w.Sync(); err != nil {
+					log.Printf("Kafka spool: fsync: %v", err)
+				}
+				s.dirty = false
+			}
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.stop)
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil {
+		return nil
+	}
+	if err := s.w.Sync(); err != nil {
+		s.w.Close()
+		return err
+	}
+	return s.w.Close()
+}
+
+// A record is the header (payload length, crc32) and the payload: the spool
+// time in unix nanoseconds, the message count and the length prefixed key
+// and value of every message.
+func encodeRecord(batch []Message, at time.Time) []byte {
+	size := recordHeader + 12
+	for _, m := range batch {
+		size += 8 + len(m.Key) + len(m.Value)
+	}
+	rec := make([]byte, recordHeader, size)
+	rec = binary.BigEndian.AppendUint64(rec, uint64(at.UnixNano()))
+	rec = binary.BigEndian.AppendUint32(rec, uint32(len(batch)))
+	for _, m := range batch {
+		rec = binary.BigEndian.AppendUint32(rec, uint32(len(m.Key)))
+		rec = append(rec, m.Key...)
+		rec = binary.BigEndian.AppendUint32(rec, uint32(len(m.Value)))
+		rec = append(rec, m.Value...)
+	}
+	binary.BigEndian.PutUint32(rec[0:], uint32(len(rec)-recordHeader))
+	binary.BigEndian.PutUint32(rec[4:], crc32.ChecksumIEEE(rec[recordHeader:]))
+	return rec
+}
+
+// decodeHeader checks the record at the start of b and returns its size
+func decodeHeader(b []byte) (size int64, at time.Time, count int, err error) {
+	if len(b) < recordHeader+12 {
+		return 0, at, 0, io.ErrUnexpectedEOF
+	}
+	n := int64(binary.BigEndian.Uint32(b[0:]))
+	if n < 12 || int64(len(b)) < recordHeader+n {
+		return 0, at, 0, io.ErrUnexpectedEOF
+	}
+	payload := b[recordHeader : recordHeader+n]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(b[4:]) {
+		return 0, at, 0, fmt.Errorf("checksum mismatch")
+	}
+	at = time.Unix(0, int64(binary.BigEndian.Uint64(payload)))
+	count = int(binary.BigEndian.Uint32(payload[8:]))
+	return recordHeader + n, at, count, nil
+}
+
+func decodeRecord(rec []byte) ([]Message, error) {
+	if _, _, _, err := decodeHeader(rec); err != nil {
+		return nil, err
+	}
+	payload := rec[recordHeader:]
+	count := int(binary.BigEndian.Uint32(payload[8:]))
+	p := payload[12:]
+
+	next := func() ([]byte, error) {
+		if len(p) < 4 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		n := int(binary.BigEndian.Uint32(p))
+		if len(p) < 4+n {
+			return nil, io.ErrUnexpectedEOF
+		}
+		b := p[4 : 4+n]
+		p = p[4+n:]
+		return b, nil
+	}
+	batch := make([]Message, count)
+	for i := range batch {
+		key, err := next()
+		if err != nil {
+			return nil, err
+		}
+		value, err := next()
+		if err != nil {
+			return nil, err
+		}
+		batch[i] = Message{Key: key, Value: value}
+	}
+	return batch, nil
+}
+
+// SpoolingProducer spools the batches its producer fails to deliver and
+// replays them in order every retry interval until they are delivered.
+// While the spool is not empty new batches are spooled behind them. Replay
+// is at least once: a batch that was partly delivered is sent again.
+type SpoolingProducer struct {
+	producer Producer
+	spool    *Spool
+	retry    time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+var _ Producer = (*SpoolingProducer)(nil)
+
+func NewSpoolingProducer(producer Producer, spool *Spool, retry time.Duration) *SpoolingProducer {
+	if retry <= 0 {
+		retry = time.Second
+	}
+	p := &SpoolingProducer{
+		producer: producer,
+		spool:    spool,
+		retry:    retry,
+		stop:     make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.replayLoop()
+	return p
+}
+
+// ProduceBatch only fails if a batch could neither be delivered nor spooled
+func (p *SpoolingProducer) ProduceBatch(messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	if p.spool.Len() > 0 {
+		return p.spool.Append(messages)
+	}
+
+	err := p.producer.ProduceBatch(messages)
+	if err == nil {
+		return nil
+	}
+	failed := messages
+	var derr *DeliveryError
+	if errors.As(err, &derr) {
+		failed = derr.Failed
+	}
+	if serr := p.spool.Append(failed); serr != nil {
+		return fmt.Errorf("%w (spool: %v)", err, serr)
+	}
+	log.Printf("Kafka unavailable, spooling to disk: %v", err)
+	return nil
+}
+
+func (p *SpoolingProducer) replayLoop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.retry)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.replay()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// replay sends spooled batches oldest first until one fails
+func (p *SpoolingProducer) replay() {
+	replayed := 0
+	for {
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+		batch, ok, err := p.spool.Peek()
+		if !ok {
+			if replayed > 0 {
+				log.Printf("Kafka spool drained, %d batches replayed", replayed)
+			}
+			return
+		}
+		if err != nil {
+			log.Printf("Kafka spool: skipping unreadable batch: %v", err)
+		} else if err := p.producer.ProduceBatch(batch); err != nil {
+			return
+		}
+		if err := p.spool.Ack(); err != nil {
+			log.Printf("Kafka spool: %v", err)
+			return
+		}
+		replayed++
+	}
+}
+
+func (p *SpoolingProducer) Stats() SpoolStats {
+	return p.spool.Stats()
+}
+
+// Close stops replaying and closes the producer and the spool, batches
+// still spooled are replayed after the next start
+func (p *SpoolingProducer) Close() error {
+	close(p.stop)
+	p.wg.Wait()
+	return errors.Join(p.producer.Close(), p.spool.Close())
+}
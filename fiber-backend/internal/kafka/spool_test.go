@@ -0,0 +1,220 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testBatch(n, from int) []Message {
+	batch := make([]Message, n)
+	for i := range batch {
+		batch[i] = Message{
+			Key:   []byte(fmt.Sprintf("m1-S%d", i)),
+			Value: []byte(fmt.Sprintf("%d", from+i)),
+		}
+	}
+	return batch
+}
+
+// drain reads and acks every spooled batch
+func drain(t *testing.T, s *Spool) [][]Message {
+	t.Helper()
+	var out [][]Message
+	for {
+		batch, ok, err := s.Peek()
+		require.NoError(t, err)
+		if !ok {
+			return out
+		}
+		out = append(out, batch)
+		require.NoError(t, s.Ack())
+	}
+}
+
+func TestSpool_OrderAcrossSegments(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool(SpoolConfig{Dir: dir, SegmentBytes: 200, Fsync: FsyncAlways})
+	require.NoError(t, err)
+	defer s.Close()
+
+	var want [][]Message
+	for i := 0; i < 10; i++ {
+		batch := testBatch(3, i*3)
+		want = append(want, batch)
+		require.NoError(t, s.Append(batch))
+	}
+	stats := s.Stats()
+	assert.Equal(t, 10, stats.Batches)
+	assert.Equal(t, 30, stats.Messages)
+	assert.Greater(t, stats.Segments, 1)
+	assert.False(t, stats.Oldest.IsZero())
+
+	assert.Equal(t, want, drain(t, s))
+	assert.Equal(t, SpoolStats{MaxBytes: DefaultSpoolMaxBytes}, s.Stats())
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	assert.Empty(t, segments)
+	assert.NoFileExists(t, filepath.Join(dir, cursorFile))
+}
+
+func TestSpool_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	cfg := SpoolConfig{Dir: dir, SegmentBytes: 200, Fsync: FsyncNever}
+	s, err := OpenSpool(cfg)
+	require.NoError(t, err)
+	for i := 0; i < 6; i++ {
+		require.NoError(t, s.Append(testBatch(3, i*3)))
+	}
+	// two batches were delivered before the restart
+	for i := 0; i < 2; i++ {
+		require.NoError(t, s.Ack())
+	}
+	require.NoError(t, s.Close())
+
+	// a crash tore the last record
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	last := segments[len(segments)-1]
+	f, err := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = f.Write(encodeRecord(testBatch(3, 100), time.Now())[:20])
+	require.NoError(t, err)
+	f.Close()
+
+	s, err = OpenSpool(cfg)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, 4, s.Len())
+	require.NoError(t, s.Append(testBatch(3, 18)))
+
+	got := drain(t, s)
+	require.Len(t, got, 5)
+	for i, batch := range got {
+		assert.Equal(t, testBatch(3, (i+2)*3), batch)
+	}
+}
+
+func TestSpool_FailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	cfg := SpoolConfig{Dir: dir, Fsync: FsyncNever}
+	s, err := OpenSpool(cfg)
+	require.NoError(t, err)
+	require.NoError(t, s.Append(testBatch(3, 0)))
+
+	// a write that failed halfway is cut off
+	s.mu.Lock()
+	_, err = s.w.Write(encodeRecord(testBatch(3, 100), time.Now())[:20])
+	require.NoError(t, err)
+	s.discardLocked()
+	s.mu.Unlock()
+	require.NoError(t, s.Append(testBatch(3, 3)))
+
+	// a segment that can not be written or cut is given up
+	s.mu.Lock()
+	seg := s.segments[len(s.segments)-1]
+	s.w.Close()
+	s.w, err = os.Open(s.segmentPath(seg))
+	require.NoError(t, err)
+	s.mu.Unlock()
+	assert.Error(t, s.Append(testBatch(3, 100)))
+	require.NoError(t, s.Append(testBatch(3, 6)))
+	assert.Equal(t, 2, s.Stats().Segments)
+	require.NoError(t, s.Close())
+
+	s, err = OpenSpool(cfg)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, [][]Message{testBatch(3, 0), testBatch(3, 3), testBatch(3, 6)}, drain(t, s))
+}
+
+func TestSpool_Cap(t *testing.T) {
+	size := int64(len(encodeRecord(testBatch(3, 10), time.Now())))
+	s, err := OpenSpool(SpoolConfig{Dir: t.TempDir(), MaxBytes: 4 * size, AlertPercent: 70})
+	require.NoError(t, err)
+	defer s.Close()
+
+	var alerts []SpoolStats
+	s.OnNearCap = func(stats SpoolStats) { alerts = append(alerts, stats) }
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, s.Append(testBatch(3, 10+i*3)))
+	}
+	require.Len(t, alerts, 1)
+	assert.Equal(t, 3, alerts[0].Batches)
+
+	assert.ErrorIs(t, s.Append(testBatch(3, 22)), ErrSpoolFull)
+	assert.Equal(t, uint64(1), s.Stats().Dropped)
+
+	// draining below the threshold re-arms the alert
+	require.NoError(t, s.Ack())
+	require.NoError(t, s.Ack())
+	require.NoError(t, s.Append(testBatch(3, 22)))
+	require.NoError(t, s.Append(testBatch(3, 25)))
+	assert.Len(t, alerts, 2)
+}
+
+// flakyProducer fails while down and records what it delivered
+type flakyProducer struct {
+	mu        sync.Mutex
+	down      bool
+	delivered [][]Message
+}
+
+func (p *flakyProducer) setDown(down bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.down = down
+}
+
+func (p *flakyProducer) ProduceBatch(messages []Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.down {
+		return &DeliveryError{Failed: messages, Total: len(messages), Err: errors.New("broker down")}
+	}
+	p.delivered = append(p.delivered, messages)
+	return nil
+}
+
+func (p *flakyProducer) Close() error { return nil }
+
+func (p *flakyProducer) values() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []string
+	for _, batch := range p.delivered {
+		for _, m := range batch {
+			out = append(out, string(m.Value))
+		}
+	}
+	return out
+}
+
+func TestSpoolingProducer_Replay(t *testing.T) {
+	s, err := OpenSpool(SpoolConfig{Dir: t.TempDir()})
+	require.NoError(t, err)
+	inner := &flakyProducer{}
+	p := NewSpoolingProducer(inner, s, 20*time.Millisecond)
+	defer p.Close()
+
+	require.NoError(t, p.ProduceBatch(testBatch(2, 0)))
+
+	inner.setDown(true)
+	require.NoError(t, p.ProduceBatch(testBatch(2, 2)))
+	require.NoError(t, p.ProduceBatch(testBatch(2, 4)))
+	assert.Equal(t, 2, p.Stats().Batches)
+
+	inner.setDown(false)
+	// spooled behind the outage, not ahead of it
+	require.NoError(t, p.ProduceBatch(testBatch(2, 6)))
+
+	require.Eventually(t, func() bool { return s.Len() == 0 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6", "7"}, inner.values())
+}
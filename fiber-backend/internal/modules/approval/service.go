@@ -2,17 +2,33 @@ package approval
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v3"
 )
 
+// ApplyFunc carries out an approved change request
+type ApplyFunc func(ctx context.Context, a *PendingApproval) error
+
 type Service struct {
 	Repo Repository
+
+	mu       sync.RWMutex
+	appliers map[string]ApplyFunc
 }
 
 func NewService(repo Repository) *Service {
-	return &Service{Repo: repo}
+	return &Service{Repo: repo, appliers: make(map[string]ApplyFunc)}
+}
+
+// OnApprove registers fn to apply approved requests for resource. Without an
+// applier, approving only records the decision.
+func (s *Service) OnApprove(resource string, fn ApplyFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.appliers[resource] = fn
 }
 
 func (s *Service) Request(c fiber.Ctx, action, resource string, resourceID *string, data any) error {
@@ -34,6 +50,21 @@ func (s *Service) Request(c fiber.Ctx, action, resource string, resourceID *stri
 }
 
 func (s *Service) Approve(ctx context.Context, id string, reviewerID string, notes *string) error {
+	a, err := s.Repo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	apply := s.appliers[a.Resource]
+	s.mu.RUnlock()
+
+	// The request stays pending if the change cannot be applied
+	if apply != nil {
+		if err := apply(ctx, a); err != nil {
+			return fmt.Errorf("apply %s: %w", a.Resource, err)
+		}
+	}
 	return s.Repo.UpdateStatus(ctx, id, StatusApproved, reviewerID, notes)
 }
 
@@ -8,18 +8,28 @@ type Machine struct {
 	ID        string    `json:"id" gorm:"primaryKey"`
 	Name      string    `json:"name" validate:"required"`
 	IP        string    `json:"ip" validate:"required,ip"`
-	AmsNetID  string    `json:"ams_net_id" validate:"required"`
-	Port      int       `json:"port" validate:"required"`
-	Chambers  []Chamber `json:"chambers" gorm:"foreignKey:MachineID;constraint:OnDelete:CASCADE"`
+	AmsNetID  string    `json:"ams_net_id" validate:"required_unless=Protocol opcua|required_unless=Protocol modbus"`
+	Port      int       `json:"port" validate:"required"`                                       // ADS port, or the OPC UA or Modbus server port
+	Gateway   string    `json:"gateway,omitempty" validate:"omitempty,hostname_port|ip"`        // AMS router or Modbus gateway of controllers behind one
+	Protocol  string    `json:"protocol,omitempty" validate:"omitempty,oneof=ads opcua modbus"` // defaults to ads
+	Chambers  []Chamber `json:"chambers" validate:"dive" gorm:"foreignKey:MachineID;constraint:OnDelete:CASCADE"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	// InfluxEnabled writes the machine's collected values to InfluxDB
+	InfluxEnabled bool `json:"influx_enabled"`
 }
 
 type Chamber struct {
 	ID        string   `json:"id" gorm:"primaryKey"`
 	MachineID string   `json:"machine_id"`
 	Name      string   `json:"name" validate:"required"`
-	Symbols   []Symbol `json:"symbols" gorm:"foreignKey:ChamberID;constraint:OnDelete:CASCADE"`
+	Symbols   []Symbol `json:"symbols" validate:"dive" gorm:"foreignKey:ChamberID;constraint:OnDelete:CASCADE"`
+
+	// Scan rate of the chamber's symbols: an interval, or a class whose
+	// interval is configured in config.yaml. Defaults to plc.scan_rate_ms.
+	ScanRateMs int    `json:"scan_rate_ms,omitempty" validate:"gte=0"`
+	ScanClass  string `json:"scan_class,omitempty" validate:"omitempty,oneof=fast medium slow"`
 }
 
 type Symbol struct {
@@ -28,6 +38,45 @@ type Symbol struct {
 	Name      string `json:"name" validate:"required"`
 	DataType  string `json:"data_type" validate:"required"`
 	Unit      string `json:"unit,omitempty"`
+
+	// Valid range, values outside are collected with bad quality
+	MinValue *float64 `json:"min_value,omitempty"`
+	MaxValue *float64 `json:"max_value,omitempty"`
+
+	// Writable puts the symbol on the write allowlist of the machine. Writes
+	// outside [WriteMin, WriteMax] are rejected, the bounds default to the
+	// valid range and either may be left open.
+	Writable bool     `json:"writable,omitempty"`
+	WriteMin *float64 `json:"write_min,omitempty"`
+	WriteMax *float64 `json:"write_max,omitempty"`
+
+	// Register of the symbol on machines with protocol modbus
+	Modbus *ModbusRegister `json:"modbus,omitempty"`
+
+	// Reporting: changes within the deadband (absolute, or percent of the
+	// valid range) are not sent, change-only sends only changed values and
+	// the heartbeat resends unchanged values. Default is every poll.
+	Deadband        float64 `json:"deadband,omitempty" validate:"gte=0"`
+	DeadbandPercent float64 `json:"deadband_percent,omitempty" validate:"gte=0,lte=100"`
+	ChangeOnly      bool    `json:"change_only,omitempty"`
+	HeartbeatMs     int     `json:"heartbeat_ms,omitempty" validate:"gte=0"`
+
+	// Scan rate of the symbol, overrides the chamber's
+	ScanRateMs int    `json:"scan_rate_ms,omitempty" validate:"gte=0"`
+	ScanClass  string `json:"scan_class,omitempty" validate:"omitempty,oneof=fast medium slow"`
+}
+
+// ModbusRegister maps a symbol to Modbus registers. Values are scaled to
+// engineering units as raw*scale + offset.
+type ModbusRegister struct {
+	Table     string  `json:"table" validate:"required,oneof=holding input coil discrete"`
+	Address   uint16  `json:"address"` // zero-based
+	Unit      uint8   `json:"unit,omitempty"`
+	Type      string  `json:"type,omitempty" validate:"omitempty,oneof=bool int16 uint16 int32 uint32 int64 uint64 float32 float64 string"`
+	Length    int     `json:"length,omitempty" validate:"required_if=Type string"` // characters of a string
+	WordOrder string  `json:"word_order,omitempty" validate:"omitempty,oneof=ABCD CDAB BADC DCBA"`
+	Scale     float64 `json:"scale,omitempty" validate:"gte=0"`
+	Offset    float64 `json:"offset,omitempty"`
 }
 
 type MachineResponse struct {
@@ -36,6 +85,8 @@ type MachineResponse struct {
 	IP       string            `json:"ip"`
 	AmsNetID string            `json:"ams_net_id"`
 	Port     int               `json:"port"`
+	Gateway  string            `json:"gateway,omitempty"`
+	Protocol string            `json:"protocol"`
 	Status   string            `json:"status"` // Configured/Online/Offline
 	Chambers []ChamberResponse `json:"chambers"`
 }
@@ -47,7 +98,18 @@ type ChamberResponse struct {
 }
 
 type SymbolResponse struct {
-	Name     string `json:"name"`
-	DataType string `json:"data_type"`
-	Unit     string `json:"unit,omitempty"`
+	Name     string          `json:"name"`
+	DataType string          `json:"data_type"`
+	Unit     string          `json:"unit,omitempty"`
+	MinValue *float64        `json:"min_value,omitempty"`
+	MaxValue *float64        `json:"max_value,omitempty"`
+	Writable bool            `json:"writable,omitempty"`
+	WriteMin *float64        `json:"write_min,omitempty"`
+	WriteMax *float64        `json:"write_max,omitempty"`
+	Modbus   *ModbusRegister `json:"modbus,omitempty"`
+
+	Deadband        float64 `json:"deadband,omitempty"`
+	DeadbandPercent float64 `json:"deadband_percent,omitempty"`
+	ChangeOnly      bool    `json:"change_only,omitempty"`
+	HeartbeatMs     int     `json:"heartbeat_ms,omitempty"`
 }
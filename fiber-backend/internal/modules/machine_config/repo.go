@@ -42,9 +42,9 @@ func (r PgRepo) SaveMachines(ctx context.Context, machines []Machine) error {
 
 	for _, m := range machines {
 		_, err = tx.Exec(ctx,
-			`INSERT INTO machines(id, name, ip, ams_net_id, port, created_at, updated_at)
-			 VALUES($1, $2, $3, $4, $5, now(), now())`,
-			m.ID, m.Name, m.IP, m.AmsNetID, m.Port,
+			`INSERT INTO machines(id, name, ip, ams_net_id, port, gateway, protocol, influx_enabled, created_at, updated_at)
+			 VALUES($1, $2, $3, $4, $5, $6, COALESCE(NULLIF($7, ''), 'ads'), $8, now(), now())`,
+			m.ID, m.Name, m.IP, m.AmsNetID, m.Port, m.Gateway, m.Protocol, m.InfluxEnabled,
 		)
 		if err != nil {
 			return err
@@ -52,9 +52,9 @@ func (r PgRepo) SaveMachines(ctx context.Context, machines []Machine) error {
 
 		for _, c := range m.Chambers {
 			_, err = tx.Exec(ctx,
-				`INSERT INTO chambers(id, machine_id, name)
-				 VALUES($1, $2, $3)`,
-				c.ID, m.ID, c.Name,
+				`INSERT INTO chambers(id, machine_id, name, scan_rate_ms, scan_class)
+				 VALUES($1, $2, $3, $4, $5)`,
+				c.ID, m.ID, c.Name, c.ScanRateMs, c.ScanClass,
 			)
 			if err != nil {
 				return err
@@ -62,9 +62,13 @@ func (r PgRepo) SaveMachines(ctx context.Context, machines []Machine) error {
 
 			for _, s := range c.Symbols {
 				_, err = tx.Exec(ctx,
-					`INSERT INTO symbols(id, chamber_id, name, data_type, unit)
-					 VALUES($1, $2, $3, $4, $5)`,
-					s.ID, c.ID, s.Name, s.DataType, s.Unit,
+					`INSERT INTO symbols(id, chamber_id, name, data_type, unit, min_value, max_value, modbus,
+					                    deadband, deadband_percent, change_only, heartbeat_ms, scan_rate_ms, scan_class,
+					                    writable, write_min, write_max)
+					 VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`,
+					s.ID, c.ID, s.Name, s.DataType, s.Unit, s.MinValue, s.MaxValue, s.Modbus,
+					s.Deadband, s.DeadbandPercent, s.ChangeOnly, s.HeartbeatMs, s.ScanRateMs, s.ScanClass,
+					s.Writable, s.WriteMin, s.WriteMax,
 				)
 				if err != nil {
 					return err
@@ -77,9 +81,11 @@ func (r PgRepo) SaveMachines(ctx context.Context, machines []Machine) error {
 
 func (r PgRepo) GetMachines(ctx context.Context) ([]Machine, error) {
 	rows, err := r.DB.Query(ctx,
-		`SELECT m.id, m.name, m.ip, m.ams_net_id, m.port, m.created_at, m.updated_at,
-		        c.id, c.name,
-		        s.id, s.name, s.data_type, s.unit
+		`SELECT m.id, m.name, m.ip, m.ams_net_id, m.port, m.gateway, m.protocol, m.influx_enabled, m.created_at, m.updated_at,
+		        c.id, c.name, c.scan_rate_ms, c.scan_class,
+		        s.id, s.name, s.data_type, s.unit, s.min_value, s.max_value, s.modbus,
+		        s.deadband, s.deadband_percent, s.change_only, s.heartbeat_ms, s.scan_rate_ms, s.scan_class,
+		        s.writable, s.write_min, s.write_max
 		 FROM machines m
 		 LEFT JOIN chambers c ON m.id = c.machine_id
 		 LEFT JOIN symbols s ON c.id = s.chamber_id
@@ -93,16 +99,28 @@ func (r PgRepo) GetMachines(ctx context.Context) ([]Machine, error) {
 	chamberMap := make(map[string]*Chamber)
 
 	for rows.Next() {
-		var mID, mName, mIP, mNetID string
+		var mID, mName, mIP, mNetID, mGateway, mProtocol string
 		var mPort int
+		var mInflux bool
 		var mCreated, mUpdated time.Time
-		var cID, cName *string
+		var cID, cName, cScanClass *string
+		var cScanRate *int
 		var sID, sName, sType, sUnit *string
+		var sMin, sMax *float64
+		var sModbus *ModbusRegister
+		var sDeadband, sDeadbandPct *float64
+		var sChangeOnly *bool
+		var sHeartbeat, sScanRate *int
+		var sScanClass *string
+		var sWritable *bool
+		var sWriteMin, sWriteMax *float64
 
 		err := rows.Scan(
-			&mID, &mName, &mIP, &mNetID, &mPort, &mCreated, &mUpdated,
-			&cID, &cName,
-			&sID, &sName, &sType, &sUnit,
+			&mID, &mName, &mIP, &mNetID, &mPort, &mGateway, &mProtocol, &mInflux, &mCreated, &mUpdated,
+			&cID, &cName, &cScanRate, &cScanClass,
+			&sID, &sName, &sType, &sUnit, &sMin, &sMax, &sModbus,
+			&sDeadband, &sDeadbandPct, &sChangeOnly, &sHeartbeat, &sScanRate, &sScanClass,
+			&sWritable, &sWriteMin, &sWriteMax,
 		)
 		if err != nil {
 			return nil, err
@@ -116,9 +134,13 @@ func (r PgRepo) GetMachines(ctx context.Context) ([]Machine, error) {
 				IP:        mIP,
 				AmsNetID:  mNetID,
 				Port:      mPort,
+				Gateway:   mGateway,
+				Protocol:  mProtocol,
 				CreatedAt: mCreated,
 				UpdatedAt: mUpdated,
 				Chambers:  []Chamber{},
+
+				InfluxEnabled: mInflux,
 			}
 			machineMap[mID] = m
 		}
@@ -131,6 +153,9 @@ func (r PgRepo) GetMachines(ctx context.Context) ([]Machine, error) {
 					MachineID: mID,
 					Name:      *cName,
 					Symbols:   []Symbol{},
+
+					ScanRateMs: *cScanRate,
+					ScanClass:  *cScanClass,
 				}
 				chamberMap[*cID] = c
 				m.Chambers = append(m.Chambers, *c)
@@ -150,6 +175,20 @@ func (r PgRepo) GetMachines(ctx context.Context) ([]Machine, error) {
 					Name:      *sName,
 					DataType:  *sType,
 					Unit:      unit,
+					MinValue:  sMin,
+					MaxValue:  sMax,
+					Modbus:    sModbus,
+
+					Deadband:        *sDeadband,
+					DeadbandPercent: *sDeadbandPct,
+					ChangeOnly:      *sChangeOnly,
+					HeartbeatMs:     *sHeartbeat,
+					ScanRateMs:      *sScanRate,
+					ScanClass:       *sScanClass,
+
+					Writable: *sWritable,
+					WriteMin: sWriteMin,
+					WriteMax: sWriteMax,
 				}
 				c.Symbols = append(c.Symbols, s)
 			}
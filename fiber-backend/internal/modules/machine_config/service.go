@@ -0,0 +1,34 @@
+package machine_config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"fiber-backend/internal/modules/approval"
+)
+
+// ApplyApproved returns the approval applier for "machine_config" requests.
+// It saves the approved machines and hands them to onSaved, e.g. to
+// reconfigure the running collector.
+func ApplyApproved(repo Repository, onSaved func(machines []Machine) error) approval.ApplyFunc {
+	return func(ctx context.Context, a *approval.PendingApproval) error {
+		// Data comes back from JSONB as generic maps
+		b, err := json.Marshal(a.Data)
+		if err != nil {
+			return err
+		}
+		var machines []Machine
+		if err := json.Unmarshal(b, &machines); err != nil {
+			return fmt.Errorf("invalid machine configuration: %w", err)
+		}
+
+		if err := repo.SaveMachines(ctx, machines); err != nil {
+			return err
+		}
+		if onSaved != nil {
+			return onSaved(machines)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,77 @@
+package machine_config
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"fiber-backend/internal/modules/approval"
+	"fiber-backend/internal/validator"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockRepo struct {
+	saved []Machine
+	err   error
+}
+
+func (m *mockRepo) SaveMachines(ctx context.Context, machines []Machine) error {
+	m.saved = machines
+	return m.err
+}
+
+func (m *mockRepo) GetMachines(ctx context.Context) ([]Machine, error) {
+	return m.saved, nil
+}
+
+func TestApplyApproved(t *testing.T) {
+	repo := &mockRepo{}
+	var applied []Machine
+	apply := ApplyApproved(repo, func(machines []Machine) error {
+		applied = machines
+		return nil
+	})
+
+	// Approvals read back from Postgres carry the request as decoded JSON
+	data := []interface{}{map[string]interface{}{
+		"id": "m1", "name": "Etcher", "ip": "10.0.0.1", "ams_net_id": "10.0.0.1.1.1", "port": 851.0,
+		"chambers": []interface{}{map[string]interface{}{"id": "c1", "name": "PM1"}},
+	}}
+	require.NoError(t, apply(context.Background(), &approval.PendingApproval{Resource: "machine_config", Data: data}))
+
+	require.Len(t, repo.saved, 1)
+	assert.Equal(t, 851, repo.saved[0].Port)
+	assert.Equal(t, "PM1", repo.saved[0].Chambers[0].Name)
+	assert.Equal(t, repo.saved, applied)
+
+	// Nothing is applied if saving fails
+	applied = nil
+	repo.err = errors.New("db down")
+	assert.Error(t, apply(context.Background(), &approval.PendingApproval{Data: data}))
+	assert.Nil(t, applied)
+
+	assert.Error(t, apply(context.Background(), &approval.PendingApproval{Data: "bogus"}))
+}
+
+func TestMachine_Validate(t *testing.T) {
+	chiller := Machine{
+		Name: "Chiller", IP: "10.0.0.2", Port: 502, Protocol: "modbus",
+		Chambers: []Chamber{{Name: "PM1", Symbols: []Symbol{{
+			Name: "Chiller.Setpoint", DataType: "LREAL",
+			Modbus: &ModbusRegister{Table: "holding", Address: 0, Type: "int16", Scale: 0.1},
+		}}}},
+	}
+	assert.NoError(t, validator.V.Struct(chiller))
+
+	// Only ADS machines need an AMS Net ID
+	ads := chiller
+	ads.Protocol = ""
+	assert.Error(t, validator.V.Struct(ads))
+
+	chiller.Chambers[0].Symbols[0].Modbus.Table = "memory"
+	assert.Error(t, validator.V.Struct(chiller))
+	chiller.Chambers[0].Symbols[0].Modbus = &ModbusRegister{Table: "holding", Type: "string"}
+	assert.Error(t, validator.V.Struct(chiller), "strings need a length")
+}
@@ -0,0 +1,163 @@
+package plc
+
+import (
+	"time"
+
+	"fiber-backend/internal/plcengine"
+	"fiber-backend/internal/validator"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+)
+
+// defaultWriteTimeout bounds how long a REST write waits for its result
+const defaultWriteTimeout = 10 * time.Second
+
+type Handler struct {
+	Engine Engine
+}
+
+// ReadSymbol godoc
+// @Summary     Read a PLC symbol
+// @Tags        plc
+// @Security    BearerAuth
+// @Produce     json
+// @Param       id   path string true "Machine ID"
+// @Param       name path string true "Symbol name, e.g. GVL.Temperature"
+// @Success     200 {object} plcengine.PLCValue
+// @Failure     502 {object} map[string]interface{} "PLC read failed"
+// @Router      /plc/machines/{id}/symbols/{name} [get]
+func (h Handler) ReadSymbol(c fiber.Ctx) error {
+	val, err := h.Engine.ReadSymbol(c.Params("id"), c.Params("name"))
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(val)
+}
+
+// ReadSymbols godoc
+// @Summary     Read several PLC symbols in one request
+// @Tags        plc
+// @Security    BearerAuth
+// @Accept      json
+// @Produce     json
+// @Param       id   path string           true "Machine ID"
+// @Param       body body BatchReadRequest true "Symbols to read"
+// @Success     200 {object} map[string]plcengine.PLCValue
+// @Failure     400 {object} map[string]interface{} "Invalid request body"
+// @Failure     502 {object} map[string]interface{} "PLC read failed"
+// @Router      /plc/machines/{id}/symbols/batch [post]
+func (h Handler) ReadSymbols(c fiber.Ctx) error {
+	var req BatchReadRequest
+	if err := c.Bind().Body(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	if err := validator.V.Struct(req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	vals, err := h.Engine.ReadSymbols(c.Params("id"), req.Symbols)
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(vals)
+}
+
+// Browse godoc
+// @Summary     Browse the address space of a PLC
+// @Description Lists the child nodes of a node on machines whose driver supports browsing, such as OPC UA.
+// @Description Variables can be read and written with their id as symbol name.
+// @Tags        plc
+// @Security    BearerAuth
+// @Produce     json
+// @Param       id   path  string true  "Machine ID"
+// @Param       node query string false "Node to list, the top level if empty"
+// @Success     200 {array}  plcengine.BrowseNode
+// @Failure     502 {object} map[string]interface{} "Browse failed or not supported"
+// @Router      /plc/machines/{id}/browse [get]
+func (h Handler) Browse(c fiber.Ctx) error {
+	nodes, err := h.Engine.Browse(c.Params("id"), c.Query("node"))
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(nodes)
+}
+
+// WriteSymbol godoc
+// @Summary     Write a PLC symbol
+// @Description Runs the write through the engine's guards and returns the write result.
+// @Description Rejected writes return 422 with the rejection reason.
+// @Tags        plc
+// @Security    BearerAuth
+// @Accept      json
+// @Produce     json
+// @Param       id   path string       true "Machine ID"
+// @Param       name path string       true "Symbol name"
+// @Param       body body WriteRequest true "Value and options"
+// @Success     200 {object} plcengine.WriteResponse
+// @Failure     422 {object} plcengine.WriteResponse "Rejected by a write guard"
+// @Failure     502 {object} plcengine.WriteResponse "PLC write failed"
+// @Failure     504 {object} map[string]interface{} "No result within the timeout"
+// @Router      /plc/machines/{id}/symbols/{name} [post]
+func (h Handler) WriteSymbol(c fiber.Ctx) error {
+	var body WriteRequest
+	if err := c.Bind().Body(&body); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	if body.Value == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "value is required"})
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	req := plcengine.WriteRequest{
+		ID:         uuid.New().String(),
+		MachineID:  c.Params("id"),
+		Symbol:     c.Params("name"),
+		Value:      body.Value,
+		Priority:   body.Priority,
+		RequireAck: body.RequireAck,
+		Timeout:    time.Duration(body.TimeoutMs) * time.Millisecond,
+		UserID:     userID,
+	}
+
+	timeout := defaultWriteTimeout
+	if req.Timeout > 0 {
+		timeout = req.Timeout
+	}
+
+	select {
+	case resp := <-h.Engine.WriteAsync(req):
+		switch {
+		case resp.Success:
+			return c.JSON(resp)
+		case resp.Rejection != nil:
+			return c.Status(422).JSON(resp)
+		default:
+			return c.Status(502).JSON(resp)
+		}
+	case <-time.After(timeout):
+		return c.Status(504).JSON(fiber.Map{"error": "write timed out", "id": req.ID})
+	}
+}
+
+// GetStatus godoc
+// @Summary     PLC connection status per machine
+// @Tags        plc
+// @Security    BearerAuth
+// @Produce     json
+// @Success     200 {object} map[string]plcengine.ConnectionStatus
+// @Router      /plc/status [get]
+func (h Handler) GetStatus(c fiber.Ctx) error {
+	return c.JSON(h.Engine.GetStatus())
+}
+
+// GetWriteQueues godoc
+// @Summary     PLC write queue metrics per priority
+// @Tags        plc
+// @Security    BearerAuth
+// @Produce     json
+// @Success     200 {array} plcengine.WriteQueueStats
+// @Router      /plc/queues [get]
+func (h Handler) GetWriteQueues(c fiber.Ctx) error {
+	return c.JSON(h.Engine.WriteQueueStats())
+}
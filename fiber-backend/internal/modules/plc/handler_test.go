@@ -0,0 +1,218 @@
+package plc
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"fiber-backend/internal/auth"
+	"fiber-backend/internal/collector"
+	"fiber-backend/internal/middleware"
+	"fiber-backend/internal/plcengine"
+	"fiber-backend/internal/streamer"
+
+	fws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	os.Setenv("JWT_SECRET", "plc-test-secret")
+	os.Exit(m.Run())
+}
+
+// setupApp connects the engine the way main does, from the collector's
+// machine configuration
+func setupApp(t *testing.T) *fiber.App {
+	t.Helper()
+	engine := plcengine.NewEngine(make(chan plcengine.PLCValue, 100))
+	engine.ClientFactory = func(ip, amsID string, port int) (plcengine.ADSClient, error) {
+		return plcengine.NewMockADSClient(ip), nil
+	}
+	min, max := 0.0, 100.0
+	col := collector.NewCollector(engine, streamer.NewHub())
+	require.NoError(t, col.Start([]collector.MachineConfig{{
+		ID: "m1", IP: "10.0.0.1", AmsNetID: "10.0.0.1.1.1", Port: 851,
+		Chambers: []collector.ChamberConfig{{ID: "c1", ScanRateMs: 1000, Symbols: []collector.SymbolConfig{
+			{Name: "GVL.Setpoint", DataType: "LREAL", MinValue: &min, MaxValue: &max, Writable: true},
+			{Name: "GVL.Temp", DataType: "LREAL"},
+		}}},
+	}}))
+	t.Cleanup(func() {
+		col.Stop()
+		engine.Stop()
+	})
+
+	require.Eventually(t, func() bool { return engine.GetStatus()["m1"].Connected }, time.Second, 5*time.Millisecond)
+
+	app := fiber.New()
+	Routes(app.Group("/api/plc", middleware.JWT()), engine, auth.NewAuthMiddleware(""))
+	return app
+}
+
+func token(t *testing.T, perms map[string][]string) string {
+	t.Helper()
+	tok, _, err := auth.GenerateAccessToken("user-1", "alice", []string{"operator"}, perms)
+	require.NoError(t, err)
+	return tok
+}
+
+var (
+	readOnly  = map[string][]string{"chambers": {"read"}}
+	readWrite = map[string][]string{"chambers": {"read", "write"}}
+)
+
+func doRequest(t *testing.T, app *fiber.App, method, url, tok string, body any) (*http.Response, map[string]any) {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+	req, _ := http.NewRequest(method, url, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+tok)
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var out map[string]any
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp, out
+}
+
+func TestReadEndpoints(t *testing.T) {
+	app := setupApp(t)
+	tok := token(t, readOnly)
+
+	resp, body := doRequest(t, app, "GET", "/api/plc/machines/m1/symbols/GVL.Temp", tok, nil)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, 42.0, body["value"])
+	assert.Equal(t, "m1", body["source"])
+
+	resp, body = doRequest(t, app, "POST", "/api/plc/machines/m1/symbols/batch", tok, BatchReadRequest{Symbols: []string{"A", "B"}})
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Len(t, body, 2)
+
+	resp, _ = doRequest(t, app, "POST", "/api/plc/machines/m1/symbols/batch", tok, BatchReadRequest{})
+	assert.Equal(t, 400, resp.StatusCode)
+
+	resp, _ = doRequest(t, app, "GET", "/api/plc/machines/nope/symbols/A", tok, nil)
+	assert.Equal(t, 502, resp.StatusCode)
+
+	// ADS clients cannot browse
+	resp, body = doRequest(t, app, "GET", "/api/plc/machines/m1/browse?node=GVL", tok, nil)
+	assert.Equal(t, 502, resp.StatusCode)
+	assert.Contains(t, body["error"], "does not support browsing")
+
+	resp, body = doRequest(t, app, "GET", "/api/plc/status", tok, nil)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, true, body["m1"].(map[string]any)["connected"])
+
+	resp, _ = doRequest(t, app, "GET", "/api/plc/status", token(t, nil), nil)
+	assert.Equal(t, 403, resp.StatusCode)
+
+	req, _ := http.NewRequest("GET", "/api/plc/queues", nil)
+	req.Header.Set("Authorization", "Bearer "+tok)
+	queues, err := app.Test(req)
+	require.NoError(t, err)
+	var stats []plcengine.WriteQueueStats
+	require.NoError(t, json.NewDecoder(queues.Body).Decode(&stats))
+	require.Len(t, stats, 3)
+	assert.Equal(t, "high", stats[0].Priority)
+}
+
+func TestWriteEndpoint(t *testing.T) {
+	app := setupApp(t)
+
+	resp, _ := doRequest(t, app, "POST", "/api/plc/machines/m1/symbols/GVL.Setpoint", token(t, readOnly), WriteRequest{Value: 50})
+	assert.Equal(t, 403, resp.StatusCode)
+
+	tok := token(t, readWrite)
+	resp, body := doRequest(t, app, "POST", "/api/plc/machines/m1/symbols/GVL.Setpoint", tok, WriteRequest{Value: 50, Priority: 9})
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, true, body["success"])
+	assert.NotEmpty(t, body["id"])
+
+	resp, body = doRequest(t, app, "POST", "/api/plc/machines/m1/symbols/GVL.Setpoint", tok, WriteRequest{Value: 500, Priority: 9})
+	assert.Equal(t, 422, resp.StatusCode)
+	assert.Equal(t, "out_of_range", body["rejection"].(map[string]any)["reason"])
+
+	resp, body = doRequest(t, app, "POST", "/api/plc/machines/m1/symbols/GVL.Temp", tok, WriteRequest{Value: 20, Priority: 9})
+	assert.Equal(t, 422, resp.StatusCode)
+	assert.Equal(t, "read_only", body["rejection"].(map[string]any)["reason"])
+
+	resp, _ = doRequest(t, app, "POST", "/api/plc/machines/m1/symbols/GVL.Setpoint", tok, map[string]any{})
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestWriteWebSocket(t *testing.T) {
+	app := setupApp(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go app.Listener(ln, fiber.ListenConfig{DisableStartupMessage: true})
+	t.Cleanup(func() { app.Shutdown() })
+
+	dial := func(perms map[string][]string) *fws.Conn {
+		header := http.Header{"Authorization": {"Bearer " + token(t, perms)}}
+		conn, _, err := fws.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/api/plc/ws", header)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+	read := func(conn *fws.Conn) WSMessage {
+		var msg WSMessage
+		require.NoError(t, conn.ReadJSON(&msg))
+		return msg
+	}
+
+	conn := dial(readWrite)
+	require.NoError(t, conn.WriteJSON(WSRequest{Type: WSTypeWrite, Ref: "r1", MachineID: "m1", Symbol: "GVL.Setpoint", Value: 12.5, Priority: 9}))
+
+	accepted := read(conn)
+	assert.Equal(t, WSTypeAccepted, accepted.Type)
+	assert.Equal(t, "r1", accepted.Ref)
+
+	confirm := read(conn)
+	assert.Equal(t, WSTypeWriteConfirm, confirm.Type)
+	assert.Equal(t, "r1", confirm.Ref)
+	assert.Equal(t, accepted.ID, confirm.ID)
+	require.NotNil(t, confirm.Confirm)
+	assert.True(t, confirm.Confirm.Success)
+	assert.Equal(t, "GVL.Setpoint", confirm.Confirm.Symbol)
+
+	require.NoError(t, conn.WriteJSON(WSRequest{Type: WSTypeWrite, Ref: "r2", MachineID: "m1", Symbol: "GVL.Other", Value: 1, Priority: 9}))
+	read(conn) // accepted
+	confirm = read(conn)
+	require.NotNil(t, confirm.Confirm)
+	assert.Equal(t, plcengine.RejectNotAllowed, confirm.Confirm.Rejection.Reason)
+
+	viewer := dial(readOnly)
+	require.NoError(t, viewer.WriteJSON(WSRequest{Type: WSTypeWrite, Ref: "r3", MachineID: "m1", Symbol: "GVL.Setpoint", Value: 1}))
+	denied := read(viewer)
+	assert.Equal(t, WSTypeError, denied.Type)
+	assert.Equal(t, "r3", denied.Ref)
+}
+
+func TestWriteStream_ExpiresLostConfirmations(t *testing.T) {
+	s := &WriteStream{ConfirmTimeout: time.Minute, pending: make(map[string]pendingWrite)}
+	client := &wsClient{send: make(chan WSMessage, 4)}
+	now := time.Now()
+	s.pending["w1"] = pendingWrite{client: client, ref: "r1", deadline: now.Add(-time.Second)}
+	s.pending["w2"] = pendingWrite{client: client, ref: "r2", deadline: now.Add(time.Second)}
+
+	s.expire(now)
+	assert.Len(t, s.pending, 1)
+	require.Len(t, client.send, 1)
+	msg := <-client.send
+	assert.Equal(t, WSTypeError, msg.Type)
+	assert.Equal(t, "r1", msg.Ref)
+	assert.Equal(t, "w1", msg.ID)
+	assert.Contains(t, msg.Error, "confirmation lost")
+}
@@ -0,0 +1,58 @@
+package plc
+
+import (
+	"fiber-backend/internal/plcengine"
+)
+
+// Engine is the part of the PLC engine used by the API
+type Engine interface {
+	ReadSymbol(machineID, symbol string) (*plcengine.PLCValue, error)
+	ReadSymbols(machineID string, symbols []string) (map[string]*plcengine.PLCValue, error)
+	Browse(machineID, node string) ([]plcengine.BrowseNode, error)
+	WriteAsync(req plcengine.WriteRequest) <-chan plcengine.WriteResponse
+	SubmitWrite(req plcengine.WriteRequest) error
+	SubscribeWrites(filter plcengine.WriteFilter) <-chan plcengine.WriteResponse
+	GetStatus() map[string]plcengine.ConnectionStatus
+	WriteQueueStats() []plcengine.WriteQueueStats
+}
+
+type BatchReadRequest struct {
+	Symbols []string `json:"symbols" validate:"required,min=1"`
+}
+
+type WriteRequest struct {
+	Value      any  `json:"value"`
+	Priority   int  `json:"priority"` // 0=low, 10=high
+	RequireAck bool `json:"require_ack"`
+	TimeoutMs  int  `json:"timeout_ms,omitempty"`
+}
+
+// WebSocket message types
+const (
+	WSTypeWrite        = "write"
+	WSTypeAccepted     = "accepted"
+	WSTypeWriteConfirm = "writeConfirm"
+	WSTypeError        = "error"
+)
+
+// WSRequest is sent by clients to submit a write. Ref is echoed back so the
+// client can match the replies.
+type WSRequest struct {
+	Type       string `json:"type"`
+	Ref        string `json:"ref,omitempty"`
+	MachineID  string `json:"machine_id"`
+	Symbol     string `json:"symbol"`
+	Value      any    `json:"value"`
+	Priority   int    `json:"priority"`
+	RequireAck bool   `json:"require_ack"`
+	TimeoutMs  int    `json:"timeout_ms,omitempty"`
+}
+
+// WSMessage is sent to clients. ID is the engine write request ID.
+type WSMessage struct {
+	Type    string                   `json:"type"`
+	Ref     string                   `json:"ref,omitempty"`
+	ID      string                   `json:"id,omitempty"`
+	Confirm *plcengine.WriteResponse `json:"confirm,omitempty"`
+	Error   string                   `json:"error,omitempty"`
+}
@@ -0,0 +1,26 @@
+package plc
+
+import (
+	"fiber-backend/internal/auth"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// Routes registers the PLC API. router must be behind the JWT middleware.
+// Reads need chambers:read, writes chambers:write.
+func Routes(router fiber.Router, engine Engine, authz *auth.AuthMiddleware) {
+	h := Handler{Engine: engine}
+	stream := NewWriteStream(engine)
+	go stream.Run()
+
+	read := authz.RequirePermission("chambers", "read")
+	write := authz.RequirePermission("chambers", "write")
+
+	router.Get("/status", read, h.GetStatus)
+	router.Get("/queues", read, h.GetWriteQueues)
+	router.Post("/machines/:id/symbols/batch", read, h.ReadSymbols)
+	router.Get("/machines/:id/symbols/:name", read, h.ReadSymbol)
+	router.Get("/machines/:id/browse", read, h.Browse)
+	router.Post("/machines/:id/symbols/:name", write, h.WriteSymbol)
+	router.Get("/ws", read, stream.NewHandler())
+}
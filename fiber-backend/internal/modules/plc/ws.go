@@ -0,0 +1,213 @@
+package plc
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"fiber-backend/internal/auth"
+	"fiber-backend/internal/plcengine"
+
+	"github.com/gofiber/contrib/v3/websocket"
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+)
+
+// wsClient is one connected operator console
+type wsClient struct {
+	conn   *websocket.Conn
+	send   chan WSMessage
+	claims *auth.Claims
+}
+
+type pendingWrite struct {
+	client   *wsClient
+	ref      string
+	deadline time.Time
+}
+
+// DefaultConfirmTimeout is how long a write may wait for its confirmation on
+// top of its own timeout
+const DefaultConfirmTimeout = 2 * time.Minute
+
+// WriteStream accepts writes over WebSocket and routes the engine's write
+// confirmations back to the client that submitted them.
+type WriteStream struct {
+	engine   Engine
+	confirms <-chan plcengine.WriteResponse
+
+	// ConfirmTimeout ends a pending write whose confirmation was dropped
+	// under load, the client gets an error instead. Set before Run.
+	ConfirmTimeout time.Duration
+
+	mu      sync.Mutex
+	pending map[string]pendingWrite // engine request ID -> submitter
+}
+
+func NewWriteStream(engine Engine) *WriteStream {
+	return &WriteStream{
+		engine: engine,
+		// Subscribed right away so that no confirmation is missed before Run
+		confirms: engine.SubscribeWrites(plcengine.WriteFilter{
+			StreamOptions: plcengine.StreamOptions{Name: "plc-ws", Buffer: 256},
+		}),
+		ConfirmTimeout: DefaultConfirmTimeout,
+		pending:        make(map[string]pendingWrite),
+	}
+}
+
+// Run drains the engine's write confirmations and expires the writes whose
+// confirmation never came. It returns when the stream closes, i.e. when the
+// engine stops.
+func (s *WriteStream) Run() {
+	ticker := time.NewTicker(min(time.Second, s.ConfirmTimeout/2))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case resp, ok := <-s.confirms:
+			if !ok {
+				return
+			}
+			s.mu.Lock()
+			p, ok := s.pending[resp.ID]
+			delete(s.pending, resp.ID)
+			s.mu.Unlock()
+			if !ok {
+				continue // written through REST or by another component
+			}
+
+			confirm := resp
+			p.client.push(WSMessage{Type: WSTypeWriteConfirm, Ref: p.ref, ID: resp.ID, Confirm: &confirm})
+
+		case now := <-ticker.C:
+			s.expire(now)
+		}
+	}
+}
+
+// expire drops the pending writes past their deadline and tells their clients
+func (s *WriteStream) expire(now time.Time) {
+	s.mu.Lock()
+	expired := make(map[string]pendingWrite)
+	for id, p := range s.pending {
+		if now.After(p.deadline) {
+			expired[id] = p
+			delete(s.pending, id)
+		}
+	}
+	s.mu.Unlock()
+
+	for id, p := range expired {
+		p.client.push(WSMessage{Type: WSTypeError, Ref: p.ref, ID: id, Error: "write confirmation lost, check the write journal for its outcome"})
+	}
+}
+
+// NewHandler returns the WebSocket handler. It must run behind the JWT
+// middleware; every write is checked against the chambers:write permission.
+func (s *WriteStream) NewHandler() fiber.Handler {
+	return websocket.New(func(c *websocket.Conn) {
+		claims, _ := c.Locals("user").(*auth.Claims)
+		client := &wsClient{conn: c, send: make(chan WSMessage, 64), claims: claims}
+
+		done := make(chan struct{})
+		pumpDone := make(chan struct{})
+		go func() {
+			defer close(pumpDone)
+			client.writePump(done)
+		}()
+
+		s.readPump(client)
+		s.forget(client)
+		// The connection is recycled once the handler returns
+		close(done)
+		<-pumpDone
+	})
+}
+
+func (s *WriteStream) readPump(client *wsClient) {
+	for {
+		var msg WSRequest
+		if err := client.conn.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		if msg.Type != WSTypeWrite {
+			client.push(WSMessage{Type: WSTypeError, Ref: msg.Ref, Error: "unsupported message type " + msg.Type})
+			continue
+		}
+		if client.claims == nil || !auth.HasPermission(client.claims, "chambers", "write") {
+			client.push(WSMessage{Type: WSTypeError, Ref: msg.Ref, Error: "insufficient permissions"})
+			continue
+		}
+		if msg.MachineID == "" || msg.Symbol == "" || msg.Value == nil {
+			client.push(WSMessage{Type: WSTypeError, Ref: msg.Ref, Error: "machine_id, symbol and value are required"})
+			continue
+		}
+
+		req := plcengine.WriteRequest{
+			ID:         uuid.New().String(),
+			MachineID:  msg.MachineID,
+			Symbol:     msg.Symbol,
+			Value:      msg.Value,
+			Priority:   msg.Priority,
+			RequireAck: msg.RequireAck,
+			Timeout:    time.Duration(msg.TimeoutMs) * time.Millisecond,
+			UserID:     client.claims.UserID,
+		}
+
+		// Register before submitting, the confirmation may arrive right away
+		s.mu.Lock()
+		s.pending[req.ID] = pendingWrite{client: client, ref: msg.Ref, deadline: time.Now().Add(req.Timeout + s.ConfirmTimeout)}
+		s.mu.Unlock()
+
+		if err := s.engine.SubmitWrite(req); err != nil {
+			s.mu.Lock()
+			delete(s.pending, req.ID)
+			s.mu.Unlock()
+			client.push(WSMessage{Type: WSTypeError, Ref: msg.Ref, ID: req.ID, Error: err.Error()})
+			continue
+		}
+		client.push(WSMessage{Type: WSTypeAccepted, Ref: msg.Ref, ID: req.ID})
+	}
+}
+
+// forget drops pending writes of a disconnected client
+func (s *WriteStream) forget(client *wsClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, p := range s.pending {
+		if p.client == client {
+			delete(s.pending, id)
+		}
+	}
+}
+
+// push queues a message without blocking the confirmation stream
+func (c *wsClient) push(msg WSMessage) {
+	select {
+	case c.send <- msg:
+	default:
+		log.Printf("PLC write stream: dropping %s message for slow client", msg.Type)
+	}
+}
+
+func (c *wsClient) writePump(done <-chan struct{}) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case msg := <-c.send:
+			if err := c.conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
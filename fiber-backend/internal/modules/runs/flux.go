@@ -0,0 +1,122 @@
+package runs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fiber-backend/internal/influxsink"
+
+	"github.com/influxdata/influxdb-client-go/v2/api"
+)
+
+// fluxString quotes s as a Flux string literal
+func fluxString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `${`, `\${`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// runRange selects the plc_data values collected during a run, they carry
+// the step tag the collector adds while a recipe runs. A running run ends
+// now.
+func runRange(bucket string, run *Run, now time.Time) string {
+	stop := now
+	if run.EndTime != nil {
+		stop = *run.EndTime
+	}
+	return fmt.Sprintf(`from(bucket: %s)
+|> range(start: %s, stop: %s)
+|> filter(fn: (r) => r._measurement == %s and r.machine_id == %s and r.chamber_id == %s and exists r.step)`,
+		fluxString(bucket),
+		run.StartTime.UTC().Format(time.RFC3339Nano), stop.Add(time.Millisecond).UTC().Format(time.RFC3339Nano),
+		fluxString(influxsink.Measurement), fluxString(run.MachineID), fluxString(run.ChamberID))
+}
+
+// statsQuery reduces the good numeric values of a run to count, min, max
+// and sum per symbol and step
+func statsQuery(bucket string, run *Run, now time.Time) string {
+	return `import "types"
+
+` + runRange(bucket, run, now) + `
+|> filter(fn: (r) => r.quality == "good" and types.isNumeric(v: r._value))
+|> map(fn: (r) => ({r with _value: float(v: r._value)}))
+|> group(columns: ["_field", "step"])
+|> reduce(identity: {count: 0, min: 0.0, max: 0.0, sum: 0.0}, fn: (r, accumulator) => ({
+    count: accumulator.count + 1,
+    min: if accumulator.count == 0 or r._value < accumulator.min then r._value else accumulator.min,
+    max: if accumulator.count == 0 or r._value > accumulator.max then r._value else accumulator.max,
+    sum: accumulator.sum + r._value,
+}))`
+}
+
+// seriesQuery returns every value of a run, of one symbol if symbol is set
+func seriesQuery(bucket string, run *Run, symbol string, now time.Time) string {
+	query := runRange(bucket, run, now)
+	if symbol != "" {
+		query += "\n|> filter(fn: (r) => r._field == " + fluxString(symbol) + ")"
+	}
+	return query + "\n|> keep(columns: [\"_time\", \"_field\", \"_value\", \"step\", \"quality\"])"
+}
+
+func queryStats(ctx context.Context, q api.QueryAPI, query string) ([]StepStats, error) {
+	result, err := q.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	stats := []StepStats{}
+	for result.Next() {
+		rec := result.Record()
+		s := StepStats{Symbol: rec.Field(), Step: stepOf(rec.ValueByKey("step"))}
+		s.Count, _ = rec.ValueByKey("count").(int64)
+		s.Min, _ = rec.ValueByKey("min").(float64)
+		s.Max, _ = rec.ValueByKey("max").(float64)
+		if sum, ok := rec.ValueByKey("sum").(float64); ok && s.Count > 0 {
+			s.Mean = sum / float64(s.Count)
+		}
+		stats = append(stats, s)
+	}
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Step != stats[j].Step {
+			return stats[i].Step < stats[j].Step
+		}
+		return stats[i].Symbol < stats[j].Symbol
+	})
+	return stats, nil
+}
+
+func querySeries(ctx context.Context, q api.QueryAPI, query string) ([]SeriesPoint, error) {
+	result, err := q.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	points := []SeriesPoint{}
+	for result.Next() {
+		rec := result.Record()
+		p := SeriesPoint{
+			Time:   rec.Time(),
+			Symbol: rec.Field(),
+			Value:  rec.Value(),
+			Step:   stepOf(rec.ValueByKey("step")),
+		}
+		p.Quality, _ = rec.ValueByKey("quality").(string)
+		points = append(points, p)
+	}
+	return points, result.Err()
+}
+
+func stepOf(v interface{}) int {
+	s, _ := v.(string)
+	step, _ := strconv.Atoi(s)
+	return step
+}
@@ -0,0 +1,174 @@
+package runs
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+type Handler struct {
+	Repo   Repository
+	Influx influxdb2.Client
+	Org    string
+	Bucket string
+}
+
+// List returns recipe runs, newest first
+// @Summary List recipe runs
+// @Description Recipe runs tracked by the collector, newest first
+// @Tags runs
+// @Produce json
+// @Security BearerAuth
+// @Param machine_id query string false "Machine ID"
+// @Param chamber_id query string false "Chamber ID"
+// @Param recipe_id query string false "Recipe ID"
+// @Param substrate_id query string false "Substrate ID"
+// @Param from query string false "Runs started at or after (RFC3339)"
+// @Param to query string false "Runs started before (RFC3339)"
+// @Param limit query int false "Limit" default(100)
+// @Param offset query int false "Offset" default(0)
+// @Success 200 {array} Run
+// @Failure 400 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /runs [get]
+func (h Handler) List(c fiber.Ctx) error {
+	limit, _ := strconv.Atoi(c.Query("limit", "100"))
+	offset, _ := strconv.Atoi(c.Query("offset", "0"))
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	f := ListFilter{
+		MachineID:   c.Query("machine_id"),
+		ChamberID:   c.Query("chamber_id"),
+		RecipeID:    c.Query("recipe_id"),
+		SubstrateID: c.Query("substrate_id"),
+		Limit:       limit,
+		Offset:      offset,
+	}
+	for param, dst := range map[string]**time.Time{"from": &f.From, "to": &f.To} {
+		if v := c.Query(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "invalid " + param + ", expected RFC3339"})
+			}
+			*dst = &t
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	runs, err := h.Repo.List(ctx, f)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(runs)
+}
+
+// Get returns a run with min/max/mean per symbol and step
+// @Summary Recipe run detail
+// @Description A recipe run with the min, max and mean of every numeric symbol per step, and the link to its full time series.
+// @Description The values are read from InfluxDB, only machines with influx_enabled write there: for other machines stats is always empty.
+// @Description When InfluxDB can not be queried the run is returned with empty stats and the reason in stats_error.
+// @Tags runs
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Run ID"
+// @Success 200 {object} RunDetail
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /runs/{id} [get]
+func (h Handler) Get(c fiber.Ctx) error {
+	run, err := h.run(c)
+	if err != nil {
+		return err
+	}
+	if run == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	detail := RunDetail{
+		Run:         *run,
+		Series:      c.BaseURL() + c.Path() + "/series",
+		SeriesQuery: seriesQuery(h.Bucket, run, "", now),
+	}
+	stats, err := queryStats(ctx, h.Influx.QueryAPI(h.Org), statsQuery(h.Bucket, run, now))
+	if err != nil {
+		detail.Stats = []StepStats{}
+		detail.StatsError = err.Error()
+	} else {
+		detail.Stats = stats
+	}
+
+	return c.JSON(detail)
+}
+
+// Series returns the values collected during a run
+// @Summary Recipe run time series
+// @Description Every value collected during a recipe run, read from InfluxDB. Only machines with influx_enabled write there.
+// @Tags runs
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Run ID"
+// @Param symbol query string false "Only this symbol"
+// @Success 200 {array} SeriesPoint
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /runs/{id}/series [get]
+func (h Handler) Series(c fiber.Ctx) error {
+	run, err := h.run(c)
+	if err != nil {
+		return err
+	}
+	if run == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	points, err := querySeries(ctx, h.Influx.QueryAPI(h.Org), seriesQuery(h.Bucket, run, c.Query("symbol"), time.Now()))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(points)
+}
+
+// run loads the run of the id parameter, it answers the request and returns
+// nil if there is none
+func (h Handler) run(c fiber.Ctx) (*Run, error) {
+	id := c.Params("id")
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "invalid run id"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	run, err := h.Repo.Get(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "run not found"})
+	}
+	if err != nil {
+		return nil, c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return run, nil
+}
@@ -0,0 +1,276 @@
+package runs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"fiber-backend/internal/auth"
+	"fiber-backend/internal/collector"
+	"fiber-backend/internal/middleware"
+
+	"github.com/gofiber/fiber/v3"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const runID = "4f0c3a7e-2b8d-4d6e-9a51-0c6f1e2b3d4a"
+
+type mockRepo struct {
+	mu          sync.Mutex
+	saved       []*Run
+	runs        map[string]*Run
+	filter      ListFilter
+	fail        int // saves to fail
+	interrupted bool
+}
+
+func (m *mockRepo) Save(ctx context.Context, r *Run) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail > 0 {
+		m.fail--
+		return errors.New("connection refused")
+	}
+	m.saved = append(m.saved, r)
+	return nil
+}
+
+func (m *mockRepo) InterruptOpen(ctx context.Context) (int64, error) {
+	m.interrupted = true
+	return 1, nil
+}
+
+func (m *mockRepo) List(ctx context.Context, f ListFilter) ([]Run, error) {
+	m.filter = f
+	return []Run{{ID: runID, MachineID: f.MachineID, RecipeID: f.RecipeID}}, nil
+}
+
+func (m *mockRepo) Get(ctx context.Context, id string) (*Run, error) {
+	run, ok := m.runs[id]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	return run, nil
+}
+
+// fakeInflux answers every Flux query with csv, or fails it if down, and
+// records the queries
+type fakeInflux struct {
+	mu      sync.Mutex
+	csv     string
+	down    bool
+	queries []string
+}
+
+func (f *fakeInflux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Query string `json:"query"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	f.mu.Lock()
+	f.queries = append(f.queries, body.Query)
+	f.mu.Unlock()
+	if f.down {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = io.WriteString(w, `{"code":"unavailable","message":"influxdb is starting"}`)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	_, _ = io.WriteString(w, f.csv)
+}
+
+func newApp(t *testing.T, repo *mockRepo, fake *fakeInflux) *fiber.App {
+	t.Helper()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	client := influxdb2.NewClient(srv.URL, "token")
+	t.Cleanup(client.Close)
+
+	t.Setenv("JWT_SECRET", "runs-test-secret")
+	app := fiber.New()
+	Routes(app.Group("/api/runs", middleware.JWT()), Handler{Repo: repo, Influx: client, Org: "org", Bucket: "plc-data"}, auth.NewAuthMiddleware(""))
+	return app
+}
+
+// get requests url as a user with the given permissions, chambers:read if nil
+func get(t *testing.T, app *fiber.App, url string, perms map[string][]string) *http.Response {
+	t.Helper()
+	if perms == nil {
+		perms = map[string][]string{"chambers": {"read"}}
+	}
+	tok, _, err := auth.GenerateAccessToken("user-1", "alice", []string{"operator"}, perms)
+	require.NoError(t, err)
+	req := httptest.NewRequest("GET", url, nil)
+	req.Header.Set("Authorization", "Bearer "+tok)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestList_Filters(t *testing.T) {
+	repo := &mockRepo{}
+	app := newApp(t, repo, &fakeInflux{})
+
+	resp := get(t, app, "/api/runs?machine_id=m1&chamber_id=c1&recipe_id=etch.rcp&substrate_id=W07&from=2026-01-02T03:04:05Z&limit=20", nil)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var runs []Run
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&runs))
+	require.Len(t, runs, 1)
+	assert.Equal(t, "etch.rcp", runs[0].RecipeID)
+
+	assert.Equal(t, ListFilter{
+		MachineID:   "m1",
+		ChamberID:   "c1",
+		RecipeID:    "etch.rcp",
+		SubstrateID: "W07",
+		From:        repo.filter.From,
+		Limit:       20,
+	}, repo.filter)
+	require.NotNil(t, repo.filter.From)
+	assert.Equal(t, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), *repo.filter.From)
+
+	resp = get(t, app, "/api/runs?to=yesterday", nil)
+	assert.Equal(t, 400, resp.StatusCode)
+
+	// reading runs needs chambers:read
+	for _, url := range []string{"/api/runs", "/api/runs/" + runID, "/api/runs/" + runID + "/series"} {
+		resp = get(t, app, url, map[string][]string{"users": {"read"}})
+		assert.Equal(t, 403, resp.StatusCode, url)
+	}
+}
+
+func TestGet_Stats(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	end := start.Add(10 * time.Minute)
+	repo := &mockRepo{runs: map[string]*Run{runID: {
+		ID: runID, MachineID: "m1", ChamberID: "c1", RecipeID: "etch.rcp", Status: "completed", StartTime: start, EndTime: &end,
+	}}}
+	fake := &fakeInflux{csv: `#datatype,string,long,string,string,long,double,double,double
+#group,false,false,true,true,false,false,false,false
+#default,_result,,,,,,,
+,result,table,_field,step,count,min,max,sum
+,,0,GVL.temp,2,2,40,50,90
+,,1,GVL.temp,1,4,10,30,80
+,,2,GVL.pressure,1,1,0.5,0.5,0.5
+
+`}
+	app := newApp(t, repo, fake)
+
+	resp := get(t, app, "/api/runs/"+runID, nil)
+	require.Equal(t, 200, resp.StatusCode)
+
+	var detail RunDetail
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&detail))
+	assert.Equal(t, "etch.rcp", detail.RecipeID)
+	assert.Equal(t, []StepStats{
+		{Step: 1, Symbol: "GVL.pressure", Count: 1, Min: 0.5, Max: 0.5, Mean: 0.5},
+		{Step: 1, Symbol: "GVL.temp", Count: 4, Min: 10, Max: 30, Mean: 20},
+		{Step: 2, Symbol: "GVL.temp", Count: 2, Min: 40, Max: 50, Mean: 45},
+	}, detail.Stats)
+	assert.Equal(t, "http://example.com/api/runs/"+runID+"/series", detail.Series)
+	assert.Contains(t, detail.SeriesQuery, `range(start: 2026-01-02T03:04:05Z, stop: 2026-01-02T03:14:05.001Z)`)
+
+	require.Len(t, fake.queries, 1)
+	assert.Contains(t, fake.queries[0], `r._measurement == "plc_data" and r.machine_id == "m1" and r.chamber_id == "c1" and exists r.step`)
+
+	resp = get(t, app, "/api/runs/3c9d3f0e-1111-4d6e-9a51-0c6f1e2b3d4a", nil)
+	assert.Equal(t, 404, resp.StatusCode)
+
+	resp = get(t, app, "/api/runs/latest", nil)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestGet_InfluxDown(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	repo := &mockRepo{runs: map[string]*Run{runID: {ID: runID, MachineID: "m1", ChamberID: "c1", StartTime: start}}}
+	app := newApp(t, repo, &fakeInflux{down: true})
+
+	resp := get(t, app, "/api/runs/"+runID, nil)
+	require.Equal(t, 200, resp.StatusCode)
+
+	var detail map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&detail))
+	assert.Equal(t, runID, detail["id"])
+	assert.Equal(t, []interface{}{}, detail["stats"])
+	assert.Contains(t, detail["stats_error"], "influxdb is starting")
+	assert.NotEmpty(t, detail["series"])
+}
+
+func TestSeries_Symbol(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	repo := &mockRepo{runs: map[string]*Run{runID: {ID: runID, MachineID: "m1", ChamberID: "c1", StartTime: start}}}
+	fake := &fakeInflux{csv: `#datatype,string,long,dateTime:RFC3339,string,double,string,string
+#group,false,false,false,true,false,true,true
+#default,_result,,,,,,
+,result,table,_time,_field,_value,quality,step
+,,0,2026-01-02T03:04:06Z,GVL.temp,21.5,good,1
+,,0,2026-01-02T03:04:07Z,GVL.temp,22,good,1
+
+`}
+	app := newApp(t, repo, fake)
+
+	resp := get(t, app, "/api/runs/"+runID+"/series?symbol=GVL.temp", nil)
+	require.Equal(t, 200, resp.StatusCode)
+
+	var points []SeriesPoint
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&points))
+	require.Len(t, points, 2)
+	assert.Equal(t, SeriesPoint{Time: start.Add(time.Second), Symbol: "GVL.temp", Value: 21.5, Step: 1, Quality: "good"}, points[0])
+
+	require.Len(t, fake.queries, 1)
+	assert.Contains(t, fake.queries[0], `|> filter(fn: (r) => r._field == "GVL.temp")`)
+}
+
+func TestService_SavesRuns(t *testing.T) {
+	repo := &mockRepo{fail: 1}
+	svc := NewService(repo)
+	svc.RetryInterval = time.Millisecond
+	require.NoError(t, svc.InterruptOpen(context.Background()))
+	assert.True(t, repo.interrupted)
+
+	start := time.Now()
+	svc.RunStarted(collector.RecipeContext{RunID: runID, MachineID: "m1", ChamberID: "c1", Status: collector.RunRunning, StartTime: start})
+	svc.RunEnded(collector.RecipeContext{RunID: runID, MachineID: "m1", ChamberID: "c1", Status: collector.RunInterrupted, StartTime: start, EndTime: start.Add(time.Minute)})
+	require.Eventually(t, func() bool {
+		repo.mu.Lock()
+		defer repo.mu.Unlock()
+		return len(repo.saved) == 2
+	}, time.Second, 5*time.Millisecond)
+	svc.Close()
+
+	// the failed start is retried ahead of the end
+	require.Len(t, repo.saved, 2)
+	assert.Equal(t, collector.RunRunning, repo.saved[0].Status)
+	assert.Nil(t, repo.saved[0].EndTime)
+	assert.Equal(t, collector.RunInterrupted, repo.saved[1].Status)
+	require.NotNil(t, repo.saved[1].EndTime)
+	assert.Equal(t, start.Add(time.Minute), *repo.saved[1].EndTime)
+}
+
+func TestService_CloseDrains(t *testing.T) {
+	repo := &mockRepo{}
+	svc := NewService(repo)
+	for i := 0; i < 50; i++ {
+		svc.RunEnded(collector.RecipeContext{RunID: runID, Status: collector.RunInterrupted, EndTime: time.Now()})
+	}
+	svc.Close()
+	assert.Len(t, repo.saved, 50)
+
+	svc.RunEnded(collector.RecipeContext{RunID: runID})
+	assert.Len(t, repo.saved, 50)
+}
+
+func TestFluxString(t *testing.T) {
+	assert.Equal(t, `"a\"b\\c\${d}"`, fluxString(`a"b\c${d}`))
+}
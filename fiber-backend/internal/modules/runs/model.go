@@ -0,0 +1,63 @@
+package runs
+
+import (
+	"time"
+)
+
+// Run is a recipe run of a chamber, EndTime is nil while it runs
+type Run struct {
+	ID          string         `json:"id"`
+	MachineID   string         `json:"machine_id"`
+	ChamberID   string         `json:"chamber_id"`
+	RecipeID    string         `json:"recipe_id"`
+	ProcessJob  string         `json:"process_job"`
+	SubstrateID string         `json:"substrate_id"`
+	Status      string         `json:"status"`
+	StartTime   time.Time      `json:"start_time"`
+	EndTime     *time.Time     `json:"end_time"`
+	Fields      map[string]any `json:"fields,omitempty"`
+}
+
+// ListFilter narrows the run query, empty fields match everything. From and
+// To bound the start of the runs.
+type ListFilter struct {
+	MachineID   string
+	ChamberID   string
+	RecipeID    string
+	SubstrateID string
+	From        *time.Time
+	To          *time.Time
+	Limit       int
+	Offset      int
+}
+
+// StepStats summarise the numeric values of one symbol during one step of a
+// run
+type StepStats struct {
+	Step   int     `json:"step"`
+	Symbol string  `json:"symbol"`
+	Count  int64   `json:"count"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+}
+
+// RunDetail is a run with its statistics. StatsError is set when InfluxDB
+// could not be queried, Stats is empty then. Series is the link to the run's
+// full time series, SeriesQuery the Flux query behind it.
+type RunDetail struct {
+	Run
+	Stats       []StepStats `json:"stats"`
+	StatsError  string      `json:"stats_error,omitempty"`
+	Series      string      `json:"series"`
+	SeriesQuery string      `json:"series_query"`
+}
+
+// SeriesPoint is one collected value of a run
+type SeriesPoint struct {
+	Time    time.Time `json:"time"`
+	Symbol  string    `json:"symbol"`
+	Value   any       `json:"value"`
+	Step    int       `json:"step"`
+	Quality string    `json:"quality"`
+}
@@ -0,0 +1,115 @@
+package runs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"fiber-backend/internal/collector"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Repository interface {
+	Save(ctx context.Context, r *Run) error
+	List(ctx context.Context, f ListFilter) ([]Run, error)
+	Get(ctx context.Context, id string) (*Run, error)
+	InterruptOpen(ctx context.Context) (int64, error)
+}
+
+type PgRepo struct {
+	DB *pgxpool.Pool
+}
+
+var _ Repository = (*PgRepo)(nil)
+
+const runColumns = `id, machine_id, chamber_id, recipe_id, process_job, substrate_id, status, start_time, end_time, fields`
+
+// Save inserts a run or updates its end. The start and the end of a run are
+// saved concurrently, a start saved after the end does not reopen the run.
+func (r PgRepo) Save(ctx context.Context, run *Run) error {
+	_, err := r.DB.Exec(ctx,
+		`INSERT INTO recipe_runs (`+runColumns+`)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		 ON CONFLICT (id) DO UPDATE SET status = EXCLUDED.status, end_time = EXCLUDED.end_time, updated_at = NOW()
+		 WHERE recipe_runs.end_time IS NULL`,
+		run.ID, run.MachineID, run.ChamberID, run.RecipeID, run.ProcessJob, run.SubstrateID, run.Status, run.StartTime, run.EndTime, run.Fields,
+	)
+	return err
+}
+
+func (r PgRepo) List(ctx context.Context, f ListFilter) ([]Run, error) {
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.MachineID != "" {
+		add("machine_id = $%d", f.MachineID)
+	}
+	if f.ChamberID != "" {
+		add("chamber_id = $%d", f.ChamberID)
+	}
+	if f.RecipeID != "" {
+		add("recipe_id = $%d", f.RecipeID)
+	}
+	if f.SubstrateID != "" {
+		add("substrate_id = $%d", f.SubstrateID)
+	}
+	if f.From != nil {
+		add("start_time >= $%d", *f.From)
+	}
+	if f.To != nil {
+		add("start_time < $%d", *f.To)
+	}
+
+	query := `SELECT ` + runColumns + ` FROM recipe_runs`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, f.Limit, f.Offset)
+	query += fmt.Sprintf(" ORDER BY start_time DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := r.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []Run{}
+	for rows.Next() {
+		var run Run
+		err := rows.Scan(&run.ID, &run.MachineID, &run.ChamberID, &run.RecipeID, &run.ProcessJob, &run.SubstrateID, &run.Status, &run.StartTime, &run.EndTime, &run.Fields)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// Get returns a run, pgx.ErrNoRows if there is none with id
+func (r PgRepo) Get(ctx context.Context, id string) (*Run, error) {
+	var run Run
+	err := r.DB.QueryRow(ctx, `SELECT `+runColumns+` FROM recipe_runs WHERE id = $1`, id).
+		Scan(&run.ID, &run.MachineID, &run.ChamberID, &run.RecipeID, &run.ProcessJob, &run.SubstrateID, &run.Status, &run.StartTime, &run.EndTime, &run.Fields)
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// InterruptOpen ends every run without an end as interrupted. Nothing is
+// collected while the backend is down, so ending them now adds no values of
+// other runs to them.
+func (r PgRepo) InterruptOpen(ctx context.Context) (int64, error) {
+	tag, err := r.DB.Exec(ctx,
+		`UPDATE recipe_runs SET status = $1, end_time = NOW(), updated_at = NOW() WHERE end_time IS NULL`,
+		collector.RunInterrupted,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
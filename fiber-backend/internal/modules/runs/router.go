@@ -0,0 +1,17 @@
+package runs
+
+import (
+	"fiber-backend/internal/auth"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// Routes registers the recipe runs. router must be behind the JWT
+// middleware, reading runs needs chambers:read like the PLC API.
+func Routes(router fiber.Router, h Handler, authz *auth.AuthMiddleware) {
+	read := authz.RequirePermission("chambers", "read")
+
+	router.Get("/", read, h.List)
+	router.Get("/:id", read, h.Get)
+	router.Get("/:id/series", read, h.Series)
+}
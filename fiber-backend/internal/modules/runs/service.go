@@ -0,0 +1,71 @@
+package runs
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"fiber-backend/internal/collector"
+	"fiber-backend/internal/persist"
+)
+
+const DefaultQueueSize = 1000
+
+// Service persists the recipe runs seen by the collector. Runs are queued
+// and saved in order by one worker, so the end of a run is never saved
+// before its start; a failed save is retried, see persist.Queue.
+type Service struct {
+	*persist.Queue[*Run]
+	Repo Repository
+}
+
+var _ collector.RunRecorder = (*Service)(nil)
+
+func NewService(repo Repository) *Service {
+	return &Service{
+		Queue: persist.NewQueue("recipe runs", DefaultQueueSize, repo.Save, func(r *Run) string {
+			return fmt.Sprintf("%s on %s/%s", r.ID, r.MachineID, r.ChamberID)
+		}),
+		Repo: repo,
+	}
+}
+
+// InterruptOpen ends the runs left running by a previous process as
+// interrupted. Call it before the collector starts.
+func (s *Service) InterruptOpen(ctx context.Context) error {
+	n, err := s.Repo.InterruptOpen(ctx)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("recipe runs: %d runs left open marked %s", n, collector.RunInterrupted)
+	}
+	return nil
+}
+
+func (s *Service) RunStarted(rc collector.RecipeContext) {
+	s.Add(fromContext(rc))
+}
+
+func (s *Service) RunEnded(rc collector.RecipeContext) {
+	s.Add(fromContext(rc))
+}
+
+func fromContext(rc collector.RecipeContext) *Run {
+	run := &Run{
+		ID:          rc.RunID,
+		MachineID:   rc.MachineID,
+		ChamberID:   rc.ChamberID,
+		RecipeID:    rc.RecipeID,
+		ProcessJob:  rc.ProcessJob,
+		SubstrateID: rc.SubstrateID,
+		Status:      rc.Status,
+		StartTime:   rc.StartTime,
+		Fields:      rc.Fields,
+	}
+	if !rc.EndTime.IsZero() {
+		end := rc.EndTime
+		run.EndTime = &end
+	}
+	return run
+}
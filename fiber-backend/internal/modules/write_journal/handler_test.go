@@ -0,0 +1,160 @@
+package write_journal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"fiber-backend/internal/auth"
+	"fiber-backend/internal/middleware"
+	"fiber-backend/internal/plcengine"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockRepo struct {
+	mu      sync.Mutex
+	created []*WriteEntry
+	filter  ListFilter
+	fail    int // inserts to fail
+	calls   int
+}
+
+func (m *mockRepo) Create(ctx context.Context, e *WriteEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls++
+	if m.fail > 0 {
+		m.fail--
+		return errors.New("connection refused")
+	}
+	m.created = append(m.created, e)
+	return nil
+}
+
+func (m *mockRepo) List(ctx context.Context, f ListFilter) ([]WriteEntry, error) {
+	m.filter = f
+	return []WriteEntry{{ID: "1", MachineID: f.MachineID, Symbol: f.Symbol, Success: true}}, nil
+}
+
+func (m *mockRepo) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.created)
+}
+
+func TestList_Filters(t *testing.T) {
+	t.Setenv("JWT_SECRET", "journal-test-secret")
+	repo := &mockRepo{}
+	app := fiber.New()
+	Routes(app.Group("/api/plc/writes", middleware.JWT()), repo, auth.NewAuthMiddleware(""))
+
+	get := func(url string, perms map[string][]string) *http.Response {
+		tok, _, err := auth.GenerateAccessToken("user-1", "alice", []string{"operator"}, perms)
+		require.NoError(t, err)
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+	readOnly := map[string][]string{"chambers": {"read"}}
+
+	resp := get("/api/plc/writes?machine_id=m1", nil)
+	assert.Equal(t, 403, resp.StatusCode)
+
+	resp = get("/api/plc/writes?machine_id=m1&symbol=GVL.Temp&from=2026-01-02T03:04:05Z&limit=5000", readOnly)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var entries []WriteEntry
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&entries))
+	require.Len(t, entries, 1)
+	assert.Equal(t, "GVL.Temp", entries[0].Symbol)
+
+	assert.Equal(t, "m1", repo.filter.MachineID)
+	assert.Equal(t, "GVL.Temp", repo.filter.Symbol)
+	assert.Equal(t, 100, repo.filter.Limit)
+	require.NotNil(t, repo.filter.From)
+	assert.Equal(t, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), *repo.filter.From)
+	assert.Nil(t, repo.filter.To)
+
+	resp = get("/api/plc/writes?to=yesterday", readOnly)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestService_RecordWrite(t *testing.T) {
+	repo := &mockRepo{}
+	svc := NewService(repo)
+
+	now := time.Now()
+	svc.RecordWrite(plcengine.WriteRecord{
+		RequestID:      "w1",
+		MachineID:      "m1",
+		Symbol:         "GVL.Temp",
+		PreviousValue:  float32(20),
+		RequestedValue: float32(500),
+		RejectReason:   plcengine.RejectOutOfRange,
+		Error:          "out of range",
+		Latency:        1500 * time.Microsecond,
+		Timestamp:      now,
+	})
+
+	require.Eventually(t, func() bool { return repo.count() == 1 }, time.Second, 5*time.Millisecond)
+	e := repo.created[0]
+	assert.Equal(t, "w1", *e.RequestID)
+	assert.Nil(t, e.UserID)
+	assert.Equal(t, "out_of_range", *e.RejectReason)
+	assert.Equal(t, 1.5, e.LatencyMs)
+	assert.Equal(t, float32(500), e.RequestedValue)
+	assert.Equal(t, now, e.CreatedAt)
+}
+
+func TestService_Retry(t *testing.T) {
+	repo := &mockRepo{fail: 2}
+	svc := NewService(repo)
+	svc.RetryInterval = time.Millisecond
+	defer svc.Close()
+
+	for i := 0; i < 3; i++ {
+		svc.RecordWrite(plcengine.WriteRecord{RequestID: string(rune('a' + i)), MachineID: "m1", Symbol: "GVL.Temp"})
+	}
+
+	// the failed entry is retried ahead of the others
+	require.Eventually(t, func() bool { return repo.count() == 3 }, time.Second, 5*time.Millisecond)
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	assert.Equal(t, 5, repo.calls)
+	for i, e := range repo.created {
+		assert.Equal(t, string(rune('a'+i)), *e.RequestID)
+	}
+}
+
+func TestService_CloseDrains(t *testing.T) {
+	repo := &mockRepo{}
+	svc := NewService(repo)
+	for i := 0; i < 100; i++ {
+		svc.RecordWrite(plcengine.WriteRecord{MachineID: "m1", Symbol: "GVL.Temp"})
+	}
+	svc.Close()
+	assert.Equal(t, 100, repo.count())
+
+	// entries recorded after Close are not queued
+	svc.RecordWrite(plcengine.WriteRecord{MachineID: "m1", Symbol: "GVL.Temp"})
+	assert.Equal(t, 100, repo.count())
+
+	// a failing insert is not retried once closing
+	repo = &mockRepo{fail: 100}
+	svc = NewService(repo)
+	svc.RetryInterval = time.Hour
+	svc.RecordWrite(plcengine.WriteRecord{MachineID: "m1", Symbol: "GVL.Temp"})
+	svc.RecordWrite(plcengine.WriteRecord{MachineID: "m1", Symbol: "GVL.Temp"})
+	svc.Close()
+	assert.Equal(t, 2, repo.calls)
+}
@@ -0,0 +1,33 @@
+package write_journal
+
+import (
+	"time"
+)
+
+// WriteEntry is one journaled PLC write attempt
+type WriteEntry struct {
+	ID             string    `json:"id"`
+	RequestID      *string   `json:"request_id"`
+	UserID         *string   `json:"user_id"`
+	MachineID      string    `json:"machine_id"`
+	Symbol         string    `json:"symbol"`
+	PreviousValue  any       `json:"previous_value"`
+	RequestedValue any       `json:"requested_value"`
+	ReadbackValue  any       `json:"readback_value"`
+	Success        bool      `json:"success"`
+	ErrorMessage   *string   `json:"error_message"`
+	RejectReason   *string   `json:"reject_reason"`
+	LatencyMs      float64   `json:"latency_ms"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// ListFilter narrows the journal query, empty fields match everything
+type ListFilter struct {
+	MachineID string
+	Symbol    string
+	UserID    string
+	From      *time.Time
+	To        *time.Time
+	Limit     int
+	Offset    int
+}
@@ -0,0 +1,99 @@
+package write_journal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Repository interface {
+	Create(ctx context.Context, e *WriteEntry) error
+	List(ctx context.Context, f ListFilter) ([]WriteEntry, error)
+}
+
+type PgRepo struct {
+	DB *pgxpool.Pool
+}
+
+func (r PgRepo) Create(ctx context.Context, e *WriteEntry) error {
+	prev, err := jsonValue(e.PreviousValue)
+	if err != nil {
+		return err
+	}
+	requested, err := jsonValue(e.RequestedValue)
+	if err != nil {
+		return err
+	}
+	readback, err := jsonValue(e.ReadbackValue)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.DB.Exec(ctx,
+		`INSERT INTO plc_write_journal (request_id, user_id, machine_id, symbol, previous_value, requested_value, readback_value, success, error_message, reject_reason, latency_ms, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		e.RequestID, e.UserID, e.MachineID, e.Symbol, prev, requested, readback, e.Success, e.ErrorMessage, e.RejectReason, e.LatencyMs, e.CreatedAt,
+	)
+	return err
+}
+
+func (r PgRepo) List(ctx context.Context, f ListFilter) ([]WriteEntry, error) {
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.MachineID != "" {
+		add("machine_id = $%d", f.MachineID)
+	}
+	if f.Symbol != "" {
+		add("symbol = $%d", f.Symbol)
+	}
+	if f.UserID != "" {
+		add("user_id = $%d", f.UserID)
+	}
+	if f.From != nil {
+		add("created_at >= $%d", *f.From)
+	}
+	if f.To != nil {
+		add("created_at < $%d", *f.To)
+	}
+
+	query := `SELECT id, request_id, user_id, machine_id, symbol, previous_value, requested_value, readback_value, success, error_message, reject_reason, latency_ms, created_at
+		 FROM plc_write_journal`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, f.Limit, f.Offset)
+	query += fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := r.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []WriteEntry{}
+	for rows.Next() {
+		var e WriteEntry
+		err := rows.Scan(&e.ID, &e.RequestID, &e.UserID, &e.MachineID, &e.Symbol, &e.PreviousValue, &e.RequestedValue, &e.ReadbackValue, &e.Success, &e.ErrorMessage, &e.RejectReason, &e.LatencyMs, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// jsonValue encodes a PLC value for a JSONB column. pgx would send strings
+// unquoted, so values are always marshalled here.
+func jsonValue(v any) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
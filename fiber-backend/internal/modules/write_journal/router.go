@@ -0,0 +1,62 @@
+package write_journal
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"fiber-backend/internal/auth"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+type Handler struct {
+	Repo Repository
+}
+
+// List returns journaled writes, newest first. Supports machine_id, symbol,
+// user_id, from/to (RFC3339), limit and offset query parameters.
+func (h Handler) List(c fiber.Ctx) error {
+	limit, _ := strconv.Atoi(c.Query("limit", "100"))
+	offset, _ := strconv.Atoi(c.Query("offset", "0"))
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	f := ListFilter{
+		MachineID: c.Query("machine_id"),
+		Symbol:    c.Query("symbol"),
+		UserID:    c.Query("user_id"),
+		Limit:     limit,
+		Offset:    offset,
+	}
+	for param, dst := range map[string]**time.Time{"from": &f.From, "to": &f.To} {
+		if v := c.Query(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "invalid " + param + ", expected RFC3339"})
+			}
+			*dst = &t
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	entries, err := h.Repo.List(ctx, f)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(entries)
+}
+
+// Routes registers the journal. router must be behind the JWT middleware,
+// reading the journal needs chambers:read like the PLC API.
+func Routes(router fiber.Router, repo Repository, authz *auth.AuthMiddleware) {
+	h := Handler{Repo: repo}
+	router.Get("/", authz.RequirePermission("chambers", "read"), h.List)
+}
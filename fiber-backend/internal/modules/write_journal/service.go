@@ -0,0 +1,63 @@
+package write_journal
+
+import (
+	"fmt"
+	"time"
+
+	"fiber-backend/internal/persist"
+	"fiber-backend/internal/plcengine"
+)
+
+const DefaultQueueSize = 10000
+
+// Service journals the write attempts of the PLC engine. Entries are queued
+// and inserted in order by one worker, a failed insert is retried, see
+// persist.Queue. Recording never holds up the engine's writer: while the
+// database is down and the queue is full entries are dropped.
+type Service struct {
+	*persist.Queue[*WriteEntry]
+	Repo Repository
+}
+
+var _ plcengine.WriteRecorder = (*Service)(nil)
+
+func NewService(repo Repository) *Service {
+	return &Service{
+		Queue: persist.NewQueue("write journal", DefaultQueueSize, repo.Create, func(e *WriteEntry) string {
+			return fmt.Sprintf("%s/%s by %v", e.MachineID, e.Symbol, optionalString(e.UserID))
+		}),
+		Repo: repo,
+	}
+}
+
+// RecordWrite queues a write attempt without blocking
+func (s *Service) RecordWrite(rec plcengine.WriteRecord) {
+	s.Add(&WriteEntry{
+		RequestID:      optional(rec.RequestID),
+		UserID:         optional(rec.UserID),
+		MachineID:      rec.MachineID,
+		Symbol:         rec.Symbol,
+		PreviousValue:  rec.PreviousValue,
+		RequestedValue: rec.RequestedValue,
+		ReadbackValue:  rec.ReadbackValue,
+		Success:        rec.Success,
+		ErrorMessage:   optional(rec.Error),
+		RejectReason:   optional(string(rec.RejectReason)),
+		LatencyMs:      float64(rec.Latency) / float64(time.Millisecond),
+		CreatedAt:      rec.Timestamp,
+	})
+}
+
+func optionalString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,128 @@
+package persist
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultMaxAttempts   = 5
+	DefaultRetryInterval = time.Second
+	saveTimeout          = 5 * time.Second
+)
+
+// Queue saves items in order on one worker and retries a failed save. Add
+// never blocks the caller, an item that does not fit is dropped and counted.
+type Queue[T any] struct {
+	// MaxAttempts of a save, RetryInterval is the first delay between them
+	// and doubles. Set before the first Add.
+	MaxAttempts   int
+	RetryInterval time.Duration
+
+	name     string
+	save     func(context.Context, T) error
+	describe func(T) string
+
+	closed  atomic.Bool
+	dropped atomic.Uint64
+	queue   chan T
+	closing chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewQueue starts the worker of a queue of size items. name prefixes the log
+// lines, describe names an item in them.
+func NewQueue[T any](name string, size int, save func(context.Context, T) error, describe func(T) string) *Queue[T] {
+	q := &Queue[T]{
+		MaxAttempts:   DefaultMaxAttempts,
+		RetryInterval: DefaultRetryInterval,
+		name:          name,
+		save:          save,
+		describe:      describe,
+		queue:         make(chan T, size),
+		closing:       make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Add queues item, it reports false if the queue is full or closed
+func (q *Queue[T]) Add(item T) bool {
+	if q.closed.Load() {
+		log.Printf("%s: closed, %s not saved", q.name, q.describe(item))
+		return false
+	}
+	select {
+	case q.queue <- item:
+		return true
+	default:
+	}
+	if n := q.dropped.Add(1); n == 1 || n%1000 == 0 {
+		log.Printf("%s: queue full, %s not saved (%d dropped)", q.name, q.describe(item), n)
+	}
+	return false
+}
+
+// Dropped returns how many items did not fit in the queue
+func (q *Queue[T]) Dropped() uint64 {
+	return q.dropped.Load()
+}
+
+// Close saves the queued items and stops the worker. Failed saves are no
+// longer retried once Close is called. Stop the callers of Add first, an
+// item added while Close runs may be lost.
+func (q *Queue[T]) Close() {
+	q.once.Do(func() {
+		q.closed.Store(true)
+		close(q.closing)
+	})
+	<-q.done
+}
+
+func (q *Queue[T]) run() {
+	defer close(q.done)
+	for {
+		select {
+		case item := <-q.queue:
+			q.store(item)
+		case <-q.closing:
+			for {
+				select {
+				case item := <-q.queue:
+					q.store(item)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// store saves an item, retrying until MaxAttempts or Close
+func (q *Queue[T]) store(item T) {
+	delay := q.RetryInterval
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), saveTimeout)
+		err := q.save(ctx, item)
+		cancel()
+		if err == nil {
+			return
+		}
+		if attempt >= q.MaxAttempts {
+			log.Printf("%s: %s not saved after %d attempts: %v", q.name, q.describe(item), attempt, err)
+			return
+		}
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-q.closing:
+			log.Printf("%s: %s not saved: %v", q.name, q.describe(item), err)
+			return
+		}
+	}
+}
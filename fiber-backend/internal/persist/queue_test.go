@@ -0,0 +1,111 @@
+package persist
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// store records saved items, failing the first fail saves and holding every
+// save while hold is set
+type store struct {
+	mu    sync.Mutex
+	saved []int
+	fail  int
+	hold  chan struct{}
+}
+
+func (s *store) save(ctx context.Context, n int) error {
+	if s.hold != nil {
+		<-s.hold
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail > 0 {
+		s.fail--
+		return errors.New("connection refused")
+	}
+	s.saved = append(s.saved, n)
+	return nil
+}
+
+func (s *store) items() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int(nil), s.saved...)
+}
+
+func newTestQueue(s *store, size int) *Queue[int] {
+	return NewQueue("test", size, s.save, strconv.Itoa)
+}
+
+func TestQueue_RetriesInOrder(t *testing.T) {
+	s := &store{fail: 2}
+	q := newTestQueue(s, 10)
+	q.RetryInterval = time.Millisecond
+	for i := 1; i <= 3; i++ {
+		require.True(t, q.Add(i))
+	}
+	require.Eventually(t, func() bool { return len(s.items()) == 3 }, time.Second, time.Millisecond)
+	q.Close()
+	assert.Equal(t, []int{1, 2, 3}, s.items())
+
+	// gives up after MaxAttempts
+	s = &store{fail: 2}
+	q = newTestQueue(s, 10)
+	q.MaxAttempts = 2
+	q.RetryInterval = time.Millisecond
+	q.Add(1)
+	q.Add(2)
+	require.Eventually(t, func() bool { return len(s.items()) == 1 }, time.Second, time.Millisecond)
+	q.Close()
+	assert.Equal(t, []int{2}, s.items())
+}
+
+func TestQueue_FullDoesNotBlock(t *testing.T) {
+	s := &store{hold: make(chan struct{})}
+	q := newTestQueue(s, 2)
+
+	// the worker holds one item, two are queued, the rest is dropped
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 10; i++ {
+			q.Add(i)
+			if i == 1 {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Add blocked on a full queue")
+	}
+	assert.Equal(t, uint64(7), q.Dropped())
+
+	close(s.hold)
+	q.Close()
+	assert.Equal(t, []int{1, 2, 3}, s.items())
+}
+
+func TestQueue_CloseDrains(t *testing.T) {
+	s := &store{fail: 1}
+	q := newTestQueue(s, 100)
+	q.RetryInterval = time.Hour
+	for i := 0; i < 50; i++ {
+		q.Add(i)
+	}
+	q.Close()
+
+	// the failed first save is not retried after Close
+	assert.Len(t, s.items(), 49)
+	assert.False(t, q.Add(50))
+	q.Close()
+}
@@ -0,0 +1,265 @@
+package plcengine
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AMS/TCP transport constants
+const (
+	AMSTCPPort = 48898
+
+	amsTCPHeaderLen = 6
+	amsHeaderLen    = 32
+)
+
+// ADS command IDs
+const (
+	adsCmdReadDeviceInfo     uint16 = 1
+	adsCmdRead               uint16 = 2
+	adsCmdWrite              uint16 = 3
+	adsCmdReadState          uint16 = 4
+	adsCmdWriteControl       uint16 = 5
+	adsCmdAddNotification    uint16 = 6
+	adsCmdDeleteNotification uint16 = 7
+	adsCmdNotification       uint16 = 8
+	adsCmdReadWrite          uint16 = 9
+)
+
+// AMS state flags
+const (
+	amsStateRequest  uint16 = 0x0004
+	amsStateResponse uint16 = 0x0005
+)
+
+// Reserved ADS index groups used for symbolic access
+const (
+	ADSIGrpSymHandleByName uint32 = 0xF003
+	ADSIGrpSymValueByName  uint32 = 0xF004
+	ADSIGrpSymValueByHnd   uint32 = 0xF005
+	ADSIGrpSymReleaseHnd   uint32 = 0xF006
+	ADSIGrpSymInfoByNameEx uint32 = 0xF009
+	ADSIGrpSumRead         uint32 = 0xF080
+	ADSIGrpSumWrite        uint32 = 0xF081
+	ADSIGrpSumReadWrite    uint32 = 0xF082
+)
+
+// maxSumCommands is the number of sub-commands TwinCAT accepts in one sum request
+const maxSumCommands = 500
+
+// ADS data type IDs (ADST_*) as reported in symbol entries
+const (
+	adsTypeVoid    uint32 = 0
+	adsTypeInt16   uint32 = 2
+	adsTypeInt32   uint32 = 3
+	adsTypeReal32  uint32 = 4
+	adsTypeReal64  uint32 = 5
+	adsTypeInt8    uint32 = 16
+	adsTypeUInt8   uint32 = 17
+	adsTypeUInt16  uint32 = 18
+	adsTypeUInt32  uint32 = 19
+	adsTypeInt64   uint32 = 20
+	adsTypeUInt64  uint32 = 21
+	adsTypeString  uint32 = 30
+	adsTypeWString uint32 = 31
+	adsTypeBit     uint32 = 33
+	adsTypeBigType uint32 = 65
+)
+
+// ADS error codes that the engine reacts to
+const (
+	ADSErrNoError             uint32 = 0x000
+	ADSErrTargetPortNotFound  uint32 = 0x006
+	ADSErrTargetNotFound      uint32 = 0x007
+	ADSErrDeviceError         uint32 = 0x700
+	ADSErrServiceNotSupported uint32 = 0x701
+	ADSErrInvalidGroup        uint32 = 0x702
+	ADSErrInvalidOffset       uint32 = 0x703
+	ADSErrInvalidAccess       uint32 = 0x704
+	ADSErrInvalidSize         uint32 = 0x705
+	ADSErrInvalidData         uint32 = 0x706
+	ADSErrNotReady            uint32 = 0x707
+	ADSErrBusy                uint32 = 0x708
+	ADSErrSymbolNotFound      uint32 = 0x710
+	ADSErrSymbolVersion       uint32 = 0x711
+	ADSErrInvalidState        uint32 = 0x712
+	ADSErrTransModeNotSupp    uint32 = 0x713
+	ADSErrInvalidNotifyHandle uint32 = 0x714
+	ADSErrNoMoreNotifyHandles uint32 = 0x716
+	ADSErrDeviceTimeout       uint32 = 0x719
+	ADSErrClientSyncTimeout   uint32 = 0x745
+)
+
+var adsErrorText = map[uint32]string{
+	0x001:                     "internal error",
+	0x002:                     "no real time",
+	0x005:                     "mailbox full",
+	ADSErrTargetPortNotFound:  "target port not found",
+	ADSErrTargetNotFound:      "target machine not found",
+	0x008:                     "unknown command ID",
+	0x012:                     "port disabled",
+	0x013:                     "port already connected",
+	0x018:                     "invalid AMS net ID",
+	ADSErrDeviceError:         "general device error",
+	ADSErrServiceNotSupported: "service not supported by server",
+	ADSErrInvalidGroup:        "invalid index group",
+	ADSErrInvalidOffset:       "invalid index offset",
+	ADSErrInvalidAccess:       "reading/writing not permitted",
+	ADSErrInvalidSize:         "parameter size not correct",
+	ADSErrInvalidData:         "invalid parameter value(s)",
+	ADSErrNotReady:            "device not in ready state",
+	ADSErrBusy:                "device busy",
+	0x709:                     "invalid context",
+	0x70A:                     "out of memory",
+	0x70B:                     "invalid parameter value(s)",
+	0x70C:                     "not found",
+	0x70D:                     "syntax error",
+	0x70E:                     "objects do not match",
+	0x70F:                     "object already exists",
+	ADSErrSymbolNotFound:      "symbol not found",
+	ADSErrSymbolVersion:       "symbol version invalid",
+	ADSErrInvalidState:        "device in invalid state",
+	ADSErrTransModeNotSupp:    "transmission mode not supported",
+	ADSErrInvalidNotifyHandle: "notification handle is invalid",
+	0x715:                     "notification client not registered",
+	ADSErrNoMoreNotifyHandles: "no further notification handle",
+	0x717:                     "notification size too large",
+	0x718:                     "device not initialized",
+	ADSErrDeviceTimeout:       "device timeout",
+	0x71A:                     "interface query failed",
+	0x71B:                     "wrong interface requested",
+	0x71C:                     "class ID is invalid",
+	0x71D:                     "object ID is invalid",
+	0x71E:                     "request pending",
+	0x71F:                     "request aborted",
+	0x720:                     "signal warning",
+	0x721:                     "invalid array index",
+	0x722:                     "symbol not active",
+	0x723:                     "access denied",
+	0x740:                     "client error",
+	0x741:                     "service contains an invalid parameter",
+	0x742:                     "polling list is empty",
+	0x743:                     "var connection already in use",
+	0x744:                     "invoke ID in use",
+	ADSErrClientSyncTimeout:   "timeout elapsed",
+	0x746:                     "error in Win32 subsystem",
+	0x748:                     "ADS port not opened",
+	0x750:                     "internal error in ADS sync",
+	0x751:                     "hash table overflow",
+	0x752:                     "key not found in hash",
+	0x753:                     "no more symbols in cache",
+	0x754:                     "invalid response received",
+	0x755:                     "sync port is locked",
+}
+
+var (
+	ErrClientClosed    = errors.New("ADS client connection closed")
+	ErrRequestTimeout  = errors.New("ADS request timed out")
+	ErrInvalidResponse = errors.New("invalid ADS response")
+)
+
+// ADSError is an error code returned by the ADS device or router
+type ADSError struct {
+	Code uint32
+}
+
+func (e *ADSError) Error() string {
+	if text, ok := adsErrorText[e.Code]; ok {
+		return fmt.Sprintf("ADS error 0x%X: %s", e.Code, text)
+	}
+	return fmt.Sprintf("ADS error 0x%X", e.Code)
+}
+
+// IsADSError reports whether err carries the given ADS error code
+func IsADSError(err error, code uint32) bool {
+	var adsErr *ADSError
+	return errors.As(err, &adsErr) && adsErr.Code == code
+}
+
+func adsErr(code uint32) error {
+	if code == ADSErrNoError {
+		return nil
+	}
+	return &ADSError{Code: code}
+}
+
+// AmsNetID is the 6-byte address of an ADS device, e.g. "5.12.34.56.1.1"
+type AmsNetID [6]byte
+
+func ParseAmsNetID(s string) (AmsNetID, error) {
+	var id AmsNetID
+	parts := strings.Split(strings.TrimSpace(s), ".")
+	if len(parts) != 6 {
+		return id, fmt.Errorf("invalid AMS net ID %q", s)
+	}
+	for i, p := range parts {
+		b, err := strconv.ParseUint(p, 10, 8)
+		if err != nil {
+			return id, fmt.Errorf("invalid AMS net ID %q", s)
+		}
+		id[i] = byte(b)
+	}
+	return id, nil
+}
+
+func (id AmsNetID) String() string {
+	return fmt.Sprintf("%d.%d.%d.%d.%d.%d", id[0], id[1], id[2], id[3], id[4], id[5])
+}
+
+// amsHeader is the fixed 32-byte header preceding every ADS command
+type amsHeader struct {
+	TargetNetID AmsNetID
+	TargetPort  uint16
+	SourceNetID AmsNetID
+	SourcePort  uint16
+	CommandID   uint16
+	StateFlags  uint16
+	Length      uint32
+	ErrorCode   uint32
+	InvokeID    uint32
+}
+
+func (h *amsHeader) marshal(b []byte) {
+	copy(b[0:6], h.TargetNetID[:])
+	binary.LittleEndian.PutUint16(b[6:], h.TargetPort)
+	copy(b[8:14], h.SourceNetID[:])
+	binary.LittleEndian.PutUint16(b[14:], h.SourcePort)
+	binary.LittleEndian.PutUint16(b[16:], h.CommandID)
+	binary.LittleEndian.PutUint16(b[18:], h.StateFlags)
+	binary.LittleEndian.PutUint32(b[20:], h.Length)
+	binary.LittleEndian.PutUint32(b[24:], h.ErrorCode)
+	binary.LittleEndian.PutUint32(b[28:], h.InvokeID)
+}
+
+func (h *amsHeader) unmarshal(b []byte) {
+	copy(h.TargetNetID[:], b[0:6])
+	h.TargetPort = binary.LittleEndian.Uint16(b[6:])
+	copy(h.SourceNetID[:], b[8:14])
+	h.SourcePort = binary.LittleEndian.Uint16(b[14:])
+	h.CommandID = binary.LittleEndian.Uint16(b[16:])
+	h.StateFlags = binary.LittleEndian.Uint16(b[18:])
+	h.Length = binary.LittleEndian.Uint32(b[20:])
+	h.ErrorCode = binary.LittleEndian.Uint32(b[24:])
+	h.InvokeID = binary.LittleEndian.Uint32(b[28:])
+}
+
+// encodeAMSFrame builds a complete AMS/TCP frame for the given header and payload
+func encodeAMSFrame(h amsHeader, payload []byte) []byte {
+	h.Length = uint32(len(payload))
+	frame := make([]byte, amsTCPHeaderLen+amsHeaderLen+len(payload))
+	binary.LittleEndian.PutUint32(frame[2:], uint32(amsHeaderLen+len(payload)))
+	h.marshal(frame[amsTCPHeaderLen:])
+	copy(frame[amsTCPHeaderLen+amsHeaderLen:], payload)
+	return frame
+}
+
+// sumEntry addresses one sub-command of a sum read/write request
+type sumEntry struct {
+	Group  uint32
+	Offset uint32
+	Length uint32
+	Data   []byte // only used for sum writes
+}
@@ -0,0 +1,738 @@
+package plcengine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// AMSConfig describes how to reach a TwinCAT runtime over AMS/TCP
+type AMSConfig struct {
+	Address     string // host or host:port of the AMS router, port defaults to 48898
+	TargetNetID string // AmsNetID of the controller, e.g. "5.12.34.56.1.1"
+	TargetPort  int    // ADS port of the PLC runtime, e.g. 851
+	SourceNetID string // defaults to the local IP address + ".1.1"
+	SourcePort  int    // defaults to 32905
+	Timeout     time.Duration
+}
+
+// adsSymbol caches what the client needs to access a symbol by handle
+type adsSymbol struct {
+	Name     string
+	Type     string
+	DataType uint32
+	Size     uint32
+	Group    uint32
+	Offset   uint32
+	Flags    uint32
+	Comment  string
+	Handle   uint32
+}
+
+type amsPacket struct {
+	header amsHeader
+	data   []byte
+}
+
+// AMSClient is a pure-Go ADS client speaking AMS/TCP directly to a TwinCAT
+// router. It implements ADSClient and is safe for concurrent use.
+type AMSClient struct {
+	conn       net.Conn
+	target     AmsNetID
+	source     AmsNetID
+	targetPort uint16
+	sourcePort uint16
+	timeout    time.Duration
+
+	writeMu  sync.Mutex
+	mu       sync.Mutex
+	invokeID uint32
+	pending  map[uint32]chan amsPacket
+	closed   bool
+	done     chan struct{}
+
+	symMu    sync.RWMutex
+	symbols  map[string]*adsSymbol
+	registry *SymbolRegistry // set by UploadSymbols
+
+	notifyMu      sync.Mutex
+	notifications map[uint32]*adsNotification
+	orphans       map[uint32][]NotificationSample
+}
+
+var (
+	_ ADSClient      = (*AMSClient)(nil)
+	_ SymbolUploader = (*AMSClient)(nil)
+)
+
+func NewAMSClient(cfg AMSConfig) (*AMSClient, error) {
+	target, err := ParseAmsNetID(cfg.TargetNetID)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := cfg.Address
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, fmt.Sprint(AMSTCPPort))
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var source AmsNetID
+	if cfg.SourceNetID != "" {
+		source, err = ParseAmsNetID(cfg.SourceNetID)
+	} else {
+		source, err = localAmsNetID(conn)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	sourcePort := uint16(32905)
+	if cfg.SourcePort > 0 {
+		sourcePort = uint16(cfg.SourcePort)
+	}
+
+	c := &AMSClient{
+		conn:       conn,
+		target:     target,
+		source:     source,
+		targetPort: uint16(cfg.TargetPort),
+		sourcePort: sourcePort,
+		timeout:    timeout,
+		pending:    make(map[uint32]chan amsPacket),
+		done:       make(chan struct{}),
+		symbols:    make(map[string]*adsSymbol),
+
+		notifications: make(map[uint32]*adsNotification),
+		orphans:       make(map[uint32][]NotificationSample),
+	}
+	go c.readLoop()
+
+	return c, nil
+}
+
+func localAmsNetID(conn net.Conn) (AmsNetID, error) {
+	addr, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok || addr.IP.To4() == nil {
+		return AmsNetID{}, errors.New("cannot derive source AMS net ID, set SourceNetID")
+	}
+	ip := addr.IP.To4()
+	return AmsNetID{ip[0], ip[1], ip[2], ip[3], 1, 1}, nil
+}
+
+func (c *AMSClient) readLoop() {
+	r := bufio.NewReader(c.conn)
+	var err error
+	for {
+		var pkt amsPacket
+		pkt, err = readAMSPacket(r)
+		if err != nil {
+			break
+		}
+		if pkt.header.StateFlags&0x0001 == 0 {
+			if pkt.header.CommandID == adsCmdNotification {
+				c.dispatchNotification(pkt.data)
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		ch, ok := c.pending[pkt.header.InvokeID]
+		delete(c.pending, pkt.header.InvokeID)
+		c.mu.Unlock()
+		if ok {
+			ch <- pkt
+		}
+	}
+	c.shutdown()
+}
+
+func readAMSPacket(r io.Reader) (amsPacket, error) {
+	var pkt amsPacket
+	tcpHdr := make([]byte, amsTCPHeaderLen)
+	if _, err := io.ReadFull(r, tcpHdr); err != nil {
+		return pkt, err
+	}
+	length := binary.LittleEndian.Uint32(tcpHdr[2:])
+	if length < amsHeaderLen {
+		return pkt, ErrInvalidResponse
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return pkt, err
+	}
+	pkt.header.unmarshal(buf[:amsHeaderLen])
+	pkt.data = buf[amsHeaderLen:]
+	return pkt, nil
+}
+
+func (c *AMSClient) shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.done)
+	c.conn.Close()
+	c.pending = make(map[uint32]chan amsPacket)
+}
+
+// Closed reports whether the connection to the router was lost or closed
+func (c *AMSClient) Closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close removes all notifications, releases cached symbol handles and
+// closes the connection
+func (c *AMSClient) Close() error {
+	c.notifyMu.Lock()
+	handles := make([]uint32, 0, len(c.notifications))
+	for h := range c.notifications {
+		handles = append(handles, h)
+	}
+	c.notifyMu.Unlock()
+	for _, h := range handles {
+		_ = c.DeleteNotification(h)
+	}
+
+	c.symMu.Lock()
+	var release []sumEntry
+	for _, sym := range c.symbols {
+		if sym.Handle != 0 {
+			data := make([]byte, 4)
+			binary.LittleEndian.PutUint32(data, sym.Handle)
+			release = append(release, sumEntry{Group: ADSIGrpSymReleaseHnd, Data: data, Length: 4})
+		}
+	}
+	c.symbols = make(map[string]*adsSymbol)
+	c.symMu.Unlock()
+
+	if len(release) > 0 {
+		_, _ = c.sumWrite(release) // best effort, the device drops handles with the route anyway
+	}
+
+	c.shutdown()
+	return nil
+}
+
+// request sends a single ADS command and waits for the matching response
+func (c *AMSClient) request(cmd uint16, payload []byte) ([]byte, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClientClosed
+	}
+	c.invokeID++
+	id := c.invokeID
+	ch := make(chan amsPacket, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	frame := encodeAMSFrame(amsHeader{
+		TargetNetID: c.target,
+		TargetPort:  c.targetPort,
+		SourceNetID: c.source,
+		SourcePort:  c.sourcePort,
+		CommandID:   cmd,
+		StateFlags:  amsStateRequest,
+		InvokeID:    id,
+	}, payload)
+
+	c.writeMu.Lock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(frame)
+	c.writeMu.Unlock()
+	if err != nil {
+		c.shutdown()
+		return nil, fmt.Errorf("%w: %v", ErrClientClosed, err)
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+
+	select {
+	case pkt := <-ch:
+		if pkt.header.ErrorCode != ADSErrNoError {
+			return nil, adsErr(pkt.header.ErrorCode)
+		}
+		return pkt.data, nil
+	case <-timer.C:
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, ErrRequestTimeout
+	case <-c.done:
+		return nil, ErrClientClosed
+	}
+}
+
+// ReadState returns the ADS state and device state of the target runtime
+func (c *AMSClient) ReadState() (adsState, deviceState uint16, err error) {
+	resp, err := c.request(adsCmdReadState, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(resp) < 8 {
+		return 0, 0, ErrInvalidResponse
+	}
+	if err := adsErr(binary.LittleEndian.Uint32(resp)); err != nil {
+		return 0, 0, err
+	}
+	return binary.LittleEndian.Uint16(resp[4:]), binary.LittleEndian.Uint16(resp[6:]), nil
+}
+
+// Read reads length bytes from the given index group and offset
+func (c *AMSClient) Read(group, offset, length uint32) ([]byte, error) {
+	req := make([]byte, 12)
+	binary.LittleEndian.PutUint32(req[0:], group)
+	binary.LittleEndian.PutUint32(req[4:], offset)
+	binary.LittleEndian.PutUint32(req[8:], length)
+
+	resp, err := c.request(adsCmdRead, req)
+	if err != nil {
+		return nil, err
+	}
+	return parseDataResponse(resp)
+}
+
+// Write writes data to the given index group and offset
+func (c *AMSClient) Write(group, offset uint32, data []byte) error {
+	req := make([]byte, 12+len(data))
+	binary.LittleEndian.PutUint32(req[0:], group)
+	binary.LittleEndian.PutUint32(req[4:], offset)
+	binary.LittleEndian.PutUint32(req[8:], uint32(len(data)))
+	copy(req[12:], data)
+
+	resp, err := c.request(adsCmdWrite, req)
+	if err != nil {
+		return err
+	}
+	if len(resp) < 4 {
+		return ErrInvalidResponse
+	}
+	return adsErr(binary.LittleEndian.Uint32(resp))
+}
+
+// ReadWrite writes data and reads back up to readLength bytes in one round trip
+func (c *AMSClient) ReadWrite(group, offset, readLength uint32, data []byte) ([]byte, error) {
+	req := make([]byte, 16+len(data))
+	binary.LittleEndian.PutUint32(req[0:], group)
+	binary.LittleEndian.PutUint32(req[4:], offset)
+	binary.LittleEndian.PutUint32(req[8:], readLength)
+	binary.LittleEndian.PutUint32(req[12:], uint32(len(data)))
+	copy(req[16:], data)
+
+	resp, err := c.request(adsCmdReadWrite, req)
+	if err != nil {
+		return nil, err
+	}
+	return parseDataResponse(resp)
+}
+
+func parseDataResponse(resp []byte) ([]byte, error) {
+	if len(resp) < 8 {
+		return nil, ErrInvalidResponse
+	}
+	if err := adsErr(binary.LittleEndian.Uint32(resp)); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(resp[4:])
+	if int(n) > len(resp)-8 {
+		return nil, ErrInvalidResponse
+	}
+	return resp[8 : 8+n], nil
+}
+
+// sumRead reads several areas in one ADSIGRP_SUMUP_READ request. The returned
+// slices are nil for entries whose error code is non-zero.
+func (c *AMSClient) sumRead(entries []sumEntry) ([][]byte, []error, error) {
+	req := make([]byte, 12*len(entries))
+	var total uint32
+	for i, e := range entries {
+		binary.LittleEndian.PutUint32(req[i*12:], e.Group)
+		binary.LittleEndian.PutUint32(req[i*12+4:], e.Offset)
+		binary.LittleEndian.PutUint32(req[i*12+8:], e.Length)
+		total += e.Length
+	}
+
+	resp, err := c.ReadWrite(ADSIGrpSumRead, uint32(len(entries)), uint32(4*len(entries))+total, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(resp) < 4*len(entries) {
+		return nil, nil, ErrInvalidResponse
+	}
+
+	results := make([][]byte, len(entries))
+	errs := make([]error, len(entries))
+	pos := 4 * len(entries)
+	for i, e := range entries {
+		errs[i] = adsErr(binary.LittleEndian.Uint32(resp[i*4:]))
+		// The device always reserves the requested length, even for failed entries
+		end := pos + int(e.Length)
+		if end > len(resp) {
+			return nil, nil, ErrInvalidResponse
+		}
+		if errs[i] == nil {
+			results[i] = resp[pos:end]
+		}
+		pos = end
+	}
+	return results, errs, nil
+}
+
+// sumWrite writes several areas in one ADSIGRP_SUMUP_WRITE request
+func (c *AMSClient) sumWrite(entries []sumEntry) ([]error, error) {
+	size := 12 * len(entries)
+	for _, e := range entries {
+		size += len(e.Data)
+	}
+	req := make([]byte, size)
+	pos := 12 * len(entries)
+	for i, e := range entries {
+		binary.LittleEndian.PutUint32(req[i*12:], e.Group)
+		binary.LittleEndian.PutUint32(req[i*12+4:], e.Offset)
+		binary.LittleEndian.PutUint32(req[i*12+8:], uint32(len(e.Data)))
+		copy(req[pos:], e.Data)
+		pos += len(e.Data)
+	}
+
+	resp, err := c.ReadWrite(ADSIGrpSumWrite, uint32(len(entries)), uint32(4*len(entries)), req)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 4*len(entries) {
+		return nil, ErrInvalidResponse
+	}
+
+	errs := make([]error, len(entries))
+	for i := range entries {
+		errs[i] = adsErr(binary.LittleEndian.Uint32(resp[i*4:]))
+	}
+	return errs, nil
+}
+
+// symbol returns the cached symbol entry and handle, fetching both on first use
+func (c *AMSClient) symbol(name string) (*adsSymbol, error) {
+	c.symMu.RLock()
+	sym, ok := c.symbols[name]
+	reg := c.registry
+	c.symMu.RUnlock()
+	if ok {
+		return sym, nil
+	}
+
+	// The uploaded symbol table saves the per-symbol info round trip
+	if info, found := registrySymbol(reg, name); found {
+		sym = &adsSymbol{
+			Type:     info.TypeName,
+			DataType: info.adsType,
+			Size:     uint32(info.Size),
+			Group:    info.IndexGroup,
+			Offset:   info.IndexOffset,
+			Comment:  info.Comment,
+		}
+		if !info.IsWritable {
+			sym.Flags |= adsSymbolFlagReadOnly
+		}
+	} else {
+		resp, err := c.ReadWrite(ADSIGrpSymInfoByNameEx, 0, 0xFFFF, nameBytes(name))
+		if err != nil {
+			return nil, fmt.Errorf("symbol %s: %w", name, err)
+		}
+		sym, err = parseSymbolEntry(resp)
+		if err != nil {
+			return nil, fmt.Errorf("symbol %s: %w", name, err)
+		}
+	}
+	sym.Name = name // TwinCAT names are case-insensitive, keep the caller's spelling
+
+	resp, err := c.ReadWrite(ADSIGrpSymHandleByName, 0, 4, nameBytes(name))
+	if err != nil {
+		return nil, fmt.Errorf("symbol %s: %w", name, err)
+	}
+	if len(resp) < 4 {
+		return nil, ErrInvalidResponse
+	}
+	sym.Handle = binary.LittleEndian.Uint32(resp)
+
+	c.symMu.Lock()
+	c.symbols[name] = sym
+	c.symMu.Unlock()
+	return sym, nil
+}
+
+func registrySymbol(reg *SymbolRegistry, name string) (*SymbolInfo, bool) {
+	if reg == nil {
+		return nil, false
+	}
+	return reg.Symbol(name)
+}
+
+// decode converts raw symbol bytes, using the uploaded type table for
+// arrays and structs
+func (c *AMSClient) decode(sym *adsSymbol, data []byte) interface{} {
+	c.symMu.RLock()
+	reg := c.registry
+	c.symMu.RUnlock()
+
+	// Arrays carry the element type as ADS data type, so any symbol known to
+	// the registry is decoded through it
+	if reg != nil {
+		if v, err := reg.Decode(sym.Name, data); err == nil {
+			return v
+		}
+	}
+	return decodeADSValue(sym.DataType, data)
+}
+
+// UploadSymbols uploads the symbol and data type tables of the PLC runtime.
+// The client keeps the registry to skip per-symbol info requests and to
+// decode arrays and structs.
+func (c *AMSClient) UploadSymbols() (*SymbolRegistry, error) {
+	info, err := c.Read(ADSIGrpSymUploadInfo2, 0, 24)
+	if err != nil {
+		return nil, fmt.Errorf("symbol upload info: %w", err)
+	}
+	if len(info) < 16 {
+		return nil, ErrInvalidResponse
+	}
+	symSize := binary.LittleEndian.Uint32(info[4:])
+	typeSize := binary.LittleEndian.Uint32(info[12:])
+
+	symData, err := c.Read(ADSIGrpSymUpload, 0, symSize)
+	if err != nil {
+		return nil, fmt.Errorf("symbol upload: %w", err)
+	}
+	symbols, err := parseSymbolTable(symData)
+	if err != nil {
+		return nil, fmt.Errorf("symbol upload: %w", err)
+	}
+
+	var types []DataTypeInfo
+	if typeSize > 0 {
+		typeData, err := c.Read(ADSIGrpSymDTUpload, 0, typeSize)
+		if err != nil {
+			return nil, fmt.Errorf("data type upload: %w", err)
+		}
+		types, err = parseDataTypeTable(typeData)
+		if err != nil {
+			return nil, fmt.Errorf("data type upload: %w", err)
+		}
+	}
+
+	reg := NewSymbolRegistry(symbols, types)
+	c.symMu.Lock()
+	c.registry = reg
+	c.symMu.Unlock()
+	return reg, nil
+}
+
+// forget drops a cached handle, e.g. after an online change invalidated it
+func (c *AMSClient) forget(name string) {
+	c.symMu.Lock()
+	delete(c.symbols, name)
+	c.symMu.Unlock()
+}
+
+func isStaleHandle(err error) bool {
+	return IsADSError(err, ADSErrSymbolNotFound) || IsADSError(err, ADSErrSymbolVersion)
+}
+
+func nameBytes(name string) []byte {
+	return append([]byte(name), 0)
+}
+
+// parseSymbolEntry decodes an ADS symbol entry as returned by
+// ADSIGRP_SYM_INFOBYNAMEEX and the symbol upload.
+func parseSymbolEntry(b []byte) (*adsSymbol, error) {
+	if len(b) < 30 {
+		return nil, ErrInvalidResponse
+	}
+	sym := &adsSymbol{
+		Group:    binary.LittleEndian.Uint32(b[4:]),
+		Offset:   binary.LittleEndian.Uint32(b[8:]),
+		Size:     binary.LittleEndian.Uint32(b[12:]),
+		DataType: binary.LittleEndian.Uint32(b[16:]),
+		Flags:    binary.LittleEndian.Uint32(b[20:]),
+	}
+	nameLen := int(binary.LittleEndian.Uint16(b[24:]))
+	typeLen := int(binary.LittleEndian.Uint16(b[26:]))
+	commentLen := int(binary.LittleEndian.Uint16(b[28:]))
+
+	pos := 30
+	if pos+nameLen+typeLen+commentLen+3 > len(b) {
+		return nil, ErrInvalidResponse
+	}
+	sym.Name = string(b[pos : pos+nameLen])
+	pos += nameLen + 1
+	sym.Type = string(b[pos : pos+typeLen])
+	pos += typeLen + 1
+	sym.Comment = string(b[pos : pos+commentLen])
+	return sym, nil
+}
+
+func (c *AMSClient) ReadSymbol(name string) (interface{}, error) {
+	for attempt := 0; ; attempt++ {
+		sym, err := c.symbol(name)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := c.Read(ADSIGrpSymValueByHnd, sym.Handle, sym.Size)
+		if err != nil {
+			if isStaleHandle(err) && attempt == 0 {
+				c.forget(name)
+				continue
+			}
+			return nil, fmt.Errorf("read %s: %w", name, err)
+		}
+		return c.decode(sym, data), nil
+	}
+}
+
+func (c *AMSClient) WriteSymbol(name string, value interface{}) error {
+	for attempt := 0; ; attempt++ {
+		sym, err := c.symbol(name)
+		if err != nil {
+			return err
+		}
+
+		data, err := encodeADSValue(sym.DataType, sym.Size, value)
+		if err != nil {
+			return fmt.Errorf("write %s: %w", name, err)
+		}
+
+		err = c.Write(ADSIGrpSymValueByHnd, sym.Handle, data)
+		if err != nil {
+			if isStaleHandle(err) && attempt == 0 {
+				c.forget(name)
+				continue
+			}
+			return fmt.Errorf("write %s: %w", name, err)
+		}
+		return nil
+	}
+}
+
+// ReadSymbols reads all symbols with ADS sum-read requests. Symbols that
+// cannot be resolved or read are left out of the result; an error is only
+// returned if no symbol could be read at all.
+func (c *AMSClient) ReadSymbols(names []string) (map[string]interface{}, error) {
+	results := make(map[string]interface{}, len(names))
+	var firstErr error
+
+	syms := make([]*adsSymbol, 0, len(names))
+	for _, name := range names {
+		sym, err := c.symbol(name)
+		if err != nil {
+			if errors.Is(err, ErrClientClosed) || errors.Is(err, ErrRequestTimeout) {
+				return nil, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		syms = append(syms, sym)
+	}
+
+	for start := 0; start < len(syms); start += maxSumCommands {
+		chunk := syms[start:min(start+maxSumCommands, len(syms))]
+
+		entries := make([]sumEntry, len(chunk))
+		for i, sym := range chunk {
+			entries[i] = sumEntry{Group: ADSIGrpSymValueByHnd, Offset: sym.Handle, Length: sym.Size}
+		}
+
+		data, errs, err := c.sumRead(entries)
+		if err != nil {
+			return nil, err
+		}
+		for i, sym := range chunk {
+			if errs[i] != nil {
+				if isStaleHandle(errs[i]) {
+					c.forget(sym.Name)
+				}
+				if firstErr == nil {
+					firstErr = fmt.Errorf("read %s: %w", sym.Name, errs[i])
+				}
+				continue
+			}
+			results[sym.Name] = c.decode(sym, data[i])
+		}
+	}
+
+	if len(results) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return results, nil
+}
+
+// WriteSymbols writes all values with ADS sum-write requests. The returned
+// map has an entry per symbol, nil if the write succeeded. The error is only
+// set if the transport failed.
+func (c *AMSClient) WriteSymbols(values map[string]interface{}) (map[string]error, error) {
+	results := make(map[string]error, len(values))
+
+	syms := make([]*adsSymbol, 0, len(values))
+	entries := make([]sumEntry, 0, len(values))
+	for name, value := range values {
+		sym, err := c.symbol(name)
+		if err != nil {
+			if errors.Is(err, ErrClientClosed) || errors.Is(err, ErrRequestTimeout) {
+				return nil, err
+			}
+			results[name] = err
+			continue
+		}
+		data, err := encodeADSValue(sym.DataType, sym.Size, value)
+		if err != nil {
+			results[name] = fmt.Errorf("write %s: %w", name, err)
+			continue
+		}
+		syms = append(syms, sym)
+		entries = append(entries, sumEntry{Group: ADSIGrpSymValueByHnd, Offset: sym.Handle, Data: data})
+	}
+
+	for start := 0; start < len(entries); start += maxSumCommands {
+		end := min(start+maxSumCommands, len(entries))
+		errs, err := c.sumWrite(entries[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for i, sym := range syms[start:end] {
+			if errs[i] != nil {
+				if isStaleHandle(errs[i]) {
+					c.forget(sym.Name)
+				}
+				results[sym.Name] = fmt.Errorf("write %s: %w", sym.Name, errs[i])
+				continue
+			}
+			results[sym.Name] = nil
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,187 @@
+package plcengine
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func real32(v float32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, math.Float32bits(v))
+	return b
+}
+
+func int16Bytes(v int16) []byte {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, uint16(v))
+	return b
+}
+
+func newTestRouter(t *testing.T) *fakeRouter {
+	r := newFakeRouter(t)
+	r.addSymbol("GVL.Temperature", "REAL", adsTypeReal32, real32(21.5))
+	r.addSymbol("GVL.Pressure", "REAL", adsTypeReal32, real32(0.75))
+	r.addSymbol("GVL.Step", "INT", adsTypeInt16, int16Bytes(3))
+	r.addSymbol("GVL.Running", "BOOL", adsTypeBit, []byte{1})
+	r.addSymbol("GVL.Recipe", "STRING(80)", adsTypeString, make([]byte, 81))
+	return r
+}
+
+func TestAMSClient_ReadWriteSymbol(t *testing.T) {
+	r := newTestRouter(t)
+	c := r.client(t)
+
+	val, err := c.ReadSymbol("GVL.Temperature")
+	require.NoError(t, err)
+	assert.Equal(t, float32(21.5), val)
+
+	val, err = c.ReadSymbol("GVL.Step")
+	require.NoError(t, err)
+	assert.Equal(t, int16(3), val)
+
+	val, err = c.ReadSymbol("GVL.Running")
+	require.NoError(t, err)
+	assert.Equal(t, true, val)
+
+	require.NoError(t, c.WriteSymbol("GVL.Temperature", 42.25))
+	assert.Equal(t, real32(42.25), r.value("GVL.Temperature"))
+
+	require.NoError(t, c.WriteSymbol("GVL.Recipe", "ETCH_01"))
+	val, err = c.ReadSymbol("GVL.Recipe")
+	require.NoError(t, err)
+	assert.Equal(t, "ETCH_01", val)
+
+	// Handles are cached after the first access
+	assert.Equal(t, 4, r.callCount(ADSIGrpSymInfoByNameEx))
+}
+
+func TestAMSClient_WriteRejectsBadValues(t *testing.T) {
+	r := newTestRouter(t)
+	c := r.client(t)
+
+	assert.Error(t, c.WriteSymbol("GVL.Step", 70000))
+	assert.Error(t, c.WriteSymbol("GVL.Step", 1.5))
+	assert.Error(t, c.WriteSymbol("GVL.Temperature", "hot"))
+	assert.Equal(t, int16Bytes(3), r.value("GVL.Step"))
+}
+
+func TestAMSClient_ReadSymbolsUsesSumRead(t *testing.T) {
+	r := newTestRouter(t)
+	c := r.client(t)
+
+	vals, err := c.ReadSymbols([]string{"GVL.Temperature", "GVL.Pressure", "GVL.Step", "GVL.Missing"})
+	require.NoError(t, err)
+
+	assert.Len(t, vals, 3)
+	assert.Equal(t, float32(21.5), vals["GVL.Temperature"])
+	assert.Equal(t, float32(0.75), vals["GVL.Pressure"])
+	assert.Equal(t, int16(3), vals["GVL.Step"])
+	assert.Equal(t, 1, r.callCount(ADSIGrpSumRead))
+	assert.Equal(t, 3, r.callCount(ADSIGrpSymValueByHnd))
+}
+
+func TestAMSClient_UnknownSymbol(t *testing.T) {
+	r := newTestRouter(t)
+	c := r.client(t)
+
+	_, err := c.ReadSymbol("GVL.Missing")
+	require.Error(t, err)
+	assert.True(t, IsADSError(err, ADSErrSymbolNotFound))
+	assert.Contains(t, err.Error(), "symbol not found")
+
+	_, err = c.ReadSymbols([]string{"GVL.Missing"})
+	assert.True(t, IsADSError(err, ADSErrSymbolNotFound))
+}
+
+func TestAMSClient_StaleHandleIsRefreshed(t *testing.T) {
+	r := newTestRouter(t)
+	c := r.client(t)
+
+	_, err := c.ReadSymbol("GVL.Temperature")
+	require.NoError(t, err)
+
+	r.onlineChange()
+	r.setValue("GVL.Temperature", real32(30))
+
+	val, err := c.ReadSymbol("GVL.Temperature")
+	require.NoError(t, err)
+	assert.Equal(t, float32(30), val)
+}
+
+func TestAMSClient_ConnectionLoss(t *testing.T) {
+	r := newTestRouter(t)
+	c := r.client(t)
+
+	r.dropConnections()
+
+	require.Eventually(t, func() bool {
+		_, err := c.ReadSymbol("GVL.Temperature")
+		return errors.Is(err, ErrClientClosed)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestEngine_ReadsThroughAMSClient(t *testing.T) {
+	r := newTestRouter(t)
+
+	// No ClientFactory: the engine dials the native AMS client by default
+	e := NewEngine(make(chan PLCValue, 10))
+	require.NoError(t, e.Start([]MachineConfig{{ID: "m1", IP: r.Addr(), AmsNetID: r.NetID(), Port: 851}}))
+	defer e.Stop()
+
+	require.Eventually(t, func() bool {
+		return e.GetStatus()["m1"].Connected
+	}, time.Second, 10*time.Millisecond)
+
+	val, err := e.ReadSymbol("m1", "GVL.Pressure")
+	require.NoError(t, err)
+	assert.Equal(t, float32(0.75), val.Value)
+
+	require.NoError(t, e.WriteSymbol("m1", "GVL.Step", 7))
+	assert.Equal(t, int16Bytes(7), r.value("GVL.Step"))
+}
+
+func TestAMSClient_OnChangeNotification(t *testing.T) {
+	r := newTestRouter(t)
+	c := r.client(t)
+
+	samples := make(chan NotificationSample, 10)
+	h, err := c.AddNotification("GVL.Temperature", NotificationAttrib{
+		Mode:      NotifyOnChange,
+		CycleTime: 10 * time.Millisecond,
+		MaxDelay:  50 * time.Millisecond,
+	}, func(s NotificationSample) { samples <- s })
+	require.NoError(t, err)
+
+	first := <-samples
+	assert.Equal(t, "GVL.Temperature", first.Symbol)
+	assert.Equal(t, float32(21.5), first.Value)
+	assert.WithinDuration(t, time.Now(), first.Timestamp, time.Second)
+
+	r.setValue("GVL.Temperature", real32(80))
+	assert.Equal(t, float32(80), (<-samples).Value)
+
+	require.NoError(t, c.DeleteNotification(h))
+	assert.Equal(t, 0, r.notificationCount())
+	assert.True(t, IsADSError(c.DeleteNotification(h), ADSErrInvalidNotifyHandle))
+}
+
+func TestAMSClient_CyclicNotification(t *testing.T) {
+	r := newTestRouter(t)
+	c := r.client(t)
+
+	samples := make(chan NotificationSample, 100)
+	_, err := c.AddNotification("GVL.Step", NotificationAttrib{
+		Mode:      NotifyCyclic,
+		CycleTime: 5 * time.Millisecond,
+	}, func(s NotificationSample) { samples <- s })
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool { return len(samples) >= 5 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int16(3), (<-samples).Value)
+}
@@ -0,0 +1,159 @@
+package plcengine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// ADS transmission modes
+const (
+	adsTransServerCycle    uint32 = 3
+	adsTransServerOnChange uint32 = 4
+)
+
+// maxOrphanHandles bounds samples buffered for handles that are not yet (or
+// no longer) registered
+const maxOrphanHandles = 64
+
+// filetimeEpochDiff is the number of 100ns intervals between 1601-01-01 and 1970-01-01
+const filetimeEpochDiff = 116444736000000000
+
+type adsNotification struct {
+	symbol   *adsSymbol
+	callback func(NotificationSample)
+}
+
+var _ NotificationClient = (*AMSClient)(nil)
+
+// AddNotification registers a device notification for a symbol. The callback
+// runs on the client's receive goroutine and must not block.
+func (c *AMSClient) AddNotification(name string, attrib NotificationAttrib, callback func(NotificationSample)) (uint32, error) {
+	sym, err := c.symbol(name)
+	if err != nil {
+		return 0, err
+	}
+
+	mode := adsTransServerOnChange
+	if attrib.Mode == NotifyCyclic {
+		mode = adsTransServerCycle
+	}
+
+	req := make([]byte, 40)
+	binary.LittleEndian.PutUint32(req[0:], ADSIGrpSymValueByHnd)
+	binary.LittleEndian.PutUint32(req[4:], sym.Handle)
+	binary.LittleEndian.PutUint32(req[8:], sym.Size)
+	binary.LittleEndian.PutUint32(req[12:], mode)
+	binary.LittleEndian.PutUint32(req[16:], uint32(attrib.MaxDelay/100))
+	binary.LittleEndian.PutUint32(req[20:], uint32(attrib.CycleTime/100))
+
+	resp, err := c.request(adsCmdAddNotification, req)
+	if err != nil {
+		return 0, fmt.Errorf("add notification %s: %w", name, err)
+	}
+	if len(resp) < 8 {
+		return 0, ErrInvalidResponse
+	}
+	if err := adsErr(binary.LittleEndian.Uint32(resp)); err != nil {
+		if isStaleHandle(err) {
+			c.forget(name)
+		}
+		return 0, fmt.Errorf("add notification %s: %w", name, err)
+	}
+	handle := binary.LittleEndian.Uint32(resp[4:])
+
+	n := &adsNotification{symbol: sym, callback: callback}
+
+	c.notifyMu.Lock()
+	defer c.notifyMu.Unlock()
+
+	c.notifications[handle] = n
+	// The initial sample may have overtaken the response on the receive goroutine
+	for _, raw := range c.orphans[handle] {
+		callback(NotificationSample{
+			Symbol:    sym.Name,
+			Value:     c.decode(sym, raw.Value.([]byte)),
+			Timestamp: raw.Timestamp,
+		})
+	}
+	delete(c.orphans, handle)
+	return handle, nil
+}
+
+// DeleteNotification removes a device notification
+func (c *AMSClient) DeleteNotification(handle uint32) error {
+	c.notifyMu.Lock()
+	delete(c.notifications, handle)
+	delete(c.orphans, handle)
+	c.notifyMu.Unlock()
+
+	req := make([]byte, 4)
+	binary.LittleEndian.PutUint32(req, handle)
+
+	resp, err := c.request(adsCmdDeleteNotification, req)
+	if err != nil {
+		return err
+	}
+	if len(resp) < 4 {
+		return ErrInvalidResponse
+	}
+	return adsErr(binary.LittleEndian.Uint32(resp))
+}
+
+// dispatchNotification decodes an ADS device notification stream and invokes
+// the registered callbacks.
+func (c *AMSClient) dispatchNotification(b []byte) {
+	if len(b) < 8 {
+		return
+	}
+	stamps := binary.LittleEndian.Uint32(b[4:])
+	pos := 8
+
+	c.notifyMu.Lock()
+	defer c.notifyMu.Unlock()
+
+	for i := uint32(0); i < stamps; i++ {
+		if pos+12 > len(b) {
+			return
+		}
+		ts := filetimeToTime(binary.LittleEndian.Uint64(b[pos:]))
+		samples := binary.LittleEndian.Uint32(b[pos+8:])
+		pos += 12
+
+		for j := uint32(0); j < samples; j++ {
+			if pos+8 > len(b) {
+				return
+			}
+			handle := binary.LittleEndian.Uint32(b[pos:])
+			size := int(binary.LittleEndian.Uint32(b[pos+4:]))
+			pos += 8
+			if pos+size > len(b) {
+				return
+			}
+			data := b[pos : pos+size]
+			pos += size
+
+			n, ok := c.notifications[handle]
+			if !ok {
+				if len(c.orphans) >= maxOrphanHandles {
+					c.orphans = make(map[uint32][]NotificationSample)
+				}
+				raw := append([]byte(nil), data...)
+				c.orphans[handle] = append(c.orphans[handle], NotificationSample{Value: raw, Timestamp: ts})
+				continue
+			}
+			n.callback(NotificationSample{
+				Symbol:    n.symbol.Name,
+				Value:     c.decode(n.symbol, data),
+				Timestamp: ts,
+			})
+		}
+	}
+}
+
+func filetimeToTime(ft uint64) time.Time {
+	if ft < filetimeEpochDiff {
+		return time.Now()
+	}
+	return time.Unix(0, int64(ft-filetimeEpochDiff)*100)
+}
@@ -0,0 +1,91 @@
+package plcengine
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// CircuitState is the state of the reconnect circuit breaker of a connection
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"    // reconnects are attempted with backoff
+	CircuitOpen     CircuitState = "open"      // too many failures, no attempts until OpenTimeout
+	CircuitHalfOpen CircuitState = "half_open" // one trial attempt decides whether to close again
+)
+
+// ReconnectPolicy controls how a PLCConnection retries a failed connect.
+// Zero fields take the values of DefaultReconnectPolicy, a negative Jitter
+// turns jitter off.
+type ReconnectPolicy struct {
+	InitialBackoff time.Duration // delay after the first failure
+	MaxBackoff     time.Duration // upper bound of the exponential delay
+	Multiplier     float64       // growth factor per consecutive failure
+	Jitter         float64       // random spread as a fraction of the delay, e.g. 0.2 for ±20%
+
+	// FailureThreshold consecutive failures open the circuit for
+	// OpenTimeout, after which a single half-open attempt is made
+	FailureThreshold int
+	OpenTimeout      time.Duration
+
+	// HealthInterval is how often a live connection is checked
+	HealthInterval time.Duration
+}
+
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		InitialBackoff:   1 * time.Second,
+		MaxBackoff:       1 * time.Minute,
+		Multiplier:       2,
+		Jitter:           0.2,
+		FailureThreshold: 5,
+		OpenTimeout:      30 * time.Second,
+		HealthInterval:   1 * time.Second,
+	}
+}
+
+func (p ReconnectPolicy) withDefaults() ReconnectPolicy {
+	d := DefaultReconnectPolicy()
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = d.InitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = d.MaxBackoff
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = d.Multiplier
+	}
+	if p.Jitter == 0 {
+		p.Jitter = d.Jitter
+	} else if p.Jitter < 0 {
+		p.Jitter = 0
+	}
+	if p.FailureThreshold <= 0 {
+		p.FailureThreshold = d.FailureThreshold
+	}
+	if p.OpenTimeout <= 0 {
+		p.OpenTimeout = d.OpenTimeout
+	}
+	if p.HealthInterval <= 0 {
+		p.HealthInterval = d.HealthInterval
+	}
+	return p
+}
+
+// Backoff returns the delay before the next attempt after the given number
+// of consecutive failures
+func (p ReconnectPolicy) Backoff(failures int) time.Duration {
+	return p.backoff(failures, rand.Float64())
+}
+
+// backoff spreads the exponential delay by Jitter, r in [0, 1) picks the point
+func (p ReconnectPolicy) backoff(failures int, r float64) time.Duration {
+	if failures < 1 {
+		failures = 1
+	}
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(failures-1))
+	d = math.Min(d, float64(p.MaxBackoff))
+	d *= 1 + p.Jitter*(2*r-1)
+	return time.Duration(d)
+}
@@ -0,0 +1,94 @@
+package plcengine
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconnectPolicy_Backoff(t *testing.T) {
+	p := ReconnectPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Jitter: 0.5}.withDefaults()
+
+	assert.Equal(t, 100*time.Millisecond, p.backoff(1, 0.5))
+	assert.Equal(t, 400*time.Millisecond, p.backoff(3, 0.5))
+	assert.Equal(t, time.Second, p.backoff(10, 0.5))
+	assert.Equal(t, 50*time.Millisecond, p.backoff(1, 0))
+	assert.Equal(t, 1500*time.Millisecond, p.backoff(20, 1))
+
+	for i := 0; i < 100; i++ {
+		d := p.Backoff(2)
+		assert.True(t, d >= 100*time.Millisecond && d <= 300*time.Millisecond, d)
+	}
+
+	assert.Equal(t, 0.2, ReconnectPolicy{}.withDefaults().Jitter)
+	p = ReconnectPolicy{InitialBackoff: 100 * time.Millisecond, Jitter: -1}.withDefaults()
+	assert.Equal(t, 200*time.Millisecond, p.backoff(2, 0))
+	assert.Equal(t, 200*time.Millisecond, p.Backoff(2))
+}
+
+func TestConnection_CircuitBreaker(t *testing.T) {
+	var attempts atomic.Int32
+	e := NewEngine(make(chan PLCValue, 10))
+	e.ReconnectPolicy = ReconnectPolicy{
+		InitialBackoff:   5 * time.Millisecond,
+		MaxBackoff:       10 * time.Millisecond,
+		FailureThreshold: 3,
+		OpenTimeout:      50 * time.Millisecond,
+	}
+	// The first four attempts fail, the fourth one as the half-open probe
+	e.ClientFactory = func(ip, amsID string, port int) (ADSClient, error) {
+		if attempts.Add(1) <= 4 {
+			return nil, errors.New("dial tcp: connection refused")
+		}
+		return NewMockADSClient(ip), nil
+	}
+	require.NoError(t, e.Start([]MachineConfig{{ID: "m1", IP: "10.0.0.1"}}))
+	defer e.Stop()
+
+	next := func() ConnectionEvent {
+		select {
+		case ev := <-e.ConnectionEvents():
+			return ev
+		case <-time.After(time.Second):
+			t.Fatal("no connection event")
+			return ConnectionEvent{}
+		}
+	}
+
+	ev := next()
+	assert.Equal(t, StateError, ev.State)
+	assert.Equal(t, CircuitClosed, ev.Circuit)
+	assert.Equal(t, "dial tcp: connection refused", ev.Reason)
+
+	ev = next()
+	assert.Equal(t, CircuitOpen, ev.Circuit)
+	assert.Equal(t, int32(3), attempts.Load())
+
+	status := e.GetStatus()["m1"]
+	assert.Equal(t, CircuitOpen, status.Circuit)
+	assert.Equal(t, 3, status.ConsecutiveFailures)
+	assert.Equal(t, "dial tcp: connection refused", status.LastError)
+	assert.False(t, status.NextRetry.IsZero())
+
+	// A failed probe opens the circuit again
+	assert.Equal(t, CircuitHalfOpen, next().Circuit)
+	assert.Equal(t, CircuitOpen, next().Circuit)
+	assert.Equal(t, int32(4), attempts.Load())
+
+	assert.Equal(t, CircuitHalfOpen, next().Circuit)
+	ev = next()
+	assert.Equal(t, StateConnected, ev.State)
+	ev = next()
+	assert.Equal(t, "m1", ev.MachineID)
+	assert.Equal(t, CircuitClosed, ev.Circuit)
+
+	status = e.GetStatus()["m1"]
+	assert.True(t, status.Connected)
+	assert.Equal(t, 0, status.ConsecutiveFailures)
+	assert.Equal(t, 4, status.ErrorCount)
+	assert.True(t, status.NextRetry.IsZero())
+}
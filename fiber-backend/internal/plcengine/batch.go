@@ -0,0 +1,294 @@
+package plcengine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// rollbackTimeout bounds restoring the snapshot after a failed batch, which
+// runs even if the batch itself ran out of time
+const rollbackTimeout = 5 * time.Second
+
+// SymbolWrite is one symbol/value pair of a batch write
+type SymbolWrite struct {
+	Symbol string      `json:"symbol"`
+	Value  interface{} `json:"value"`
+}
+
+// BatchWriteRequest writes several symbols of one machine as a unit, e.g. the
+// setpoints of a recipe. Either all values are written or the symbols are
+// restored to the values read just before the write.
+type BatchWriteRequest struct {
+	ID        string        `json:"id"`
+	MachineID string        `json:"machine_id"`
+	UserID    string        `json:"user_id,omitempty"`
+	Writes    []SymbolWrite `json:"writes"`
+	Priority  int           `json:"priority"`
+	Verify    bool          `json:"verify"`  // read all values back and compare them
+	Timeout   time.Duration `json:"timeout"` // like WriteRequest.Timeout, excluding a rollback
+}
+
+// SymbolWriteResult is the outcome of one symbol of a batch write
+type SymbolWriteResult struct {
+	Symbol       string             `json:"symbol"`
+	Success      bool               `json:"success"`
+	Error        string             `json:"error,omitempty"`
+	Rejection    *WriteRejection    `json:"rejection,omitempty"`
+	Verification *WriteVerification `json:"verification,omitempty"`
+	RolledBack   bool               `json:"rolled_back,omitempty"` // restored to the snapshot value
+}
+
+// BatchWriteResponse confirms a batch write. Success is only set if every
+// symbol was written (and verified).
+type BatchWriteResponse struct {
+	ID            string              `json:"id"`
+	MachineID     string              `json:"machine_id"`
+	Success       bool                `json:"success"`
+	Error         string              `json:"error,omitempty"`
+	RolledBack    bool                `json:"rolled_back"`
+	RollbackError string              `json:"rollback_error,omitempty"`
+	Results       []SymbolWriteResult `json:"results"`
+	Timestamp     time.Time           `json:"timestamp"`
+}
+
+// batchWrite rides through the write queues inside a WriteRequest
+type batchWrite struct {
+	req  BatchWriteRequest
+	resp chan BatchWriteResponse
+}
+
+func (b *batchWrite) respond(resp BatchWriteResponse) {
+	select {
+	case b.resp <- resp:
+	default:
+	}
+}
+
+// failed answers a batch that was not executed
+func (b *batchWrite) failed(err error) {
+	resp := BatchWriteResponse{
+		ID:        b.req.ID,
+		MachineID: b.req.MachineID,
+		Error:     err.Error(),
+		Results:   make([]SymbolWriteResult, len(b.req.Writes)),
+		Timestamp: time.Now(),
+	}
+	for i, sw := range b.req.Writes {
+		resp.Results[i] = SymbolWriteResult{Symbol: sw.Symbol, Error: "not written"}
+	}
+	b.respond(resp)
+}
+
+func validateBatch(req BatchWriteRequest) error {
+	if len(req.Writes) == 0 {
+		return fmt.Errorf("batch write has no symbols")
+	}
+	seen := make(map[string]bool, len(req.Writes))
+	for _, sw := range req.Writes {
+		key := strings.ToLower(sw.Symbol)
+		if seen[key] {
+			return fmt.Errorf("symbol %s appears more than once in the batch", sw.Symbol)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// executeBatch checks, snapshots, sum-writes and verifies a batch, rolling
+// back to the snapshot if any symbol fails
+func (w *PrioritizedWriter) executeBatch(req WriteRequest) {
+	ctx, cancel := requestContext(req)
+	defer cancel()
+
+	b := req.batch
+	start := time.Now()
+	resp := BatchWriteResponse{
+		ID:        b.req.ID,
+		MachineID: b.req.MachineID,
+		Results:   make([]SymbolWriteResult, len(b.req.Writes)),
+		Timestamp: start,
+	}
+
+	// 1. Allowlist, type and range checks, one rejection rejects the batch
+	writes := make([]SymbolWrite, len(b.req.Writes))
+	rejected := false
+	for i, sw := range b.req.Writes {
+		resp.Results[i].Symbol = sw.Symbol
+		checked, rej := w.engine.CheckWrite(WriteRequest{
+			ID:        b.req.ID,
+			MachineID: b.req.MachineID,
+			Symbol:    sw.Symbol,
+			Value:     sw.Value,
+			UserID:    b.req.UserID,
+			Priority:  b.req.Priority,
+		})
+		writes[i] = SymbolWrite{Symbol: sw.Symbol, Value: checked.Value}
+		if rej != nil {
+			resp.Results[i].Rejection = rej
+			resp.Results[i].Error = rej.Error()
+			rejected = true
+		}
+	}
+
+	var snapshot, readback map[string]interface{}
+	switch {
+	case rejected:
+		resp.Error = "batch rejected by write guards"
+	case ctx.Err() != nil:
+		resp.Error = fmt.Sprintf("batch not sent: %v", ctx.Err())
+	default:
+		snapshot, readback = w.writeBatch(ctx, b.req, writes, &resp)
+	}
+	for i := range resp.Results {
+		if !resp.Success && resp.Results[i].Error == "" {
+			resp.Results[i].Error = "not written"
+			if resp.Results[i].RolledBack {
+				resp.Results[i].Error = "rolled back"
+			}
+		}
+	}
+
+	// Journal every symbol of the batch
+	if recorder := w.engine.writeRecorder(); recorder != nil {
+		latency := time.Since(start)
+		for i, sw := range writes {
+			rec := WriteRecord{
+				RequestID:      b.req.ID,
+				UserID:         b.req.UserID,
+				MachineID:      b.req.MachineID,
+				Symbol:         sw.Symbol,
+				PreviousValue:  snapshot[sw.Symbol],
+				RequestedValue: sw.Value,
+				ReadbackValue:  readback[sw.Symbol],
+				Success:        resp.Success,
+				Error:          resp.Results[i].Error,
+				Latency:        latency,
+				Timestamp:      start,
+			}
+			if rej := resp.Results[i].Rejection; rej != nil {
+				rec.RejectReason = rej.Reason
+			}
+			recorder.RecordWrite(rec)
+		}
+	}
+
+	b.respond(resp)
+}
+
+// writeBatch sends the checked values and fills resp. It returns the snapshot
+// and, if verified, the values read back.
+func (w *PrioritizedWriter) writeBatch(ctx context.Context, req BatchWriteRequest, writes []SymbolWrite, resp *BatchWriteResponse) (map[string]interface{}, map[string]interface{}) {
+	conn, err := w.engine.getConnection(req.MachineID)
+	if err != nil {
+		resp.Error = err.Error()
+		return nil, nil
+	}
+
+	symbols := make([]string, len(writes))
+	values := make(map[string]interface{}, len(writes))
+	for i, sw := range writes {
+		symbols[i] = sw.Symbol
+		values[sw.Symbol] = sw.Value
+	}
+
+	// 2. Snapshot, without a complete one the batch cannot be rolled back
+	snapshot, err := conn.ReadSymbolsCtx(ctx, symbols)
+	if err == nil && len(snapshot) < len(symbols) {
+		err = fmt.Errorf("%d of %d symbols could not be read", len(symbols)-len(snapshot), len(symbols))
+	}
+	if err != nil {
+		resp.Error = "snapshot read failed: " + err.Error()
+		return nil, nil
+	}
+
+	// 3. Sum-write all values
+	failed := false
+	errs, writeErr := conn.WriteSymbolsCtx(ctx, values)
+	for i, sw := range writes {
+		switch {
+		case writeErr != nil:
+			resp.Results[i].Error = writeErr.Error()
+			failed = true
+		case errs[sw.Symbol] != nil:
+			resp.Results[i].Error = errs[sw.Symbol].Error()
+			failed = true
+		}
+	}
+
+	// 4. Read-after-write verification of all values
+	var readback map[string]interface{}
+	if !failed && req.Verify {
+		policy := w.engine.VerifyPolicy.withDefaults()
+		select {
+		case <-time.After(policy.settle()):
+		case <-ctx.Done():
+		}
+
+		readback, err = conn.ReadSymbolsCtx(ctx, symbols)
+		for i, sw := range writes {
+			v := &WriteVerification{Expected: sw.Value, Attempts: 1}
+			actual, ok := readback[sw.Symbol]
+			switch {
+			case err != nil:
+				v.Error = err.Error()
+			case !ok:
+				v.Error = "symbol not read back"
+			default:
+				v.Actual = actual
+				v.Verified, v.Delta, v.Tolerance = compareValues(conn.valueType(sw.Symbol, actual), sw.Value, actual, policy)
+			}
+			resp.Results[i].Verification = v
+			if !v.Verified {
+				failed = true
+				if v.Error != "" {
+					resp.Results[i].Error = "verification read failed: " + v.Error
+				} else {
+					resp.Results[i].Error = fmt.Sprintf("verification failed: expected %v, got %v", sw.Value, actual)
+				}
+			}
+		}
+	}
+
+	if !failed {
+		resp.Success = true
+		for i := range resp.Results {
+			resp.Results[i].Success = true
+		}
+		return snapshot, readback
+	}
+
+	// 5. Roll the written symbols back to the snapshot. Symbols whose write
+	// failed are unchanged, unless the transport failed mid-request.
+	restore := make(map[string]interface{}, len(writes))
+	for _, sw := range writes {
+		if writeErr != nil || errs[sw.Symbol] == nil {
+			restore[sw.Symbol] = snapshot[sw.Symbol]
+		}
+	}
+	rbCtx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+	rbErrs, err := conn.WriteSymbolsCtx(rbCtx, restore)
+	resp.RolledBack = err == nil
+	for i, sw := range writes {
+		if _, ok := restore[sw.Symbol]; !ok {
+			continue
+		}
+		restored := err == nil && rbErrs[sw.Symbol] == nil
+		resp.Results[i].RolledBack = restored
+		resp.RolledBack = resp.RolledBack && restored
+	}
+	switch {
+	case err != nil:
+		resp.RollbackError = err.Error()
+	case !resp.RolledBack:
+		resp.RollbackError = "some symbols could not be restored"
+	}
+	if resp.RolledBack {
+		resp.Error = "batch write failed, rolled back to snapshot"
+	} else {
+		resp.Error = "batch write failed, rollback incomplete"
+	}
+	return snapshot, readback
+}
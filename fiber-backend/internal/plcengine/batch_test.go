@@ -0,0 +1,111 @@
+package plcengine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startBatchEngine(t *testing.T) (*PLCReadWriteEngine, *fakeRouter) {
+	r := newTestRouter(t)
+
+	e := NewEngine(make(chan PLCValue, 10))
+	e.VerifyPolicy = VerifyPolicy{SettleTime: time.Millisecond}
+	require.NoError(t, e.Start([]MachineConfig{{
+		ID: "m1", IP: r.Addr(), AmsNetID: r.NetID(), Port: 851,
+		Symbols: []SymbolInfo{
+			{Name: "GVL.Temperature", IsWritable: true, MinValue: 0, MaxValue: 100},
+			{Name: "GVL.Pressure", IsWritable: true},
+			{Name: "GVL.Step", IsWritable: true},
+		},
+	}}))
+	t.Cleanup(func() { e.Stop() })
+
+	require.Eventually(t, func() bool {
+		_, err := e.GetSymbolInfo("m1", "GVL.Temperature")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	return e, r
+}
+
+func batch(e *PLCReadWriteEngine, temperature, pressure float64, step int) BatchWriteResponse {
+	return <-e.WriteBatch(BatchWriteRequest{ID: "b1", MachineID: "m1", Priority: 9, Verify: true, Writes: []SymbolWrite{
+		{Symbol: "GVL.Temperature", Value: temperature},
+		{Symbol: "GVL.Pressure", Value: pressure},
+		{Symbol: "GVL.Step", Value: step},
+	}})
+}
+
+func TestEngine_WriteBatch(t *testing.T) {
+	e, r := startBatchEngine(t)
+	records := make(chanRecorder, 10)
+	e.SetWriteRecorder(records)
+
+	resp := batch(e, 50, 1.5, 9)
+	require.True(t, resp.Success, resp.Error)
+	assert.False(t, resp.RolledBack)
+	require.Len(t, resp.Results, 3)
+	for _, res := range resp.Results {
+		assert.True(t, res.Success, res.Symbol)
+		require.NotNil(t, res.Verification, res.Symbol)
+		assert.True(t, res.Verification.Verified, res.Symbol)
+	}
+	assert.Equal(t, real32(50), r.value("GVL.Temperature"))
+	assert.Equal(t, real32(1.5), r.value("GVL.Pressure"))
+	assert.Equal(t, int16Bytes(9), r.value("GVL.Step"))
+	assert.Equal(t, 1, r.callCount(ADSIGrpSumWrite))
+
+	rec := <-records
+	assert.Equal(t, "b1", rec.RequestID)
+	assert.Equal(t, "GVL.Temperature", rec.Symbol)
+	assert.Equal(t, float32(21.5), rec.PreviousValue)
+	assert.Equal(t, float32(50), rec.ReadbackValue)
+	assert.True(t, rec.Success)
+}
+
+func TestEngine_WriteBatchRollsBack(t *testing.T) {
+	e, r := startBatchEngine(t)
+	require.True(t, batch(e, 50, 1.5, 9).Success)
+
+	r.denyWrite("GVL.Pressure")
+	resp := batch(e, 60, 2.5, 10)
+	assert.False(t, resp.Success)
+	assert.True(t, resp.RolledBack)
+	assert.Empty(t, resp.RollbackError)
+	assert.Equal(t, "batch write failed, rolled back to snapshot", resp.Error)
+
+	assert.True(t, resp.Results[0].RolledBack)
+	assert.Equal(t, "rolled back", resp.Results[0].Error)
+	assert.False(t, resp.Results[1].RolledBack)
+	assert.Contains(t, resp.Results[1].Error, "not permitted")
+	assert.True(t, resp.Results[2].RolledBack)
+
+	assert.Equal(t, real32(50), r.value("GVL.Temperature"))
+	assert.Equal(t, real32(1.5), r.value("GVL.Pressure"))
+	assert.Equal(t, int16Bytes(9), r.value("GVL.Step"))
+}
+
+func TestEngine_WriteBatchRejected(t *testing.T) {
+	e, r := startBatchEngine(t)
+
+	resp := batch(e, 500, 1.5, 9)
+	assert.False(t, resp.Success)
+	assert.Equal(t, "batch rejected by write guards", resp.Error)
+	require.NotNil(t, resp.Results[0].Rejection)
+	assert.Equal(t, RejectOutOfRange, resp.Results[0].Rejection.Reason)
+	assert.Equal(t, "not written", resp.Results[1].Error)
+	assert.Equal(t, real32(0.75), r.value("GVL.Pressure"))
+	assert.Equal(t, 0, r.callCount(ADSIGrpSumWrite))
+
+	resp = <-e.WriteBatch(BatchWriteRequest{MachineID: "m1", Writes: []SymbolWrite{
+		{Symbol: "GVL.Step", Value: 1},
+		{Symbol: "gvl.step", Value: 2},
+	}})
+	assert.False(t, resp.Success)
+	assert.Contains(t, resp.Error, "more than once")
+
+	resp = <-e.WriteBatch(BatchWriteRequest{MachineID: "m1"})
+	assert.Equal(t, "batch write has no symbols", resp.Error)
+}
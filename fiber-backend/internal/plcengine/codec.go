@@ -0,0 +1,264 @@
+package plcengine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode/utf16"
+)
+
+// decodeADSValue converts raw little-endian PLC bytes into the matching Go type.
+// Types without a scalar representation (structs, arrays) are returned as []byte.
+func decodeADSValue(dataType uint32, b []byte) interface{} {
+	switch dataType {
+	case adsTypeBit:
+		return len(b) > 0 && b[0] != 0
+	case adsTypeInt8:
+		if len(b) >= 1 {
+			return int8(b[0])
+		}
+	case adsTypeUInt8:
+		if len(b) >= 1 {
+			return b[0]
+		}
+	case adsTypeInt16:
+		if len(b) >= 2 {
+			return int16(binary.LittleEndian.Uint16(b))
+		}
+	case adsTypeUInt16:
+		if len(b) >= 2 {
+			return binary.LittleEndian.Uint16(b)
+		}
+	case adsTypeInt32:
+		if len(b) >= 4 {
+			return int32(binary.LittleEndian.Uint32(b))
+		}
+	case adsTypeUInt32:
+		if len(b) >= 4 {
+			return binary.LittleEndian.Uint32(b)
+		}
+	case adsTypeInt64:
+		if len(b) >= 8 {
+			return int64(binary.LittleEndian.Uint64(b))
+		}
+	case adsTypeUInt64:
+		if len(b) >= 8 {
+			return binary.LittleEndian.Uint64(b)
+		}
+	case adsTypeReal32:
+		if len(b) >= 4 {
+			return math.Float32frombits(binary.LittleEndian.Uint32(b))
+		}
+	case adsTypeReal64:
+		if len(b) >= 8 {
+			return math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}
+	case adsTypeString:
+		for i, c := range b {
+			if c == 0 {
+				return string(b[:i])
+			}
+		}
+		return string(b)
+	case adsTypeWString:
+		u := make([]uint16, 0, len(b)/2)
+		for i := 0; i+1 < len(b); i += 2 {
+			c := binary.LittleEndian.Uint16(b[i:])
+			if c == 0 {
+				break
+			}
+			u = append(u, c)
+		}
+		return string(utf16.Decode(u))
+	}
+
+	raw := make([]byte, len(b))
+	copy(raw, b)
+	return raw
+}
+
+// encodeADSValue converts a Go value into the PLC representation of dataType.
+// size is the byte size of the PLC symbol and bounds strings and raw data.
+// Raw data of the symbol's size is written as it is: arrays carry their
+// element type as data type.
+func encodeADSValue(dataType uint32, size uint32, value interface{}) ([]byte, error) {
+	if raw, ok := value.([]byte); ok && uint32(len(raw)) == size {
+		return append([]byte(nil), raw...), nil
+	}
+	b := make([]byte, size)
+
+	switch dataType {
+	case adsTypeBit:
+		v, err := toBool(value)
+		if err != nil {
+			return nil, err
+		}
+		if v {
+			b[0] = 1
+		}
+	case adsTypeInt8, adsTypeInt16, adsTypeInt32, adsTypeInt64:
+		v, err := toInt64(value)
+		if err != nil {
+			return nil, err
+		}
+		bits := size * 8
+		if bits < 64 && (v < -(1<<(bits-1)) || v > 1<<(bits-1)-1) {
+			return nil, fmt.Errorf("value %d overflows %d-bit integer", v, bits)
+		}
+		putUint(b, uint64(v))
+	case adsTypeUInt8, adsTypeUInt16, adsTypeUInt32, adsTypeUInt64:
+		v, err := toInt64(value)
+		if err != nil {
+			return nil, err
+		}
+		bits := size * 8
+		if v < 0 || (bits < 64 && v > 1<<bits-1) {
+			return nil, fmt.Errorf("value %d overflows unsigned %d-bit integer", v, bits)
+		}
+		putUint(b, uint64(v))
+	case adsTypeReal32:
+		v, err := toFloat64(value)
+		if err != nil {
+			return nil, err
+		}
+		binary.LittleEndian.PutUint32(b, math.Float32bits(float32(v)))
+	case adsTypeReal64:
+		v, err := toFloat64(value)
+		if err != nil {
+			return nil, err
+		}
+		binary.LittleEndian.PutUint64(b, math.Float64bits(v))
+	case adsTypeString:
+		s := fmt.Sprint(value)
+		if uint32(len(s)) >= size {
+			return nil, fmt.Errorf("string of length %d does not fit STRING(%d)", len(s), size-1)
+		}
+		copy(b, s)
+	case adsTypeWString:
+		u := utf16.Encode([]rune(fmt.Sprint(value)))
+		if uint32(2*len(u)) >= size {
+			return nil, fmt.Errorf("string of length %d does not fit WSTRING(%d)", len(u), size/2-1)
+		}
+		for i, c := range u {
+			binary.LittleEndian.PutUint16(b[2*i:], c)
+		}
+	default:
+		raw, ok := value.([]byte)
+		if !ok || uint32(len(raw)) != size {
+			return nil, fmt.Errorf("cannot encode %T for ADS data type %d", value, dataType)
+		}
+		copy(b, raw)
+	}
+
+	return b, nil
+}
+
+func putUint(b []byte, v uint64) {
+	switch len(b) {
+	case 1:
+		b[0] = byte(v)
+	case 2:
+		binary.LittleEndian.PutUint16(b, uint16(v))
+	case 4:
+		binary.LittleEndian.PutUint32(b, uint32(v))
+	case 8:
+		binary.LittleEndian.PutUint64(b, v)
+	}
+}
+
+// ToFloat64 converts a numeric, bool or numeric string value
+func ToFloat64(value interface{}) (float64, error) {
+	return toFloat64(value)
+}
+
+func toFloat64(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int8:
+		return float64(v), nil
+	case int16:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint:
+		return float64(v), nil
+	case uint8:
+		return float64(v), nil
+	case uint16:
+		return float64(v), nil
+	case uint32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("cannot convert %T to a number", value)
+}
+
+func toInt64(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint:
+		return int64(v), nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, fmt.Errorf("value %d overflows int64", v)
+		}
+		return int64(v), nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+
+	// JSON numbers arrive as float64; only accept them when they are whole
+	f, err := toFloat64(value)
+	if err != nil {
+		return 0, err
+	}
+	if f != math.Trunc(f) || f < math.MinInt64 || f > math.MaxInt64 {
+		return 0, fmt.Errorf("value %v is not an integer", value)
+	}
+	return int64(f), nil
+}
+
+func toBool(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		return strconv.ParseBool(v)
+	}
+	f, err := toFloat64(value)
+	if err != nil {
+		return false, fmt.Errorf("cannot convert %T to a boolean", value)
+	}
+	return f != 0, nil
+}
@@ -3,6 +3,8 @@ package plcengine
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log"
 	"sync"
 	"time"
 )
@@ -15,6 +17,19 @@ type ADSClient interface {
 	Close() error
 }
 
+// NotificationClient is implemented by ADS clients that support push-mode
+// device notifications in addition to polling.
+type NotificationClient interface {
+	AddNotification(name string, attrib NotificationAttrib, callback func(NotificationSample)) (uint32, error)
+	DeleteNotification(handle uint32) error
+}
+
+// BatchWriter is implemented by ADS clients that write several symbols in
+// one request, e.g. with ADS sum-write
+type BatchWriter interface {
+	WriteSymbols(values map[string]interface{}) (map[string]error, error)
+}
+
 // ConnectionState represents the current state of a PLC connection
 type ConnectionState int
 
@@ -25,117 +40,401 @@ const (
 	StateError
 )
 
+var connectionStateNames = map[ConnectionState]string{
+	StateDisconnected: "disconnected",
+	StateConnecting:   "connecting",
+	StateConnected:    "connected",
+	StateError:        "error",
+}
+
+func (s ConnectionState) String() string {
+	if n, ok := connectionStateNames[s]; ok {
+		return n
+	}
+	return "unknown"
+}
+
+func (s ConnectionState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// closeNotifier is implemented by clients that notice a lost transport on
+// their own, without a failed request
+type closeNotifier interface {
+	Closed() bool
+}
+
 var (
-	ErrNotConnected = errors.New("PLC connection not established")
+	ErrNotConnected      = errors.New("PLC connection not established")
+	ErrConnectionStopped = errors.New("PLC connection stopped")
 )
 
-// PLCConnection handles a single physical connection to a TwinCAT PLC.
-// It multiplexes requests from multiple goroutines to respect the ADS
-// "one connection per IP" limitation.
+// PLCConnection handles the connection to one TwinCAT PLC. Requests are
+// queued on lanes for polling, bulk reads and writes, each served by its own
+// workers. The lanes share one ADS client unless the pool opens a connection
+// per lane.
 type PLCConnection struct {
 	MachineID string
 	IP        string
 	Port      int
 	AmsNetID  string
 
-	client ADSClient
-	state  ConnectionState
-	mu     sync.RWMutex
+	clients map[Lane]ADSClient // nil while disconnected
+	state   ConnectionState
+	mu      sync.RWMutex
+
+	// Request lanes, see PoolConfig
+	pool     PoolConfig
+	lanes    map[Lane]*connLane
+	stopChan chan struct{}
+	stopOnce sync.Once
+
+	// ready is closed after the first connect attempt, the workers wait for
+	// it so early requests are not failed while the first dial is pending
+	ready chan struct{}
 
-	// Internal queues for multiplexing
-	requestChan chan *internalRequest
-	stopChan    chan struct{}
+	// Active notification subscriptions, re-registered on every reconnect
+	subs map[*Subscription]struct{}
+
+	// Symbol table uploaded on connect, nil if the client cannot upload
+	registry *SymbolRegistry
+
+	// Reconnect backoff and circuit breaker, see ReconnectPolicy
+	policy  ReconnectPolicy
+	onEvent func(ConnectionEvent)
 
 	stats ConnectionStatus
 }
 
 type internalRequest struct {
-	op       string // "read", "write", "batch_read"
+	ctx      context.Context
+	op       string // "read", "write", "batch_read", "batch_write", "subscribe", "unsubscribe", "browse"
 	symbol   string
 	symbols  []string
 	value    interface{}
+	values   map[string]interface{}
+	sub      *Subscription
 	respChan chan *internalResponse
 }
 
 type internalResponse struct {
 	value  interface{}
 	values map[string]interface{}
+	errs   map[string]error
+	nodes  []BrowseNode
 	err    error
 }
 
 func NewPLCConnection(machineID, ip string, amsID string, port int) *PLCConnection {
-	return &PLCConnection{
-		MachineID:   machineID,
-		IP:          ip,
-		Port:        port,
-		AmsNetID:    amsID,
-		requestChan: make(chan *internalRequest, 100),
-		stopChan:    make(chan struct{}),
+	c := &PLCConnection{
+		MachineID: machineID,
+		IP:        ip,
+		Port:      port,
+		AmsNetID:  amsID,
+		stopChan:  make(chan struct{}),
+		ready:     make(chan struct{}),
+		subs:      make(map[*Subscription]struct{}),
+		policy:    DefaultReconnectPolicy(),
 		stats: ConnectionStatus{
 			MachineID: machineID,
+			State:     StateDisconnected,
+			Circuit:   CircuitClosed,
 		},
 	}
+	c.setPool(DefaultPoolConfig())
+	return c
+}
+
+// setPool replaces the lanes, it must be called before Start
+func (c *PLCConnection) setPool(cfg PoolConfig) {
+	c.pool = cfg.withDefaults()
+	c.lanes = make(map[Lane]*connLane, len(laneOrder))
+	for _, l := range laneOrder {
+		c.lanes[l] = newConnLane(l, c.pool)
+	}
 }
 
-func (c *PLCConnection) Start(ctx context.Context, clientFactory func() (ADSClient, error)) {
+// Start connects in the background. clientFactory is called once per lane
+// with ConnectionPerLane, otherwise once for all lanes.
+func (c *PLCConnection) Start(ctx context.Context, clientFactory func(lane Lane) (ADSClient, error)) {
 	go c.handler(clientFactory)
+	for _, l := range laneOrder {
+		lane := c.lanes[l]
+		for i := 0; i < lane.workers; i++ {
+			go c.worker(lane)
+		}
+	}
 }
 
 func (c *PLCConnection) Stop() {
-	close(c.stopChan)
+	c.stopOnce.Do(func() { close(c.stopChan) })
 }
 
-func (c *PLCConnection) handler(clientFactory func() (ADSClient, error)) {
+// handler keeps the connection up
+func (c *PLCConnection) handler(clientFactory func(lane Lane) (ADSClient, error)) {
 	// Initial connection attempt
-	c.checkConnection(clientFactory)
-
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
+	timer := time.NewTimer(c.checkConnection(clientFactory))
+	defer timer.Stop()
+	close(c.ready)
 
 	for {
 		select {
 		case <-c.stopChan:
-			if c.client != nil {
-				c.client.Close()
-			}
+			c.mu.Lock()
+			c.closeClientsLocked()
+			c.mu.Unlock()
 			return
 
-		case req := <-c.requestChan:
-			c.processRequest(req)
+		case <-timer.C:
+			timer.Reset(c.checkConnection(clientFactory))
+		}
+	}
+}
 
-		case <-ticker.C:
-			c.checkConnection(clientFactory)
+// worker serves the requests of one lane once the first connect attempt is done
+func (c *PLCConnection) worker(lane *connLane) {
+	select {
+	case <-c.stopChan:
+		return
+	case <-c.ready:
+	}
+	for {
+		select {
+		case <-c.stopChan:
+			return
+		case req := <-lane.requests:
+			c.processRequest(lane, req)
 		}
 	}
 }
 
-func (c *PLCConnection) checkConnection(factory func() (ADSClient, error)) {
+// checkConnection reconnects if needed and returns the delay until the next check
+func (c *PLCConnection) checkConnection(factory func(lane Lane) (ADSClient, error)) time.Duration {
+	connected, next := c.connect(factory)
+	if connected {
+		c.uploadSymbols()
+		c.resubscribe()
+	}
+	return next
+}
+
+// uploadSymbols refreshes the symbol table from a freshly connected client.
+// A failed upload is not fatal, symbols are then resolved one by one.
+func (c *PLCConnection) uploadSymbols() {
+	c.mu.RLock()
+	uploader, ok := c.clients[LaneBulk].(SymbolUploader)
+	c.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	reg, err := uploader.UploadSymbols()
+	if err != nil {
+		log.Printf("Symbol upload on machine %s: %v", c.MachineID, err)
+		return
+	}
+
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.registry = reg
+	c.mu.Unlock()
+}
+
+// Symbols returns the symbol table of the last upload, or nil
+func (c *PLCConnection) Symbols() *SymbolRegistry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.registry
+}
 
+// valueType returns the PLC type of a symbol from the symbol table, falling
+// back to the Go type of the decoded value
+func (c *PLCConnection) valueType(symbol string, value interface{}) PLCType {
+	if reg := c.Symbols(); reg != nil {
+		if info, ok := reg.Symbol(symbol); ok {
+			return info.Type
+		}
+	}
+	return typeOfValue(value)
+}
+
+// connect creates new clients if needed and reports whether it did, along
+// with the delay until the next check. Only the handler connects, c.mu is
+// not held while dialing so status and requests are not held up.
+func (c *PLCConnection) connect(factory func(lane Lane) (ADSClient, error)) (bool, time.Duration) {
+	c.mu.Lock()
 	if c.state == StateConnected {
-		return
+		if !c.clientClosedLocked() {
+			c.mu.Unlock()
+			return false, c.policy.HealthInterval
+		}
+		c.dropClientLocked("connection closed by peer")
 	}
 
+	now := time.Now()
+	if c.stats.Circuit == CircuitOpen {
+		if now.Before(c.stats.NextRetry) {
+			c.mu.Unlock()
+			return false, c.stats.NextRetry.Sub(now)
+		}
+		c.setCircuitLocked(CircuitHalfOpen, "open timeout elapsed, probing")
+	}
 	c.state = StateConnecting
-	client, err := factory()
+	c.mu.Unlock()
+
+	clients, err := c.dial(factory)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if err != nil {
-		c.state = StateError
-		c.stats.ErrorCount++
-		c.stats.Connected = false
-		return
+		c.failLocked(err.Error())
+		return false, time.Until(c.stats.NextRetry)
 	}
 
-	c.client = client
-	c.state = StateConnected
+	c.clients = clients
 	c.stats.Connected = true
 	c.stats.ReconnectCount++
+	c.stats.ConsecutiveFailures = 0
+	c.stats.NextRetry = time.Time{}
 	c.stats.LastSeen = time.Now()
+	c.setStateLocked(StateConnected, "")
+	if c.stats.Circuit != CircuitClosed {
+		c.setCircuitLocked(CircuitClosed, "connected")
+	}
+	return true, c.policy.HealthInterval
+}
+
+// dial creates the clients of all lanes, either one shared client or one per
+// lane. Nothing stays open if one of them fails.
+func (c *PLCConnection) dial(factory func(lane Lane) (ADSClient, error)) (map[Lane]ADSClient, error) {
+	clients := make(map[Lane]ADSClient, len(laneOrder))
+	if !c.pool.ConnectionPerLane {
+		client, err := factory(LanePolling)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range laneOrder {
+			clients[l] = client
+		}
+		return clients, nil
+	}
+
+	for _, l := range laneOrder {
+		client, err := factory(l)
+		if err != nil {
+			for _, opened := range clients {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("%s lane: %w", l, err)
+		}
+		clients[l] = client
+	}
+	return clients, nil
+}
+
+// clientClosedLocked reports whether a client noticed a lost transport. c.mu must be held.
+func (c *PLCConnection) clientClosedLocked() bool {
+	for _, client := range c.clients {
+		if cn, ok := client.(closeNotifier); ok && cn.Closed() {
+			return true
+		}
+	}
+	return false
+}
+
+// closeClientsLocked closes every client once. c.mu must be held.
+func (c *PLCConnection) closeClientsLocked() {
+	closed := make(map[ADSClient]bool, len(c.clients))
+	for _, client := range c.clients {
+		if !closed[client] {
+			closed[client] = true
+			client.Close()
+		}
+	}
+	c.clients = nil
+}
+
+// failLocked records a failed connect and schedules the next attempt, opening
+// the circuit after too many failures in a row. c.mu must be held.
+func (c *PLCConnection) failLocked(reason string) {
+	now := time.Now()
+	c.stats.Connected = false
+	c.stats.ErrorCount++
+	c.stats.ConsecutiveFailures++
+	c.stats.LastError = reason
+	c.stats.LastErrorAt = now
+	c.setStateLocked(StateError, reason)
+
+	if c.stats.Circuit == CircuitHalfOpen || c.stats.ConsecutiveFailures >= c.policy.FailureThreshold {
+		c.stats.NextRetry = now.Add(c.policy.OpenTimeout)
+		if c.stats.Circuit != CircuitOpen {
+			c.setCircuitLocked(CircuitOpen, reason)
+		}
+		return
+	}
+	c.stats.NextRetry = now.Add(c.policy.Backoff(c.stats.ConsecutiveFailures))
+}
+
+// dropClientLocked discards all clients once a transport failed, the lanes
+// reconnect together. c.mu must be held.
+func (c *PLCConnection) dropClientLocked(reason string) {
+	c.closeClientsLocked()
+	c.stats.Connected = false
+	c.stats.ErrorCount++
+	c.stats.LastError = reason
+	c.stats.LastErrorAt = time.Now()
+	c.setStateLocked(StateDisconnected, reason)
+}
+
+// setStateLocked updates the connection state and publishes a change, the
+// transient connecting state is not published. c.mu must be held.
+func (c *PLCConnection) setStateLocked(state ConnectionState, reason string) {
+	c.state = state
+	if c.stats.State == state {
+		return
+	}
+	c.stats.State = state
+	c.publishLocked(reason)
+}
+
+// setCircuitLocked moves the circuit breaker and publishes the change. c.mu must be held.
+func (c *PLCConnection) setCircuitLocked(circuit CircuitState, reason string) {
+	c.stats.Circuit = circuit
+	c.publishLocked(reason)
+}
+
+func (c *PLCConnection) publishLocked(reason string) {
+	if c.onEvent == nil {
+		return
+	}
+	c.onEvent(ConnectionEvent{
+		MachineID: c.MachineID,
+		State:     c.stats.State,
+		Circuit:   c.stats.Circuit,
+		Reason:    reason,
+		Timestamp: time.Now(),
+	})
 }
 
-func (c *PLCConnection) processRequest(req *internalRequest) {
+// resubscribe registers all subscriptions on a freshly connected polling client
+func (c *PLCConnection) resubscribe() {
 	c.mu.RLock()
-	client := c.client
+	client := c.clients[LanePolling]
+	subs := make([]*Subscription, 0, len(c.subs))
+	for s := range c.subs {
+		subs = append(subs, s)
+	}
+	c.mu.RUnlock()
+
+	for _, s := range subs {
+		if err := s.register(client); err != nil {
+			log.Printf("Subscription on machine %s: %v", c.MachineID, err)
+		}
+	}
+}
+
+func (c *PLCConnection) processRequest(lane *connLane, req *internalRequest) {
+	c.mu.RLock()
+	client := c.clients[lane.name]
 	state := c.state
 	c.mu.RUnlock()
 
@@ -143,7 +442,14 @@ func (c *PLCConnection) processRequest(req *internalRequest) {
 		req.respChan <- &internalResponse{err: ErrNotConnected}
 		return
 	}
+	// The caller gave up while the request was queued, don't touch the PLC
+	if err := req.ctx.Err(); err != nil {
+		req.respChan <- &internalResponse{err: err}
+		return
+	}
 
+	lane.begin()
+	start := time.Now()
 	var resp internalResponse
 	switch req.op {
 	case "read":
@@ -152,12 +458,32 @@ func (c *PLCConnection) processRequest(req *internalRequest) {
 		resp.err = client.WriteSymbol(req.symbol, req.value)
 	case "batch_read":
 		resp.values, resp.err = client.ReadSymbols(req.symbols)
+	case "batch_write":
+		resp.errs, resp.err = writeSymbols(client, req.values)
+	case "subscribe":
+		resp.err = req.sub.register(client)
+	case "unsubscribe":
+		req.sub.unregister(client)
+	case "browse":
+		if b, ok := client.(Browser); ok {
+			resp.nodes, resp.err = b.Browse(req.symbol)
+		} else {
+			resp.err = fmt.Errorf("client for machine %s does not support browsing", c.MachineID)
+		}
 	}
+	lane.end(time.Since(start), resp.err)
 
 	if resp.err == nil {
 		c.mu.Lock()
 		c.stats.LastSeen = time.Now()
 		c.mu.Unlock()
+	} else if errors.Is(resp.err, ErrClientClosed) {
+		// Transport is gone, drop the client so the next tick reconnects
+		c.mu.Lock()
+		if c.clients != nil && c.clients[lane.name] == client {
+			c.dropClientLocked(resp.err.Error())
+		}
+		c.mu.Unlock()
 	}
 
 	req.respChan <- &resp
@@ -165,26 +491,154 @@ func (c *PLCConnection) processRequest(req *internalRequest) {
 
 // Public API for the connection (Thread-safe via channel)
 
+// do queues req on a lane and waits for the response. It fails with
+// ErrConnectionStopped once the connection is stopped and with the context
+// error once ctx is done.
+func (c *PLCConnection) do(ctx context.Context, lane Lane, req *internalRequest) *internalResponse {
+	select {
+	case <-c.stopChan:
+		return &internalResponse{err: ErrConnectionStopped}
+	default:
+	}
+
+	req.ctx = ctx
+	req.respChan = make(chan *internalResponse, 1)
+	select {
+	case c.lanes[lane].requests <- req:
+	case <-c.stopChan:
+		return &internalResponse{err: ErrConnectionStopped}
+	case <-ctx.Done():
+		return &internalResponse{err: ctx.Err()}
+	}
+
+	select {
+	case resp := <-req.respChan:
+		return resp
+	case <-c.stopChan:
+		return &internalResponse{err: ErrConnectionStopped}
+	case <-ctx.Done():
+		return &internalResponse{err: ctx.Err()}
+	}
+}
+
 func (c *PLCConnection) ReadSymbol(symbol string) (interface{}, error) {
-	respChan := make(chan *internalResponse, 1)
-	c.requestChan <- &internalRequest{op: "read", symbol: symbol, respChan: respChan}
+	return c.ReadSymbolCtx(context.Background(), symbol)
+}
+
+func (c *PLCConnection) WriteSymbol(symbol string, value interface{}) error {
+	return c.WriteSymbolCtx(context.Background(), symbol, value)
+}
 
-	resp := <-respChan
+func (c *PLCConnection) ReadSymbols(symbols []string) (map[string]interface{}, error) {
+	return c.ReadSymbolsCtx(context.Background(), symbols)
+}
+
+// ReadSymbolCtx is ReadSymbol bounded by ctx
+func (c *PLCConnection) ReadSymbolCtx(ctx context.Context, symbol string) (interface{}, error) {
+	resp := c.do(ctx, c.readLane([]string{symbol}), &internalRequest{op: "read", symbol: symbol})
 	return resp.value, resp.err
 }
 
-func (c *PLCConnection) WriteSymbol(symbol string, value interface{}) error {
-	respChan := make(chan *internalResponse, 1)
-	c.requestChan <- &internalRequest{op: "write", symbol: symbol, value: value, respChan: respChan}
+// WriteSymbolCtx is WriteSymbol bounded by ctx. A write whose context ends
+// while it is queued is not sent to the PLC.
+func (c *PLCConnection) WriteSymbolCtx(ctx context.Context, symbol string, value interface{}) error {
+	return c.do(ctx, LaneWrite, &internalRequest{op: "write", symbol: symbol, value: value}).err
+}
 
-	resp := <-respChan
+// ReadSymbolsCtx is ReadSymbols bounded by ctx
+func (c *PLCConnection) ReadSymbolsCtx(ctx context.Context, symbols []string) (map[string]interface{}, error) {
+	resp := c.do(ctx, c.readLane(symbols), &internalRequest{op: "batch_read", symbols: symbols})
+	return resp.values, resp.err
+}
+
+// WriteSymbolsCtx writes several symbols in one request if the client
+// supports it. The returned map has an entry per symbol, nil on success.
+func (c *PLCConnection) WriteSymbolsCtx(ctx context.Context, values map[string]interface{}) (map[string]error, error) {
+	resp := c.do(ctx, LaneWrite, &internalRequest{op: "batch_write", values: values})
+	return resp.errs, resp.err
+}
+
+// BrowseCtx lists the child nodes of node on the bulk lane
+func (c *PLCConnection) BrowseCtx(ctx context.Context, node string) ([]BrowseNode, error) {
+	resp := c.do(ctx, LaneBulk, &internalRequest{op: "browse", symbol: node})
+	return resp.nodes, resp.err
+}
+
+// readLane picks the bulk lane for arrays, structs and reads of at least
+// BulkThreshold bytes. Without a symbol table all reads use the polling lane.
+func (c *PLCConnection) readLane(symbols []string) Lane {
+	reg := c.Symbols()
+	if reg == nil {
+		return LanePolling
+	}
+	size := 0
+	for _, name := range symbols {
+		info, ok := reg.Symbol(name)
+		if !ok {
+			continue
+		}
+		if info.Type == TypeArray || info.Type == TypeStruct {
+			return LaneBulk
+		}
+		size += info.Size
+	}
+	if size >= c.pool.BulkThreshold {
+		return LaneBulk
+	}
+	return LanePolling
+}
+
+// writeSymbols writes values with a sum-write, or one by one if the client
+// cannot batch
+func writeSymbols(client ADSClient, values map[string]interface{}) (map[string]error, error) {
+	if bw, ok := client.(BatchWriter); ok {
+		return bw.WriteSymbols(values)
+	}
+	errs := make(map[string]error, len(values))
+	for name, value := range values {
+		err := client.WriteSymbol(name, value)
+		if errors.Is(err, ErrClientClosed) {
+			return nil, err
+		}
+		errs[name] = err
+	}
+	return errs, nil
+}
+
+// Status returns a snapshot of the connection health
+func (c *PLCConnection) Status() ConnectionStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	status := c.stats
+	status.Lanes = make([]LaneStatus, 0, len(laneOrder))
+	for _, l := range laneOrder {
+		ls := c.lanes[l].status()
+		status.QueueDepth += ls.QueueDepth
+		status.QueueCapacity += ls.QueueCapacity
+		status.Lanes = append(status.Lanes, ls)
+	}
+	return status
+}
+
+// addSubscription tracks s and registers it right away if the PLC is online.
+// An offline PLC is not an error, s is registered once the connection is up.
+func (c *PLCConnection) addSubscription(s *Subscription) error {
+	c.mu.Lock()
+	c.subs[s] = struct{}{}
+	c.mu.Unlock()
+
+	resp := c.do(context.Background(), LanePolling, &internalRequest{op: "subscribe", sub: s})
+	if errors.Is(resp.err, ErrNotConnected) {
+		return nil
+	}
 	return resp.err
 }
 
-func (c *PLCConnection) ReadSymbols(symbols []string) (map[string]interface{}, error) {
-	respChan := make(chan *internalResponse, 1)
-	c.requestChan <- &internalRequest{op: "batch_read", symbols: symbols, respChan: respChan}
+func (c *PLCConnection) removeSubscription(s *Subscription) {
+	c.mu.Lock()
+	delete(c.subs, s)
+	c.mu.Unlock()
 
-	resp := <-respChan
-	return resp.values, resp.err
+	c.do(context.Background(), LanePolling, &internalRequest{op: "unsubscribe", sub: s})
 }
@@ -0,0 +1,116 @@
+package plcengine
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingClient holds every read until release is closed
+type blockingClient struct {
+	*MockADSClient
+	release chan struct{}
+	writes  atomic.Int32
+}
+
+func (b *blockingClient) ReadSymbol(name string) (interface{}, error) {
+	<-b.release
+	return 42.0, nil
+}
+
+// WriteSymbol holds writes of GVL.Slow and counts the others
+func (b *blockingClient) WriteSymbol(name string, value interface{}) error {
+	if name == "GVL.Slow" {
+		<-b.release
+		return nil
+	}
+	b.writes.Add(1)
+	return nil
+}
+
+func TestEngine_Deadlines(t *testing.T) {
+	client := &blockingClient{MockADSClient: NewMockADSClient("m1"), release: make(chan struct{})}
+	e := NewEngine(make(chan PLCValue, 10))
+	e.ClientFactory = func(ip, amsID string, port int) (ADSClient, error) { return client, nil }
+	require.NoError(t, e.Start([]MachineConfig{{
+		ID: "m1", IP: "10.0.0.1",
+		Symbols: []SymbolInfo{{Name: "GVL.Setpoint", IsWritable: true}},
+	}}))
+	require.Eventually(t, func() bool { return e.GetStatus()["m1"].Connected }, time.Second, 5*time.Millisecond)
+
+	// Occupy the polling and the write lane with requests the PLC does not answer
+	conn, err := e.getConnection("m1")
+	require.NoError(t, err)
+	go e.ReadSymbol("m1", "GVL.Slow")
+	go conn.WriteSymbol("GVL.Slow", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	_, err = e.ReadSymbolCtx(ctx, "m1", "GVL.Temperature")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	resp := <-e.WriteAsync(WriteRequest{ID: "w1", MachineID: "m1", Symbol: "GVL.Setpoint", Value: 1, Priority: 9, Timeout: 30 * time.Millisecond})
+	assert.False(t, resp.Success)
+	assert.Contains(t, resp.Error, "deadline exceeded")
+
+	status := e.GetStatus()["m1"]
+	assert.Equal(t, 2, status.QueueDepth)
+	assert.Equal(t, 300, status.QueueCapacity)
+	require.Len(t, status.Lanes, 3)
+	assert.Equal(t, LaneStatus{Lane: LanePolling, Workers: 1, Busy: 1, QueueDepth: 1, QueueCapacity: 100}, status.Lanes[0])
+	assert.Equal(t, 1, status.Lanes[2].Busy)
+	assert.Equal(t, 1, status.Lanes[2].QueueDepth)
+
+	// Abandoned requests are skipped once the handler is free again
+	close(client.release)
+	require.Eventually(t, func() bool { return e.GetStatus()["m1"].QueueDepth == 0 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(0), client.writes.Load())
+
+	// Everything fails fast once stopped
+	require.NoError(t, e.Stop())
+
+	_, err = conn.ReadSymbol("GVL.Temperature")
+	assert.ErrorIs(t, err, ErrConnectionStopped)
+	resp = <-e.WriteAsync(WriteRequest{MachineID: "m1", Symbol: "GVL.Setpoint", Value: 1})
+	assert.Equal(t, ErrWriterStopped.Error(), resp.Error)
+}
+
+func TestEngine_FirstConnect(t *testing.T) {
+	dialing := make(chan struct{})
+	e := NewEngine(make(chan PLCValue, 10))
+	e.ClientFactory = func(ip, amsID string, port int) (ADSClient, error) {
+		<-dialing
+		return NewMockADSClient(ip), nil
+	}
+	require.NoError(t, e.Start([]MachineConfig{{ID: "m1", IP: "10.0.0.1"}}))
+	defer e.Stop()
+
+	// A read right after Start waits for the first dial instead of failing
+	read := make(chan error, 1)
+	go func() {
+		_, err := e.ReadSymbol("m1", "GVL.Temperature")
+		read <- err
+	}()
+
+	// The status does not wait for a pending dial
+	status := make(chan ConnectionStatus, 1)
+	go func() { status <- e.GetStatus()["m1"] }()
+	select {
+	case s := <-status:
+		assert.False(t, s.Connected)
+	case <-time.After(time.Second):
+		t.Fatal("GetStatus blocked by the dial")
+	}
+
+	close(dialing)
+	select {
+	case err := <-read:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("read not answered")
+	}
+}
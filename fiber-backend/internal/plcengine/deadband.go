@@ -0,0 +1,64 @@
+package plcengine
+
+import (
+	"math"
+	"reflect"
+	"time"
+)
+
+// ReportFilter decides which polled values of a symbol are worth reporting.
+// Without deadband or ChangeOnly every value is reported.
+type ReportFilter struct {
+	// Deadband suppresses numeric changes up to this absolute amount
+	Deadband float64
+
+	// DeadbandPercent suppresses numeric changes up to this percentage of
+	// Span, or of the last reported value when Span is 0. With both
+	// deadbands the larger one applies.
+	DeadbandPercent float64
+	Span            float64
+
+	// ChangeOnly reports a value only when it differs from the last
+	// reported one, implied by a deadband
+	ChangeOnly bool
+
+	// Heartbeat reports an unchanged value again once this long has passed
+	// since the last report, 0 disables it
+	Heartbeat time.Duration
+}
+
+// Active reports whether the filter suppresses any values
+func (f ReportFilter) Active() bool {
+	return f.ChangeOnly || f.Deadband > 0 || f.DeadbandPercent > 0
+}
+
+// Report decides whether v is reported. last is the value reported at
+// lastAt, nil if the symbol was never reported. Quality changes are always
+// reported.
+func (f ReportFilter) Report(last *PLCValue, lastAt time.Time, v PLCValue, now time.Time) bool {
+	if !f.Active() || last == nil {
+		return true
+	}
+	if v.Quality != last.Quality || v.QualityReason != last.QualityReason {
+		return true
+	}
+	if f.Heartbeat > 0 && now.Sub(lastAt) >= f.Heartbeat {
+		return true
+	}
+
+	prev, errPrev := toFloat64(last.Value)
+	cur, errCur := toFloat64(v.Value)
+	if errPrev != nil || errCur != nil || v.Type == TypeBool || v.Type == TypeString || v.Type == TypeWString {
+		return !reflect.DeepEqual(last.Value, v.Value)
+	}
+	if math.IsNaN(prev) || math.IsNaN(cur) {
+		return math.IsNaN(prev) != math.IsNaN(cur)
+	}
+
+	span := f.Span
+	if span <= 0 {
+		span = math.Abs(prev)
+	}
+	threshold := math.Max(f.Deadband, f.DeadbandPercent/100*span)
+	return math.Abs(cur-prev) > threshold
+}
@@ -0,0 +1,52 @@
+package plcengine
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportFilter(t *testing.T) {
+	now := time.Now()
+	good := func(v interface{}, typ PLCType) PLCValue {
+		return PLCValue{Value: v, Type: typ, Quality: QualityGood}
+	}
+	tests := []struct {
+		name   string
+		filter ReportFilter
+		last   PLCValue
+		v      PLCValue
+		want   bool
+	}{
+		{"no filter", ReportFilter{}, good(20.0, TypeLReal), good(20.0, TypeLReal), true},
+		{"unchanged", ReportFilter{ChangeOnly: true}, good(20.0, TypeLReal), good(20.0, TypeLReal), false},
+		{"changed", ReportFilter{ChangeOnly: true}, good(20.0, TypeLReal), good(20.1, TypeLReal), true},
+		{"within deadband", ReportFilter{Deadband: 0.5}, good(20.0, TypeLReal), good(20.5, TypeLReal), false},
+		{"beyond deadband", ReportFilter{Deadband: 0.5}, good(20.0, TypeLReal), good(19.4, TypeLReal), true},
+		{"integer deadband", ReportFilter{Deadband: 2}, good(int16(10), TypeInt16), good(int16(12), TypeInt16), false},
+		{"percent of value", ReportFilter{DeadbandPercent: 10}, good(200.0, TypeLReal), good(215.0, TypeLReal), false},
+		{"percent of value exceeded", ReportFilter{DeadbandPercent: 10}, good(200.0, TypeLReal), good(225.0, TypeLReal), true},
+		{"percent of span", ReportFilter{DeadbandPercent: 1, Span: 1000}, good(200.0, TypeLReal), good(209.0, TypeLReal), false},
+		{"larger deadband applies", ReportFilter{Deadband: 20, DeadbandPercent: 1, Span: 1000}, good(200.0, TypeLReal), good(215.0, TypeLReal), false},
+		{"quality changed", ReportFilter{Deadband: 0.5}, PLCValue{Value: 20.0, Type: TypeLReal, Quality: QualityUncertain, QualityReason: ReasonStale}, good(20.0, TypeLReal), true},
+		{"bool unchanged", ReportFilter{Deadband: 1}, good(true, TypeBool), good(true, TypeBool), false},
+		{"bool changed", ReportFilter{ChangeOnly: true}, good(true, TypeBool), good(false, TypeBool), true},
+		{"string changed", ReportFilter{ChangeOnly: true}, good("RCP-1", TypeString), good("RCP-2", TypeString), true},
+		{"array unchanged", ReportFilter{ChangeOnly: true}, good([]interface{}{1, 2}, TypeArray), good([]interface{}{1, 2}, TypeArray), false},
+		{"NaN stays NaN", ReportFilter{Deadband: 1}, good(math.NaN(), TypeLReal), good(math.NaN(), TypeLReal), false},
+		{"NaN recovers", ReportFilter{Deadband: 1}, good(math.NaN(), TypeLReal), good(1.0, TypeLReal), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.Report(&tt.last, now, tt.v, now))
+		})
+	}
+
+	f := ReportFilter{ChangeOnly: true, Heartbeat: time.Second}
+	last := good(20.0, TypeLReal)
+	assert.True(t, f.Report(nil, time.Time{}, last, now), "first value")
+	assert.False(t, f.Report(&last, now.Add(-500*time.Millisecond), last, now))
+	assert.True(t, f.Report(&last, now.Add(-time.Second), last, now), "heartbeat")
+}
@@ -0,0 +1,109 @@
+package plcengine
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Protocol selects the driver a machine is connected with
+type Protocol string
+
+const (
+	ProtocolADS    Protocol = "ads"    // TwinCAT ADS over AMS/TCP, the default
+	ProtocolOPCUA  Protocol = "opcua"  // OPC UA binary, security policy None
+	ProtocolModbus Protocol = "modbus" // Modbus TCP with per-symbol register maps
+)
+
+// Driver creates the clients of a machine. The returned factory is called by
+// the machine's PLCConnection for every (re)connect, once per lane with
+// ConnectionPerLane.
+type Driver interface {
+	Dialer(cfg MachineConfig, pool PoolConfig) func(lane Lane) (ADSClient, error)
+}
+
+// DriverFunc adapts a function to the Driver interface
+type DriverFunc func(cfg MachineConfig, pool PoolConfig) func(lane Lane) (ADSClient, error)
+
+func (f DriverFunc) Dialer(cfg MachineConfig, pool PoolConfig) func(lane Lane) (ADSClient, error) {
+	return f(cfg, pool)
+}
+
+// Browser is implemented by clients that can list the nodes below a node,
+// see PLCReadWriteEngine.Browse
+type Browser interface {
+	Browse(node string) ([]BrowseNode, error)
+}
+
+// BrowseNode is a child node returned by Browse. Variables carry their PLC
+// type; ID is the symbol name to read and write them with.
+type BrowseNode struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Class       string `json:"class"` // "object", "variable", "method" or "other"
+	Type        string `json:"type,omitempty"`
+	Writable    bool   `json:"writable,omitempty"`
+}
+
+// OPCUADriver connects machines with Protocol opcua to the OPC UA server at
+// opc.tcp://IP:Port, the port defaults to 4840. Symbol names are node IDs
+// such as "ns=4;s=GVL.Temperature"; other names are string node IDs in
+// Namespace.
+type OPCUADriver struct {
+	Namespace       uint16
+	Timeout         time.Duration
+	SessionTimeout  time.Duration
+	PublishInterval time.Duration
+}
+
+func (d OPCUADriver) Dialer(cfg MachineConfig, pool PoolConfig) func(lane Lane) (ADSClient, error) {
+	port := cfg.Port
+	if port == 0 {
+		port = OPCUAPort
+	}
+	endpoint := "opc.tcp://" + net.JoinHostPort(cfg.IP, fmt.Sprint(port))
+
+	return func(lane Lane) (ADSClient, error) {
+		return NewOPCUAClient(OPCUAConfig{
+			Endpoint:        endpoint,
+			Namespace:       d.Namespace,
+			Timeout:         d.Timeout,
+			SessionTimeout:  d.SessionTimeout,
+			PublishInterval: d.PublishInterval,
+			SessionName:     fmt.Sprintf("%s/%s", cfg.ID, lane),
+		})
+	}
+}
+
+// ModbusDriver connects machines with Protocol modbus to IP:Port, the port
+// defaults to 502. With Gateway set requests go to the gateway instead and
+// Unit selects the device behind it. Symbols are read and written through
+// the machine's Registers.
+type ModbusDriver struct {
+	Unit    uint8
+	Timeout time.Duration
+	MaxGap  int
+}
+
+func (d ModbusDriver) Dialer(cfg MachineConfig, pool PoolConfig) func(lane Lane) (ADSClient, error) {
+	host := cfg.Gateway
+	if host == "" {
+		host = cfg.IP
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = ModbusPort
+	}
+	address := net.JoinHostPort(host, fmt.Sprint(port))
+
+	return func(lane Lane) (ADSClient, error) {
+		return NewModbusClient(ModbusConfig{
+			Address:   address,
+			Unit:      d.Unit,
+			Timeout:   d.Timeout,
+			MaxGap:    d.MaxGap,
+			Registers: cfg.Registers,
+		})
+	}
+}
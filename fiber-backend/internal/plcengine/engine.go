@@ -2,7 +2,10 @@ package plcengine
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"strings"
 	"sync"
 	"time"
 )
@@ -17,6 +20,11 @@ type Engine interface {
 
 	WriteSymbol(machineID, symbol string, value interface{}) error
 	WriteAsync(req WriteRequest) <-chan WriteResponse
+	WriteBatch(req BatchWriteRequest) <-chan BatchWriteResponse
+
+	AddMachine(cfg MachineConfig) error
+	RemoveMachine(machineID string) error
+	UpdateMachine(cfg MachineConfig) error
 
 	GetStatus() map[string]ConnectionStatus
 }
@@ -25,30 +33,98 @@ type Engine interface {
 type MachineConfig struct {
 	ID       string
 	IP       string
-	AmsNetID string
-	Port     int
+	AmsNetID string // defaults to IP + ".1.1"
+	Port     int    // ADS port, or the OPC UA (default 4840) or Modbus (default 502) server port
+
+	// Protocol selects the driver, empty means ADS
+	Protocol Protocol
+
+	// Gateway is the AMS router of a controller without its own TCP
+	// endpoint, requests are routed by AmsNetID from there. Defaults to IP.
+	Gateway string
+
+	// Symbols is the write allowlist with type and limits, see WriteGuard.
+	// TypeName (e.g. "REAL") sets the type until the PLC symbol table is known.
+	Symbols []SymbolInfo
+
+	// Registers maps symbol names to Modbus registers, used with ProtocolModbus
+	Registers map[string]ModbusRegister
 }
 
 type PLCReadWriteEngine struct {
 	connections map[string]*PLCConnection
+	configs     map[string]MachineConfig
 	writer      *PrioritizedWriter
 	mu          sync.RWMutex
 
+	// Write allowlist per machine, keyed by lower-case symbol name
+	writable map[string]map[string]SymbolInfo
+	guards   []WriteGuard
+	recorder WriteRecorder
+
+	// Operator substitutes per machine, keyed by lower-case symbol name
+	substitutes map[string]map[string]interface{}
+
 	dataChan     chan PLCValue
 	writeConfirm chan WriteResponse
+	connEvents   chan ConnectionEvent
 	stopChan     chan struct{}
+	stopOnce     sync.Once
 	wg           sync.WaitGroup
 
-	// Dependency injection for client creation
+	// Subscribers of dataChan and writeConfirm, see Subscribe
+	values *fanout[PLCValue]
+	writes *fanout[WriteResponse]
+
+	// Dependency injection for client creation, overrides Drivers
 	ClientFactory func(ip, amsID string, port int) (ADSClient, error)
+
+	// Drivers per protocol, ADS, OPC UA and Modbus are registered by NewEngine
+	Drivers map[Protocol]Driver
+
+	// ReconnectPolicy applies to machines added after it is set
+	ReconnectPolicy ReconnectPolicy
+
+	// VerifyPolicy applies to writes with RequireAck
+	VerifyPolicy VerifyPolicy
+
+	// WriterConfig selects the write scheduling, it is applied on Start
+	WriterConfig WriterConfig
+
+	// PoolConfig sizes the request lanes of machines added after it is set
+	PoolConfig PoolConfig
+
+	// SourceNetID is the local AMS Net ID, by default derived from the local
+	// IP address. With Route set the engine registers it on every router
+	// before connecting.
+	SourceNetID string
+	Route       *RouteConfig
 }
 
+// NewEngine creates an engine that delivers notification and batch reader
+// values on dataChan, a channel of 10000 values is created when it is nil.
+// Consumers read them through Subscribe.
 func NewEngine(dataChan chan PLCValue) *PLCReadWriteEngine {
+	if dataChan == nil {
+		dataChan = make(chan PLCValue, 10000)
+	}
 	e := &PLCReadWriteEngine{
 		connections:  make(map[string]*PLCConnection),
+		configs:      make(map[string]MachineConfig),
+		writable:     make(map[string]map[string]SymbolInfo),
+		substitutes:  make(map[string]map[string]interface{}),
+		guards:       DefaultWriteGuards(),
 		dataChan:     dataChan,
 		writeConfirm: make(chan WriteResponse, 100),
+		connEvents:   make(chan ConnectionEvent, 100),
 		stopChan:     make(chan struct{}),
+		values:       newFanout[PLCValue]("values"),
+		writes:       newFanout[WriteResponse]("writes"),
+	}
+	e.Drivers = map[Protocol]Driver{
+		ProtocolADS:    DriverFunc(e.amsClientFactory),
+		ProtocolOPCUA:  OPCUADriver{},
+		ProtocolModbus: ModbusDriver{},
 	}
 	e.writer = NewPrioritizedWriter(e)
 	return e
@@ -59,35 +135,214 @@ func (e *PLCReadWriteEngine) Start(configs []MachineConfig) error {
 	defer e.mu.Unlock()
 
 	e.writer.Start()
+	e.wg.Add(1)
+	go e.dispatch()
 
 	for _, cfg := range configs {
-		conn := NewPLCConnection(cfg.ID, cfg.IP, cfg.AmsNetID, cfg.Port)
-		e.connections[cfg.ID] = conn
-
-		conn.Start(context.Background(), func() (ADSClient, error) {
-			if e.ClientFactory != nil {
-				return e.ClientFactory(cfg.IP, cfg.AmsNetID, cfg.Port)
-			}
-			return NewMockADSClient(cfg.ID), nil // Default to mock for dev
-		})
+		if err := e.addMachineLocked(cfg); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// Stop fails the queued writes, waits for the executing ones and closes the
+// connections and streams
 func (e *PLCReadWriteEngine) Stop() error {
 	e.mu.Lock()
-	defer e.mu.Unlock()
-
 	e.writer.Stop()
-
 	for _, conn := range e.connections {
 		conn.Stop()
 	}
+	e.mu.Unlock()
+
+	// Executing writes fail fast on the stopped connections and need e.mu
+	e.writer.wait()
 
+	// The dispatcher looks up symbol configurations, e.mu must be free
+	e.stopOnce.Do(func() { close(e.stopChan) })
+	e.wg.Wait()
+	e.values.close()
+	e.writes.close()
 	return nil
 }
 
+// AddMachine connects to a machine while the engine is running
+func (e *PLCReadWriteEngine) AddMachine(cfg MachineConfig) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.addMachineLocked(cfg)
+}
+
+// RemoveMachine closes the connection to a machine. Pending reads and writes
+// on it fail with ErrConnectionStopped and its subscriptions stop delivering.
+func (e *PLCReadWriteEngine) RemoveMachine(machineID string) error {
+	e.mu.Lock()
+	conn, ok := e.connections[machineID]
+	if !ok {
+		e.mu.Unlock()
+		return fmt.Errorf("machine %s not found", machineID)
+	}
+	delete(e.connections, machineID)
+	delete(e.configs, machineID)
+	delete(e.writable, machineID)
+	delete(e.substitutes, machineID)
+	e.mu.Unlock()
+
+	e.writer.RemoveMachine(machineID)
+	conn.Stop()
+	return nil
+}
+
+// UpdateMachine applies a changed configuration. The connection is only
+// replaced if the address changed, a new allowlist takes effect in place.
+// A configuration the engine cannot connect with leaves the machine as it is.
+func (e *PLCReadWriteEngine) UpdateMachine(cfg MachineConfig) error {
+	e.mu.Lock()
+	old, ok := e.configs[cfg.ID]
+	if !ok {
+		e.mu.Unlock()
+		return fmt.Errorf("machine %s not found", cfg.ID)
+	}
+	if _, err := e.driverLocked(cfg); err != nil {
+		e.mu.Unlock()
+		return err
+	}
+
+	if old.IP == cfg.IP && old.AmsNetID == cfg.AmsNetID && old.Port == cfg.Port && old.Gateway == cfg.Gateway && old.Protocol == cfg.Protocol &&
+		reflect.DeepEqual(old.Registers, cfg.Registers) {
+		e.configs[cfg.ID] = cfg
+		e.writable[cfg.ID] = allowlist(cfg.Symbols)
+		e.mu.Unlock()
+		return nil
+	}
+
+	conn := e.connections[cfg.ID]
+	delete(e.connections, cfg.ID)
+	if err := e.addMachineLocked(cfg); err != nil {
+		e.connections[cfg.ID] = conn
+		e.mu.Unlock()
+		return err
+	}
+	e.mu.Unlock()
+
+	conn.Stop()
+	return nil
+}
+
+// Machines returns the configuration of all connected machines
+func (e *PLCReadWriteEngine) Machines() []MachineConfig {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	out := make([]MachineConfig, 0, len(e.configs))
+	for _, cfg := range e.configs {
+		out = append(out, cfg)
+	}
+	return out
+}
+
+// CheckMachine reports whether the engine can connect with cfg, without
+// changing anything
+func (e *PLCReadWriteEngine) CheckMachine(cfg MachineConfig) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	_, err := e.driverLocked(cfg)
+	return err
+}
+
+// driverLocked returns the driver of the machine's protocol, nil with a
+// ClientFactory. e.mu must be held.
+func (e *PLCReadWriteEngine) driverLocked(cfg MachineConfig) (Driver, error) {
+	protocol := cfg.Protocol
+	if protocol == "" {
+		protocol = ProtocolADS
+	}
+	driver, ok := e.Drivers[protocol]
+	if !ok && e.ClientFactory == nil {
+		return nil, fmt.Errorf("machine %s: unsupported protocol %q", cfg.ID, cfg.Protocol)
+	}
+	return driver, nil
+}
+
+// addMachineLocked creates and starts the connection of a machine. e.mu must be held.
+func (e *PLCReadWriteEngine) addMachineLocked(cfg MachineConfig) error {
+	if _, exists := e.connections[cfg.ID]; exists {
+		return fmt.Errorf("machine %s already exists", cfg.ID)
+	}
+	driver, err := e.driverLocked(cfg)
+	if err != nil {
+		return err
+	}
+
+	conn := NewPLCConnection(cfg.ID, cfg.IP, cfg.AmsNetID, cfg.Port)
+	conn.policy = e.ReconnectPolicy.withDefaults()
+	conn.onEvent = e.publishConnectionEvent
+	conn.setPool(e.PoolConfig)
+	e.connections[cfg.ID] = conn
+	e.configs[cfg.ID] = cfg
+	e.writable[cfg.ID] = allowlist(cfg.Symbols)
+
+	factory := e.ClientFactory
+	if factory != nil {
+		conn.Start(context.Background(), func(Lane) (ADSClient, error) {
+			return factory(cfg.IP, cfg.AmsNetID, cfg.Port)
+		})
+		return nil
+	}
+	conn.Start(context.Background(), driver.Dialer(cfg, conn.pool))
+	return nil
+}
+
+// amsClientFactory connects the lanes of a machine through its AMS router.
+// Every lane connection of a pool uses its own source AMS Net ID, which gets
+// a route of its own.
+func (e *PLCReadWriteEngine) amsClientFactory(cfg MachineConfig, pool PoolConfig) func(Lane) (ADSClient, error) {
+	address := cfg.Gateway
+	if address == "" {
+		address = cfg.IP
+	}
+	target := cfg.AmsNetID
+	if target == "" {
+		target = cfg.IP + ".1.1"
+	}
+	sourceID, route := e.SourceNetID, e.Route
+
+	// Only used by the connection handler goroutine
+	routed := make(map[AmsNetID]bool)
+
+	return func(lane Lane) (ADSClient, error) {
+		var source AmsNetID
+		var err error
+		if sourceID != "" {
+			source, err = ParseAmsNetID(sourceID)
+		} else {
+			source, err = localNetID(address)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if pool.ConnectionPerLane {
+			source = laneNetID(source, lane)
+		}
+
+		if route != nil && !routed[source] {
+			if err := AddRoute(context.Background(), address, source, *route); err != nil {
+				return nil, err
+			}
+			routed[source] = true
+		}
+
+		return NewAMSClient(AMSConfig{
+			Address:     address,
+			TargetNetID: target,
+			TargetPort:  cfg.Port,
+			SourceNetID: source.String(),
+		})
+	}
+}
+
 func (e *PLCReadWriteEngine) getConnection(machineID string) (*PLCConnection, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
@@ -100,31 +355,45 @@ func (e *PLCReadWriteEngine) getConnection(machineID string) (*PLCConnection, er
 }
 
 func (e *PLCReadWriteEngine) ReadSymbol(machineID, symbol string) (*PLCValue, error) {
+	return e.ReadSymbolCtx(context.Background(), machineID, symbol)
+}
+
+func (e *PLCReadWriteEngine) ReadSymbols(machineID string, symbols []string) (map[string]*PLCValue, error) {
+	return e.ReadSymbolsCtx(context.Background(), machineID, symbols)
+}
+
+// ReadSymbolCtx is ReadSymbol bounded by ctx
+func (e *PLCReadWriteEngine) ReadSymbolCtx(ctx context.Context, machineID, symbol string) (*PLCValue, error) {
 	conn, err := e.getConnection(machineID)
 	if err != nil {
 		return nil, err
 	}
 
-	val, err := conn.ReadSymbol(symbol)
+	val, err := conn.ReadSymbolCtx(ctx, symbol)
 	if err != nil {
 		return nil, err
 	}
 
-	return &PLCValue{
+	v := &PLCValue{
 		Symbol:    symbol,
 		Value:     val,
+		Type:      conn.valueType(symbol, val),
+		Quality:   QualityGood,
 		Timestamp: time.Now(),
 		Source:    machineID,
-	}, nil
+	}
+	e.qualify(v)
+	return v, nil
 }
 
-func (e *PLCReadWriteEngine) ReadSymbols(machineID string, symbols []string) (map[string]*PLCValue, error) {
+// ReadSymbolsCtx is ReadSymbols bounded by ctx
+func (e *PLCReadWriteEngine) ReadSymbolsCtx(ctx context.Context, machineID string, symbols []string) (map[string]*PLCValue, error) {
 	conn, err := e.getConnection(machineID)
 	if err != nil {
 		return nil, err
 	}
 
-	rawValues, err := conn.ReadSymbols(symbols)
+	rawValues, err := conn.ReadSymbolsCtx(ctx, symbols)
 	if err != nil {
 		return nil, err
 	}
@@ -135,20 +404,230 @@ func (e *PLCReadWriteEngine) ReadSymbols(machineID string, symbols []string) (ma
 		results[sym] = &PLCValue{
 			Symbol:    sym,
 			Value:     val,
+			Type:      conn.valueType(sym, val),
+			Quality:   QualityGood,
 			Timestamp: now,
 			Source:    machineID,
 		}
+		e.qualify(results[sym])
+	}
+	// Substitutes stand in for symbols the PLC failed to read
+	for _, sym := range symbols {
+		if _, ok := results[sym]; !ok {
+			v := &PLCValue{Symbol: sym, Timestamp: now, Source: machineID}
+			e.qualify(v)
+			if v.Quality == QualitySubstituted {
+				results[sym] = v
+			}
+		}
 	}
 	return results, nil
 }
 
+// SetSubstitute makes reads of a symbol return value with QualitySubstituted
+// instead of the PLC value, e.g. while a sensor is broken. It lasts until
+// ClearSubstitute or until the machine is removed.
+func (e *PLCReadWriteEngine) SetSubstitute(machineID, symbol string, value interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.connections[machineID]; !ok {
+		return fmt.Errorf("machine %s not found", machineID)
+	}
+	if e.substitutes[machineID] == nil {
+		e.substitutes[machineID] = make(map[string]interface{})
+	}
+	e.substitutes[machineID][strings.ToLower(symbol)] = value
+	return nil
+}
+
+// ClearSubstitute returns to the PLC value of a symbol
+func (e *PLCReadWriteEngine) ClearSubstitute(machineID, symbol string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.substitutes[machineID], strings.ToLower(symbol))
+}
+
+// qualify applies a substitute to a value read from the PLC, or rates it
+// against the configured symbol type and range
+func (e *PLCReadWriteEngine) qualify(v *PLCValue) {
+	key := strings.ToLower(v.Symbol)
+	e.mu.RLock()
+	sub, substituted := e.substitutes[v.Source][key]
+	cfg, configured := e.writable[v.Source][key]
+	e.mu.RUnlock()
+
+	if substituted {
+		v.Value = sub
+		v.Quality, v.QualityReason = QualitySubstituted, ReasonSubstituted
+		return
+	}
+	if v.Quality == "" {
+		v.Quality = QualityGood
+	}
+	if configured {
+		AssessQuality(v, cfg)
+	}
+}
+
+// GetSymbolInfo returns the metadata of a symbol from the uploaded symbol table
+func (e *PLCReadWriteEngine) GetSymbolInfo(machineID, symbol string) (*SymbolInfo, error) {
+	conn, err := e.getConnection(machineID)
+	if err != nil {
+		return nil, err
+	}
+
+	reg := conn.Symbols()
+	if reg == nil {
+		return nil, fmt.Errorf("no symbol table for machine %s", machineID)
+	}
+	info, ok := reg.Symbol(symbol)
+	if !ok {
+		return nil, fmt.Errorf("symbol %s not found on machine %s", symbol, machineID)
+	}
+	return info, nil
+}
+
+// Browse lists the child nodes of node on a machine whose driver supports
+// browsing, such as OPC UA. An empty node lists the top level.
+func (e *PLCReadWriteEngine) Browse(machineID, node string) ([]BrowseNode, error) {
+	return e.BrowseCtx(context.Background(), machineID, node)
+}
+
+// BrowseCtx is Browse bounded by ctx
+func (e *PLCReadWriteEngine) BrowseCtx(ctx context.Context, machineID, node string) ([]BrowseNode, error) {
+	conn, err := e.getConnection(machineID)
+	if err != nil {
+		return nil, err
+	}
+	return conn.BrowseCtx(ctx, node)
+}
+
+// ReadStruct reads a struct or array symbol into out, which must be a pointer.
+// Struct fields are matched by name like encoding/json does.
+func (e *PLCReadWriteEngine) ReadStruct(machineID, symbol string, out interface{}) error {
+	val, err := e.ReadSymbol(machineID, symbol)
+	if err != nil {
+		return err
+	}
+	if _, raw := val.Value.([]byte); raw {
+		return fmt.Errorf("symbol %s has no type information", symbol)
+	}
+
+	b, err := json.Marshal(val.Value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
 func (e *PLCReadWriteEngine) WriteSymbol(machineID, symbol string, value interface{}) error {
+	return e.WriteSymbolCtx(context.Background(), machineID, symbol, value)
+}
+
+// WriteSymbolCtx is WriteSymbol bounded by ctx. It bypasses the write guards
+// like WriteSymbol does.
+func (e *PLCReadWriteEngine) WriteSymbolCtx(ctx context.Context, machineID, symbol string, value interface{}) error {
 	conn, err := e.getConnection(machineID)
 	if err != nil {
 		return err
 	}
 
-	return conn.WriteSymbol(symbol, value)
+	return conn.WriteSymbolCtx(ctx, symbol, value)
+}
+
+// AddWriteGuard appends a guard to the write validation chain
+func (e *PLCReadWriteEngine) AddWriteGuard(g WriteGuard) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.guards = append(e.guards, g)
+}
+
+// SetWriteGuards replaces the write validation chain
+func (e *PLCReadWriteEngine) SetWriteGuards(guards ...WriteGuard) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.guards = guards
+}
+
+// SetWriteRecorder sets where write attempts are journaled. With a recorder
+// set, writes also read the previous and the written value back.
+func (e *PLCReadWriteEngine) SetWriteRecorder(r WriteRecorder) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.recorder = r
+}
+
+func (e *PLCReadWriteEngine) writeRecorder() WriteRecorder {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.recorder
+}
+
+// CheckWrite runs the write guards on req without writing. It returns the
+// request with the coerced value, or the rejection of the first failing guard.
+func (e *PLCReadWriteEngine) CheckWrite(req WriteRequest) (WriteRequest, *WriteRejection) {
+	e.mu.RLock()
+	guards := e.guards
+	e.mu.RUnlock()
+
+	info := e.writeInfo(req.MachineID, req.Symbol)
+	for _, g := range guards {
+		if rej := g.Check(&req, info); rej != nil {
+			return req, rej
+		}
+	}
+	return req, nil
+}
+
+// writeInfo merges the allowlist entry of a symbol with the uploaded PLC
+// symbol table. It returns nil for symbols that are not allowlisted.
+func (e *PLCReadWriteEngine) writeInfo(machineID, symbol string) *SymbolInfo {
+	e.mu.RLock()
+	cfg, ok := e.writable[machineID][strings.ToLower(symbol)]
+	conn := e.connections[machineID]
+	e.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	info := cfg
+	info.Type = ParsePLCType(cfg.TypeName)
+	if conn != nil {
+		if reg := conn.Symbols(); reg != nil {
+			if plc, found := reg.Symbol(symbol); found {
+				info.Type = plc.Type
+				info.TypeName = plc.TypeName
+				info.Size = plc.Size
+				info.IsWritable = info.IsWritable && plc.IsWritable
+			}
+		}
+	}
+	return &info
+}
+
+func allowlist(symbols []SymbolInfo) map[string]SymbolInfo {
+	m := make(map[string]SymbolInfo, len(symbols))
+	for _, s := range symbols {
+		m[strings.ToLower(s.Name)] = s
+	}
+	return m
+}
+
+// AddSubscription registers ADS device notifications for symbols on a machine.
+// Pushed values are delivered on the engine data channel until the returned
+// subscription is stopped.
+func (e *PLCReadWriteEngine) AddSubscription(machineID string, symbols []SubscriptionSymbol) (*Subscription, error) {
+	conn, err := e.getConnection(machineID)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := NewSubscription(conn, symbols, e.dataChan)
+	if err := sub.Start(); err != nil {
+		sub.Stop()
+		return nil, err
+	}
+	return sub, nil
 }
 
 func (e *PLCReadWriteEngine) GetStatus() map[string]ConnectionStatus {
@@ -157,9 +636,7 @@ func (e *PLCReadWriteEngine) GetStatus() map[string]ConnectionStatus {
 
 	status := make(map[string]ConnectionStatus)
 	for id, conn := range e.connections {
-		conn.mu.RLock()
-		status[id] = conn.stats
-		conn.mu.RUnlock()
+		status[id] = conn.Status()
 	}
 	return status
 }
@@ -179,6 +656,100 @@ func (e *PLCReadWriteEngine) WriteAsync(req WriteRequest) <-chan WriteResponse {
 	return respChan
 }
 
+// WriteBatch queues an all-or-nothing write of several symbols of one
+// machine, see BatchWriteRequest
+func (e *PLCReadWriteEngine) WriteBatch(req BatchWriteRequest) <-chan BatchWriteResponse {
+	b := &batchWrite{req: req, resp: make(chan BatchWriteResponse, 1)}
+
+	err := validateBatch(req)
+	if err == nil {
+		err = e.writer.Submit(WriteRequest{
+			ID:        req.ID,
+			MachineID: req.MachineID,
+			UserID:    req.UserID,
+			Priority:  req.Priority,
+			Timeout:   req.Timeout,
+			batch:     b,
+		})
+	}
+	if err != nil {
+		b.failed(err)
+	}
+	return b.resp
+}
+
+// WriteQueueStats returns the write queue metrics per priority level
+func (e *PLCReadWriteEngine) WriteQueueStats() []WriteQueueStats {
+	return e.writer.Stats()
+}
+
+// SubmitWrite queues a write without a response channel. The result is only
+// published to SubscribeWrites.
+func (e *PLCReadWriteEngine) SubmitWrite(req WriteRequest) error {
+	req.ResponseChan = nil
+	return e.writer.Submit(req)
+}
+
+// Subscribe returns a stream of the values matching filter, from device
+// notifications and batch readers of all machines. Every subscriber has its
+// own buffer; when it is full values are dropped according to filter.Policy
+// without holding up the engine or other subscribers. The channel is closed
+// by Unsubscribe or Stop.
+func (e *PLCReadWriteEngine) Subscribe(filter ValueFilter) <-chan PLCValue {
+	return e.values.subscribe(filter.StreamOptions, filter.match())
+}
+
+// Unsubscribe ends a Subscribe stream and closes its channel
+func (e *PLCReadWriteEngine) Unsubscribe(ch <-chan PLCValue) {
+	e.values.unsubscribe(ch)
+}
+
+// SubscribeWrites returns a stream of the results of executed writes matching
+// filter, including writes rejected by a guard. Buffering and closing work as
+// for Subscribe.
+func (e *PLCReadWriteEngine) SubscribeWrites(filter WriteFilter) <-chan WriteResponse {
+	return e.writes.subscribe(filter.StreamOptions, filter.match())
+}
+
+// UnsubscribeWrites ends a SubscribeWrites stream and closes its channel
+func (e *PLCReadWriteEngine) UnsubscribeWrites(ch <-chan WriteResponse) {
+	e.writes.unsubscribe(ch)
+}
+
+// StreamStats returns the buffer and drop counters of all subscribers
+func (e *PLCReadWriteEngine) StreamStats() []StreamStats {
+	return append(e.values.stats(), e.writes.stats()...)
+}
+
+// dispatch drains dataChan and writeConfirm into the subscribers until Stop
+func (e *PLCReadWriteEngine) dispatch() {
+	defer e.wg.Done()
+	for {
+		select {
+		case v := <-e.dataChan:
+			e.qualify(&v)
+			e.values.publish(v)
+		case resp := <-e.writeConfirm:
+			e.writes.publish(resp)
+		case <-e.stopChan:
+			return
+		}
+	}
+}
+
+// ConnectionEvents streams connection state and circuit breaker changes of
+// all machines. Events are dropped while nobody drains the channel.
+func (e *PLCReadWriteEngine) ConnectionEvents() <-chan ConnectionEvent {
+	return e.connEvents
+}
+
+func (e *PLCReadWriteEngine) publishConnectionEvent(ev ConnectionEvent) {
+	select {
+	case e.connEvents <- ev:
+	default:
+	}
+}
+
 // Mock implementation for development
 
 type MockADSClient struct {
@@ -0,0 +1,70 @@
+package plcengine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_HotAddUpdateRemove(t *testing.T) {
+	r1 := newTestRouter(t)
+	r2 := newTestRouter(t)
+	r2.setValue("GVL.Temperature", real32(80))
+
+	e := NewEngine(make(chan PLCValue, 10))
+	require.NoError(t, e.Start(nil))
+	defer e.Stop()
+
+	cfg := MachineConfig{ID: "m1", IP: r1.Addr(), AmsNetID: r1.NetID(), Port: 851}
+	require.NoError(t, e.AddMachine(cfg))
+	assert.Error(t, e.AddMachine(cfg))
+
+	require.Eventually(t, func() bool { return e.GetStatus()["m1"].Connected }, time.Second, 10*time.Millisecond)
+	val, err := e.ReadSymbol("m1", "GVL.Temperature")
+	require.NoError(t, err)
+	assert.Equal(t, float32(21.5), val.Value)
+
+	// A new allowlist applies without reconnecting
+	resp := writeAndWait(t, e, "GVL.Step", 7)
+	require.NotNil(t, resp.Rejection)
+	assert.Equal(t, RejectNotAllowed, resp.Rejection.Reason)
+
+	cfg.Symbols = []SymbolInfo{{Name: "GVL.Step", IsWritable: true}}
+	require.NoError(t, e.UpdateMachine(cfg))
+	resp = writeAndWait(t, e, "GVL.Step", 7)
+	assert.True(t, resp.Success, resp.Error)
+	assert.Equal(t, int16Bytes(7), r1.value("GVL.Step"))
+	assert.Equal(t, 1, e.GetStatus()["m1"].ReconnectCount)
+
+	// A new address replaces the connection
+	old, err := e.getConnection("m1")
+	require.NoError(t, err)
+	cfg.IP = r2.Addr()
+	require.NoError(t, e.UpdateMachine(cfg))
+
+	_, err = old.ReadSymbol("GVL.Temperature")
+	assert.ErrorIs(t, err, ErrConnectionStopped)
+	require.Eventually(t, func() bool {
+		val, err := e.ReadSymbol("m1", "GVL.Temperature")
+		return err == nil && val.Value == float32(80)
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []MachineConfig{cfg}, e.Machines())
+
+	// An unsupported protocol keeps the machine connected as it was
+	bad := cfg
+	bad.Protocol = "profinet"
+	assert.EqualError(t, e.CheckMachine(bad), `machine m1: unsupported protocol "profinet"`)
+	assert.Error(t, e.UpdateMachine(bad))
+	assert.Equal(t, []MachineConfig{cfg}, e.Machines())
+	_, err = e.ReadSymbol("m1", "GVL.Temperature")
+	require.NoError(t, err)
+
+	require.NoError(t, e.RemoveMachine("m1"))
+	_, err = e.ReadSymbol("m1", "GVL.Temperature")
+	assert.Error(t, err)
+	assert.Empty(t, e.GetStatus())
+	assert.Error(t, e.RemoveMachine("m1"))
+	assert.Error(t, e.UpdateMachine(cfg))
+}
@@ -0,0 +1,552 @@
+package plcengine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSymbol is a PLC variable served by fakeRouter
+type fakeSymbol struct {
+	name      string
+	typeName  string
+	dataType  uint32
+	flags     uint32
+	data      []byte
+	handle    uint32
+	denyWrite bool
+}
+
+// fakeConn is an accepted client connection
+type fakeConn struct {
+	net.Conn
+	mu   sync.Mutex
+	peer amsHeader // header of the last request, used to address notifications
+}
+
+func (c *fakeConn) send(h amsHeader, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.Write(encodeAMSFrame(h, payload))
+	return err
+}
+
+// fakeNotification is a device notification registered by a client
+type fakeNotification struct {
+	conn   *fakeConn
+	sym    *fakeSymbol
+	mode   uint32
+	cycle  time.Duration
+	handle uint32
+	stop   chan struct{}
+}
+
+// fakeRouter is an in-process AMS router and TwinCAT runtime. It understands
+// enough of the ADS protocol to exercise AMSClient without hardware.
+type fakeRouter struct {
+	t  *testing.T
+	ln net.Listener
+
+	mu            sync.Mutex
+	symbols       map[string]*fakeSymbol
+	handles       map[uint32]*fakeSymbol
+	nextHandle    uint32
+	conns         []*fakeConn
+	calls         map[uint32]int // requests per index group
+	notifications map[uint32]*fakeNotification
+	nextNotify    uint32
+	types         []DataTypeInfo // served by the data type upload
+}
+
+func newFakeRouter(t *testing.T) *fakeRouter {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	r := &fakeRouter{
+		t:       t,
+		ln:      ln,
+		symbols: make(map[string]*fakeSymbol),
+		handles: make(map[uint32]*fakeSymbol),
+		calls:   make(map[uint32]int),
+
+		notifications: make(map[uint32]*fakeNotification),
+	}
+	go r.accept()
+	t.Cleanup(r.close)
+	return r
+}
+
+func (r *fakeRouter) Addr() string { return r.ln.Addr().String() }
+
+func (r *fakeRouter) NetID() string { return "127.0.0.1.1.1" }
+
+func (r *fakeRouter) client(t *testing.T) *AMSClient {
+	t.Helper()
+	c, err := NewAMSClient(AMSConfig{Address: r.Addr(), TargetNetID: r.NetID(), TargetPort: 851})
+	if err != nil {
+		t.Fatalf("dial fake router: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func (r *fakeRouter) addSymbol(name, typeName string, dataType uint32, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.symbols[strings.ToLower(name)] = &fakeSymbol{name: name, typeName: typeName, dataType: dataType, data: data}
+}
+
+func (r *fakeRouter) addDataType(t DataTypeInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types = append(r.types, t)
+}
+
+// denyWrite makes writes to a symbol fail with an access error
+func (r *fakeRouter) denyWrite(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.symbols[strings.ToLower(name)].denyWrite = true
+}
+
+func (r *fakeRouter) setReadOnly(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.symbols[strings.ToLower(name)].flags |= adsSymbolFlagReadOnly
+}
+
+func (r *fakeRouter) value(name string) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]byte(nil), r.symbols[strings.ToLower(name)].data...)
+}
+
+func (r *fakeRouter) setValue(name string, data []byte) {
+	r.mu.Lock()
+	sym := r.symbols[strings.ToLower(name)]
+	copy(sym.data, data)
+	r.mu.Unlock()
+	r.notifyChange(sym)
+}
+
+func (r *fakeRouter) notificationCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.notifications)
+}
+
+func (r *fakeRouter) callCount(group uint32) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls[group]
+}
+
+// onlineChange invalidates all handles like a PLC program download does
+func (r *fakeRouter) onlineChange() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handles = make(map[uint32]*fakeSymbol)
+}
+
+// dropConnections closes every accepted client connection together with
+// its notifications
+func (r *fakeRouter) dropConnections() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.conns {
+		c.Close()
+	}
+	r.conns = nil
+	for h, n := range r.notifications {
+		close(n.stop)
+		delete(r.notifications, h)
+	}
+}
+
+func (r *fakeRouter) close() {
+	r.ln.Close()
+	r.dropConnections()
+}
+
+func (r *fakeRouter) accept() {
+	for {
+		conn, err := r.ln.Accept()
+		if err != nil {
+			return
+		}
+		fc := &fakeConn{Conn: conn}
+		r.mu.Lock()
+		r.conns = append(r.conns, fc)
+		r.mu.Unlock()
+		go r.serve(fc)
+	}
+}
+
+func (r *fakeRouter) serve(conn *fakeConn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for {
+		pkt, err := readAMSPacket(br)
+		if err != nil {
+			return
+		}
+
+		h := pkt.header
+		h.TargetNetID, h.SourceNetID = pkt.header.SourceNetID, pkt.header.TargetNetID
+		h.TargetPort, h.SourcePort = pkt.header.SourcePort, pkt.header.TargetPort
+		h.StateFlags = amsStateResponse
+
+		conn.mu.Lock()
+		conn.peer = h
+		conn.mu.Unlock()
+
+		var payload []byte
+		var after func()
+		if pkt.header.CommandID == adsCmdAddNotification {
+			payload, after = r.addNotification(conn, pkt.data)
+		} else {
+			payload = r.handle(pkt.header.CommandID, pkt.data)
+		}
+
+		if err := conn.send(h, payload); err != nil {
+			return
+		}
+		if after != nil {
+			after()
+		}
+	}
+}
+
+func (r *fakeRouter) addNotification(conn *fakeConn, req []byte) ([]byte, func()) {
+	group := binary.LittleEndian.Uint32(req[0:])
+	offset := binary.LittleEndian.Uint32(req[4:])
+	mode := binary.LittleEndian.Uint32(req[12:])
+	cycle := time.Duration(binary.LittleEndian.Uint32(req[20:])) * 100
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sym, ok := r.handles[offset]
+	if group != ADSIGrpSymValueByHnd || !ok {
+		return codeResponse(ADSErrSymbolNotFound), nil
+	}
+	if mode != adsTransServerCycle && mode != adsTransServerOnChange {
+		return codeResponse(ADSErrTransModeNotSupp), nil
+	}
+
+	r.nextNotify++
+	n := &fakeNotification{conn: conn, sym: sym, mode: mode, cycle: cycle, handle: r.nextNotify, stop: make(chan struct{})}
+	r.notifications[n.handle] = n
+
+	resp := make([]byte, 8)
+	binary.LittleEndian.PutUint32(resp[4:], n.handle)
+
+	// Like TwinCAT, push the current value right after registration
+	return resp, func() {
+		r.sendSample(n)
+		if mode == adsTransServerCycle {
+			go r.cyclic(n)
+		}
+	}
+}
+
+func (r *fakeRouter) cyclic(n *fakeNotification) {
+	ticker := time.NewTicker(max(n.cycle, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+			r.sendSample(n)
+		}
+	}
+}
+
+func (r *fakeRouter) notifyChange(sym *fakeSymbol) {
+	r.mu.Lock()
+	var targets []*fakeNotification
+	for _, n := range r.notifications {
+		if n.sym == sym && n.mode == adsTransServerOnChange {
+			targets = append(targets, n)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, n := range targets {
+		r.sendSample(n)
+	}
+}
+
+func (r *fakeRouter) sendSample(n *fakeNotification) {
+	r.mu.Lock()
+	data := append([]byte(nil), n.sym.data...)
+	r.mu.Unlock()
+
+	b := make([]byte, 8+12+8+len(data))
+	binary.LittleEndian.PutUint32(b[0:], uint32(len(b)-4))
+	binary.LittleEndian.PutUint32(b[4:], 1)
+	binary.LittleEndian.PutUint64(b[8:], uint64(time.Now().UnixNano()/100)+filetimeEpochDiff)
+	binary.LittleEndian.PutUint32(b[16:], 1)
+	binary.LittleEndian.PutUint32(b[20:], n.handle)
+	binary.LittleEndian.PutUint32(b[24:], uint32(len(data)))
+	copy(b[28:], data)
+
+	n.conn.mu.Lock()
+	h := n.conn.peer
+	n.conn.mu.Unlock()
+	h.CommandID = adsCmdNotification
+	h.StateFlags = amsStateRequest
+	h.InvokeID = 0
+	_ = n.conn.send(h, b)
+}
+
+func (r *fakeRouter) handle(cmd uint16, req []byte) []byte {
+	switch cmd {
+	case adsCmdReadState:
+		resp := make([]byte, 8)
+		binary.LittleEndian.PutUint16(resp[4:], 5) // RUN
+		return resp
+	case adsCmdRead:
+		group := binary.LittleEndian.Uint32(req[0:])
+		offset := binary.LittleEndian.Uint32(req[4:])
+		length := binary.LittleEndian.Uint32(req[8:])
+		data, code := r.read(group, offset, length)
+		return dataResponse(code, data)
+	case adsCmdWrite:
+		group := binary.LittleEndian.Uint32(req[0:])
+		offset := binary.LittleEndian.Uint32(req[4:])
+		length := binary.LittleEndian.Uint32(req[8:])
+		code := r.write(group, offset, req[12:12+length])
+		if code == ADSErrNoError && group == ADSIGrpSymValueByHnd {
+			r.mu.Lock()
+			sym := r.handles[offset]
+			r.mu.Unlock()
+			r.notifyChange(sym)
+		}
+		return codeResponse(code)
+	case adsCmdDeleteNotification:
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		n, ok := r.notifications[binary.LittleEndian.Uint32(req)]
+		if !ok {
+			return codeResponse(ADSErrInvalidNotifyHandle)
+		}
+		close(n.stop)
+		delete(r.notifications, n.handle)
+		return codeResponse(ADSErrNoError)
+	case adsCmdReadWrite:
+		group := binary.LittleEndian.Uint32(req[0:])
+		offset := binary.LittleEndian.Uint32(req[4:])
+		readLen := binary.LittleEndian.Uint32(req[8:])
+		writeLen := binary.LittleEndian.Uint32(req[12:])
+		data, code := r.readWrite(group, offset, readLen, req[16:16+writeLen])
+		return dataResponse(code, data)
+	}
+	return codeResponse(ADSErrServiceNotSupported)
+}
+
+func (r *fakeRouter) read(group, offset, length uint32) ([]byte, uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls[group]++
+
+	switch group {
+	case ADSIGrpSymUploadInfo2:
+		info := make([]byte, 24)
+		binary.LittleEndian.PutUint32(info[0:], uint32(len(r.symbols)))
+		binary.LittleEndian.PutUint32(info[4:], uint32(len(r.symbolTable())))
+		binary.LittleEndian.PutUint32(info[8:], uint32(len(r.types)))
+		binary.LittleEndian.PutUint32(info[12:], uint32(len(r.typeTable())))
+		return info, ADSErrNoError
+	case ADSIGrpSymUpload:
+		return r.symbolTable(), ADSErrNoError
+	case ADSIGrpSymDTUpload:
+		return r.typeTable(), ADSErrNoError
+	case ADSIGrpSymValueByHnd:
+	default:
+		return nil, ADSErrInvalidGroup
+	}
+	sym, ok := r.handles[offset]
+	if !ok {
+		return nil, ADSErrSymbolNotFound
+	}
+	if length > uint32(len(sym.data)) {
+		return nil, ADSErrInvalidSize
+	}
+	return append([]byte(nil), sym.data[:length]...), ADSErrNoError
+}
+
+func (r *fakeRouter) write(group, offset uint32, data []byte) uint32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls[group]++
+
+	switch group {
+	case ADSIGrpSymValueByHnd:
+		sym, ok := r.handles[offset]
+		if !ok {
+			return ADSErrSymbolNotFound
+		}
+		if len(data) != len(sym.data) {
+			return ADSErrInvalidSize
+		}
+		if sym.denyWrite {
+			return ADSErrInvalidAccess
+		}
+		copy(sym.data, data)
+		return ADSErrNoError
+	case ADSIGrpSymReleaseHnd:
+		delete(r.handles, binary.LittleEndian.Uint32(data))
+		return ADSErrNoError
+	}
+	return ADSErrInvalidGroup
+}
+
+func (r *fakeRouter) readWrite(group, offset, readLen uint32, data []byte) ([]byte, uint32) {
+	switch group {
+	case ADSIGrpSymHandleByName, ADSIGrpSymInfoByNameEx:
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.calls[group]++
+
+		name := strings.TrimRight(string(data), "\x00")
+		sym, ok := r.symbols[strings.ToLower(name)]
+		if !ok {
+			return nil, ADSErrSymbolNotFound
+		}
+		if group == ADSIGrpSymInfoByNameEx {
+			return encodeFakeSymbolEntry(sym), ADSErrNoError
+		}
+		r.nextHandle++
+		sym.handle = r.nextHandle
+		r.handles[sym.handle] = sym
+		resp := make([]byte, 4)
+		binary.LittleEndian.PutUint32(resp, sym.handle)
+		return resp, ADSErrNoError
+
+	case ADSIGrpSumRead:
+		r.mu.Lock()
+		r.calls[group]++
+		r.mu.Unlock()
+
+		codes := make([]byte, 4*offset)
+		var values []byte
+		for i := uint32(0); i < offset; i++ {
+			g := binary.LittleEndian.Uint32(data[i*12:])
+			o := binary.LittleEndian.Uint32(data[i*12+4:])
+			l := binary.LittleEndian.Uint32(data[i*12+8:])
+			v, code := r.read(g, o, l)
+			binary.LittleEndian.PutUint32(codes[i*4:], code)
+			padded := make([]byte, l)
+			copy(padded, v)
+			values = append(values, padded...)
+		}
+		return append(codes, values...), ADSErrNoError
+
+	case ADSIGrpSumWrite:
+		r.mu.Lock()
+		r.calls[group]++
+		r.mu.Unlock()
+
+		codes := make([]byte, 4*offset)
+		pos := 12 * offset
+		for i := uint32(0); i < offset; i++ {
+			g := binary.LittleEndian.Uint32(data[i*12:])
+			o := binary.LittleEndian.Uint32(data[i*12+4:])
+			l := binary.LittleEndian.Uint32(data[i*12+8:])
+			binary.LittleEndian.PutUint32(codes[i*4:], r.write(g, o, data[pos:pos+l]))
+			pos += l
+		}
+		return codes, ADSErrNoError
+	}
+	return nil, ADSErrInvalidGroup
+}
+
+// symbolTable encodes all symbols for the symbol upload. r.mu must be held.
+func (r *fakeRouter) symbolTable() []byte {
+	names := make([]string, 0, len(r.symbols))
+	for name := range r.symbols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b []byte
+	for _, name := range names {
+		b = append(b, encodeFakeSymbolEntry(r.symbols[name])...)
+	}
+	return b
+}
+
+// typeTable encodes all data types for the data type upload. r.mu must be held.
+func (r *fakeRouter) typeTable() []byte {
+	var b []byte
+	for _, t := range r.types {
+		b = append(b, encodeFakeDataType(t, 0)...)
+	}
+	return b
+}
+
+func encodeFakeDataType(t DataTypeInfo, offset int) []byte {
+	var subs []byte
+	for _, f := range t.Fields {
+		sub := DataTypeInfo{Name: f.Name, BaseType: f.TypeName, Size: f.Size, adsType: f.adsType}
+		subs = append(subs, encodeFakeDataType(sub, f.Offset)...)
+	}
+
+	b := make([]byte, 42+len(t.Name)+len(t.BaseType)+3+8*len(t.ArrayBounds)+len(subs))
+	binary.LittleEndian.PutUint32(b[0:], uint32(len(b)))
+	binary.LittleEndian.PutUint32(b[16:], uint32(t.Size))
+	binary.LittleEndian.PutUint32(b[20:], uint32(offset))
+	binary.LittleEndian.PutUint32(b[24:], t.adsType)
+	binary.LittleEndian.PutUint16(b[32:], uint16(len(t.Name)))
+	binary.LittleEndian.PutUint16(b[34:], uint16(len(t.BaseType)))
+	binary.LittleEndian.PutUint16(b[38:], uint16(len(t.ArrayBounds)))
+	binary.LittleEndian.PutUint16(b[40:], uint16(len(t.Fields)))
+
+	pos := 42
+	pos += copy(b[pos:], t.Name) + 1
+	pos += copy(b[pos:], t.BaseType) + 2 // no comment
+	for _, d := range t.ArrayBounds {
+		binary.LittleEndian.PutUint32(b[pos:], uint32(int32(d.Lower)))
+		binary.LittleEndian.PutUint32(b[pos+4:], uint32(d.Elements))
+		pos += 8
+	}
+	copy(b[pos:], subs)
+	return b
+}
+
+func encodeFakeSymbolEntry(sym *fakeSymbol) []byte {
+	b := make([]byte, 30+len(sym.name)+len(sym.typeName)+3)
+	binary.LittleEndian.PutUint32(b[0:], uint32(len(b)))
+	binary.LittleEndian.PutUint32(b[4:], 0x4040)
+	binary.LittleEndian.PutUint32(b[12:], uint32(len(sym.data)))
+	binary.LittleEndian.PutUint32(b[16:], sym.dataType)
+	binary.LittleEndian.PutUint32(b[20:], sym.flags)
+	binary.LittleEndian.PutUint16(b[24:], uint16(len(sym.name)))
+	binary.LittleEndian.PutUint16(b[26:], uint16(len(sym.typeName)))
+	copy(b[30:], sym.name)
+	copy(b[31+len(sym.name):], sym.typeName)
+	return b
+}
+
+func dataResponse(code uint32, data []byte) []byte {
+	resp := make([]byte, 8+len(data))
+	binary.LittleEndian.PutUint32(resp[0:], code)
+	binary.LittleEndian.PutUint32(resp[4:], uint32(len(data)))
+	copy(resp[8:], data)
+	return resp
+}
+
+func codeResponse(code uint32) []byte {
+	resp := make([]byte, 4)
+	binary.LittleEndian.PutUint32(resp, code)
+	return resp
+}
@@ -0,0 +1,205 @@
+package plcengine
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DropPolicy decides which value a subscriber loses when its buffer is full.
+// The engine never waits for a slow subscriber.
+type DropPolicy int
+
+const (
+	DropNewest DropPolicy = iota // discard the value being delivered
+	DropOldest                   // discard the oldest buffered value to make room
+)
+
+func (p DropPolicy) String() string {
+	if p == DropOldest {
+		return "drop_oldest"
+	}
+	return "drop_newest"
+}
+
+// DefaultStreamBuffer is the buffer of a subscriber without one configured
+const DefaultStreamBuffer = 1024
+
+// StreamOptions configures the channel of one subscriber
+type StreamOptions struct {
+	Name   string // identifies the subscriber in StreamStats
+	Buffer int    // channel capacity, defaults to DefaultStreamBuffer
+	Policy DropPolicy
+}
+
+// ValueFilter selects the values of a Subscribe stream. Empty lists match
+// everything, symbols are compared case-insensitively.
+type ValueFilter struct {
+	MachineIDs []string
+	Symbols    []string
+	StreamOptions
+}
+
+func (f ValueFilter) match() func(PLCValue) bool {
+	machines := keySet(f.MachineIDs, false)
+	symbols := keySet(f.Symbols, true)
+	return func(v PLCValue) bool {
+		if machines != nil && !machines[v.Source] {
+			return false
+		}
+		return symbols == nil || symbols[strings.ToLower(v.Symbol)]
+	}
+}
+
+// WriteFilter selects the results of a SubscribeWrites stream. An empty list
+// matches every machine.
+type WriteFilter struct {
+	MachineIDs []string
+	StreamOptions
+}
+
+func (f WriteFilter) match() func(WriteResponse) bool {
+	machines := keySet(f.MachineIDs, false)
+	return func(r WriteResponse) bool {
+		return machines == nil || machines[r.MachineID]
+	}
+}
+
+func keySet(keys []string, lower bool) map[string]bool {
+	if len(keys) == 0 {
+		return nil
+	}
+	m := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if lower {
+			k = strings.ToLower(k)
+		}
+		m[k] = true
+	}
+	return m
+}
+
+// StreamStats reports the delivery of one subscriber
+type StreamStats struct {
+	Name      string `json:"name"`
+	Stream    string `json:"stream"` // "values" or "writes"
+	Policy    string `json:"policy"`
+	Buffered  int    `json:"buffered"`
+	Capacity  int    `json:"capacity"`
+	Delivered uint64 `json:"delivered"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+type subscriber[T any] struct {
+	name      string
+	ch        chan T
+	match     func(T) bool
+	policy    DropPolicy
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// fanout copies every published item to the matching subscribers. publish
+// must only be called from one goroutine at a time, which makes evicting the
+// oldest value safe.
+type fanout[T any] struct {
+	stream string
+
+	mu     sync.RWMutex
+	subs   []*subscriber[T]
+	closed bool
+}
+
+func newFanout[T any](stream string) *fanout[T] {
+	return &fanout[T]{stream: stream}
+}
+
+func (f *fanout[T]) subscribe(opts StreamOptions, match func(T) bool) <-chan T {
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultStreamBuffer
+	}
+	s := &subscriber[T]{name: opts.Name, ch: make(chan T, opts.Buffer), match: match, policy: opts.Policy}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		close(s.ch)
+		return s.ch
+	}
+	f.subs = append(f.subs, s)
+	return s.ch
+}
+
+// unsubscribe removes and closes the channel of a subscriber
+func (f *fanout[T]) unsubscribe(ch <-chan T) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, s := range f.subs {
+		if s.ch == ch {
+			f.subs = append(f.subs[:i], f.subs[i+1:]...)
+			close(s.ch)
+			return
+		}
+	}
+}
+
+func (f *fanout[T]) publish(v T) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, s := range f.subs {
+		if s.match != nil && !s.match(v) {
+			continue
+		}
+		select {
+		case s.ch <- v:
+			s.delivered.Add(1)
+			continue
+		default:
+		}
+
+		if s.policy == DropOldest {
+			select {
+			case <-s.ch:
+			default:
+			}
+			select {
+			case s.ch <- v:
+				s.delivered.Add(1)
+			default:
+			}
+		}
+		s.dropped.Add(1)
+	}
+}
+
+// close closes all subscriber channels, later subscribers get a closed channel
+func (f *fanout[T]) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return
+	}
+	f.closed = true
+	for _, s := range f.subs {
+		close(s.ch)
+	}
+	f.subs = nil
+}
+
+func (f *fanout[T]) stats() []StreamStats {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	out := make([]StreamStats, len(f.subs))
+	for i, s := range f.subs {
+		out[i] = StreamStats{
+			Name:      s.name,
+			Stream:    f.stream,
+			Policy:    s.policy.String(),
+			Buffered:  len(s.ch),
+			Capacity:  cap(s.ch),
+			Delivered: s.delivered.Load(),
+			Dropped:   s.dropped.Load(),
+		}
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+package plcengine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v, ok := <-ch:
+		require.True(t, ok, "stream closed")
+		return v
+	case <-time.After(time.Second):
+		t.Fatal("nothing received")
+	}
+	var zero T
+	return zero
+}
+
+func TestFanout_DropPolicies(t *testing.T) {
+	f := newFanout[int]("values")
+	newest := f.subscribe(StreamOptions{Name: "newest", Buffer: 2}, nil)
+	oldest := f.subscribe(StreamOptions{Name: "oldest", Buffer: 2, Policy: DropOldest}, nil)
+	even := f.subscribe(StreamOptions{Name: "even"}, func(v int) bool { return v%2 == 0 })
+
+	for i := 1; i <= 4; i++ {
+		f.publish(i)
+	}
+
+	assert.Equal(t, []int{1, 2}, []int{<-newest, <-newest})
+	assert.Equal(t, []int{3, 4}, []int{<-oldest, <-oldest})
+	assert.Equal(t, []int{2, 4}, []int{<-even, <-even})
+
+	stats := f.stats()
+	require.Len(t, stats, 3)
+	assert.Equal(t, StreamStats{Name: "newest", Stream: "values", Policy: "drop_newest", Capacity: 2, Delivered: 2, Dropped: 2}, stats[0])
+	assert.Equal(t, StreamStats{Name: "oldest", Stream: "values", Policy: "drop_oldest", Capacity: 2, Delivered: 4, Dropped: 2}, stats[1])
+	assert.Equal(t, StreamStats{Name: "even", Stream: "values", Policy: "drop_newest", Capacity: DefaultStreamBuffer, Delivered: 2}, stats[2])
+
+	f.unsubscribe(even)
+	_, ok := <-even
+	assert.False(t, ok)
+	assert.Len(t, f.stats(), 2)
+
+	f.close()
+	_, ok = <-newest
+	assert.False(t, ok)
+	_, ok = <-f.subscribe(StreamOptions{}, nil)
+	assert.False(t, ok)
+}
+
+func TestEngine_Subscribe(t *testing.T) {
+	e := NewEngine(nil)
+	e.ClientFactory = func(ip, amsID string, port int) (ADSClient, error) { return NewMockADSClient(ip), nil }
+
+	all := e.Subscribe(ValueFilter{StreamOptions: StreamOptions{Name: "collector"}})
+	pressure := e.Subscribe(ValueFilter{MachineIDs: []string{"m1"}, Symbols: []string{"gvl.pressure"}})
+	slow := e.Subscribe(ValueFilter{StreamOptions: StreamOptions{Name: "slow", Buffer: 1}})
+	writes := e.SubscribeWrites(WriteFilter{MachineIDs: []string{"m1"}})
+
+	require.NoError(t, e.Start([]MachineConfig{
+		{ID: "m1", IP: "10.0.0.1", Symbols: []SymbolInfo{{Name: "GVL.Setpoint", IsWritable: true, MinValue: 0, MaxValue: 100}}},
+	}))
+
+	e.dataChan <- PLCValue{Source: "m1", Symbol: "GVL.Temperature", Value: 21.5}
+	e.dataChan <- PLCValue{Source: "m2", Symbol: "GVL.Pressure", Value: 0.2}
+	e.dataChan <- PLCValue{Source: "m1", Symbol: "GVL.Pressure", Value: 0.1}
+
+	// Every subscriber gets its own copy
+	assert.Equal(t, "GVL.Temperature", receive(t, all).Symbol)
+	assert.Equal(t, "m2", receive(t, all).Source)
+	assert.Equal(t, "m1", receive(t, all).Source)
+	v := receive(t, pressure)
+	assert.Equal(t, 0.1, v.Value)
+
+	require.Eventually(t, func() bool { return e.GetStatus()["m1"].Connected }, time.Second, 5*time.Millisecond)
+	resp := <-e.WriteAsync(WriteRequest{ID: "w1", MachineID: "m1", Symbol: "GVL.Setpoint", Value: 50.0})
+	require.True(t, resp.Success, resp.Error)
+	assert.Equal(t, "w1", receive(t, writes).ID)
+
+	// The slow subscriber lost values without holding up the others
+	require.Eventually(t, func() bool {
+		for _, s := range e.StreamStats() {
+			if s.Name == "slow" {
+				return s.Dropped == 2
+			}
+		}
+		return false
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "GVL.Temperature", receive(t, slow).Symbol)
+
+	e.Unsubscribe(pressure)
+	assert.Len(t, e.StreamStats(), 3)
+
+	require.NoError(t, e.Stop())
+	_, ok := <-all
+	assert.False(t, ok)
+	_, ok = <-writes
+	assert.False(t, ok)
+}
@@ -0,0 +1,225 @@
+package plcengine
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// RejectReason classifies why a write was refused before reaching the PLC
+type RejectReason string
+
+const (
+	RejectNotAllowed   RejectReason = "not_allowed"   // symbol is not on the write allowlist
+	RejectReadOnly     RejectReason = "read_only"     // IsWritable is false
+	RejectTypeMismatch RejectReason = "type_mismatch" // value cannot be coerced to the PLC type
+	RejectOutOfRange   RejectReason = "out_of_range"  // value outside MinValue/MaxValue
+)
+
+// WriteRejection is the structured reason a write guard refused a write
+type WriteRejection struct {
+	Reason  RejectReason `json:"reason"`
+	Symbol  string       `json:"symbol"`
+	Message string       `json:"message"`
+}
+
+func (r *WriteRejection) Error() string {
+	return fmt.Sprintf("write to %s rejected (%s): %s", r.Symbol, r.Reason, r.Message)
+}
+
+func reject(reason RejectReason, symbol, format string, args ...interface{}) *WriteRejection {
+	return &WriteRejection{Reason: reason, Symbol: symbol, Message: fmt.Sprintf(format, args...)}
+}
+
+// WriteGuard validates a write before it is sent to the PLC. Guards run in
+// order and may replace req.Value, e.g. to coerce it to the PLC type. info is
+// nil if the symbol is not on the machine's write allowlist.
+type WriteGuard interface {
+	Check(req *WriteRequest, info *SymbolInfo) *WriteRejection
+}
+
+// WriteGuardFunc adapts a function to the WriteGuard interface
+type WriteGuardFunc func(req *WriteRequest, info *SymbolInfo) *WriteRejection
+
+func (f WriteGuardFunc) Check(req *WriteRequest, info *SymbolInfo) *WriteRejection {
+	return f(req, info)
+}
+
+// DefaultWriteGuards returns the allowlist, type and range checks from the
+// safety write flow
+func DefaultWriteGuards() []WriteGuard {
+	return []WriteGuard{AllowlistGuard{}, TypeGuard{}, RangeGuard{}}
+}
+
+// AllowlistGuard only lets writes through to configured, writable symbols
+type AllowlistGuard struct{}
+
+func (AllowlistGuard) Check(req *WriteRequest, info *SymbolInfo) *WriteRejection {
+	if info == nil {
+		return reject(RejectNotAllowed, req.Symbol, "symbol is not on the write allowlist of machine %s", req.MachineID)
+	}
+	if !info.IsWritable {
+		return reject(RejectReadOnly, req.Symbol, "symbol is read-only")
+	}
+	return nil
+}
+
+// TypeGuard coerces the value to the Go type of the PLC data type, e.g. a JSON
+// number 42 to int16 for an INT. Symbols of unknown type pass unchanged.
+type TypeGuard struct{}
+
+func (TypeGuard) Check(req *WriteRequest, info *SymbolInfo) *WriteRejection {
+	if info == nil {
+		return nil
+	}
+	v, err := coerceValue(info.Type, req.Value)
+	if err != nil {
+		return reject(RejectTypeMismatch, req.Symbol, "%v", err)
+	}
+	req.Value = v
+	return nil
+}
+
+// RangeGuard rejects numeric values outside [WriteMin, WriteMax], or
+// [MinValue, MaxValue] if the symbol has no write bounds. Symbols with
+// MinValue == MaxValue and no write bounds have no limits.
+type RangeGuard struct{}
+
+func (RangeGuard) Check(req *WriteRequest, info *SymbolInfo) *WriteRejection {
+	if info == nil || !isNumeric(info.Type) {
+		return nil
+	}
+	min, max := info.MinValue, info.MaxValue
+	if info.WriteMin != info.WriteMax {
+		min, max = info.WriteMin, info.WriteMax
+	}
+	if min == max {
+		return nil
+	}
+	v, err := toFloat64(req.Value)
+	if err != nil {
+		return reject(RejectTypeMismatch, req.Symbol, "%v", err)
+	}
+	if math.IsNaN(v) || v < min || v > max {
+		return reject(RejectOutOfRange, req.Symbol, "value %v outside [%v, %v]%s", req.Value, min, max, unitSuffix(info.Unit))
+	}
+	return nil
+}
+
+func unitSuffix(unit string) string {
+	if unit == "" {
+		return ""
+	}
+	return " " + unit
+}
+
+func isNumeric(t PLCType) bool {
+	switch t {
+	case TypeInt8, TypeInt16, TypeInt32, TypeInt64,
+		TypeUInt8, TypeUInt16, TypeUInt32, TypeUInt64,
+		TypeReal, TypeLReal:
+		return true
+	}
+	return false
+}
+
+// coerceValue converts v to the Go type that decodeADSValue returns for t
+func coerceValue(t PLCType, v interface{}) (interface{}, error) {
+	switch t {
+	case TypeBool:
+		return toBool(v)
+	case TypeInt8, TypeInt16, TypeInt32, TypeInt64:
+		i, err := toInt64(v)
+		if err != nil {
+			return nil, err
+		}
+		switch t {
+		case TypeInt8:
+			if i < math.MinInt8 || i > math.MaxInt8 {
+				return nil, fmt.Errorf("value %d overflows %s", i, t)
+			}
+			return int8(i), nil
+		case TypeInt16:
+			if i < math.MinInt16 || i > math.MaxInt16 {
+				return nil, fmt.Errorf("value %d overflows %s", i, t)
+			}
+			return int16(i), nil
+		case TypeInt32:
+			if i < math.MinInt32 || i > math.MaxInt32 {
+				return nil, fmt.Errorf("value %d overflows %s", i, t)
+			}
+			return int32(i), nil
+		}
+		return i, nil
+	case TypeUInt8, TypeUInt16, TypeUInt32, TypeUInt64:
+		i, err := toInt64(v)
+		if err != nil {
+			return nil, err
+		}
+		limit := map[PLCType]int64{TypeUInt8: math.MaxUint8, TypeUInt16: math.MaxUint16, TypeUInt32: math.MaxUint32, TypeUInt64: math.MaxInt64}[t]
+		if i < 0 || i > limit {
+			return nil, fmt.Errorf("value %d overflows %s", i, t)
+		}
+		switch t {
+		case TypeUInt8:
+			return uint8(i), nil
+		case TypeUInt16:
+			return uint16(i), nil
+		case TypeUInt32:
+			return uint32(i), nil
+		}
+		return uint64(i), nil
+	case TypeReal:
+		f, err := toFloat64(v)
+		if err != nil {
+			return nil, err
+		}
+		if math.Abs(f) > math.MaxFloat32 {
+			return nil, fmt.Errorf("value %v overflows REAL", f)
+		}
+		return float32(f), nil
+	case TypeLReal:
+		return toFloat64(v)
+	case TypeString, TypeWString:
+		switch s := v.(type) {
+		case string:
+			return s, nil
+		case []byte:
+			return string(s), nil
+		}
+		return nil, fmt.Errorf("cannot convert %T to %s", v, t)
+	case TypeArray, TypeStruct:
+		if _, ok := v.([]byte); !ok {
+			return nil, fmt.Errorf("%s symbols can only be written as raw bytes", t)
+		}
+	}
+	return v, nil
+}
+
+// ParsePLCType maps an IEC 61131-3 type name such as "REAL", "DWORD" or
+// "STRING(80)" to a PLCType. Unknown names map to TypeUnknown.
+func ParsePLCType(name string) PLCType {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if i := strings.IndexAny(name, "(["); i > 0 {
+		name = strings.TrimSpace(name[:i])
+	}
+	switch name {
+	case "BYTE":
+		return TypeUInt8
+	case "WORD":
+		return TypeUInt16
+	case "DWORD":
+		return TypeUInt32
+	case "LWORD":
+		return TypeUInt64
+	}
+	if strings.HasPrefix(name, "ARRAY") {
+		return TypeArray
+	}
+	for t, n := range plcTypeNames {
+		if n == name {
+			return t
+		}
+	}
+	return TypeUnknown
+}
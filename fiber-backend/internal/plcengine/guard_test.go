@@ -0,0 +1,140 @@
+package plcengine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoerceValue(t *testing.T) {
+	tests := []struct {
+		typ     PLCType
+		in      interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{TypeInt16, 42.0, int16(42), false},
+		{TypeInt16, "7", int16(7), false},
+		{TypeInt16, 40000, nil, true},
+		{TypeInt16, 1.5, nil, true},
+		{TypeUInt8, -1, nil, true},
+		{TypeUInt32, 70000.0, uint32(70000), false},
+		{TypeReal, 21, float32(21), false},
+		{TypeReal, "hot", nil, true},
+		{TypeLReal, float32(0.5), 0.5, false},
+		{TypeBool, 1.0, true, false},
+		{TypeBool, "false", false, false},
+		{TypeString, "ETCH_01", "ETCH_01", false},
+		{TypeString, 12, nil, true},
+		{TypeStruct, map[string]interface{}{}, nil, true},
+		{TypeUnknown, 3, 3, false},
+	}
+	for _, tt := range tests {
+		got, err := coerceValue(tt.typ, tt.in)
+		if tt.wantErr {
+			assert.Error(t, err, "%s %v", tt.typ, tt.in)
+			continue
+		}
+		require.NoError(t, err, "%s %v", tt.typ, tt.in)
+		assert.Equal(t, tt.want, got)
+	}
+}
+
+func TestParsePLCType(t *testing.T) {
+	assert.Equal(t, TypeReal, ParsePLCType("REAL"))
+	assert.Equal(t, TypeInt16, ParsePLCType("int"))
+	assert.Equal(t, TypeUInt32, ParsePLCType("DWORD"))
+	assert.Equal(t, TypeString, ParsePLCType("STRING(80)"))
+	assert.Equal(t, TypeArray, ParsePLCType("ARRAY [1..3] OF REAL"))
+	assert.Equal(t, TypeUnknown, ParsePLCType("ST_Chamber"))
+}
+
+func startGuardedEngine(t *testing.T) (*PLCReadWriteEngine, *fakeRouter) {
+	r := newTypedRouter(t)
+
+	e := NewEngine(make(chan PLCValue, 10))
+	require.NoError(t, e.Start([]MachineConfig{{
+		ID: "m1", IP: r.Addr(), AmsNetID: r.NetID(), Port: 851,
+		Symbols: []SymbolInfo{
+			{Name: "GVL.Temperature", IsWritable: true, MinValue: 0, MaxValue: 100, Unit: "°C"},
+			{Name: "GVL.Step", TypeName: "INT", IsWritable: true},
+			{Name: "GVL.Recipe", IsWritable: true},
+			{Name: "GVL.Running", IsWritable: false},
+		},
+	}}))
+	t.Cleanup(func() { e.Stop() })
+
+	require.Eventually(t, func() bool {
+		_, err := e.GetSymbolInfo("m1", "GVL.Temperature")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	return e, r
+}
+
+func writeAndWait(t *testing.T, e *PLCReadWriteEngine, symbol string, value interface{}) WriteResponse {
+	t.Helper()
+	select {
+	case resp := <-e.WriteAsync(WriteRequest{ID: symbol, MachineID: "m1", Symbol: symbol, Value: value, Priority: 9}):
+		return resp
+	case <-time.After(2 * time.Second):
+		t.Fatal("no write response")
+		return WriteResponse{}
+	}
+}
+
+func TestEngine_WriteGuards(t *testing.T) {
+	e, r := startGuardedEngine(t)
+
+	resp := writeAndWait(t, e, "GVL.Temperature", 42.5)
+	assert.True(t, resp.Success, resp.Error)
+	assert.Nil(t, resp.Rejection)
+	assert.Equal(t, real32(42.5), r.value("GVL.Temperature"))
+
+	resp = writeAndWait(t, e, "GVL.Step", "12")
+	assert.True(t, resp.Success, resp.Error)
+	assert.Equal(t, int16Bytes(12), r.value("GVL.Step"))
+
+	rejected := []struct {
+		symbol string
+		value  interface{}
+		reason RejectReason
+	}{
+		{"GVL.Temperature", 150.0, RejectOutOfRange},
+		{"GVL.Temperature", "hot", RejectTypeMismatch},
+		{"GVL.Step", 2.5, RejectTypeMismatch},
+		{"GVL.Pressure", 1.0, RejectNotAllowed},
+		{"GVL.Recipe", "X", RejectReadOnly},   // read-only on the PLC
+		{"GVL.Running", true, RejectReadOnly}, // read-only in the allowlist
+	}
+	for _, tt := range rejected {
+		resp := writeAndWait(t, e, tt.symbol, tt.value)
+		assert.False(t, resp.Success, tt.symbol)
+		require.NotNil(t, resp.Rejection, tt.symbol)
+		assert.Equal(t, tt.reason, resp.Rejection.Reason, tt.symbol)
+		assert.Equal(t, tt.symbol, resp.Rejection.Symbol)
+		assert.Equal(t, resp.Rejection.Error(), resp.Error)
+	}
+	assert.Equal(t, real32(42.5), r.value("GVL.Temperature"))
+}
+
+func TestEngine_CustomWriteGuard(t *testing.T) {
+	e, r := startGuardedEngine(t)
+
+	e.AddWriteGuard(WriteGuardFunc(func(req *WriteRequest, info *SymbolInfo) *WriteRejection {
+		if req.Priority < 5 {
+			return &WriteRejection{Reason: "priority", Symbol: req.Symbol, Message: "low priority writes are disabled"}
+		}
+		return nil
+	}))
+
+	resp := <-e.WriteAsync(WriteRequest{MachineID: "m1", Symbol: "GVL.Step", Value: 5, Priority: 1})
+	require.NotNil(t, resp.Rejection)
+	assert.Equal(t, RejectReason("priority"), resp.Rejection.Reason)
+	assert.Equal(t, int16Bytes(3), r.value("GVL.Step"))
+
+	req, rej := e.CheckWrite(WriteRequest{MachineID: "m1", Symbol: "GVL.Step", Value: 5.0, Priority: 9})
+	assert.Nil(t, rej)
+	assert.Equal(t, int16(5), req.Value)
+}
@@ -0,0 +1,355 @@
+package plcengine
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ModbusPort is the default TCP port of Modbus servers
+const ModbusPort = 502
+
+// Modbus function codes
+const (
+	modbusReadCoils         byte   = 0x01
+	modbusReadDiscrete      byte   = 0x02
+	modbusReadHolding       byte   = 0x03
+	modbusReadInput         byte   = 0x04
+	modbusWriteCoil         byte   = 0x05
+	modbusWriteRegister     byte   = 0x06
+	modbusWriteCoils        byte   = 0x0F
+	modbusWriteRegisters    byte   = 0x10
+	modbusExceptionFlag     byte   = 0x80
+	modbusMBAPLen                  = 7
+	modbusMaxReadRegisters         = 125
+	modbusMaxReadBits              = 2000
+	modbusMaxWriteRegisters        = 123
+	modbusCoilOn            uint16 = 0xFF00
+)
+
+// Modbus exception codes
+const (
+	ModbusIllegalFunction     byte = 0x01
+	ModbusIllegalDataAddress  byte = 0x02
+	ModbusIllegalDataValue    byte = 0x03
+	ModbusServerDeviceFailure byte = 0x04
+	ModbusAcknowledge         byte = 0x05
+	ModbusServerDeviceBusy    byte = 0x06
+	ModbusGatewayPathFailed   byte = 0x0A
+	ModbusGatewayTargetFailed byte = 0x0B
+)
+
+var modbusErrorText = map[byte]string{
+	ModbusIllegalFunction:     "illegal function",
+	ModbusIllegalDataAddress:  "illegal data address",
+	ModbusIllegalDataValue:    "illegal data value",
+	ModbusServerDeviceFailure: "server device failure",
+	ModbusAcknowledge:         "acknowledge",
+	ModbusServerDeviceBusy:    "server device busy",
+	ModbusGatewayPathFailed:   "gateway path unavailable",
+	ModbusGatewayTargetFailed: "gateway target device failed to respond",
+}
+
+// ModbusError is an exception response of a Modbus server
+type ModbusError struct {
+	Function byte
+	Code     byte
+}
+
+func (e *ModbusError) Error() string {
+	if text, ok := modbusErrorText[e.Code]; ok {
+		return fmt.Sprintf("Modbus exception %d on function 0x%02X: %s", e.Code, e.Function, text)
+	}
+	return fmt.Sprintf("Modbus exception %d on function 0x%02X", e.Code, e.Function)
+}
+
+// IsModbusError reports whether err is the Modbus exception code
+func IsModbusError(err error, code byte) bool {
+	var mbErr *ModbusError
+	return errors.As(err, &mbErr) && mbErr.Code == code
+}
+
+// ModbusTable is the data model table a register lives in
+type ModbusTable string
+
+const (
+	ModbusHolding  ModbusTable = "holding"  // read/write 16 bit registers
+	ModbusInput    ModbusTable = "input"    // read-only 16 bit registers
+	ModbusCoil     ModbusTable = "coil"     // read/write bits
+	ModbusDiscrete ModbusTable = "discrete" // read-only bits
+)
+
+// WordOrder is the byte layout of values spanning several registers, named
+// after the position of the bytes of the big-endian value 0xAABBCCDD
+type WordOrder string
+
+const (
+	WordOrderABCD WordOrder = "ABCD" // big-endian, high word first (the default)
+	WordOrderCDAB WordOrder = "CDAB" // low word first
+	WordOrderBADC WordOrder = "BADC" // high word first, bytes swapped
+	WordOrderDCBA WordOrder = "DCBA" // little-endian
+)
+
+// ModbusRegister maps a symbol to Modbus registers. Numeric values are
+// scaled to engineering units as raw*Scale + Offset; writes apply the
+// inverse and round to the register type.
+type ModbusRegister struct {
+	Table   ModbusTable `json:"table"`
+	Address uint16      `json:"address"` // zero-based, holding register 40001 is address 0
+	Unit    uint8       `json:"unit,omitempty"`
+
+	// Type of the raw value: bool, int16, uint16, int32, uint32, int64,
+	// uint64, float32, float64 or string. Defaults to bool for coils and
+	// discrete inputs and to uint16 for registers.
+	Type      string    `json:"type,omitempty"`
+	Length    int       `json:"length,omitempty"` // characters of a string, two per register
+	WordOrder WordOrder `json:"word_order,omitempty"`
+	Scale     float64   `json:"scale,omitempty"` // 0 means 1
+	Offset    float64   `json:"offset,omitempty"`
+}
+
+// Validate checks table, type and word order
+func (r ModbusRegister) Validate() error {
+	switch r.Table {
+	case ModbusHolding, ModbusInput:
+		if r.rawType() == "bool" {
+			return fmt.Errorf("bool is only valid for coils and discrete inputs")
+		}
+		if _, ok := modbusTypeRegisters[r.rawType()]; !ok && r.rawType() != "string" {
+			return fmt.Errorf("unknown Modbus type %q", r.Type)
+		}
+		if r.rawType() == "string" && r.Length <= 0 {
+			return fmt.Errorf("string registers need a length")
+		}
+	case ModbusCoil, ModbusDiscrete:
+		if r.rawType() != "bool" {
+			return fmt.Errorf("%s registers hold bools, not %s", r.Table, r.Type)
+		}
+	default:
+		return fmt.Errorf("unknown Modbus table %q", r.Table)
+	}
+	switch r.WordOrder {
+	case "", WordOrderABCD, WordOrderCDAB, WordOrderBADC, WordOrderDCBA:
+	default:
+		return fmt.Errorf("unknown word order %q", r.WordOrder)
+	}
+	if r.Scale < 0 || math.IsNaN(r.Scale) || math.IsInf(r.Scale, 0) {
+		return fmt.Errorf("invalid scale %v", r.Scale)
+	}
+	return nil
+}
+
+var modbusTypeRegisters = map[string]int{
+	"int16": 1, "uint16": 1,
+	"int32": 2, "uint32": 2, "float32": 2,
+	"int64": 4, "uint64": 4, "float64": 4,
+}
+
+var modbusTypePLC = map[string]PLCType{
+	"bool":    TypeBool,
+	"int16":   TypeInt16,
+	"uint16":  TypeUInt16,
+	"int32":   TypeInt32,
+	"uint32":  TypeUInt32,
+	"int64":   TypeInt64,
+	"uint64":  TypeUInt64,
+	"float32": TypeReal,
+	"float64": TypeLReal,
+	"string":  TypeString,
+}
+
+func (r ModbusRegister) rawType() string {
+	if r.Type == "" {
+		if r.isBit() {
+			return "bool"
+		}
+		return "uint16"
+	}
+	return strings.ToLower(r.Type)
+}
+
+func (r ModbusRegister) isBit() bool {
+	return r.Table == ModbusCoil || r.Table == ModbusDiscrete
+}
+
+func (r ModbusRegister) writable() bool {
+	return r.Table == ModbusHolding || r.Table == ModbusCoil
+}
+
+func (r ModbusRegister) scaled() bool {
+	return (r.Scale != 0 && r.Scale != 1) || r.Offset != 0
+}
+
+// count is the number of registers or bits the value spans
+func (r ModbusRegister) count() int {
+	if r.isBit() {
+		return 1
+	}
+	if r.rawType() == "string" {
+		return (r.Length + 1) / 2
+	}
+	return modbusTypeRegisters[r.rawType()]
+}
+
+// plcType is the type of the engineering value: LREAL for scaled registers
+func (r ModbusRegister) plcType() PLCType {
+	if r.scaled() && r.rawType() != "string" && !r.isBit() {
+		return TypeLReal
+	}
+	return modbusTypePLC[r.rawType()]
+}
+
+// orderBytes converts between register bytes as sent and the big-endian
+// value, the conversion is its own inverse
+func (r ModbusRegister) orderBytes(b []byte) []byte {
+	out := append([]byte(nil), b...)
+	if r.WordOrder == WordOrderBADC || r.WordOrder == WordOrderDCBA {
+		for i := 0; i+1 < len(out); i += 2 {
+			out[i], out[i+1] = out[i+1], out[i]
+		}
+	}
+	if r.WordOrder == WordOrderCDAB || r.WordOrder == WordOrderDCBA {
+		for i, j := 0, len(out)-2; i < j; i, j = i+2, j-2 {
+			out[i], out[i+1], out[j], out[j+1] = out[j], out[j+1], out[i], out[i+1]
+		}
+	}
+	return out
+}
+
+// decode converts the raw register bytes into the engineering value
+func (r ModbusRegister) decode(b []byte) (interface{}, error) {
+	if len(b) < 2*r.count() {
+		return nil, ErrInvalidResponse
+	}
+	b = r.orderBytes(b[:2*r.count()])
+
+	var raw interface{}
+	switch r.rawType() {
+	case "int16":
+		raw = int16(binary.BigEndian.Uint16(b))
+	case "uint16":
+		raw = binary.BigEndian.Uint16(b)
+	case "int32":
+		raw = int32(binary.BigEndian.Uint32(b))
+	case "uint32":
+		raw = binary.BigEndian.Uint32(b)
+	case "int64":
+		raw = int64(binary.BigEndian.Uint64(b))
+	case "uint64":
+		raw = binary.BigEndian.Uint64(b)
+	case "float32":
+		raw = math.Float32frombits(binary.BigEndian.Uint32(b))
+	case "float64":
+		raw = math.Float64frombits(binary.BigEndian.Uint64(b))
+	case "string":
+		return strings.TrimRight(string(b[:r.Length]), "\x00 "), nil
+	default:
+		return nil, fmt.Errorf("unknown Modbus type %q", r.Type)
+	}
+	if !r.scaled() {
+		return raw, nil
+	}
+	f, err := toFloat64(raw)
+	if err != nil {
+		return nil, err
+	}
+	scale := r.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return f*scale + r.Offset, nil
+}
+
+// encode converts an engineering value into register bytes
+func (r ModbusRegister) encode(v interface{}) ([]byte, error) {
+	t := r.rawType()
+	if t == "string" {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot write %T to a string register", v)
+		}
+		if len(s) > r.Length {
+			return nil, fmt.Errorf("string of %d characters exceeds length %d", len(s), r.Length)
+		}
+		b := make([]byte, 2*r.count())
+		copy(b, s)
+		return r.orderBytes(b), nil
+	}
+
+	if r.scaled() {
+		f, err := toFloat64(v)
+		if err != nil {
+			return nil, err
+		}
+		scale := r.Scale
+		if scale == 0 {
+			scale = 1
+		}
+		f = (f - r.Offset) / scale
+		if t != "float32" && t != "float64" {
+			f = math.Round(f)
+		}
+		v = f
+	}
+
+	raw, err := coerceValue(modbusTypePLC[t], v)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 2*r.count())
+	switch x := raw.(type) {
+	case int16:
+		binary.BigEndian.PutUint16(b, uint16(x))
+	case uint16:
+		binary.BigEndian.PutUint16(b, x)
+	case int32:
+		binary.BigEndian.PutUint32(b, uint32(x))
+	case uint32:
+		binary.BigEndian.PutUint32(b, x)
+	case int64:
+		binary.BigEndian.PutUint64(b, uint64(x))
+	case uint64:
+		binary.BigEndian.PutUint64(b, x)
+	case float32:
+		binary.BigEndian.PutUint32(b, math.Float32bits(x))
+	case float64:
+		binary.BigEndian.PutUint64(b, math.Float64bits(x))
+	default:
+		return nil, fmt.Errorf("cannot encode %T as %s", raw, t)
+	}
+	return r.orderBytes(b), nil
+}
+
+// modbusFrame encodes an MBAP header and PDU
+func modbusFrame(transaction uint16, unit byte, pdu []byte) []byte {
+	b := make([]byte, modbusMBAPLen, modbusMBAPLen+len(pdu))
+	binary.BigEndian.PutUint16(b[0:], transaction)
+	binary.BigEndian.PutUint16(b[2:], 0) // protocol ID
+	binary.BigEndian.PutUint16(b[4:], uint16(len(pdu)+1))
+	b[6] = unit
+	return append(b, pdu...)
+}
+
+// unpackBits expands the bit bytes of a coil or discrete input response
+func unpackBits(b []byte, n int) []bool {
+	bits := make([]bool, n)
+	for i := range bits {
+		if i/8 < len(b) {
+			bits[i] = b[i/8]&(1<<(i%8)) != 0
+		}
+	}
+	return bits
+}
+
+// packBits is the inverse of unpackBits
+func packBits(bits []bool) []byte {
+	b := make([]byte, (len(bits)+7)/8)
+	for i, on := range bits {
+		if on {
+			b[i/8] |= 1 << (i % 8)
+		}
+	}
+	return b
+}
@@ -0,0 +1,459 @@
+package plcengine
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ModbusConfig describes how to reach a Modbus TCP server and which
+// registers hold the symbols
+type ModbusConfig struct {
+	Address string // host or host:port, port defaults to 502
+	Unit    uint8  // unit identifier, defaults to 1; registers may override it
+	Timeout time.Duration
+
+	// MaxGap is the number of unmapped registers (or bits) a batched read
+	// may span to merge two symbols into one request, defaults to 8
+	MaxGap int
+
+	// Registers maps symbol names to their registers
+	Registers map[string]ModbusRegister
+}
+
+// modbusSymbol is a mapped symbol with the name it was configured with
+type modbusSymbol struct {
+	name string
+	reg  ModbusRegister
+}
+
+// modbusBlock is a contiguous range of one table read with a single request
+type modbusBlock struct {
+	unit    uint8
+	table   ModbusTable
+	start   uint16
+	count   int
+	symbols []modbusSymbol
+}
+
+// ModbusClient is a Modbus TCP client mapping symbols to registers. Reads
+// of many symbols are merged into as few register range requests as
+// possible. Requests are sent one at a time since many devices do not
+// queue. It implements ADSClient and is safe for concurrent use.
+type ModbusClient struct {
+	conn    net.Conn
+	unit    uint8
+	timeout time.Duration
+	maxGap  int
+
+	registers map[string]modbusSymbol // keyed by lower-case name
+
+	mu          sync.Mutex // serializes requests
+	transaction uint16
+	closeOnce   sync.Once
+	done        chan struct{}
+}
+
+var (
+	_ ADSClient      = (*ModbusClient)(nil)
+	_ SymbolUploader = (*ModbusClient)(nil)
+)
+
+func NewModbusClient(cfg ModbusConfig) (*ModbusClient, error) {
+	registers := make(map[string]modbusSymbol, len(cfg.Registers))
+	for name, reg := range cfg.Registers {
+		if err := reg.Validate(); err != nil {
+			return nil, fmt.Errorf("register of %s: %w", name, err)
+		}
+		registers[strings.ToLower(name)] = modbusSymbol{name: name, reg: reg}
+	}
+
+	addr := cfg.Address
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, fmt.Sprint(ModbusPort))
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	unit := cfg.Unit
+	if unit == 0 {
+		unit = 1
+	}
+	maxGap := cfg.MaxGap
+	if maxGap <= 0 {
+		maxGap = 8
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ModbusClient{
+		conn:      conn,
+		unit:      unit,
+		timeout:   timeout,
+		maxGap:    maxGap,
+		registers: registers,
+		done:      make(chan struct{}),
+	}, nil
+}
+
+func (c *ModbusClient) shutdown() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// Closed reports whether the connection to the server was lost or closed
+func (c *ModbusClient) Closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close closes the connection
+func (c *ModbusClient) Close() error {
+	c.shutdown()
+	return nil
+}
+
+// request sends a PDU and returns the response PDU without the function
+// code. Exception responses are returned as *ModbusError. A transport error
+// or timeout closes the client, the stream cannot be resynchronized.
+func (c *ModbusClient) request(unit uint8, pdu []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Closed() {
+		return nil, ErrClientClosed
+	}
+
+	c.transaction++
+	id := c.transaction
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(modbusFrame(id, unit, pdu)); err != nil {
+		c.shutdown()
+		return nil, fmt.Errorf("%w: %v", ErrClientClosed, err)
+	}
+
+	for {
+		hdr := make([]byte, modbusMBAPLen)
+		if _, err := io.ReadFull(c.conn, hdr); err != nil {
+			return nil, c.transportError(err)
+		}
+		length := int(binary.BigEndian.Uint16(hdr[4:]))
+		if binary.BigEndian.Uint16(hdr[2:]) != 0 || length < 2 || length > 254 {
+			c.shutdown()
+			return nil, fmt.Errorf("%w: %v", ErrClientClosed, ErrInvalidResponse)
+		}
+		resp := make([]byte, length-1)
+		if _, err := io.ReadFull(c.conn, resp); err != nil {
+			return nil, c.transportError(err)
+		}
+		if binary.BigEndian.Uint16(hdr[0:]) != id {
+			continue // late response of an earlier request
+		}
+
+		if resp[0] == pdu[0]|modbusExceptionFlag {
+			if len(resp) < 2 {
+				return nil, ErrInvalidResponse
+			}
+			return nil, &ModbusError{Function: pdu[0], Code: resp[1]}
+		}
+		if resp[0] != pdu[0] {
+			return nil, ErrInvalidResponse
+		}
+		return resp[1:], nil
+	}
+}
+
+func (c *ModbusClient) transportError(err error) error {
+	c.shutdown()
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return ErrRequestTimeout
+	}
+	return fmt.Errorf("%w: %v", ErrClientClosed, err)
+}
+
+// symbol returns the register of a symbol
+func (c *ModbusClient) symbol(name string) (modbusSymbol, error) {
+	sym, ok := c.registers[strings.ToLower(name)]
+	if !ok {
+		return modbusSymbol{}, fmt.Errorf("no Modbus register mapped for symbol %s", name)
+	}
+	if sym.reg.Unit == 0 {
+		sym.reg.Unit = c.unit
+	}
+	return sym, nil
+}
+
+// readBlock reads a block and decodes the values of its symbols
+func (c *ModbusClient) readBlock(b modbusBlock) (map[string]interface{}, error) {
+	fc := map[ModbusTable]byte{
+		ModbusCoil:     modbusReadCoils,
+		ModbusDiscrete: modbusReadDiscrete,
+		ModbusHolding:  modbusReadHolding,
+		ModbusInput:    modbusReadInput,
+	}[b.table]
+
+	pdu := make([]byte, 5)
+	pdu[0] = fc
+	binary.BigEndian.PutUint16(pdu[1:], b.start)
+	binary.BigEndian.PutUint16(pdu[3:], uint16(b.count))
+	resp, err := c.request(b.unit, pdu)
+	if err != nil {
+		return nil, err
+	}
+
+	want := 2 * b.count
+	if b.table == ModbusCoil || b.table == ModbusDiscrete {
+		want = (b.count + 7) / 8
+	}
+	if len(resp) < 1 || int(resp[0]) != want || len(resp)-1 < want {
+		return nil, ErrInvalidResponse
+	}
+	data := resp[1:]
+
+	values := make(map[string]interface{}, len(b.symbols))
+	if b.table == ModbusCoil || b.table == ModbusDiscrete {
+		bits := unpackBits(data, b.count)
+		for _, sym := range b.symbols {
+			values[sym.name] = bits[sym.reg.Address-b.start]
+		}
+		return values, nil
+	}
+	for _, sym := range b.symbols {
+		off := 2 * int(sym.reg.Address-b.start)
+		v, err := sym.reg.decode(data[off:])
+		if err != nil {
+			return nil, err
+		}
+		values[sym.name] = v
+	}
+	return values, nil
+}
+
+// planReads groups symbols by unit and table and merges neighbouring
+// registers into blocks within the request size limits
+func (c *ModbusClient) planReads(symbols []modbusSymbol) []modbusBlock {
+	sort.Slice(symbols, func(i, j int) bool {
+		a, b := symbols[i].reg, symbols[j].reg
+		if a.Unit != b.Unit {
+			return a.Unit < b.Unit
+		}
+		if a.Table != b.Table {
+			return a.Table < b.Table
+		}
+		return a.Address < b.Address
+	})
+
+	var blocks []modbusBlock
+	for _, sym := range symbols {
+		reg := sym.reg
+		limit := modbusMaxReadRegisters
+		if reg.isBit() {
+			limit = modbusMaxReadBits
+		}
+		end := int(reg.Address) + reg.count()
+
+		if n := len(blocks); n > 0 {
+			b := &blocks[n-1]
+			blockEnd := int(b.start) + b.count
+			if b.unit == reg.Unit && b.table == reg.Table &&
+				int(reg.Address) <= blockEnd+c.maxGap && end-int(b.start) <= limit {
+				b.count = max(b.count, end-int(b.start))
+				b.symbols = append(b.symbols, sym)
+				continue
+			}
+		}
+		blocks = append(blocks, modbusBlock{
+			unit:    reg.Unit,
+			table:   reg.Table,
+			start:   reg.Address,
+			count:   reg.count(),
+			symbols: []modbusSymbol{sym},
+		})
+	}
+	return blocks
+}
+
+// ReadSymbol reads a single symbol
+func (c *ModbusClient) ReadSymbol(name string) (interface{}, error) {
+	sym, err := c.symbol(name)
+	if err != nil {
+		return nil, err
+	}
+	values, err := c.readBlock(modbusBlock{
+		unit:    sym.reg.Unit,
+		table:   sym.reg.Table,
+		start:   sym.reg.Address,
+		count:   sym.reg.count(),
+		symbols: []modbusSymbol{sym},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", name, err)
+	}
+	return values[sym.name], nil
+}
+
+// ReadSymbols reads all symbols with as few requests as possible. A block
+// the server rejects, e.g. because it spans an unmapped address, is read
+// again symbol by symbol. Symbols that cannot be read are left out of the
+// result; the error is only returned if nothing could be read.
+func (c *ModbusClient) ReadSymbols(names []string) (map[string]interface{}, error) {
+	results := make(map[string]interface{}, len(names))
+	var firstErr error
+
+	symbols := make([]modbusSymbol, 0, len(names))
+	for _, name := range names {
+		sym, err := c.symbol(name)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		symbols = append(symbols, sym)
+	}
+
+	for _, block := range c.planReads(symbols) {
+		values, err := c.readBlock(block)
+		if err != nil && len(block.symbols) > 1 && !isModbusTransportError(err) {
+			values, err = c.readEach(block)
+		}
+		if err != nil {
+			if isModbusTransportError(err) {
+				return nil, err
+			}
+			if firstErr == nil {
+				firstErr = err
+				if len(block.symbols) == 1 {
+					firstErr = fmt.Errorf("read %s: %w", block.symbols[0].name, err)
+				}
+			}
+			continue
+		}
+		for name, v := range values {
+			results[name] = v
+		}
+	}
+
+	if len(results) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return results, nil
+}
+
+// readEach reads the symbols of a block one by one
+func (c *ModbusClient) readEach(block modbusBlock) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(block.symbols))
+	var firstErr error
+	for _, sym := range block.symbols {
+		v, err := c.ReadSymbol(sym.name)
+		if err != nil {
+			if isModbusTransportError(err) {
+				return nil, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		values[sym.name] = v
+	}
+	if len(values) == 0 {
+		return nil, firstErr
+	}
+	return values, nil
+}
+
+func isModbusTransportError(err error) bool {
+	return errors.Is(err, ErrClientClosed) || errors.Is(err, ErrRequestTimeout)
+}
+
+// WriteSymbol writes a coil with function 5, a single register with
+// function 6 and wider values with function 16
+func (c *ModbusClient) WriteSymbol(name string, value interface{}) error {
+	sym, err := c.symbol(name)
+	if err != nil {
+		return err
+	}
+	reg := sym.reg
+	if !reg.writable() {
+		return fmt.Errorf("write %s: %s registers are read-only", name, reg.Table)
+	}
+
+	var pdu []byte
+	if reg.Table == ModbusCoil {
+		on, err := toBool(value)
+		if err != nil {
+			return fmt.Errorf("write %s: %w", name, err)
+		}
+		pdu = make([]byte, 5)
+		pdu[0] = modbusWriteCoil
+		binary.BigEndian.PutUint16(pdu[1:], reg.Address)
+		if on {
+			binary.BigEndian.PutUint16(pdu[3:], modbusCoilOn)
+		}
+	} else {
+		data, err := reg.encode(value)
+		if err != nil {
+			return fmt.Errorf("write %s: %w", name, err)
+		}
+		if len(data)/2 > modbusMaxWriteRegisters {
+			return fmt.Errorf("write %s: %d registers exceed the request limit", name, len(data)/2)
+		}
+		if len(data) == 2 {
+			pdu = append([]byte{modbusWriteRegister, 0, 0}, data...)
+			binary.BigEndian.PutUint16(pdu[1:], reg.Address)
+		} else {
+			pdu = make([]byte, 6, 6+len(data))
+			pdu[0] = modbusWriteRegisters
+			binary.BigEndian.PutUint16(pdu[1:], reg.Address)
+			binary.BigEndian.PutUint16(pdu[3:], uint16(len(data)/2))
+			pdu[5] = byte(len(data))
+			pdu = append(pdu, data...)
+		}
+	}
+
+	if _, err := c.request(reg.Unit, pdu); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return nil
+}
+
+// UploadSymbols builds the registry from the register map, Modbus has no
+// symbol table to upload. Scaled registers are LREAL; input registers and
+// discrete inputs are read-only.
+func (c *ModbusClient) UploadSymbols() (*SymbolRegistry, error) {
+	infos := make([]SymbolInfo, 0, len(c.registers))
+	for _, sym := range c.registers {
+		t := sym.reg.plcType()
+		size := 2 * sym.reg.count()
+		if sym.reg.isBit() {
+			size = 1
+		}
+		infos = append(infos, SymbolInfo{
+			Name:        sym.name,
+			Type:        t,
+			TypeName:    t.String(),
+			Size:        size,
+			IsWritable:  sym.reg.writable(),
+			IndexOffset: uint32(sym.reg.Address),
+		})
+	}
+	return NewSymbolRegistry(infos, nil), nil
+}
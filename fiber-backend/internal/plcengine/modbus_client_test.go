@@ -0,0 +1,239 @@
+package plcengine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModbusRegister_WordOrder(t *testing.T) {
+	tests := []struct {
+		order WordOrder
+		words []uint16
+	}{
+		{"", []uint16{0x1122, 0x3344}},
+		{WordOrderABCD, []uint16{0x1122, 0x3344}},
+		{WordOrderCDAB, []uint16{0x3344, 0x1122}},
+		{WordOrderBADC, []uint16{0x2211, 0x4433}},
+		{WordOrderDCBA, []uint16{0x4433, 0x2211}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.order), func(t *testing.T) {
+			reg := ModbusRegister{Table: ModbusHolding, Type: "int32", WordOrder: tt.order}
+			b := []byte{byte(tt.words[0] >> 8), byte(tt.words[0]), byte(tt.words[1] >> 8), byte(tt.words[1])}
+
+			v, err := reg.decode(b)
+			require.NoError(t, err)
+			assert.Equal(t, int32(0x11223344), v)
+
+			enc, err := reg.encode(int32(0x11223344))
+			require.NoError(t, err)
+			assert.Equal(t, b, enc)
+		})
+	}
+}
+
+func TestModbusRegister_Scaling(t *testing.T) {
+	reg := ModbusRegister{Table: ModbusInput, Type: "int16", Scale: 0.1, Offset: -40}
+	assert.Equal(t, TypeLReal, reg.plcType())
+
+	v, err := reg.decode([]byte{0x02, 0x8A}) // 650
+	require.NoError(t, err)
+	assert.InDelta(t, 25.0, v, 1e-9)
+
+	b, err := reg.encode(25.04)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x02, 0x8A}, b, "raw values are rounded")
+
+	_, err = reg.encode(4000.0)
+	assert.ErrorContains(t, err, "overflows")
+
+	assert.Error(t, ModbusRegister{Table: ModbusHolding, Type: "bool"}.Validate())
+	assert.Error(t, ModbusRegister{Table: ModbusCoil, Type: "int16"}.Validate())
+	assert.Error(t, ModbusRegister{Table: ModbusHolding, Type: "string"}.Validate())
+	assert.Error(t, ModbusRegister{Table: "memory"}.Validate())
+	assert.NoError(t, ModbusRegister{Table: ModbusHolding, Type: "string", Length: 8}.Validate())
+}
+
+func chillerRegisters() map[string]ModbusRegister {
+	return map[string]ModbusRegister{
+		"Chiller.Setpoint": {Table: ModbusHolding, Address: 0, Type: "int16", Scale: 0.1},
+		"Chiller.Mode":     {Table: ModbusHolding, Address: 1},
+		"Chiller.Flow":     {Table: ModbusHolding, Address: 2, Type: "float32", WordOrder: WordOrderCDAB},
+		"Chiller.Hours":    {Table: ModbusHolding, Address: 10, Type: "uint32"},
+		"Chiller.Model":    {Table: ModbusHolding, Address: 200, Type: "string", Length: 6},
+		"Chiller.Supply":   {Table: ModbusInput, Address: 0, Type: "int16", Scale: 0.01},
+		"Chiller.Return":   {Table: ModbusInput, Address: 1, Type: "int16", Scale: 0.01},
+		"Chiller.Run":      {Table: ModbusCoil, Address: 0},
+		"Chiller.Remote":   {Table: ModbusCoil, Address: 5},
+		"Chiller.Alarm":    {Table: ModbusDiscrete, Address: 3},
+		"Pump.Speed":       {Table: ModbusHolding, Address: 298},
+		"Pump.Pressure":    {Table: ModbusHolding, Address: 301},
+		"RF.ForwardPower":  {Table: ModbusInput, Address: 0, Unit: 7},
+		"Chiller.Missing":  {Table: ModbusHolding, Address: 999},
+	}
+}
+
+func newChillerServer(t *testing.T) *fakeModbusServer {
+	s := newFakeModbusServer(t)
+	s.setRegisters(ModbusHolding, 0, 185, 2, 0x0000, 0x4148)   // 18.5, 2, 12.5 low word first
+	s.setRegisters(ModbusHolding, 10, 0x0001, 0x86A0)          // 100000
+	s.setRegisters(ModbusHolding, 200, 0x5443, 0x552D, 0x3400) // "TCU-4"
+	s.setRegisters(ModbusHolding, 298, 1450)
+	s.setRegisters(ModbusHolding, 301, 72)
+	s.setRegisters(ModbusInput, 0, 1850, 2230)
+	s.setBit(ModbusCoil, 0, true)
+	s.setBit(ModbusDiscrete, 3, true)
+	s.invalid[300] = true // between the pump registers
+	s.invalid[999] = true
+	return s
+}
+
+func TestModbusClient_ReadSymbols(t *testing.T) {
+	s := newChillerServer(t)
+	c := s.client(t, chillerRegisters())
+
+	v, err := c.ReadSymbol("chiller.setpoint")
+	require.NoError(t, err)
+	assert.InDelta(t, 18.5, v, 1e-9)
+
+	values, err := c.ReadSymbols([]string{
+		"Chiller.Setpoint", "Chiller.Mode", "Chiller.Flow", "Chiller.Hours", "Chiller.Model",
+		"Chiller.Supply", "Chiller.Return", "Chiller.Run", "Chiller.Remote", "Chiller.Alarm",
+		"Chiller.Missing", "Chiller.Unknown",
+	})
+	require.NoError(t, err)
+	assert.InDelta(t, 18.5, values["Chiller.Setpoint"], 1e-9)
+	assert.Equal(t, uint16(2), values["Chiller.Mode"])
+	assert.Equal(t, float32(12.5), values["Chiller.Flow"])
+	assert.Equal(t, uint32(100000), values["Chiller.Hours"])
+	assert.Equal(t, "TCU-4", values["Chiller.Model"])
+	assert.InDelta(t, 18.5, values["Chiller.Supply"], 1e-9)
+	assert.InDelta(t, 22.3, values["Chiller.Return"], 1e-9)
+	assert.Equal(t, true, values["Chiller.Run"])
+	assert.Equal(t, false, values["Chiller.Remote"])
+	assert.Equal(t, true, values["Chiller.Alarm"])
+	assert.NotContains(t, values, "Chiller.Missing")
+	assert.NotContains(t, values, "Chiller.Unknown")
+
+	// Holding 0-11, 200-202 and 999; input 0-1; coils 0-5; discrete 3
+	assert.Equal(t, 1+3, s.callCount(modbusReadHolding))
+	assert.Equal(t, 1, s.callCount(modbusReadInput))
+	assert.Equal(t, 1, s.callCount(modbusReadCoils))
+	assert.Equal(t, 1, s.callCount(modbusReadDiscrete))
+
+	// A block spanning an invalid address is read again symbol by symbol
+	values, err = c.ReadSymbols([]string{"Pump.Speed", "Pump.Pressure"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"Pump.Speed": uint16(1450), "Pump.Pressure": uint16(72)}, values)
+	assert.Equal(t, 4+3, s.callCount(modbusReadHolding))
+
+	_, err = c.ReadSymbols([]string{"Chiller.Missing"})
+	assert.True(t, IsModbusError(err, ModbusIllegalDataAddress), err)
+	_, err = c.ReadSymbol("RF.ForwardPower")
+	assert.True(t, IsModbusError(err, ModbusGatewayTargetFailed), err)
+	_, err = c.ReadSymbol("Chiller.Unknown")
+	assert.ErrorContains(t, err, "no Modbus register mapped")
+}
+
+func TestModbusClient_Write(t *testing.T) {
+	s := newChillerServer(t)
+	c := s.client(t, chillerRegisters())
+
+	require.NoError(t, c.WriteSymbol("Chiller.Setpoint", 21.5))
+	assert.Equal(t, []uint16{215}, s.registers(0, 1))
+	require.NoError(t, c.WriteSymbol("Chiller.Flow", float32(8.25)))
+	assert.Equal(t, []uint16{0x0000, 0x4104}, s.registers(2, 2))
+	require.NoError(t, c.WriteSymbol("Chiller.Remote", true))
+	assert.True(t, s.coil(5))
+	require.NoError(t, c.WriteSymbol("Chiller.Model", "TCU-6"))
+	v, err := c.ReadSymbol("Chiller.Model")
+	require.NoError(t, err)
+	assert.Equal(t, "TCU-6", v)
+
+	assert.Equal(t, 1, s.callCount(modbusWriteRegister))
+	assert.Equal(t, 2, s.callCount(modbusWriteRegisters))
+	assert.Equal(t, 1, s.callCount(modbusWriteCoil))
+
+	assert.ErrorContains(t, c.WriteSymbol("Chiller.Supply", 20.0), "read-only")
+	assert.ErrorContains(t, c.WriteSymbol("Chiller.Mode", 70000), "overflows")
+	err = c.WriteSymbol("Chiller.Missing", 1)
+	assert.True(t, IsModbusError(err, ModbusIllegalDataAddress), err)
+}
+
+func TestModbusClient_UploadSymbols(t *testing.T) {
+	s := newChillerServer(t)
+	c := s.client(t, chillerRegisters())
+
+	reg, err := c.UploadSymbols()
+	require.NoError(t, err)
+	sym, ok := reg.Symbol("chiller.setpoint")
+	require.True(t, ok)
+	assert.Equal(t, TypeLReal, sym.Type)
+	assert.True(t, sym.IsWritable)
+	sym, ok = reg.Symbol("Chiller.Flow")
+	require.True(t, ok)
+	assert.Equal(t, TypeReal, sym.Type)
+	sym, ok = reg.Symbol("Chiller.Alarm")
+	require.True(t, ok)
+	assert.Equal(t, TypeBool, sym.Type)
+	assert.False(t, sym.IsWritable)
+}
+
+func TestModbusClient_ConnectionLoss(t *testing.T) {
+	s := newChillerServer(t)
+	c := s.client(t, chillerRegisters())
+	_, err := c.ReadSymbol("Chiller.Mode")
+	require.NoError(t, err)
+
+	s.dropConnections()
+	_, err = c.ReadSymbol("Chiller.Mode")
+	assert.ErrorIs(t, err, ErrClientClosed)
+	assert.True(t, c.Closed())
+}
+
+func TestEngine_Modbus(t *testing.T) {
+	s := newChillerServer(t)
+
+	e := NewEngine(nil)
+	e.ReconnectPolicy = ReconnectPolicy{InitialBackoff: 10 * time.Millisecond, HealthInterval: 10 * time.Millisecond}
+	require.NoError(t, e.Start([]MachineConfig{{
+		ID:        "chiller1",
+		IP:        "127.0.0.1",
+		Port:      s.Port(),
+		Protocol:  ProtocolModbus,
+		Registers: chillerRegisters(),
+		Symbols:   []SymbolInfo{{Name: "Chiller.Setpoint", IsWritable: true, MinValue: 5, MaxValue: 35}},
+	}}))
+	defer e.Stop()
+
+	require.Eventually(t, func() bool {
+		conn, err := e.getConnection("chiller1")
+		return err == nil && conn.Symbols() != nil
+	}, time.Second, 5*time.Millisecond)
+
+	values, err := e.ReadSymbols("chiller1", []string{"Chiller.Setpoint", "Chiller.Supply", "Chiller.Run"})
+	require.NoError(t, err)
+	assert.InDelta(t, 18.5, values["Chiller.Setpoint"].Value, 1e-9)
+	assert.Equal(t, TypeLReal, values["Chiller.Setpoint"].Type)
+	assert.Equal(t, QualityGood, values["Chiller.Supply"].Quality)
+	assert.Equal(t, true, values["Chiller.Run"].Value)
+
+	// Writes go through the guards and the prioritized writer
+	resp := <-e.WriteAsync(WriteRequest{ID: "w1", MachineID: "chiller1", Symbol: "Chiller.Setpoint", Value: 22.0, RequireAck: true})
+	require.True(t, resp.Success, resp.Error)
+	assert.Equal(t, []uint16{220}, s.registers(0, 1))
+	resp = <-e.WriteAsync(WriteRequest{ID: "w2", MachineID: "chiller1", Symbol: "Chiller.Setpoint", Value: 80.0})
+	require.NotNil(t, resp.Rejection)
+	assert.Equal(t, RejectOutOfRange, resp.Rejection.Reason)
+
+	// The connection comes back after the server dropped it
+	s.dropConnections()
+	require.Eventually(t, func() bool {
+		v, err := e.ReadSymbol("chiller1", "Chiller.Mode")
+		return err == nil && v.Value == uint16(2)
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, e.GetStatus()["chiller1"].ReconnectCount, 2)
+}
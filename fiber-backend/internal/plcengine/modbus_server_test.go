@@ -0,0 +1,235 @@
+package plcengine
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+// fakeModbusServer is an in-process Modbus TCP server with 1000 entries in
+// every table. Addresses in invalid answer with an illegal data address
+// exception, as unmapped registers of real devices do.
+type fakeModbusServer struct {
+	t  *testing.T
+	ln net.Listener
+
+	mu       sync.Mutex
+	holding  []uint16
+	input    []uint16
+	coils    []bool
+	discrete []bool
+	invalid  map[uint16]bool // holding and input register addresses
+	units    map[byte]bool   // unit IDs that answer, others get a gateway exception
+	calls    map[byte]int    // requests per function code
+	conns    []net.Conn
+}
+
+const fakeModbusSize = 1000
+
+func newFakeModbusServer(t *testing.T) *fakeModbusServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &fakeModbusServer{
+		t:        t,
+		ln:       ln,
+		holding:  make([]uint16, fakeModbusSize),
+		input:    make([]uint16, fakeModbusSize),
+		coils:    make([]bool, fakeModbusSize),
+		discrete: make([]bool, fakeModbusSize),
+		invalid:  make(map[uint16]bool),
+		units:    map[byte]bool{1: true},
+		calls:    make(map[byte]int),
+	}
+	go s.accept()
+	t.Cleanup(s.close)
+	return s
+}
+
+func (s *fakeModbusServer) Address() string { return s.ln.Addr().String() }
+
+func (s *fakeModbusServer) Port() int { return s.ln.Addr().(*net.TCPAddr).Port }
+
+func (s *fakeModbusServer) client(t *testing.T, registers map[string]ModbusRegister) *ModbusClient {
+	t.Helper()
+	c, err := NewModbusClient(ModbusConfig{Address: s.Address(), Registers: registers})
+	if err != nil {
+		t.Fatalf("connect fake Modbus server: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// setRegisters stores words in the holding (or input) registers from addr
+func (s *fakeModbusServer) setRegisters(table ModbusTable, addr uint16, words ...uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	regs := s.holding
+	if table == ModbusInput {
+		regs = s.input
+	}
+	copy(regs[addr:], words)
+}
+
+func (s *fakeModbusServer) registers(addr uint16, n int) []uint16 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]uint16(nil), s.holding[addr:int(addr)+n]...)
+}
+
+func (s *fakeModbusServer) setBit(table ModbusTable, addr uint16, on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if table == ModbusDiscrete {
+		s.discrete[addr] = on
+		return
+	}
+	s.coils[addr] = on
+}
+
+func (s *fakeModbusServer) coil(addr uint16) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.coils[addr]
+}
+
+func (s *fakeModbusServer) callCount(fc byte) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[fc]
+}
+
+// dropConnections closes every client connection, as a lost network would
+func (s *fakeModbusServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeModbusServer) close() {
+	s.ln.Close()
+	s.dropConnections()
+}
+
+func (s *fakeModbusServer) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.serve(conn)
+	}
+}
+
+func (s *fakeModbusServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		hdr := make([]byte, modbusMBAPLen)
+		if _, err := io.ReadFull(conn, hdr); err != nil {
+			return
+		}
+		pdu := make([]byte, binary.BigEndian.Uint16(hdr[4:])-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+		resp := s.handle(hdr[6], pdu)
+		if _, err := conn.Write(modbusFrame(binary.BigEndian.Uint16(hdr), hdr[6], resp)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeModbusServer) handle(unit byte, pdu []byte) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fc := pdu[0]
+	s.calls[fc]++
+	exception := func(code byte) []byte { return []byte{fc | modbusExceptionFlag, code} }
+
+	if !s.units[unit] {
+		return exception(ModbusGatewayTargetFailed)
+	}
+	if len(pdu) < 5 {
+		return exception(ModbusIllegalDataValue)
+	}
+	addr := binary.BigEndian.Uint16(pdu[1:])
+	arg := binary.BigEndian.Uint16(pdu[3:])
+	inRange := func(n int) bool {
+		if int(addr)+n > fakeModbusSize {
+			return false
+		}
+		for a := int(addr); a < int(addr)+n; a++ {
+			if s.invalid[uint16(a)] {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch fc {
+	case modbusReadCoils, modbusReadDiscrete:
+		bits := s.coils
+		if fc == modbusReadDiscrete {
+			bits = s.discrete
+		}
+		if arg == 0 || arg > modbusMaxReadBits || int(addr)+int(arg) > fakeModbusSize {
+			return exception(ModbusIllegalDataAddress)
+		}
+		data := packBits(bits[addr : addr+arg])
+		return append([]byte{fc, byte(len(data))}, data...)
+
+	case modbusReadHolding, modbusReadInput:
+		regs := s.holding
+		if fc == modbusReadInput {
+			regs = s.input
+		}
+		if arg == 0 || arg > modbusMaxReadRegisters || !inRange(int(arg)) {
+			return exception(ModbusIllegalDataAddress)
+		}
+		resp := []byte{fc, byte(2 * arg)}
+		for _, w := range regs[addr : addr+arg] {
+			resp = binary.BigEndian.AppendUint16(resp, w)
+		}
+		return resp
+
+	case modbusWriteCoil:
+		if int(addr) >= fakeModbusSize {
+			return exception(ModbusIllegalDataAddress)
+		}
+		if arg != 0 && arg != modbusCoilOn {
+			return exception(ModbusIllegalDataValue)
+		}
+		s.coils[addr] = arg == modbusCoilOn
+		return pdu
+
+	case modbusWriteRegister:
+		if !inRange(1) {
+			return exception(ModbusIllegalDataAddress)
+		}
+		s.holding[addr] = arg
+		return pdu
+
+	case modbusWriteRegisters:
+		if len(pdu) < 6 || int(pdu[5]) != 2*int(arg) || len(pdu)-6 != 2*int(arg) {
+			return exception(ModbusIllegalDataValue)
+		}
+		if !inRange(int(arg)) {
+			return exception(ModbusIllegalDataAddress)
+		}
+		for i := 0; i < int(arg); i++ {
+			s.holding[int(addr)+i] = binary.BigEndian.Uint16(pdu[6+2*i:])
+		}
+		return pdu[:5]
+	}
+	return exception(ModbusIllegalFunction)
+}
@@ -0,0 +1,925 @@
+package plcengine
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OPCUAPort is the default TCP port of OPC UA servers
+const OPCUAPort = 4840
+
+// OPC UA TCP message types, see OPC 10000-6 7.1.2
+const (
+	uaMsgHello = "HEL"
+	uaMsgAck   = "ACK"
+	uaMsgError = "ERR"
+	uaMsgOpen  = "OPN"
+	uaMsgClose = "CLO"
+	uaMsg      = "MSG"
+
+	uaChunkFinal = 'F'
+	uaChunkMore  = 'C'
+	uaChunkAbort = 'A'
+
+	uaHeaderLen       = 8
+	uaSecurityNone    = "http://opcfoundation.org/UA/SecurityPolicy#None"
+	uaMessageSecNone  = 1
+	uaBufferSize      = 1 << 16
+	uaMaxMessageSize  = 16 << 20
+	uaAttrValue       = 13
+	uaAttrDataType    = 14
+	uaAttrValueRank   = 15
+	uaAttrAccessLevel = 17
+	uaAccessWrite     = 0x02
+	uaTimestampsBoth  = 2
+	uaBrowseForward   = 0
+	uaResultMaskAll   = 0x3F
+	uaMonitorReport   = 2
+)
+
+// Binary encoding IDs of the services and structures in namespace 0
+const (
+	uaIDServiceFault                = 397
+	uaIDOpenSecureChannelRequest    = 446
+	uaIDOpenSecureChannelResponse   = 449
+	uaIDCloseSecureChannelRequest   = 452
+	uaIDCreateSessionRequest        = 461
+	uaIDCreateSessionResponse       = 464
+	uaIDActivateSessionRequest      = 467
+	uaIDActivateSessionResponse     = 470
+	uaIDCloseSessionRequest         = 473
+	uaIDCloseSessionResponse        = 476
+	uaIDAnonymousIdentityToken      = 321
+	uaIDBrowseRequest               = 527
+	uaIDBrowseResponse              = 530
+	uaIDBrowseNextRequest           = 533
+	uaIDBrowseNextResponse          = 536
+	uaIDReadRequest                 = 631
+	uaIDReadResponse                = 634
+	uaIDWriteRequest                = 673
+	uaIDWriteResponse               = 676
+	uaIDCreateMonitoredItemsRequest = 751
+	uaIDCreateMonitoredItemsResp    = 754
+	uaIDDeleteMonitoredItemsRequest = 781
+	uaIDDeleteMonitoredItemsResp    = 784
+	uaIDCreateSubscriptionRequest   = 787
+	uaIDCreateSubscriptionResponse  = 790
+	uaIDDataChangeNotification      = 811
+	uaIDPublishRequest              = 826
+	uaIDPublishResponse             = 829
+	uaIDDeleteSubscriptionsRequest  = 847
+	uaIDDeleteSubscriptionsResponse = 850
+
+	uaIDObjectsFolder          = 85
+	uaIDHierarchicalReferences = 33
+)
+
+// OPC UA node classes
+const (
+	UANodeClassObject   uint32 = 1
+	UANodeClassVariable uint32 = 2
+	UANodeClassMethod   uint32 = 4
+)
+
+// OPC UA status codes returned by the client
+const (
+	UAStatusGood                     uint32 = 0
+	UAStatusBadUnexpectedError       uint32 = 0x80010000
+	UAStatusBadInternalError         uint32 = 0x80020000
+	UAStatusBadCommunicationError    uint32 = 0x80050000
+	UAStatusBadEncodingError         uint32 = 0x80060000
+	UAStatusBadDecodingError         uint32 = 0x80070000
+	UAStatusBadTimeout               uint32 = 0x800A0000
+	UAStatusBadServiceUnsupported    uint32 = 0x800B0000
+	UAStatusBadNothingToDo           uint32 = 0x800F0000
+	UAStatusBadTooManyOperations     uint32 = 0x80100000
+	UAStatusBadUserAccessDenied      uint32 = 0x801F0000
+	UAStatusBadIdentityTokenInvalid  uint32 = 0x80200000
+	UAStatusBadSecureChannelInvalid  uint32 = 0x80220000
+	UAStatusBadSessionIDInvalid      uint32 = 0x80250000
+	UAStatusBadSessionClosed         uint32 = 0x80260000
+	UAStatusBadSessionNotActivated   uint32 = 0x80270000
+	UAStatusBadSubscriptionIDInvalid uint32 = 0x80280000
+	UAStatusBadNodeIDInvalid         uint32 = 0x80330000
+	UAStatusBadNodeIDUnknown         uint32 = 0x80340000
+	UAStatusBadAttributeIDInvalid    uint32 = 0x80350000
+	UAStatusBadNotReadable           uint32 = 0x803A0000
+	UAStatusBadNotWritable           uint32 = 0x803B0000
+	UAStatusBadOutOfRange            uint32 = 0x803C0000
+	UAStatusBadMonitoredItemInvalid  uint32 = 0x80420000
+	UAStatusBadTypeMismatch          uint32 = 0x80740000
+	UAStatusBadNoSubscription        uint32 = 0x80790000
+	UAStatusBadTCPMessageInvalid     uint32 = 0x807E0000
+)
+
+var uaStatusText = map[uint32]string{
+	UAStatusBadUnexpectedError:       "BadUnexpectedError",
+	UAStatusBadInternalError:         "BadInternalError",
+	UAStatusBadCommunicationError:    "BadCommunicationError",
+	UAStatusBadEncodingError:         "BadEncodingError",
+	UAStatusBadDecodingError:         "BadDecodingError",
+	UAStatusBadTimeout:               "BadTimeout",
+	UAStatusBadServiceUnsupported:    "BadServiceUnsupported",
+	UAStatusBadNothingToDo:           "BadNothingToDo",
+	UAStatusBadTooManyOperations:     "BadTooManyOperations",
+	UAStatusBadUserAccessDenied:      "BadUserAccessDenied",
+	UAStatusBadIdentityTokenInvalid:  "BadIdentityTokenInvalid",
+	UAStatusBadSecureChannelInvalid:  "BadSecureChannelIdInvalid",
+	UAStatusBadSessionIDInvalid:      "BadSessionIdInvalid",
+	UAStatusBadSessionClosed:         "BadSessionClosed",
+	UAStatusBadSessionNotActivated:   "BadSessionNotActivated",
+	UAStatusBadSubscriptionIDInvalid: "BadSubscriptionIdInvalid",
+	UAStatusBadNodeIDInvalid:         "BadNodeIdInvalid",
+	UAStatusBadNodeIDUnknown:         "BadNodeIdUnknown",
+	UAStatusBadAttributeIDInvalid:    "BadAttributeIdInvalid",
+	UAStatusBadNotReadable:           "BadNotReadable",
+	UAStatusBadNotWritable:           "BadNotWritable",
+	UAStatusBadOutOfRange:            "BadOutOfRange",
+	UAStatusBadMonitoredItemInvalid:  "BadMonitoredItemIdInvalid",
+	UAStatusBadTypeMismatch:          "BadTypeMismatch",
+	UAStatusBadNoSubscription:        "BadNoSubscription",
+	UAStatusBadTCPMessageInvalid:     "BadTcpMessageTypeInvalid",
+}
+
+// UAError is a bad OPC UA status code
+type UAError struct {
+	Code uint32
+}
+
+func (e *UAError) Error() string {
+	if text, ok := uaStatusText[e.Code]; ok {
+		return fmt.Sprintf("OPC UA status 0x%08X: %s", e.Code, text)
+	}
+	return fmt.Sprintf("OPC UA status 0x%08X", e.Code)
+}
+
+// IsUAError reports whether err is the OPC UA status code
+func IsUAError(err error, code uint32) bool {
+	var uaErr *UAError
+	return errors.As(err, &uaErr) && uaErr.Code == code
+}
+
+// uaErr returns nil for good and uncertain status codes
+func uaErr(code uint32) error {
+	if code&0x80000000 == 0 {
+		return nil
+	}
+	return &UAError{Code: code}
+}
+
+// NodeID identifies an OPC UA node, written as in the OPC UA XML notation,
+// e.g. "ns=2;s=Chamber1.Temperature", "i=85" or "ns=1;g=<guid>"
+type NodeID struct {
+	Namespace uint16
+	Kind      byte   // 'i' numeric, 's' string, 'g' GUID, 'b' opaque
+	Numeric   uint32 // for 'i'
+	Text      string // for 's'
+	Bytes     []byte // for 'g' (16 bytes) and 'b'
+}
+
+// NumericNodeID returns the numeric node ID i in namespace ns
+func NumericNodeID(ns uint16, i uint32) NodeID {
+	return NodeID{Namespace: ns, Kind: 'i', Numeric: i}
+}
+
+// ParseNodeID parses the XML notation of a node ID
+func ParseNodeID(s string) (NodeID, error) {
+	var id NodeID
+	rest := strings.TrimSpace(s)
+	if strings.HasPrefix(rest, "ns=") {
+		sep := strings.IndexByte(rest, ';')
+		if sep < 0 {
+			return id, fmt.Errorf("invalid node ID %q", s)
+		}
+		ns, err := strconv.ParseUint(rest[3:sep], 10, 16)
+		if err != nil {
+			return id, fmt.Errorf("invalid node ID %q: %w", s, err)
+		}
+		id.Namespace = uint16(ns)
+		rest = rest[sep+1:]
+	}
+	if len(rest) < 2 || rest[1] != '=' {
+		return id, fmt.Errorf("invalid node ID %q", s)
+	}
+	id.Kind, rest = rest[0], rest[2:]
+	switch id.Kind {
+	case 'i':
+		n, err := strconv.ParseUint(rest, 10, 32)
+		if err != nil {
+			return id, fmt.Errorf("invalid node ID %q: %w", s, err)
+		}
+		id.Numeric = uint32(n)
+	case 's':
+		id.Text = rest
+	case 'g':
+		b, err := hex.DecodeString(strings.ReplaceAll(rest, "-", ""))
+		if err != nil || len(b) != 16 {
+			return id, fmt.Errorf("invalid GUID in node ID %q", s)
+		}
+		id.Bytes = b
+	case 'b':
+		b, err := base64.StdEncoding.DecodeString(rest)
+		if err != nil {
+			return id, fmt.Errorf("invalid node ID %q: %w", s, err)
+		}
+		id.Bytes = b
+	default:
+		return id, fmt.Errorf("invalid node ID %q", s)
+	}
+	return id, nil
+}
+
+func (id NodeID) String() string {
+	var value string
+	switch id.Kind {
+	case 's':
+		value = id.Text
+	case 'g':
+		h := hex.EncodeToString(id.Bytes)
+		if len(h) == 32 {
+			h = h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+		}
+		value = h
+	case 'b':
+		value = base64.StdEncoding.EncodeToString(id.Bytes)
+	default:
+		return fmt.Sprintf("%si=%d", nsPrefix(id.Namespace), id.Numeric)
+	}
+	return fmt.Sprintf("%s%c=%s", nsPrefix(id.Namespace), id.Kind, value)
+}
+
+func nsPrefix(ns uint16) string {
+	if ns == 0 {
+		return ""
+	}
+	return fmt.Sprintf("ns=%d;", ns)
+}
+
+// isNull reports whether id is the null node ID i=0
+func (id NodeID) isNull() bool {
+	return id.Namespace == 0 && (id.Kind == 0 || id.Kind == 'i') && id.Numeric == 0
+}
+
+// Variant type IDs, see OPC 10000-6 5.1.2
+const (
+	uaTypeBoolean         byte = 1
+	uaTypeSByte           byte = 2
+	uaTypeByte            byte = 3
+	uaTypeInt16           byte = 4
+	uaTypeUInt16          byte = 5
+	uaTypeInt32           byte = 6
+	uaTypeUInt32          byte = 7
+	uaTypeInt64           byte = 8
+	uaTypeUInt64          byte = 9
+	uaTypeFloat           byte = 10
+	uaTypeDouble          byte = 11
+	uaTypeString          byte = 12
+	uaTypeDateTime        byte = 13
+	uaTypeGUID            byte = 14
+	uaTypeByteString      byte = 15
+	uaTypeXMLElement      byte = 16
+	uaTypeNodeID          byte = 17
+	uaTypeExpandedNodeID  byte = 18
+	uaTypeStatusCode      byte = 19
+	uaTypeQualifiedName   byte = 20
+	uaTypeLocalizedText   byte = 21
+	uaTypeExtensionObject byte = 22
+	uaTypeDataValue       byte = 23
+	uaTypeVariant         byte = 24
+	uaTypeDiagnosticInfo  byte = 25
+)
+
+// uaTypePLC maps the built-in scalar types to PLC types. The DataType
+// attribute of a variable uses the same numbers as node IDs in namespace 0.
+var uaTypePLC = map[byte]PLCType{
+	uaTypeBoolean: TypeBool,
+	uaTypeSByte:   TypeInt8,
+	uaTypeByte:    TypeUInt8,
+	uaTypeInt16:   TypeInt16,
+	uaTypeUInt16:  TypeUInt16,
+	uaTypeInt32:   TypeInt32,
+	uaTypeUInt32:  TypeUInt32,
+	uaTypeInt64:   TypeInt64,
+	uaTypeUInt64:  TypeUInt64,
+	uaTypeFloat:   TypeReal,
+	uaTypeDouble:  TypeLReal,
+	uaTypeString:  TypeString,
+}
+
+// uaTypeOf returns the variant type of a Go value, 0 if there is none
+func uaTypeOf(v interface{}) byte {
+	switch v.(type) {
+	case bool:
+		return uaTypeBoolean
+	case int8:
+		return uaTypeSByte
+	case uint8:
+		return uaTypeByte
+	case int16:
+		return uaTypeInt16
+	case uint16:
+		return uaTypeUInt16
+	case int32:
+		return uaTypeInt32
+	case uint32:
+		return uaTypeUInt32
+	case int64, int:
+		return uaTypeInt64
+	case uint64, uint:
+		return uaTypeUInt64
+	case float32:
+		return uaTypeFloat
+	case float64:
+		return uaTypeDouble
+	case string:
+		return uaTypeString
+	case time.Time:
+		return uaTypeDateTime
+	case []byte:
+		return uaTypeByteString
+	}
+	return 0
+}
+
+// uaCoerce converts v to the Go type of variant type t
+func uaCoerce(t byte, v interface{}) (interface{}, error) {
+	if plc, ok := uaTypePLC[t]; ok {
+		return coerceValue(plc, v)
+	}
+	switch t {
+	case uaTypeDateTime:
+		if ts, ok := v.(time.Time); ok {
+			return ts, nil
+		}
+		if s, ok := v.(string); ok {
+			return time.Parse(time.RFC3339Nano, s)
+		}
+	case uaTypeByteString:
+		if b, ok := v.([]byte); ok {
+			return b, nil
+		}
+	case uaTypeNodeID:
+		switch id := v.(type) {
+		case NodeID:
+			return id, nil
+		case string:
+			return ParseNodeID(id)
+		}
+	}
+	return nil, fmt.Errorf("cannot write %T as OPC UA type %d", v, t)
+}
+
+// uaDataValue is a value with its status and timestamps
+type uaDataValue struct {
+	Value           interface{}
+	Type            byte // variant type of Value
+	Status          uint32
+	SourceTimestamp time.Time
+	ServerTimestamp time.Time
+}
+
+// uaWriter appends OPC UA binary encoded values
+type uaWriter struct {
+	b []byte
+}
+
+func (w *uaWriter) byte(v byte) { w.b = append(w.b, v) }
+func (w *uaWriter) bool(v bool) {
+	if v {
+		w.byte(1)
+	} else {
+		w.byte(0)
+	}
+}
+func (w *uaWriter) uint16(v uint16) { w.b = binary.LittleEndian.AppendUint16(w.b, v) }
+func (w *uaWriter) uint32(v uint32) { w.b = binary.LittleEndian.AppendUint32(w.b, v) }
+func (w *uaWriter) int32(v int32)   { w.uint32(uint32(v)) }
+func (w *uaWriter) uint64(v uint64) { w.b = binary.LittleEndian.AppendUint64(w.b, v) }
+func (w *uaWriter) float64(v float64) {
+	w.uint64(math.Float64bits(v))
+}
+
+func (w *uaWriter) string(s string) {
+	w.int32(int32(len(s)))
+	w.b = append(w.b, s...)
+}
+
+// nullString writes an empty string as null
+func (w *uaWriter) nullString(s string) {
+	if s == "" {
+		w.int32(-1)
+		return
+	}
+	w.string(s)
+}
+
+func (w *uaWriter) bytes(b []byte) {
+	if b == nil {
+		w.int32(-1)
+		return
+	}
+	w.int32(int32(len(b)))
+	w.b = append(w.b, b...)
+}
+
+func (w *uaWriter) dateTime(t time.Time) {
+	if t.IsZero() {
+		w.uint64(0)
+		return
+	}
+	w.uint64(uint64(t.UnixNano()/100) + filetimeEpochDiff)
+}
+
+func (w *uaWriter) guid(b []byte) {
+	// Data1-3 are little endian, Data4 is a byte array
+	w.uint32(binary.BigEndian.Uint32(b[0:4]))
+	w.uint16(binary.BigEndian.Uint16(b[4:6]))
+	w.uint16(binary.BigEndian.Uint16(b[6:8]))
+	w.b = append(w.b, b[8:16]...)
+}
+
+func (w *uaWriter) nodeID(id NodeID) {
+	switch id.Kind {
+	case 's':
+		w.byte(0x03)
+		w.uint16(id.Namespace)
+		w.string(id.Text)
+	case 'g':
+		w.byte(0x04)
+		w.uint16(id.Namespace)
+		w.guid(id.Bytes)
+	case 'b':
+		w.byte(0x05)
+		w.uint16(id.Namespace)
+		w.bytes(id.Bytes)
+	default:
+		switch {
+		case id.Namespace == 0 && id.Numeric <= 0xFF:
+			w.byte(0x00)
+			w.byte(byte(id.Numeric))
+		case id.Namespace <= 0xFF && id.Numeric <= 0xFFFF:
+			w.byte(0x01)
+			w.byte(byte(id.Namespace))
+			w.uint16(uint16(id.Numeric))
+		default:
+			w.byte(0x02)
+			w.uint16(id.Namespace)
+			w.uint32(id.Numeric)
+		}
+	}
+}
+
+func (w *uaWriter) qualifiedName(ns uint16, name string) {
+	w.uint16(ns)
+	w.nullString(name)
+}
+
+func (w *uaWriter) localizedText(text string) {
+	if text == "" {
+		w.byte(0)
+		return
+	}
+	w.byte(0x02)
+	w.string(text)
+}
+
+// extensionObject writes body as a binary encoded structure of type id
+func (w *uaWriter) extensionObject(id uint32, body []byte) {
+	if body == nil {
+		w.nodeID(NodeID{})
+		w.byte(0)
+		return
+	}
+	w.nodeID(NumericNodeID(0, id))
+	w.byte(0x01)
+	w.bytes(body)
+}
+
+func (w *uaWriter) variant(t byte, v interface{}) error {
+	if arr, ok := v.([]interface{}); ok {
+		w.byte(t | 0x80)
+		w.int32(int32(len(arr)))
+		for _, e := range arr {
+			if err := w.scalar(t, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	w.byte(t)
+	return w.scalar(t, v)
+}
+
+func (w *uaWriter) scalar(t byte, v interface{}) error {
+	v, err := uaCoerce(t, v)
+	if err != nil {
+		return err
+	}
+	switch x := v.(type) {
+	case bool:
+		w.bool(x)
+	case int8:
+		w.byte(byte(x))
+	case uint8:
+		w.byte(x)
+	case int16:
+		w.uint16(uint16(x))
+	case uint16:
+		w.uint16(x)
+	case int32:
+		w.int32(x)
+	case uint32:
+		w.uint32(x)
+	case int64:
+		w.uint64(uint64(x))
+	case uint64:
+		w.uint64(x)
+	case float32:
+		w.uint32(math.Float32bits(x))
+	case float64:
+		w.float64(x)
+	case string:
+		w.string(x)
+	case time.Time:
+		w.dateTime(x)
+	case []byte:
+		w.bytes(x)
+	case NodeID:
+		w.nodeID(x)
+	default:
+		return fmt.Errorf("cannot encode %T", v)
+	}
+	return nil
+}
+
+func (w *uaWriter) dataValue(dv uaDataValue) error {
+	var mask byte
+	if dv.Value != nil {
+		mask |= 0x01
+	}
+	if dv.Status != 0 {
+		mask |= 0x02
+	}
+	if !dv.SourceTimestamp.IsZero() {
+		mask |= 0x04
+	}
+	if !dv.ServerTimestamp.IsZero() {
+		mask |= 0x08
+	}
+	w.byte(mask)
+	if dv.Value != nil {
+		if err := w.variant(dv.Type, dv.Value); err != nil {
+			return err
+		}
+	}
+	if dv.Status != 0 {
+		w.uint32(dv.Status)
+	}
+	if !dv.SourceTimestamp.IsZero() {
+		w.dateTime(dv.SourceTimestamp)
+	}
+	if !dv.ServerTimestamp.IsZero() {
+		w.dateTime(dv.ServerTimestamp)
+	}
+	return nil
+}
+
+// requestHeader writes the header of every service request
+func (w *uaWriter) requestHeader(token NodeID, handle uint32, timeout time.Duration) {
+	w.nodeID(token)
+	w.dateTime(time.Now())
+	w.uint32(handle)
+	w.uint32(0) // return diagnostics
+	w.int32(-1) // audit entry ID
+	w.uint32(uint32(timeout / time.Millisecond))
+	w.extensionObject(0, nil)
+}
+
+// responseHeader writes the header of every service response
+func (w *uaWriter) responseHeader(handle, status uint32) {
+	w.dateTime(time.Now())
+	w.uint32(handle)
+	w.uint32(status)
+	w.byte(0)   // diagnostic info
+	w.int32(-1) // string table
+	w.extensionObject(0, nil)
+}
+
+// uaReader decodes OPC UA binary values. The first error sticks, later reads
+// return zero values.
+type uaReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *uaReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+n > len(r.b) {
+		r.err = &UAError{Code: UAStatusBadDecodingError}
+		return nil
+	}
+	b := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *uaReader) byte() byte {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *uaReader) bool() bool { return r.byte() != 0 }
+
+func (r *uaReader) uint16() uint16 {
+	if b := r.take(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *uaReader) uint32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *uaReader) int32() int32 { return int32(r.uint32()) }
+
+func (r *uaReader) uint64() uint64 {
+	if b := r.take(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *uaReader) float64() float64 { return math.Float64frombits(r.uint64()) }
+
+func (r *uaReader) bytes() []byte {
+	n := r.int32()
+	if n < 0 {
+		return nil
+	}
+	if b := r.take(int(n)); b != nil {
+		return append([]byte(nil), b...)
+	}
+	return nil
+}
+
+func (r *uaReader) string() string { return string(r.bytes()) }
+
+func (r *uaReader) dateTime() time.Time {
+	ft := r.uint64()
+	if ft == 0 {
+		return time.Time{}
+	}
+	return filetimeToTime(ft)
+}
+
+func (r *uaReader) guid() []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint32(b[0:], r.uint32())
+	binary.BigEndian.PutUint16(b[4:], r.uint16())
+	binary.BigEndian.PutUint16(b[6:], r.uint16())
+	copy(b[8:], r.take(8))
+	return b
+}
+
+// arrayLen reads the length of an array, -1 (null) counts as empty
+func (r *uaReader) arrayLen() int {
+	n := int(r.int32())
+	if n < 0 {
+		return 0
+	}
+	if n > len(r.b)-r.pos {
+		// Every element takes at least one byte
+		r.err = &UAError{Code: UAStatusBadDecodingError}
+		return 0
+	}
+	return n
+}
+
+// nodeID reads a NodeId or ExpandedNodeId
+func (r *uaReader) nodeID() NodeID {
+	enc := r.byte()
+	var id NodeID
+	switch enc & 0x0F {
+	case 0x00:
+		id = NumericNodeID(0, uint32(r.byte()))
+	case 0x01:
+		ns := r.byte()
+		id = NumericNodeID(uint16(ns), uint32(r.uint16()))
+	case 0x02:
+		ns := r.uint16()
+		id = NumericNodeID(ns, r.uint32())
+	case 0x03:
+		id = NodeID{Namespace: r.uint16(), Kind: 's'}
+		id.Text = r.string()
+	case 0x04:
+		id = NodeID{Namespace: r.uint16(), Kind: 'g'}
+		id.Bytes = r.guid()
+	case 0x05:
+		id = NodeID{Namespace: r.uint16(), Kind: 'b'}
+		id.Bytes = r.bytes()
+	default:
+		r.err = &UAError{Code: UAStatusBadDecodingError}
+	}
+	if enc&0x80 != 0 {
+		r.string() // namespace URI
+	}
+	if enc&0x40 != 0 {
+		r.uint32() // server index
+	}
+	return id
+}
+
+func (r *uaReader) qualifiedName() string {
+	r.uint16()
+	return r.string()
+}
+
+func (r *uaReader) localizedText() string {
+	mask := r.byte()
+	if mask&0x01 != 0 {
+		r.string() // locale
+	}
+	if mask&0x02 != 0 {
+		return r.string()
+	}
+	return ""
+}
+
+// extensionObject returns the type and body of a binary encoded structure
+func (r *uaReader) extensionObject() (uint32, []byte) {
+	id := r.nodeID()
+	switch r.byte() {
+	case 0x00:
+		return id.Numeric, nil
+	case 0x01, 0x02:
+		return id.Numeric, r.bytes()
+	}
+	r.err = &UAError{Code: UAStatusBadDecodingError}
+	return 0, nil
+}
+
+func (r *uaReader) diagnosticInfo() {
+	mask := r.byte()
+	for _, bit := range []byte{0x01, 0x02, 0x04, 0x08} {
+		if mask&bit != 0 {
+			r.int32()
+		}
+	}
+	if mask&0x10 != 0 {
+		r.string()
+	}
+	if mask&0x20 != 0 {
+		r.uint32()
+	}
+	if mask&0x40 != 0 {
+		r.diagnosticInfo()
+	}
+}
+
+func (r *uaReader) diagnosticInfos() {
+	for n := r.arrayLen(); n > 0 && r.err == nil; n-- {
+		r.diagnosticInfo()
+	}
+}
+
+// variant returns the value and its variant type. Arrays are returned as
+// []interface{}, matrices flattened.
+func (r *uaReader) variant() (interface{}, byte) {
+	mask := r.byte()
+	t := mask & 0x3F
+	if mask&0x80 == 0 {
+		return r.scalar(t), t
+	}
+	n := r.arrayLen()
+	arr := make([]interface{}, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		arr = append(arr, r.scalar(t))
+	}
+	if mask&0x40 != 0 {
+		for dims := r.arrayLen(); dims > 0 && r.err == nil; dims-- {
+			r.int32()
+		}
+	}
+	return arr, t
+}
+
+func (r *uaReader) scalar(t byte) interface{} {
+	switch t {
+	case 0:
+		return nil
+	case uaTypeBoolean:
+		return r.bool()
+	case uaTypeSByte:
+		return int8(r.byte())
+	case uaTypeByte:
+		return r.byte()
+	case uaTypeInt16:
+		return int16(r.uint16())
+	case uaTypeUInt16:
+		return r.uint16()
+	case uaTypeInt32:
+		return r.int32()
+	case uaTypeUInt32, uaTypeStatusCode:
+		return r.uint32()
+	case uaTypeInt64:
+		return int64(r.uint64())
+	case uaTypeUInt64:
+		return r.uint64()
+	case uaTypeFloat:
+		return math.Float32frombits(r.uint32())
+	case uaTypeDouble:
+		return r.float64()
+	case uaTypeString, uaTypeXMLElement:
+		return r.string()
+	case uaTypeDateTime:
+		return r.dateTime()
+	case uaTypeGUID:
+		return NodeID{Kind: 'g', Bytes: r.guid()}.String()[2:]
+	case uaTypeByteString:
+		return r.bytes()
+	case uaTypeNodeID, uaTypeExpandedNodeID:
+		return r.nodeID().String()
+	case uaTypeQualifiedName:
+		return r.qualifiedName()
+	case uaTypeLocalizedText:
+		return r.localizedText()
+	case uaTypeExtensionObject:
+		_, body := r.extensionObject()
+		return body
+	case uaTypeDataValue:
+		return r.dataValue().Value
+	case uaTypeVariant:
+		v, _ := r.variant()
+		return v
+	case uaTypeDiagnosticInfo:
+		r.diagnosticInfo()
+		return nil
+	}
+	r.err = &UAError{Code: UAStatusBadDecodingError}
+	return nil
+}
+
+func (r *uaReader) dataValue() uaDataValue {
+	var dv uaDataValue
+	mask := r.byte()
+	if mask&0x01 != 0 {
+		dv.Value, dv.Type = r.variant()
+	}
+	if mask&0x02 != 0 {
+		dv.Status = r.uint32()
+	}
+	if mask&0x04 != 0 {
+		dv.SourceTimestamp = r.dateTime()
+	}
+	if mask&0x10 != 0 {
+		r.uint16()
+	}
+	if mask&0x08 != 0 {
+		dv.ServerTimestamp = r.dateTime()
+	}
+	if mask&0x20 != 0 {
+		r.uint16()
+	}
+	return dv
+}
+
+// requestHeader reads a request header and returns the request handle
+func (r *uaReader) requestHeader() (token NodeID, handle uint32) {
+	token = r.nodeID()
+	r.dateTime()
+	handle = r.uint32()
+	r.uint32() // return diagnostics
+	r.string() // audit entry ID
+	r.uint32() // timeout hint
+	r.extensionObject()
+	return token, handle
+}
+
+// responseHeader reads a response header and returns the service result
+func (r *uaReader) responseHeader() uint32 {
+	r.dateTime()
+	r.uint32() // request handle
+	status := r.uint32()
+	r.diagnosticInfo()
+	for n := r.arrayLen(); n > 0 && r.err == nil; n-- {
+		r.string()
+	}
+	r.extensionObject()
+	return status
+}
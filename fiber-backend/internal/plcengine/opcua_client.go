@@ -0,0 +1,1274 @@
+package plcengine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OPCUAConfig describes how to reach an OPC UA server. Only the security
+// policy None with an anonymous user is supported.
+type OPCUAConfig struct {
+	Endpoint string // e.g. "opc.tcp://10.0.0.5:4840"
+
+	// Namespace of symbol names that are not node IDs, e.g. "GVL.Temperature"
+	// is read as ns=<Namespace>;s=GVL.Temperature
+	Namespace uint16
+
+	Timeout         time.Duration // per request, defaults to 2s
+	SessionTimeout  time.Duration // defaults to 1 minute
+	PublishInterval time.Duration // of the monitored item subscription, defaults to 100ms
+	SessionName     string
+
+	// MaxBrowseNodes limits the symbol upload, defaults to 10000
+	MaxBrowseNodes int
+}
+
+const (
+	uaChannelLifetime = time.Hour
+	uaKeepAliveCount  = 10
+	uaLifetimeCount   = 100
+
+	// uaMaxNodesPerRead limits the variables described by one Read request
+	uaMaxNodesPerRead = 300
+)
+
+// uaMessage is a reassembled response, err is set for aborted messages
+type uaMessage struct {
+	body []byte
+	err  error
+}
+
+// uaItem is a monitored item, keyed by its client handle
+type uaItem struct {
+	symbol    string
+	node      NodeID
+	attrib    NotificationAttrib
+	monitored uint32
+	callback  func(NotificationSample)
+}
+
+// OPCUAClient is a pure-Go OPC UA client over opc.tcp. It implements
+// ADSClient with node IDs as symbol names, so OPC UA servers plug into the
+// same PLCConnection lanes and PLCValue output as TwinCAT runtimes. It is
+// safe for concurrent use.
+type OPCUAClient struct {
+	conn      net.Conn
+	endpoint  string
+	namespace uint16
+	timeout   time.Duration
+	maxNodes  int
+
+	writeMu  sync.Mutex
+	sequence uint32
+	sendSize int
+
+	mu        sync.Mutex
+	requestID uint32
+	pending   map[uint32]chan uaMessage
+	partial   map[uint32][]byte
+	channelID uint32
+	tokenID   uint32
+	authToken NodeID
+	closed    bool
+	done      chan struct{}
+
+	typeMu   sync.RWMutex
+	types    map[string]byte // variant type per node, learned from reads
+	registry *SymbolRegistry // set by UploadSymbols
+
+	subMu           sync.Mutex
+	subscriptionID  uint32
+	publishInterval time.Duration
+	keepAlive       time.Duration
+	nextHandle      uint32
+	items           map[uint32]*uaItem
+}
+
+var (
+	_ ADSClient          = (*OPCUAClient)(nil)
+	_ BatchWriter        = (*OPCUAClient)(nil)
+	_ NotificationClient = (*OPCUAClient)(nil)
+	_ SymbolUploader     = (*OPCUAClient)(nil)
+	_ Browser            = (*OPCUAClient)(nil)
+)
+
+// NewOPCUAClient opens a secure channel and an activated anonymous session
+func NewOPCUAClient(cfg OPCUAConfig) (*OPCUAClient, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || u.Scheme != "opc.tcp" || u.Host == "" {
+		return nil, fmt.Errorf("invalid OPC UA endpoint %q", cfg.Endpoint)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), fmt.Sprint(OPCUAPort))
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	sessionTimeout := cfg.SessionTimeout
+	if sessionTimeout <= 0 {
+		sessionTimeout = time.Minute
+	}
+	publishInterval := cfg.PublishInterval
+	if publishInterval <= 0 {
+		publishInterval = 100 * time.Millisecond
+	}
+	maxNodes := cfg.MaxBrowseNodes
+	if maxNodes <= 0 {
+		maxNodes = 10000
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &OPCUAClient{
+		conn:            conn,
+		endpoint:        cfg.Endpoint,
+		namespace:       cfg.Namespace,
+		timeout:         timeout,
+		maxNodes:        maxNodes,
+		pending:         make(map[uint32]chan uaMessage),
+		partial:         make(map[uint32][]byte),
+		done:            make(chan struct{}),
+		types:           make(map[string]byte),
+		publishInterval: publishInterval,
+		items:           make(map[uint32]*uaItem),
+	}
+
+	r := bufio.NewReader(conn)
+	if err := c.hello(r); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go c.readLoop(r)
+
+	if err := c.openChannel(false); err != nil {
+		c.shutdown()
+		return nil, err
+	}
+	if err := c.createSession(cfg.SessionName, sessionTimeout); err != nil {
+		c.shutdown()
+		return nil, err
+	}
+	go c.renewChannel()
+	return c, nil
+}
+
+// hello negotiates the buffer sizes with HEL/ACK before anything else is sent
+func (c *OPCUAClient) hello(r io.Reader) error {
+	var w uaWriter
+	w.uint32(0) // protocol version
+	w.uint32(uaBufferSize)
+	w.uint32(uaBufferSize)
+	w.uint32(uaMaxMessageSize)
+	w.uint32(0) // max chunk count, no limit
+	w.string(c.endpoint)
+
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	defer c.conn.SetDeadline(time.Time{})
+	if _, err := c.conn.Write(uaFrame(uaMsgHello, uaChunkFinal, w.b)); err != nil {
+		return err
+	}
+
+	msgType, _, body, err := readUAChunk(r)
+	if err != nil {
+		return err
+	}
+	rd := &uaReader{b: body}
+	switch msgType {
+	case uaMsgAck:
+		rd.uint32() // protocol version
+		// What the server can receive limits the chunks we send
+		c.sendSize = uaBufferSize
+		if size := int(rd.uint32()); size > 0 && size < c.sendSize {
+			c.sendSize = size
+		}
+		return rd.err
+	case uaMsgError:
+		code := rd.uint32()
+		return fmt.Errorf("OPC UA hello rejected: %w: %s", &UAError{Code: code}, rd.string())
+	}
+	return fmt.Errorf("unexpected OPC UA message %s", msgType)
+}
+
+func uaFrame(msgType string, chunk byte, body []byte) []byte {
+	b := make([]byte, uaHeaderLen, uaHeaderLen+len(body))
+	copy(b, msgType)
+	b[3] = chunk
+	binary.LittleEndian.PutUint32(b[4:], uint32(uaHeaderLen+len(body)))
+	return append(b, body...)
+}
+
+// readUAChunk reads one message chunk and returns its type, chunk type and
+// everything after the header
+func readUAChunk(r io.Reader) (string, byte, []byte, error) {
+	hdr := make([]byte, uaHeaderLen)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return "", 0, nil, err
+	}
+	size := binary.LittleEndian.Uint32(hdr[4:])
+	if size < uaHeaderLen || size > uaMaxMessageSize {
+		return "", 0, nil, &UAError{Code: UAStatusBadTCPMessageInvalid}
+	}
+	body := make([]byte, size-uaHeaderLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return "", 0, nil, err
+	}
+	return string(hdr[:3]), hdr[3], body, nil
+}
+
+func (c *OPCUAClient) readLoop(r io.Reader) {
+	for {
+		msgType, chunk, body, err := readUAChunk(r)
+		if err != nil {
+			break
+		}
+		if msgType == uaMsgError {
+			rd := &uaReader{b: body}
+			log.Printf("OPC UA server %s closed the connection: %v %s", c.endpoint, &UAError{Code: rd.uint32()}, rd.string())
+			break
+		}
+		if msgType != uaMsgOpen && msgType != uaMsg {
+			continue
+		}
+
+		rd := &uaReader{b: body}
+		rd.uint32() // secure channel ID
+		if msgType == uaMsgOpen {
+			rd.string() // security policy
+			rd.bytes()  // sender certificate
+			rd.bytes()  // receiver thumbprint
+		} else {
+			rd.uint32() // token ID
+		}
+		rd.uint32() // sequence number
+		id := rd.uint32()
+		if rd.err != nil {
+			break
+		}
+		c.deliver(id, chunk, body[rd.pos:])
+	}
+	c.shutdown()
+}
+
+// deliver reassembles the chunks of a response and hands it to the caller
+func (c *OPCUAClient) deliver(id uint32, chunk byte, part []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var msg uaMessage
+	switch chunk {
+	case uaChunkMore:
+		c.partial[id] = append(c.partial[id], part...)
+		return
+	case uaChunkAbort:
+		rd := &uaReader{b: part}
+		msg.err = fmt.Errorf("OPC UA response aborted: %w", &UAError{Code: rd.uint32()})
+	default:
+		msg.body = append(c.partial[id], part...)
+	}
+	delete(c.partial, id)
+
+	if ch, ok := c.pending[id]; ok {
+		delete(c.pending, id)
+		ch <- msg
+	}
+}
+
+func (c *OPCUAClient) shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.done)
+	c.conn.Close()
+	c.pending = make(map[uint32]chan uaMessage)
+}
+
+// Closed reports whether the connection to the server was lost or closed
+func (c *OPCUAClient) Closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close deletes the subscription, closes the session and the secure channel
+func (c *OPCUAClient) Close() error {
+	if c.Closed() {
+		return nil
+	}
+
+	c.subMu.Lock()
+	sub := c.subscriptionID
+	c.subscriptionID = 0
+	c.items = make(map[uint32]*uaItem)
+	c.subMu.Unlock()
+	if sub != 0 {
+		_, _ = c.call(uaIDDeleteSubscriptionsRequest, uaIDDeleteSubscriptionsResponse, c.timeout, func(w *uaWriter) {
+			w.int32(1)
+			w.uint32(sub)
+		})
+	}
+	_, _ = c.call(uaIDCloseSessionRequest, uaIDCloseSessionResponse, c.timeout, func(w *uaWriter) {
+		w.bool(true) // delete subscriptions
+	})
+
+	// CloseSecureChannel has no response
+	c.mu.Lock()
+	c.requestID++
+	id := c.requestID
+	c.mu.Unlock()
+	var w uaWriter
+	w.nodeID(NumericNodeID(0, uaIDCloseSecureChannelRequest))
+	w.requestHeader(NodeID{}, id, c.timeout)
+	_ = c.send(uaMsgClose, id, w.b)
+
+	c.shutdown()
+	return nil
+}
+
+// send writes a message, split into chunks the server can receive
+func (c *OPCUAClient) send(msgType string, id uint32, body []byte) error {
+	c.mu.Lock()
+	channelID, tokenID := c.channelID, c.tokenID
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+
+	if msgType == uaMsgOpen {
+		// Asymmetric security header, never chunked with policy None
+		var w uaWriter
+		w.uint32(channelID)
+		w.string(uaSecurityNone)
+		w.bytes(nil)
+		w.bytes(nil)
+		c.sequence++
+		w.uint32(c.sequence)
+		w.uint32(id)
+		w.b = append(w.b, body...)
+		_, err := c.conn.Write(uaFrame(msgType, uaChunkFinal, w.b))
+		return err
+	}
+
+	maxBody := c.sendSize - uaHeaderLen - 16
+	for start := 0; ; start += maxBody {
+		end := min(start+maxBody, len(body))
+		chunk := byte(uaChunkFinal)
+		if end < len(body) {
+			chunk = uaChunkMore
+		}
+		var w uaWriter
+		w.uint32(channelID)
+		w.uint32(tokenID)
+		c.sequence++
+		w.uint32(c.sequence)
+		w.uint32(id)
+		w.b = append(w.b, body[start:end]...)
+		if _, err := c.conn.Write(uaFrame(msgType, chunk, w.b)); err != nil {
+			return err
+		}
+		if chunk == uaChunkFinal {
+			return nil
+		}
+	}
+}
+
+// call sends a service request and returns a reader positioned after the
+// response header. A bad service result is returned as *UAError; a lost
+// session or channel also closes the client so the connection reconnects.
+func (c *OPCUAClient) call(reqType, respType uint32, timeout time.Duration, body func(w *uaWriter)) (*uaReader, error) {
+	return c.roundTrip(uaMsg, reqType, respType, timeout, body)
+}
+
+func (c *OPCUAClient) roundTrip(msgType string, reqType, respType uint32, timeout time.Duration, body func(w *uaWriter)) (*uaReader, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClientClosed
+	}
+	c.requestID++
+	id := c.requestID
+	ch := make(chan uaMessage, 1)
+	c.pending[id] = ch
+	token := c.authToken
+	c.mu.Unlock()
+
+	var w uaWriter
+	w.nodeID(NumericNodeID(0, reqType))
+	w.requestHeader(token, id, timeout)
+	body(&w)
+
+	if err := c.send(msgType, id, w.b); err != nil {
+		c.shutdown()
+		return nil, fmt.Errorf("%w: %v", ErrClientClosed, err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var msg uaMessage
+	select {
+	case msg = <-ch:
+	case <-timer.C:
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, ErrRequestTimeout
+	case <-c.done:
+		return nil, ErrClientClosed
+	}
+	if msg.err != nil {
+		return nil, msg.err
+	}
+
+	r := &uaReader{b: msg.body}
+	typeID := r.nodeID()
+	status := r.responseHeader()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if err := uaErr(status); err != nil {
+		switch status {
+		case UAStatusBadSessionIDInvalid, UAStatusBadSessionClosed, UAStatusBadSessionNotActivated, UAStatusBadSecureChannelInvalid:
+			c.shutdown()
+			return nil, fmt.Errorf("%w: %v", ErrClientClosed, err)
+		}
+		return nil, err
+	}
+	if typeID.Numeric != respType {
+		return nil, fmt.Errorf("%w: OPC UA response type %d, want %d", ErrInvalidResponse, typeID.Numeric, respType)
+	}
+	return r, nil
+}
+
+// openChannel issues or renews the security token of the secure channel
+func (c *OPCUAClient) openChannel(renew bool) error {
+	var requestType uint32
+	if renew {
+		requestType = 1
+	}
+	r, err := c.roundTrip(uaMsgOpen, uaIDOpenSecureChannelRequest, uaIDOpenSecureChannelResponse, c.timeout, func(w *uaWriter) {
+		w.uint32(0) // protocol version
+		w.uint32(requestType)
+		w.uint32(uaMessageSecNone)
+		w.bytes(nil) // client nonce
+		w.uint32(uint32(uaChannelLifetime / time.Millisecond))
+	})
+	if err != nil {
+		return fmt.Errorf("open secure channel: %w", err)
+	}
+	r.uint32() // server protocol version
+	channelID := r.uint32()
+	tokenID := r.uint32()
+	if r.err != nil {
+		return fmt.Errorf("open secure channel: %w", r.err)
+	}
+
+	c.mu.Lock()
+	c.channelID, c.tokenID = channelID, tokenID
+	c.mu.Unlock()
+	return nil
+}
+
+// renewChannel renews the security token before it expires
+func (c *OPCUAClient) renewChannel() {
+	ticker := time.NewTicker(uaChannelLifetime * 3 / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.openChannel(true); err != nil {
+				log.Printf("OPC UA server %s: %v", c.endpoint, err)
+				c.shutdown()
+				return
+			}
+		}
+	}
+}
+
+// createSession creates and activates an anonymous session
+func (c *OPCUAClient) createSession(name string, timeout time.Duration) error {
+	if name == "" {
+		name = "fiber-backend"
+	}
+	r, err := c.call(uaIDCreateSessionRequest, uaIDCreateSessionResponse, c.timeout, func(w *uaWriter) {
+		// Client application description
+		w.string("urn:fiber-backend")
+		w.nullString("")
+		w.localizedText("fiber-backend")
+		w.uint32(1) // client
+		w.nullString("")
+		w.nullString("")
+		w.int32(-1) // discovery URLs
+
+		w.nullString("") // server URI
+		w.string(c.endpoint)
+		w.string(name)
+		w.bytes(make([]byte, 32)) // client nonce
+		w.bytes(nil)              // client certificate
+		w.float64(float64(timeout / time.Millisecond))
+		w.uint32(uaMaxMessageSize)
+	})
+	if err != nil {
+		return fmt.Errorf("create session: %w", err)
+	}
+	r.nodeID() // session ID
+	authToken := r.nodeID()
+	r.float64() // revised session timeout
+	r.bytes()   // server nonce
+	r.bytes()   // server certificate
+	policyID := anonymousPolicy(r)
+	if r.err != nil {
+		return fmt.Errorf("create session: %w", r.err)
+	}
+
+	c.mu.Lock()
+	c.authToken = authToken
+	c.mu.Unlock()
+
+	var token uaWriter
+	token.string(policyID)
+	_, err = c.call(uaIDActivateSessionRequest, uaIDActivateSessionResponse, c.timeout, func(w *uaWriter) {
+		w.nullString("") // client signature algorithm
+		w.bytes(nil)     // client signature
+		w.int32(-1)      // client software certificates
+		w.int32(-1)      // locale IDs
+		w.extensionObject(uaIDAnonymousIdentityToken, token.b)
+		w.nullString("") // user token signature algorithm
+		w.bytes(nil)
+	})
+	if err != nil {
+		return fmt.Errorf("activate session: %w", err)
+	}
+	return nil
+}
+
+// anonymousPolicy reads the endpoint descriptions of a CreateSession
+// response and returns the policy ID of the anonymous user token of an
+// endpoint without security
+func anonymousPolicy(r *uaReader) string {
+	policyID := "anonymous"
+	found := false
+	for n := r.arrayLen(); n > 0 && r.err == nil; n-- {
+		r.string() // endpoint URL
+		r.string() // application URI
+		r.string() // product URI
+		r.localizedText()
+		r.uint32() // application type
+		r.string() // gateway server URI
+		r.string() // discovery profile URI
+		for urls := r.arrayLen(); urls > 0 && r.err == nil; urls-- {
+			r.string()
+		}
+		r.bytes() // server certificate
+		mode := r.uint32()
+		r.string() // security policy
+		for tokens := r.arrayLen(); tokens > 0 && r.err == nil; tokens-- {
+			id := r.string()
+			tokenType := r.uint32()
+			r.string() // issued token type
+			r.string() // issuer endpoint URL
+			r.string() // security policy
+			if tokenType == 0 && mode == uaMessageSecNone && !found {
+				policyID, found = id, true
+			}
+		}
+		r.string() // transport profile
+		r.byte()   // security level
+	}
+	return policyID
+}
+
+// nodeID resolves a symbol name to a node ID
+func (c *OPCUAClient) nodeID(name string) (NodeID, error) {
+	if id, err := ParseNodeID(name); err == nil {
+		return id, nil
+	}
+	if strings.Contains(name, ";") || strings.HasPrefix(name, "ns=") {
+		return NodeID{}, fmt.Errorf("%w: %q", &UAError{Code: UAStatusBadNodeIDInvalid}, name)
+	}
+	return NodeID{Namespace: c.namespace, Kind: 's', Text: name}, nil
+}
+
+// symbolName is the inverse of nodeID: string node IDs in the client's
+// namespace are named by their identifier, all others by their node ID
+func (c *OPCUAClient) symbolName(id NodeID) string {
+	if id.Kind == 's' && id.Namespace == c.namespace {
+		if _, err := ParseNodeID(id.Text); err != nil {
+			return id.Text
+		}
+	}
+	return id.String()
+}
+
+// readValue is one attribute read of ReadAttributes
+type readValue struct {
+	node NodeID
+	attr uint32
+}
+
+// readAttributes reads attributes of several nodes in one Read request
+func (c *OPCUAClient) readAttributes(nodes []readValue) ([]uaDataValue, error) {
+	r, err := c.call(uaIDReadRequest, uaIDReadResponse, c.timeout, func(w *uaWriter) {
+		w.float64(0) // max age
+		w.uint32(uaTimestampsBoth)
+		w.int32(int32(len(nodes)))
+		for _, n := range nodes {
+			w.nodeID(n.node)
+			w.uint32(n.attr)
+			w.nullString("") // index range
+			w.qualifiedName(0, "")
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	n := r.arrayLen()
+	if n != len(nodes) {
+		return nil, fmt.Errorf("%w: %d read results for %d nodes", ErrInvalidResponse, n, len(nodes))
+	}
+	results := make([]uaDataValue, n)
+	for i := range results {
+		results[i] = r.dataValue()
+	}
+	return results, r.err
+}
+
+func (c *OPCUAClient) ReadSymbol(name string) (interface{}, error) {
+	node, err := c.nodeID(name)
+	if err != nil {
+		return nil, err
+	}
+	results, err := c.readAttributes([]readValue{{node, uaAttrValue}})
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", name, err)
+	}
+	if err := uaErr(results[0].Status); err != nil {
+		return nil, fmt.Errorf("read %s: %w", name, err)
+	}
+	c.learnType(node, results[0].Type)
+	return results[0].Value, nil
+}
+
+// ReadSymbols reads all symbols in one Read request. Symbols that cannot be
+// read are left out of the result; an error is only returned if no symbol
+// could be read at all.
+func (c *OPCUAClient) ReadSymbols(names []string) (map[string]interface{}, error) {
+	results := make(map[string]interface{}, len(names))
+	var firstErr error
+
+	nodes := make([]readValue, 0, len(names))
+	valid := make([]string, 0, len(names))
+	for _, name := range names {
+		node, err := c.nodeID(name)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		nodes = append(nodes, readValue{node, uaAttrValue})
+		valid = append(valid, name)
+	}
+	if len(nodes) == 0 {
+		return nil, firstErr
+	}
+
+	values, err := c.readAttributes(nodes)
+	if err != nil {
+		return nil, err
+	}
+	for i, dv := range values {
+		if err := uaErr(dv.Status); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("read %s: %w", valid[i], err)
+			}
+			continue
+		}
+		c.learnType(nodes[i].node, dv.Type)
+		results[valid[i]] = dv.Value
+	}
+
+	if len(results) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return results, nil
+}
+
+func (c *OPCUAClient) learnType(node NodeID, t byte) {
+	if t == 0 {
+		return
+	}
+	key := node.String()
+	c.typeMu.Lock()
+	c.types[key] = t
+	c.typeMu.Unlock()
+}
+
+// variantType returns the type a node's value is written with. It comes
+// from earlier reads or the symbol upload, otherwise the value is read once.
+func (c *OPCUAClient) variantType(name string, node NodeID) (byte, error) {
+	key := node.String()
+	c.typeMu.RLock()
+	t, ok := c.types[key]
+	reg := c.registry
+	c.typeMu.RUnlock()
+	if ok {
+		return t, nil
+	}
+	if info, found := registrySymbol(reg, c.symbolName(node)); found {
+		for vt, plc := range uaTypePLC {
+			if plc == info.Type {
+				return vt, nil
+			}
+		}
+	}
+	if _, err := c.ReadSymbol(name); err != nil {
+		return 0, err
+	}
+	c.typeMu.RLock()
+	t = c.types[key]
+	c.typeMu.RUnlock()
+	if t == 0 {
+		return 0, fmt.Errorf("%s has no value to derive its type from", name)
+	}
+	return t, nil
+}
+
+// writeValue is one node of a Write request
+type writeValue struct {
+	name  string
+	node  NodeID
+	vtype byte
+	value interface{}
+}
+
+func (c *OPCUAClient) prepareWrite(name string, value interface{}) (writeValue, error) {
+	node, err := c.nodeID(name)
+	if err != nil {
+		return writeValue{}, err
+	}
+	t, err := c.variantType(name, node)
+	if err != nil {
+		return writeValue{}, fmt.Errorf("write %s: %w", name, err)
+	}
+	// Encode once up front so that a bad value fails only its own node
+	var probe uaWriter
+	if err := probe.variant(t, value); err != nil {
+		return writeValue{}, fmt.Errorf("write %s: %w", name, err)
+	}
+	return writeValue{name: name, node: node, vtype: t, value: value}, nil
+}
+
+// writeNodes writes the values in one Write request and returns the status per node
+func (c *OPCUAClient) writeNodes(values []writeValue) ([]error, error) {
+	var encErr error
+	r, err := c.call(uaIDWriteRequest, uaIDWriteResponse, c.timeout, func(w *uaWriter) {
+		w.int32(int32(len(values)))
+		for _, v := range values {
+			w.nodeID(v.node)
+			w.uint32(uaAttrValue)
+			w.nullString("")
+			if err := w.dataValue(uaDataValue{Value: v.value, Type: v.vtype}); err != nil && encErr == nil {
+				encErr = err
+			}
+		}
+	})
+	if encErr != nil {
+		return nil, encErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	n := r.arrayLen()
+	if n != len(values) {
+		return nil, fmt.Errorf("%w: %d write results for %d nodes", ErrInvalidResponse, n, len(values))
+	}
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = uaErr(r.uint32())
+	}
+	return errs, r.err
+}
+
+func (c *OPCUAClient) WriteSymbol(name string, value interface{}) error {
+	v, err := c.prepareWrite(name, value)
+	if err != nil {
+		return err
+	}
+	errs, err := c.writeNodes([]writeValue{v})
+	if err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	if errs[0] != nil {
+		return fmt.Errorf("write %s: %w", name, errs[0])
+	}
+	return nil
+}
+
+// WriteSymbols writes all values in one Write request. The returned map has
+// an entry per symbol, nil if the write succeeded. The error is only set if
+// the transport failed.
+func (c *OPCUAClient) WriteSymbols(values map[string]interface{}) (map[string]error, error) {
+	results := make(map[string]error, len(values))
+	nodes := make([]writeValue, 0, len(values))
+	for name, value := range values {
+		v, err := c.prepareWrite(name, value)
+		if err != nil {
+			if errors.Is(err, ErrClientClosed) || errors.Is(err, ErrRequestTimeout) {
+				return nil, err
+			}
+			results[name] = err
+			continue
+		}
+		nodes = append(nodes, v)
+	}
+	if len(nodes) == 0 {
+		return results, nil
+	}
+
+	errs, err := c.writeNodes(nodes)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range nodes {
+		if errs[i] != nil {
+			results[v.name] = fmt.Errorf("write %s: %w", v.name, errs[i])
+			continue
+		}
+		results[v.name] = nil
+	}
+	return results, nil
+}
+
+// AddNotification creates a monitored item on the client's subscription,
+// which is created on first use. NotifyCyclic is served like NotifyOnChange
+// since OPC UA only reports changes; CycleTime is the sampling interval.
+func (c *OPCUAClient) AddNotification(name string, attrib NotificationAttrib, callback func(NotificationSample)) (uint32, error) {
+	node, err := c.nodeID(name)
+	if err != nil {
+		return 0, err
+	}
+
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	if err := c.ensureSubscriptionLocked(); err != nil {
+		return 0, err
+	}
+
+	c.nextHandle++
+	handle := c.nextHandle
+	item := &uaItem{symbol: name, node: node, attrib: attrib, callback: callback}
+	// Registered before the request, the first sample may beat the response
+	c.items[handle] = item
+
+	r, err := c.call(uaIDCreateMonitoredItemsRequest, uaIDCreateMonitoredItemsResp, c.timeout, func(w *uaWriter) {
+		w.uint32(c.subscriptionID)
+		w.uint32(uaTimestampsBoth)
+		w.int32(1)
+		w.nodeID(node)
+		w.uint32(uaAttrValue)
+		w.nullString("")
+		w.qualifiedName(0, "")
+		w.uint32(uaMonitorReport)
+		w.uint32(handle)
+		w.float64(float64(attrib.CycleTime / time.Millisecond))
+		w.extensionObject(0, nil) // default data change filter
+		w.uint32(1)               // queue size
+		w.bool(true)              // discard oldest
+	})
+	if err == nil {
+		if r.arrayLen() != 1 {
+			err = ErrInvalidResponse
+		} else if err = uaErr(r.uint32()); err == nil {
+			item.monitored = r.uint32()
+			err = r.err
+		}
+	}
+	if err != nil {
+		delete(c.items, handle)
+		return 0, fmt.Errorf("monitor %s: %w", name, err)
+	}
+	return handle, nil
+}
+
+// DeleteNotification deletes a monitored item created by AddNotification
+func (c *OPCUAClient) DeleteNotification(handle uint32) error {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	item, ok := c.items[handle]
+	if !ok {
+		return nil
+	}
+	delete(c.items, handle)
+
+	r, err := c.call(uaIDDeleteMonitoredItemsRequest, uaIDDeleteMonitoredItemsResp, c.timeout, func(w *uaWriter) {
+		w.uint32(c.subscriptionID)
+		w.int32(1)
+		w.uint32(item.monitored)
+	})
+	if err != nil {
+		return err
+	}
+	if r.arrayLen() != 1 {
+		return ErrInvalidResponse
+	}
+	return uaErr(r.uint32())
+}
+
+// ensureSubscriptionLocked creates the subscription and starts publishing. c.subMu must be held.
+func (c *OPCUAClient) ensureSubscriptionLocked() error {
+	if c.subscriptionID != 0 {
+		return nil
+	}
+	r, err := c.call(uaIDCreateSubscriptionRequest, uaIDCreateSubscriptionResponse, c.timeout, func(w *uaWriter) {
+		w.float64(float64(c.publishInterval / time.Millisecond))
+		w.uint32(uaLifetimeCount)
+		w.uint32(uaKeepAliveCount)
+		w.uint32(0) // notifications per publish, no limit
+		w.bool(true)
+		w.byte(0) // priority
+	})
+	if err != nil {
+		return fmt.Errorf("create subscription: %w", err)
+	}
+	id := r.uint32()
+	interval := r.float64()
+	r.uint32() // revised lifetime count
+	keepAlive := r.uint32()
+	if r.err != nil {
+		return fmt.Errorf("create subscription: %w", r.err)
+	}
+
+	c.subscriptionID = id
+	c.keepAlive = time.Duration(interval*float64(keepAlive)) * time.Millisecond
+	go c.publishLoop(id, c.timeout+c.keepAlive)
+	return nil
+}
+
+// publishLoop keeps one Publish request outstanding and delivers the data
+// changes of subscription id until it is deleted or the client closes
+func (c *OPCUAClient) publishLoop(id uint32, timeout time.Duration) {
+	var acks []uint32
+	for {
+		c.subMu.Lock()
+		current := c.subscriptionID
+		c.subMu.Unlock()
+		if current != id {
+			return
+		}
+
+		pending := acks
+		r, err := c.call(uaIDPublishRequest, uaIDPublishResponse, timeout, func(w *uaWriter) {
+			w.int32(int32(len(pending)))
+			for _, seq := range pending {
+				w.uint32(id)
+				w.uint32(seq)
+			}
+		})
+		switch {
+		case errors.Is(err, ErrClientClosed), IsUAError(err, UAStatusBadNoSubscription), IsUAError(err, UAStatusBadSubscriptionIDInvalid):
+			return
+		case err != nil:
+			// Acknowledgements are resent with the next request
+			log.Printf("OPC UA publish on %s: %v", c.endpoint, err)
+			select {
+			case <-c.done:
+				return
+			case <-time.After(c.publishInterval):
+			}
+			continue
+		}
+		acks = nil
+
+		r.uint32() // subscription ID
+		for n := r.arrayLen(); n > 0 && r.err == nil; n-- {
+			r.uint32() // available sequence numbers
+		}
+		r.bool() // more notifications
+		seq := r.uint32()
+		published := r.dateTime()
+		notifications := r.arrayLen()
+		for i := 0; i < notifications && r.err == nil; i++ {
+			typeID, body := r.extensionObject()
+			if typeID == uaIDDataChangeNotification {
+				c.dispatchDataChange(body, published)
+			}
+		}
+		if r.err != nil {
+			log.Printf("OPC UA publish on %s: %v", c.endpoint, r.err)
+			continue
+		}
+		// Keep-alive messages carry no notifications and are not acknowledged
+		if notifications > 0 {
+			acks = append(acks, seq)
+		}
+	}
+}
+
+// dispatchDataChange delivers the samples of a DataChangeNotification.
+// Samples with a bad status are dropped like failed reads.
+func (c *OPCUAClient) dispatchDataChange(body []byte, published time.Time) {
+	r := &uaReader{b: body}
+	n := r.arrayLen()
+	for i := 0; i < n && r.err == nil; i++ {
+		handle := r.uint32()
+		dv := r.dataValue()
+		if r.err != nil || uaErr(dv.Status) != nil {
+			continue
+		}
+
+		c.subMu.Lock()
+		item, ok := c.items[handle]
+		c.subMu.Unlock()
+		if !ok {
+			continue
+		}
+		c.learnType(item.node, dv.Type)
+
+		ts := dv.SourceTimestamp
+		if ts.IsZero() {
+			ts = dv.ServerTimestamp
+		}
+		if ts.IsZero() {
+			ts = published
+		}
+		item.callback(NotificationSample{Symbol: item.symbol, Value: dv.Value, Timestamp: ts})
+	}
+}
+
+// uaReference is a reference returned by Browse
+type uaReference struct {
+	node        NodeID
+	browseName  string
+	displayName string
+	class       uint32
+}
+
+// browse returns the hierarchical forward references of a node, following
+// continuation points
+func (c *OPCUAClient) browse(node NodeID) ([]uaReference, error) {
+	r, err := c.call(uaIDBrowseRequest, uaIDBrowseResponse, c.timeout, func(w *uaWriter) {
+		w.nodeID(NodeID{}) // view
+		w.dateTime(time.Time{})
+		w.uint32(0)
+		w.uint32(0) // max references per node, no limit
+		w.int32(1)
+		w.nodeID(node)
+		w.uint32(uaBrowseForward)
+		w.nodeID(NumericNodeID(0, uaIDHierarchicalReferences))
+		w.bool(true) // include subtypes
+		w.uint32(0)  // all node classes
+		w.uint32(uaResultMaskAll)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []uaReference
+	for {
+		if r.arrayLen() != 1 {
+			return nil, ErrInvalidResponse
+		}
+		if err := uaErr(r.uint32()); err != nil {
+			return nil, err
+		}
+		cont := r.bytes()
+		for n := r.arrayLen(); n > 0 && r.err == nil; n-- {
+			r.nodeID() // reference type
+			r.bool()   // forward
+			ref := uaReference{node: r.nodeID()}
+			ref.browseName = r.qualifiedName()
+			ref.displayName = r.localizedText()
+			ref.class = r.uint32()
+			r.nodeID() // type definition
+			refs = append(refs, ref)
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		if len(cont) == 0 {
+			return refs, nil
+		}
+
+		r, err = c.call(uaIDBrowseNextRequest, uaIDBrowseNextResponse, c.timeout, func(w *uaWriter) {
+			w.bool(false) // release continuation points
+			w.int32(1)
+			w.bytes(cont)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// variableInfo reads data type, value rank and access level of variables
+func (c *OPCUAClient) variableInfo(nodes []NodeID) ([]SymbolInfo, error) {
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+	reads := make([]readValue, 0, 3*len(nodes))
+	for _, n := range nodes {
+		reads = append(reads, readValue{n, uaAttrDataType}, readValue{n, uaAttrValueRank}, readValue{n, uaAttrAccessLevel})
+	}
+	values, err := c.readAttributes(reads)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]SymbolInfo, len(nodes))
+	for i, n := range nodes {
+		dataType, rank, access := values[3*i], values[3*i+1], values[3*i+2]
+		info := SymbolInfo{Name: c.symbolName(n), Type: TypeUnknown}
+		if id, err := ParseNodeID(fmt.Sprint(dataType.Value)); err == nil && id.Namespace == 0 && id.Kind == 'i' {
+			if t, ok := uaTypePLC[byte(id.Numeric)]; ok && id.Numeric <= 0xFF {
+				info.Type = t
+			}
+		}
+		if r, ok := rank.Value.(int32); ok && r > 0 && info.Type != TypeUnknown {
+			info.Type = TypeArray
+		}
+		if info.Type != TypeUnknown {
+			info.TypeName = info.Type.String()
+		}
+		if a, ok := access.Value.(uint8); ok {
+			info.IsWritable = a&uaAccessWrite != 0
+		}
+		infos[i] = info
+	}
+	return infos, nil
+}
+
+func nodeClassName(class uint32) string {
+	switch class {
+	case UANodeClassObject:
+		return "object"
+	case UANodeClassVariable:
+		return "variable"
+	case UANodeClassMethod:
+		return "method"
+	}
+	return "other"
+}
+
+// Browse lists the children of a node, "" browses the Objects folder
+func (c *OPCUAClient) Browse(node string) ([]BrowseNode, error) {
+	start := NumericNodeID(0, uaIDObjectsFolder)
+	if node != "" {
+		var err error
+		if start, err = c.nodeID(node); err != nil {
+			return nil, err
+		}
+	}
+	refs, err := c.browse(start)
+	if err != nil {
+		return nil, fmt.Errorf("browse %s: %w", start, err)
+	}
+
+	var variables []NodeID
+	for _, ref := range refs {
+		if ref.class == UANodeClassVariable {
+			variables = append(variables, ref.node)
+		}
+	}
+	infos, err := c.variableInfo(variables)
+	if err != nil {
+		return nil, fmt.Errorf("browse %s: %w", start, err)
+	}
+
+	out := make([]BrowseNode, 0, len(refs))
+	v := 0
+	for _, ref := range refs {
+		bn := BrowseNode{ID: c.symbolName(ref.node), Name: ref.browseName, DisplayName: ref.displayName, Class: nodeClassName(ref.class)}
+		if ref.class == UANodeClassVariable {
+			bn.Type, bn.Writable = infos[v].TypeName, infos[v].IsWritable
+			v++
+		}
+		out = append(out, bn)
+	}
+	return out, nil
+}
+
+// UploadSymbols browses the address space below the Objects folder and
+// returns its variables outside namespace 0, named as in symbolName. The
+// comment holds the browse path.
+func (c *OPCUAClient) UploadSymbols() (*SymbolRegistry, error) {
+	type queued struct {
+		node NodeID
+		path string
+	}
+	queue := []queued{{node: NumericNodeID(0, uaIDObjectsFolder)}}
+	seen := map[string]bool{queue[0].node.String(): true}
+
+	var variables []NodeID
+	var paths []string
+	for len(queue) > 0 && len(seen) < c.maxNodes {
+		next := queue[0]
+		queue = queue[1:]
+
+		refs, err := c.browse(next.node)
+		if err != nil {
+			return nil, fmt.Errorf("browse %s: %w", next.node, err)
+		}
+		for _, ref := range refs {
+			key := ref.node.String()
+			// Namespace 0 is the server's own standard nodes
+			if ref.node.Namespace == 0 || seen[key] {
+				continue
+			}
+			seen[key] = true
+			path := ref.browseName
+			if next.path != "" {
+				path = next.path + "." + ref.browseName
+			}
+			switch ref.class {
+			case UANodeClassVariable:
+				variables = append(variables, ref.node)
+				paths = append(paths, path)
+			case UANodeClassObject:
+				queue = append(queue, queued{node: ref.node, path: path})
+			}
+		}
+	}
+
+	var symbols []SymbolInfo
+	for start := 0; start < len(variables); start += uaMaxNodesPerRead {
+		end := min(start+uaMaxNodesPerRead, len(variables))
+		infos, err := c.variableInfo(variables[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for i := range infos {
+			infos[i].Comment = paths[start+i]
+		}
+		symbols = append(symbols, infos...)
+	}
+
+	reg := NewSymbolRegistry(symbols, nil)
+	c.typeMu.Lock()
+	c.registry = reg
+	c.typeMu.Unlock()
+	return reg, nil
+}
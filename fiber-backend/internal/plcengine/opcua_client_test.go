@@ -0,0 +1,249 @@
+package plcengine
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newChamberServer serves a chamber object with a few typed variables
+func newChamberServer(t *testing.T) *fakeUAServer {
+	s := newFakeUAServer(t)
+	s.addObject("i=85", "ns=2;s=Chamber1", "Chamber1")
+	s.addVariable("ns=2;s=Chamber1", "ns=2;s=Chamber1.Temperature", "Temperature", uaTypeDouble, 21.5, false)
+	s.addVariable("ns=2;s=Chamber1", "ns=2;s=Chamber1.Setpoint", "Setpoint", uaTypeFloat, float32(20), true)
+	s.addVariable("ns=2;s=Chamber1", "ns=2;s=Chamber1.Step", "Step", uaTypeInt16, int16(3), true)
+	s.addVariable("ns=2;s=Chamber1", "ns=2;s=Chamber1.Recipe", "Recipe", uaTypeString, "ETCH-01", true)
+	s.addVariable("ns=2;s=Chamber1", "ns=2;i=1001", "DoorClosed", uaTypeBoolean, true, false)
+	return s
+}
+
+func TestParseNodeID(t *testing.T) {
+	for _, s := range []string{"i=85", "ns=2;s=GVL.Temperature", "ns=3;i=70000", "ns=1;g=72962b91-fa75-4ae6-8d28-b404dc7daf63", "ns=4;b=AQID"} {
+		id, err := ParseNodeID(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, s, id.String())
+
+		// The binary encoding round trips
+		var w uaWriter
+		w.nodeID(id)
+		r := &uaReader{b: w.b}
+		assert.Equal(t, id, r.nodeID(), s)
+		require.NoError(t, r.err)
+	}
+
+	for _, s := range []string{"", "GVL.Temperature", "ns=x;i=1", "i=abc", "ns=1;g=1234", "q=1"} {
+		_, err := ParseNodeID(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestOPCUAClient_ReadWrite(t *testing.T) {
+	s := newChamberServer(t)
+	c := s.client(t)
+
+	v, err := c.ReadSymbol("ns=2;s=Chamber1.Temperature")
+	require.NoError(t, err)
+	assert.Equal(t, 21.5, v)
+
+	// Plain names are string node IDs in the configured namespace
+	vals, err := c.ReadSymbols([]string{"Chamber1.Step", "ns=2;i=1001", "Chamber1.Missing", "Chamber1.Recipe"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"Chamber1.Step": int16(3), "ns=2;i=1001": true, "Chamber1.Recipe": "ETCH-01"}, vals)
+	assert.Equal(t, 1, s.callCount(uaIDReadRequest)-1, "one Read request for the batch")
+
+	_, err = c.ReadSymbol("Chamber1.Missing")
+	assert.True(t, IsUAError(err, UAStatusBadNodeIDUnknown), "got %v", err)
+	_, err = c.ReadSymbols([]string{"Chamber1.Missing"})
+	assert.True(t, IsUAError(err, UAStatusBadNodeIDUnknown), "got %v", err)
+
+	// The value is converted to the node's type, read once if unknown
+	require.NoError(t, c.WriteSymbol("Chamber1.Setpoint", 42))
+	assert.Equal(t, float32(42), s.value("ns=2;s=Chamber1.Setpoint"))
+	require.NoError(t, c.WriteSymbol("Chamber1.Step", 4.0))
+	assert.Equal(t, int16(4), s.value("ns=2;s=Chamber1.Step"))
+
+	err = c.WriteSymbol("ns=2;s=Chamber1.Temperature", 30.0)
+	assert.True(t, IsUAError(err, UAStatusBadNotWritable), "got %v", err)
+	err = c.WriteSymbol("Chamber1.Step", 70000)
+	assert.ErrorContains(t, err, "overflows")
+
+	// A long string is sent in several chunks
+	long := strings.Repeat("x", 20000)
+	require.NoError(t, c.WriteSymbol("Chamber1.Recipe", long))
+	assert.Equal(t, long, s.value("ns=2;s=Chamber1.Recipe"))
+
+	results, err := c.WriteSymbols(map[string]interface{}{
+		"Chamber1.Setpoint":    25.5,
+		"Chamber1.Temperature": 1.0,
+		"Chamber1.Step":        "x",
+	})
+	require.NoError(t, err)
+	assert.NoError(t, results["Chamber1.Setpoint"])
+	assert.True(t, IsUAError(results["Chamber1.Temperature"], UAStatusBadNotWritable))
+	assert.Error(t, results["Chamber1.Step"])
+	assert.Equal(t, float32(25.5), s.value("ns=2;s=Chamber1.Setpoint"))
+}
+
+func TestOPCUAClient_Browse(t *testing.T) {
+	s := newChamberServer(t)
+	c := s.client(t)
+
+	top, err := c.Browse("")
+	require.NoError(t, err)
+	assert.Equal(t, []BrowseNode{{ID: "Chamber1", Name: "Chamber1", DisplayName: "Chamber1", Class: "object"}}, top)
+
+	// Five children need BrowseNext with two references per response
+	nodes, err := c.Browse("Chamber1")
+	require.NoError(t, err)
+	require.Len(t, nodes, 5)
+	assert.Equal(t, BrowseNode{ID: "Chamber1.Setpoint", Name: "Setpoint", DisplayName: "Setpoint", Class: "variable", Type: "REAL", Writable: true}, nodes[1])
+	assert.Equal(t, BrowseNode{ID: "ns=2;i=1001", Name: "DoorClosed", DisplayName: "DoorClosed", Class: "variable", Type: "BOOL"}, nodes[4])
+	assert.Equal(t, 2, s.callCount(uaIDBrowseNextRequest))
+
+	reg, err := c.UploadSymbols()
+	require.NoError(t, err)
+	assert.Equal(t, 5, reg.Len())
+	info, ok := reg.Symbol("Chamber1.Temperature")
+	require.True(t, ok)
+	assert.Equal(t, TypeLReal, info.Type)
+	assert.Equal(t, "Chamber1.Temperature", info.Comment)
+	assert.False(t, info.IsWritable)
+
+	// Write types come from the upload, no read is needed first
+	reads := s.callCount(uaIDReadRequest)
+	fresh := s.client(t)
+	fresh.registry = reg
+	require.NoError(t, fresh.WriteSymbol("Chamber1.Setpoint", 1))
+	assert.Equal(t, reads, s.callCount(uaIDReadRequest))
+}
+
+func TestOPCUAClient_MonitoredItems(t *testing.T) {
+	s := newChamberServer(t)
+	c := s.client(t)
+
+	var mu sync.Mutex
+	var samples []NotificationSample
+	callback := func(sample NotificationSample) {
+		mu.Lock()
+		samples = append(samples, sample)
+		mu.Unlock()
+	}
+	received := func(symbol string) []interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		var out []interface{}
+		for _, s := range samples {
+			if s.Symbol == symbol {
+				out = append(out, s.Value)
+			}
+		}
+		return out
+	}
+
+	h, err := c.AddNotification("Chamber1.Temperature", NotificationAttrib{CycleTime: 10 * time.Millisecond}, callback)
+	require.NoError(t, err)
+	_, err = c.AddNotification("Chamber1.Step", NotificationAttrib{}, callback)
+	require.NoError(t, err)
+	_, err = c.AddNotification("Chamber1.Missing", NotificationAttrib{}, callback)
+	assert.True(t, IsUAError(err, UAStatusBadNodeIDUnknown), "got %v", err)
+	assert.Equal(t, 1, s.callCount(uaIDCreateSubscriptionRequest))
+
+	// The current value first, then every change
+	require.Eventually(t, func() bool { return len(received("Chamber1.Temperature")) == 1 }, time.Second, 5*time.Millisecond)
+	s.set("ns=2;s=Chamber1.Temperature", 22.0)
+	s.set("ns=2;s=Chamber1.Step", int16(5))
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]interface{}{21.5, 22.0}, received("Chamber1.Temperature")) &&
+			assert.ObjectsAreEqual([]interface{}{int16(3), int16(5)}, received("Chamber1.Step"))
+	}, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, c.DeleteNotification(h))
+	assert.Equal(t, 1, s.itemCount())
+	s.set("ns=2;s=Chamber1.Temperature", 23.0)
+	s.set("ns=2;s=Chamber1.Step", int16(6))
+	require.Eventually(t, func() bool { return len(received("Chamber1.Step")) == 3 }, time.Second, 5*time.Millisecond)
+	assert.Len(t, received("Chamber1.Temperature"), 2)
+
+	require.NoError(t, c.Close())
+	assert.Equal(t, 1, s.callCount(uaIDDeleteSubscriptionsRequest))
+	assert.Equal(t, 1, s.callCount(uaIDCloseSessionRequest))
+}
+
+func TestOPCUAClient_ConnectionLoss(t *testing.T) {
+	s := newChamberServer(t)
+	c := s.client(t)
+	_, err := c.AddNotification("Chamber1.Step", NotificationAttrib{}, func(NotificationSample) {})
+	require.NoError(t, err)
+
+	s.dropConnections()
+	require.Eventually(t, c.Closed, time.Second, 5*time.Millisecond)
+	_, err = c.ReadSymbol("Chamber1.Step")
+	assert.ErrorIs(t, err, ErrClientClosed)
+}
+
+func TestEngine_OPCUA(t *testing.T) {
+	s := newChamberServer(t)
+
+	e := NewEngine(nil)
+	e.ReconnectPolicy = ReconnectPolicy{InitialBackoff: 10 * time.Millisecond, HealthInterval: 10 * time.Millisecond}
+	e.Drivers[ProtocolOPCUA] = OPCUADriver{Namespace: 2, PublishInterval: 10 * time.Millisecond}
+	values := e.Subscribe(ValueFilter{})
+	require.NoError(t, e.Start([]MachineConfig{{
+		ID:       "m1",
+		IP:       "127.0.0.1",
+		Port:     s.Port(),
+		Protocol: ProtocolOPCUA,
+		Symbols:  []SymbolInfo{{Name: "Chamber1.Setpoint", IsWritable: true, MinValue: 0, MaxValue: 100}},
+	}}))
+	defer e.Stop()
+
+	require.Eventually(t, func() bool {
+		conn, err := e.getConnection("m1")
+		return err == nil && conn.Symbols() != nil
+	}, time.Second, 5*time.Millisecond)
+
+	v, err := e.ReadSymbol("m1", "Chamber1.Temperature")
+	require.NoError(t, err)
+	assert.Equal(t, 21.5, v.Value)
+	assert.Equal(t, TypeLReal, v.Type)
+	assert.Equal(t, QualityGood, v.Quality)
+
+	nodes, err := e.Browse("m1", "ns=2;s=Chamber1")
+	require.NoError(t, err)
+	assert.Len(t, nodes, 5)
+
+	// Writes go through the guards and the prioritized writer
+	resp := <-e.WriteAsync(WriteRequest{ID: "w1", MachineID: "m1", Symbol: "Chamber1.Setpoint", Value: 55.0, RequireAck: true})
+	require.True(t, resp.Success, resp.Error)
+	assert.Equal(t, float32(55), s.value("ns=2;s=Chamber1.Setpoint"))
+	resp = <-e.WriteAsync(WriteRequest{ID: "w2", MachineID: "m1", Symbol: "Chamber1.Setpoint", Value: 500.0})
+	require.NotNil(t, resp.Rejection)
+	assert.Equal(t, RejectOutOfRange, resp.Rejection.Reason)
+
+	// Monitored items feed the engine's value stream
+	sub, err := e.AddSubscription("m1", []SubscriptionSymbol{{Name: "Chamber1.Step"}})
+	require.NoError(t, err)
+	defer sub.Stop()
+	s.set("ns=2;s=Chamber1.Step", int16(9))
+	require.Eventually(t, func() bool {
+		select {
+		case v := <-values:
+			return v.Source == "m1" && v.Symbol == "Chamber1.Step" && v.Value == int16(9) && v.Type == TypeInt16
+		default:
+			return false
+		}
+	}, time.Second, 5*time.Millisecond)
+
+	// The connection comes back with its monitored items
+	s.dropConnections()
+	require.Eventually(t, func() bool { return s.itemCount() == 1 }, 2*time.Second, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, e.GetStatus()["m1"].ReconnectCount, 2)
+
+	// Unknown protocols are rejected
+	assert.ErrorContains(t, e.AddMachine(MachineConfig{ID: "m2", Protocol: "s7"}), "unsupported protocol")
+}
@@ -0,0 +1,685 @@
+package plcengine
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUANode is a node in the address space of fakeUAServer
+type fakeUANode struct {
+	id         NodeID
+	browseName string
+	class      uint32
+	children   []NodeID
+	vtype      byte
+	value      interface{}
+	writable   bool
+	version    int // incremented on every change, drives monitored items
+}
+
+// fakeUAItem is a monitored item of a subscription
+type fakeUAItem struct {
+	id       uint32
+	handle   uint32
+	node     NodeID
+	reported int
+}
+
+type fakeUASubscription struct {
+	id        uint32
+	interval  time.Duration
+	keepAlive int
+	items     map[uint32]*fakeUAItem
+	sequence  uint32
+}
+
+// fakeUAConn is an accepted client connection
+type fakeUAConn struct {
+	net.Conn
+	mu      sync.Mutex
+	channel uint32
+	seq     uint32
+}
+
+func (c *fakeUAConn) send(msgType string, requestID uint32, body []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var w uaWriter
+	w.uint32(c.channel)
+	if msgType == uaMsgOpen {
+		w.string(uaSecurityNone)
+		w.bytes(nil)
+		w.bytes(nil)
+	} else {
+		w.uint32(1) // token ID
+	}
+	c.seq++
+	w.uint32(c.seq)
+	w.uint32(requestID)
+	w.b = append(w.b, body...)
+	_, err := c.Write(uaFrame(msgType, uaChunkFinal, w.b))
+	return err
+}
+
+// fakeUAServer is an in-process OPC UA server. It speaks enough of the
+// binary protocol with security policy None to exercise OPCUAClient: secure
+// channel, anonymous session, browse, read, write and subscriptions with
+// monitored items.
+type fakeUAServer struct {
+	t  *testing.T
+	ln net.Listener
+
+	// maxRefs limits the references per Browse response to exercise BrowseNext
+	maxRefs int
+
+	mu            sync.Mutex
+	nodes         map[string]*fakeUANode
+	conns         []*fakeUAConn
+	sessions      map[string]bool // authentication tokens
+	subscriptions map[uint32]*fakeUASubscription
+	nextID        uint32
+	calls         map[uint32]int // requests per service
+}
+
+const fakeUAPolicy = "anonymous-none"
+
+func newFakeUAServer(t *testing.T) *fakeUAServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &fakeUAServer{
+		t:             t,
+		ln:            ln,
+		maxRefs:       2,
+		nodes:         make(map[string]*fakeUANode),
+		sessions:      make(map[string]bool),
+		subscriptions: make(map[uint32]*fakeUASubscription),
+		calls:         make(map[uint32]int),
+	}
+	objects := NumericNodeID(0, uaIDObjectsFolder)
+	s.nodes[objects.String()] = &fakeUANode{id: objects, browseName: "Objects", class: UANodeClassObject}
+	go s.accept()
+	t.Cleanup(s.close)
+	return s
+}
+
+func (s *fakeUAServer) Endpoint() string { return "opc.tcp://" + s.ln.Addr().String() }
+
+func (s *fakeUAServer) Port() int { return s.ln.Addr().(*net.TCPAddr).Port }
+
+func (s *fakeUAServer) client(t *testing.T) *OPCUAClient {
+	t.Helper()
+	c, err := NewOPCUAClient(OPCUAConfig{Endpoint: s.Endpoint(), Namespace: 2, PublishInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("connect fake OPC UA server: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func (s *fakeUAServer) add(parent string, n *fakeUANode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.nodes[parent]
+	if !ok {
+		s.t.Fatalf("parent node %s not found", parent)
+	}
+	p.children = append(p.children, n.id)
+	s.nodes[n.id.String()] = n
+}
+
+func (s *fakeUAServer) addObject(parent, id, name string) {
+	node, _ := ParseNodeID(id)
+	s.add(parent, &fakeUANode{id: node, browseName: name, class: UANodeClassObject})
+}
+
+func (s *fakeUAServer) addVariable(parent, id, name string, vtype byte, value interface{}, writable bool) {
+	node, _ := ParseNodeID(id)
+	s.add(parent, &fakeUANode{id: node, browseName: name, class: UANodeClassVariable, vtype: vtype, value: value, writable: writable})
+}
+
+// set changes a value as if the PLC did
+func (s *fakeUAServer) set(id string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.nodes[id]
+	n.value = value
+	n.version++
+}
+
+func (s *fakeUAServer) value(id string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nodes[id].value
+}
+
+func (s *fakeUAServer) callCount(service uint32) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[service]
+}
+
+func (s *fakeUAServer) itemCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, sub := range s.subscriptions {
+		n += len(sub.items)
+	}
+	return n
+}
+
+// dropConnections closes every client connection, as a lost network would
+func (s *fakeUAServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+	s.sessions = make(map[string]bool)
+	s.subscriptions = make(map[uint32]*fakeUASubscription)
+}
+
+func (s *fakeUAServer) close() {
+	s.ln.Close()
+	s.dropConnections()
+}
+
+func (s *fakeUAServer) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &fakeUAConn{Conn: conn}
+		s.mu.Lock()
+		s.nextID++
+		c.channel = s.nextID
+		s.conns = append(s.conns, c)
+		s.mu.Unlock()
+		go s.serve(c)
+	}
+}
+
+func (s *fakeUAServer) serve(c *fakeUAConn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+
+	msgType, _, _, err := readUAChunk(r)
+	if err != nil || msgType != uaMsgHello {
+		return
+	}
+	var ack uaWriter
+	ack.uint32(0)
+	ack.uint32(8192) // receive buffer, large writes arrive in chunks
+	ack.uint32(uaBufferSize)
+	ack.uint32(uaMaxMessageSize)
+	ack.uint32(0)
+	if _, err := c.Write(uaFrame(uaMsgAck, uaChunkFinal, ack.b)); err != nil {
+		return
+	}
+
+	partial := make(map[uint32][]byte)
+	for {
+		msgType, chunk, body, err := readUAChunk(r)
+		if err != nil || msgType == uaMsgClose {
+			return
+		}
+		rd := &uaReader{b: body}
+		rd.uint32() // channel
+		if msgType == uaMsgOpen {
+			rd.string()
+			rd.bytes()
+			rd.bytes()
+		} else {
+			rd.uint32()
+		}
+		rd.uint32()
+		id := rd.uint32()
+		if rd.err != nil {
+			return
+		}
+		partial[id] = append(partial[id], body[rd.pos:]...)
+		if chunk != uaChunkFinal {
+			continue
+		}
+		req := partial[id]
+		delete(partial, id)
+
+		if msgType == uaMsgOpen {
+			s.openChannel(c, id, req)
+			continue
+		}
+		s.handle(c, id, req)
+	}
+}
+
+func (s *fakeUAServer) openChannel(c *fakeUAConn, id uint32, req []byte) {
+	r := &uaReader{b: req}
+	r.nodeID()
+	_, handle := r.requestHeader()
+
+	var w uaWriter
+	w.nodeID(NumericNodeID(0, uaIDOpenSecureChannelResponse))
+	w.responseHeader(handle, 0)
+	w.uint32(0)
+	w.uint32(c.channel)
+	w.uint32(1) // token ID
+	w.dateTime(time.Now())
+	w.uint32(uint32(uaChannelLifetime / time.Millisecond))
+	w.bytes(nil)
+	c.send(uaMsgOpen, id, w.b)
+}
+
+func (s *fakeUAServer) fault(c *fakeUAConn, id, handle, status uint32) {
+	var w uaWriter
+	w.nodeID(NumericNodeID(0, uaIDServiceFault))
+	w.responseHeader(handle, status)
+	c.send(uaMsg, id, w.b)
+}
+
+func (s *fakeUAServer) handle(c *fakeUAConn, id uint32, req []byte) {
+	r := &uaReader{b: req}
+	service := r.nodeID().Numeric
+	token, handle := r.requestHeader()
+	if r.err != nil {
+		s.fault(c, id, handle, UAStatusBadDecodingError)
+		return
+	}
+
+	s.mu.Lock()
+	s.calls[service]++
+	valid := s.sessions[token.String()]
+	s.mu.Unlock()
+	if service != uaIDCreateSessionRequest && !valid {
+		s.fault(c, id, handle, UAStatusBadSessionIDInvalid)
+		return
+	}
+
+	var w uaWriter
+	respond := func(respType uint32) {
+		w.nodeID(NumericNodeID(0, respType))
+		w.responseHeader(handle, 0)
+	}
+
+	switch service {
+	case uaIDCreateSessionRequest:
+		s.mu.Lock()
+		s.nextID++
+		auth := NumericNodeID(1, s.nextID)
+		s.sessions[auth.String()] = true
+		s.mu.Unlock()
+
+		respond(uaIDCreateSessionResponse)
+		w.nodeID(auth) // session ID
+		w.nodeID(auth)
+		w.float64(60000)
+		w.bytes(make([]byte, 32))
+		w.bytes(nil)
+		w.int32(1) // endpoints
+		w.string(s.Endpoint())
+		w.string("urn:fake")
+		w.string("urn:fake")
+		w.localizedText("fake")
+		w.uint32(0)
+		w.nullString("")
+		w.nullString("")
+		w.int32(-1)
+		w.bytes(nil)
+		w.uint32(uaMessageSecNone)
+		w.string(uaSecurityNone)
+		w.int32(2) // user token policies, username first
+		w.string("username")
+		w.uint32(1)
+		w.nullString("")
+		w.nullString("")
+		w.nullString("")
+		w.string(fakeUAPolicy)
+		w.uint32(0)
+		w.nullString("")
+		w.nullString("")
+		w.nullString("")
+		w.string("http://opcfoundation.org/UA-Profile/Transport/uatcp-uasc-uabinary")
+		w.byte(0)
+		w.int32(-1) // software certificates
+		w.nullString("")
+		w.bytes(nil)
+		w.uint32(0)
+
+	case uaIDActivateSessionRequest:
+		r.string()
+		r.bytes()
+		r.arrayLen()
+		r.arrayLen()
+		typeID, body := r.extensionObject()
+		policy := (&uaReader{b: body}).string()
+		if typeID != uaIDAnonymousIdentityToken || policy != fakeUAPolicy {
+			s.fault(c, id, handle, UAStatusBadIdentityTokenInvalid)
+			return
+		}
+		respond(uaIDActivateSessionResponse)
+		w.bytes(nil)
+		w.int32(-1)
+		w.int32(-1)
+
+	case uaIDCloseSessionRequest:
+		s.mu.Lock()
+		delete(s.sessions, token.String())
+		s.mu.Unlock()
+		respond(uaIDCloseSessionResponse)
+
+	case uaIDReadRequest:
+		r.float64()
+		r.uint32()
+		n := r.arrayLen()
+		respond(uaIDReadResponse)
+		w.int32(int32(n))
+		for i := 0; i < n; i++ {
+			node := r.nodeID()
+			attr := r.uint32()
+			r.string()
+			r.qualifiedName()
+			w.dataValue(s.read(node, attr))
+		}
+		w.int32(-1)
+
+	case uaIDWriteRequest:
+		n := r.arrayLen()
+		respond(uaIDWriteResponse)
+		w.int32(int32(n))
+		for i := 0; i < n; i++ {
+			node := r.nodeID()
+			r.uint32()
+			r.string()
+			dv := r.dataValue()
+			w.uint32(s.write(node, dv))
+		}
+		w.int32(-1)
+
+	case uaIDBrowseRequest:
+		r.nodeID()
+		r.dateTime()
+		r.uint32()
+		r.uint32()
+		n := r.arrayLen()
+		respond(uaIDBrowseResponse)
+		w.int32(int32(n))
+		for i := 0; i < n; i++ {
+			node := r.nodeID()
+			r.uint32()
+			r.nodeID()
+			r.bool()
+			r.uint32()
+			r.uint32()
+			s.browse(&w, node.String(), 0)
+		}
+		w.int32(-1)
+
+	case uaIDBrowseNextRequest:
+		r.bool()
+		n := r.arrayLen()
+		respond(uaIDBrowseNextResponse)
+		w.int32(int32(n))
+		for i := 0; i < n; i++ {
+			cont := string(r.bytes())
+			sep := strings.LastIndexByte(cont, '|')
+			offset, _ := strconv.Atoi(cont[sep+1:])
+			s.browse(&w, cont[:sep], offset)
+		}
+		w.int32(-1)
+
+	case uaIDCreateSubscriptionRequest:
+		interval := r.float64()
+		r.uint32()
+		keepAlive := r.uint32()
+		s.mu.Lock()
+		s.nextID++
+		sub := &fakeUASubscription{
+			id:        s.nextID,
+			interval:  time.Duration(interval * float64(time.Millisecond)),
+			keepAlive: int(keepAlive),
+			items:     make(map[uint32]*fakeUAItem),
+		}
+		s.subscriptions[sub.id] = sub
+		s.mu.Unlock()
+		respond(uaIDCreateSubscriptionResponse)
+		w.uint32(sub.id)
+		w.float64(interval)
+		w.uint32(uaLifetimeCount)
+		w.uint32(keepAlive)
+
+	case uaIDCreateMonitoredItemsRequest:
+		subID := r.uint32()
+		r.uint32()
+		n := r.arrayLen()
+		respond(uaIDCreateMonitoredItemsResp)
+		w.int32(int32(n))
+		for i := 0; i < n; i++ {
+			node := r.nodeID()
+			r.uint32()
+			r.string()
+			r.qualifiedName()
+			r.uint32()
+			clientHandle := r.uint32()
+			r.float64()
+			r.extensionObject()
+			r.uint32()
+			r.bool()
+
+			s.mu.Lock()
+			sub, subOK := s.subscriptions[subID]
+			_, nodeOK := s.nodes[node.String()]
+			status := UAStatusGood
+			var itemID uint32
+			switch {
+			case !subOK:
+				status = UAStatusBadSubscriptionIDInvalid
+			case !nodeOK:
+				status = UAStatusBadNodeIDUnknown
+			default:
+				s.nextID++
+				itemID = s.nextID
+				sub.items[itemID] = &fakeUAItem{id: itemID, handle: clientHandle, node: node, reported: -1}
+			}
+			s.mu.Unlock()
+			w.uint32(status)
+			w.uint32(itemID)
+			w.float64(0)
+			w.uint32(1)
+			w.extensionObject(0, nil)
+		}
+		w.int32(-1)
+
+	case uaIDDeleteMonitoredItemsRequest:
+		subID := r.uint32()
+		n := r.arrayLen()
+		respond(uaIDDeleteMonitoredItemsResp)
+		w.int32(int32(n))
+		s.mu.Lock()
+		for i := 0; i < n; i++ {
+			itemID := r.uint32()
+			sub, ok := s.subscriptions[subID]
+			if !ok || sub.items[itemID] == nil {
+				w.uint32(UAStatusBadMonitoredItemInvalid)
+				continue
+			}
+			delete(sub.items, itemID)
+			w.uint32(UAStatusGood)
+		}
+		s.mu.Unlock()
+		w.int32(-1)
+
+	case uaIDDeleteSubscriptionsRequest:
+		n := r.arrayLen()
+		respond(uaIDDeleteSubscriptionsResponse)
+		w.int32(int32(n))
+		s.mu.Lock()
+		for i := 0; i < n; i++ {
+			subID := r.uint32()
+			delete(s.subscriptions, subID)
+			w.uint32(UAStatusGood)
+		}
+		s.mu.Unlock()
+		w.int32(-1)
+
+	case uaIDPublishRequest:
+		// Held until a monitored item changes or the keep-alive is due
+		go s.publish(c, id, handle)
+		return
+
+	default:
+		s.fault(c, id, handle, UAStatusBadServiceUnsupported)
+		return
+	}
+	c.send(uaMsg, id, w.b)
+}
+
+func (s *fakeUAServer) read(node NodeID, attr uint32) uaDataValue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodes[node.String()]
+	if !ok {
+		return uaDataValue{Status: UAStatusBadNodeIDUnknown}
+	}
+	if n.class != UANodeClassVariable {
+		return uaDataValue{Status: UAStatusBadAttributeIDInvalid}
+	}
+	switch attr {
+	case uaAttrValue:
+		return uaDataValue{Value: n.value, Type: n.vtype, SourceTimestamp: time.Now()}
+	case uaAttrDataType:
+		return uaDataValue{Value: NumericNodeID(0, uint32(n.vtype)), Type: uaTypeNodeID}
+	case uaAttrValueRank:
+		return uaDataValue{Value: int32(-1), Type: uaTypeInt32}
+	case uaAttrAccessLevel:
+		access := uint8(1)
+		if n.writable {
+			access |= uaAccessWrite
+		}
+		return uaDataValue{Value: access, Type: uaTypeByte}
+	}
+	return uaDataValue{Status: UAStatusBadAttributeIDInvalid}
+}
+
+func (s *fakeUAServer) write(node NodeID, dv uaDataValue) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodes[node.String()]
+	switch {
+	case !ok:
+		return UAStatusBadNodeIDUnknown
+	case !n.writable:
+		return UAStatusBadNotWritable
+	case dv.Type != n.vtype:
+		return UAStatusBadTypeMismatch
+	}
+	n.value = dv.Value
+	n.version++
+	return UAStatusGood
+}
+
+// browse writes a BrowseResult with the children of a node from offset on
+func (s *fakeUAServer) browse(w *uaWriter, node string, offset int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodes[node]
+	if !ok {
+		w.uint32(UAStatusBadNodeIDUnknown)
+		w.bytes(nil)
+		w.int32(-1)
+		return
+	}
+	children := n.children[min(offset, len(n.children)):]
+	var cont []byte
+	if len(children) > s.maxRefs {
+		children = children[:s.maxRefs]
+		cont = []byte(fmt.Sprintf("%s|%d", node, offset+s.maxRefs))
+	}
+	w.uint32(UAStatusGood)
+	w.bytes(cont)
+	w.int32(int32(len(children)))
+	for _, id := range children {
+		child := s.nodes[id.String()]
+		w.nodeID(NumericNodeID(0, 35)) // Organizes
+		w.bool(true)
+		w.nodeID(child.id)
+		w.qualifiedName(child.id.Namespace, child.browseName)
+		w.localizedText(child.browseName)
+		w.uint32(child.class)
+		w.nodeID(NodeID{})
+	}
+}
+
+// publish answers a Publish request with the changed items of the first
+// subscription, or an empty keep-alive
+func (s *fakeUAServer) publish(c *fakeUAConn, id, handle uint32) {
+	for tick := 0; ; tick++ {
+		s.mu.Lock()
+		var sub *fakeUASubscription
+		for _, candidate := range s.subscriptions {
+			sub = candidate
+			break
+		}
+		if sub == nil {
+			s.mu.Unlock()
+			s.fault(c, id, handle, UAStatusBadNoSubscription)
+			return
+		}
+
+		var changes uaWriter
+		count := 0
+		for _, item := range sub.items {
+			n := s.nodes[item.node.String()]
+			if n.version == item.reported {
+				continue
+			}
+			item.reported = n.version
+			changes.uint32(item.handle)
+			changes.dataValue(uaDataValue{Value: n.value, Type: n.vtype, SourceTimestamp: time.Now()})
+			count++
+		}
+		interval := sub.interval
+		if count == 0 && tick < sub.keepAlive {
+			s.mu.Unlock()
+			time.Sleep(interval)
+			continue
+		}
+		if count > 0 {
+			sub.sequence++
+		}
+		seq := sub.sequence
+		s.mu.Unlock()
+
+		var w uaWriter
+		w.nodeID(NumericNodeID(0, uaIDPublishResponse))
+		w.responseHeader(handle, 0)
+		w.uint32(sub.id)
+		w.int32(-1)
+		w.bool(false)
+		w.uint32(seq)
+		w.dateTime(time.Now())
+		if count == 0 {
+			w.int32(0)
+		} else {
+			var data uaWriter
+			data.int32(int32(count))
+			data.b = append(data.b, changes.b...)
+			data.int32(-1)
+			w.int32(1)
+			w.extensionObject(uaIDDataChangeNotification, data.b)
+		}
+		w.int32(-1)
+		w.int32(-1)
+		c.send(uaMsg, id, w.b)
+		return
+	}
+}
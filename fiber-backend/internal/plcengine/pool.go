@@ -0,0 +1,189 @@
+package plcengine
+
+import (
+	"sync"
+	"time"
+)
+
+// Lane is a class of requests to a controller with its own queue and
+// workers, so that slow bulk reads or writes do not delay cyclic polling
+type Lane string
+
+const (
+	LanePolling Lane = "polling" // cyclic reads of scalar symbols and notifications
+	LaneBulk    Lane = "bulk"    // large reads such as OES arrays, and symbol uploads
+	LaneWrite   Lane = "write"   // writes and batch writes
+)
+
+// laneOrder is the order of lanes in ConnectionStatus.Lanes
+var laneOrder = []Lane{LanePolling, LaneBulk, LaneWrite}
+
+// PoolConfig sizes the lanes of each controller connection. Zero fields take
+// the values of DefaultPoolConfig.
+type PoolConfig struct {
+	// Workers is the number of requests a lane runs at the same time
+	Workers map[Lane]int
+
+	// QueueSize is the request queue capacity of each lane
+	QueueSize int
+
+	// Reads of arrays, structs or at least BulkThreshold bytes use the bulk
+	// lane once the symbol table is known
+	BulkThreshold int
+
+	// ConnectionPerLane opens a TCP connection per lane instead of sharing
+	// one. The controller needs a route for the source AMS Net ID of every
+	// lane, see laneNetID.
+	ConnectionPerLane bool
+
+	// UtilisationWindow is the period lane utilisation is averaged over
+	UtilisationWindow time.Duration
+}
+
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{
+		Workers:           map[Lane]int{LanePolling: 1, LaneBulk: 1, LaneWrite: 1},
+		QueueSize:         100,
+		BulkThreshold:     1024,
+		UtilisationWindow: 10 * time.Second,
+	}
+}
+
+func (p PoolConfig) withDefaults() PoolConfig {
+	d := DefaultPoolConfig()
+	workers := make(map[Lane]int, len(laneOrder))
+	for _, l := range laneOrder {
+		workers[l] = p.Workers[l]
+		if workers[l] <= 0 {
+			workers[l] = d.Workers[l]
+		}
+	}
+	p.Workers = workers
+	if p.QueueSize <= 0 {
+		p.QueueSize = d.QueueSize
+	}
+	if p.BulkThreshold <= 0 {
+		p.BulkThreshold = d.BulkThreshold
+	}
+	if p.UtilisationWindow <= 0 {
+		p.UtilisationWindow = d.UtilisationWindow
+	}
+	return p
+}
+
+// LaneStatus reports the load of one lane of a controller connection
+type LaneStatus struct {
+	Lane          Lane          `json:"lane"`
+	Workers       int           `json:"workers"`
+	Busy          int           `json:"busy"` // requests in progress
+	QueueDepth    int           `json:"queue_depth"`
+	QueueCapacity int           `json:"queue_capacity"`
+	Requests      uint64        `json:"requests"`
+	Errors        uint64        `json:"errors"`
+	AvgLatency    time.Duration `json:"avg_latency"`
+
+	// Utilisation is the busy fraction of the workers, 0 to 1, over the last
+	// UtilisationWindow
+	Utilisation float64 `json:"utilisation"`
+}
+
+// connLane is the queue and statistics of one lane
+type connLane struct {
+	name     Lane
+	workers  int
+	requests chan *internalRequest
+	window   time.Duration
+
+	mu          sync.Mutex
+	busy        int
+	count       uint64
+	errors      uint64
+	latency     time.Duration // sum over all requests
+	busyTime    time.Duration // worker time spent on requests
+	lastChange  time.Time     // of busy
+	windowStart time.Time
+	windowBusy  time.Duration // busyTime at windowStart
+	utilisation float64       // of the last complete window
+}
+
+func newConnLane(name Lane, cfg PoolConfig) *connLane {
+	now := time.Now()
+	return &connLane{
+		name:        name,
+		workers:     cfg.Workers[name],
+		requests:    make(chan *internalRequest, cfg.QueueSize),
+		window:      cfg.UtilisationWindow,
+		lastChange:  now,
+		windowStart: now,
+	}
+}
+
+func (l *connLane) begin() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.advanceLocked(time.Now())
+	l.busy++
+}
+
+func (l *connLane) end(d time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.advanceLocked(time.Now())
+	l.busy--
+	l.count++
+	if err != nil {
+		l.errors++
+	}
+	l.latency += d
+}
+
+// advanceLocked adds the busy worker time up to now. l.mu must be held.
+func (l *connLane) advanceLocked(now time.Time) {
+	l.busyTime += time.Duration(l.busy) * now.Sub(l.lastChange)
+	l.lastChange = now
+}
+
+// rollLocked closes the current window once it is complete. l.mu must be held.
+func (l *connLane) rollLocked(now time.Time) {
+	l.advanceLocked(now)
+	elapsed := now.Sub(l.windowStart)
+	if elapsed < l.window {
+		return
+	}
+	l.utilisation = float64(l.busyTime-l.windowBusy) / (float64(elapsed) * float64(l.workers))
+	l.windowStart = now
+	l.windowBusy = l.busyTime
+}
+
+func (l *connLane) status() LaneStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rollLocked(time.Now())
+
+	s := LaneStatus{
+		Lane:          l.name,
+		Workers:       l.workers,
+		Busy:          l.busy,
+		QueueDepth:    len(l.requests),
+		QueueCapacity: cap(l.requests),
+		Requests:      l.count,
+		Errors:        l.errors,
+		Utilisation:   l.utilisation,
+	}
+	if l.count > 0 {
+		s.AvgLatency = l.latency / time.Duration(l.count)
+	}
+	return s
+}
+
+// laneNetID derives the source AMS Net ID of a lane connection from the base
+// ID by setting its last byte to 1, 2, 3 for the polling, bulk and write
+// lane, e.g. 10.0.0.5.1.1 becomes 10.0.0.5.1.3 for writes
+func laneNetID(base AmsNetID, lane Lane) AmsNetID {
+	for i, l := range laneOrder {
+		if l == lane {
+			base[5] = byte(i + 1)
+		}
+	}
+	return base
+}
@@ -0,0 +1,171 @@
+package plcengine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRouteService answers TwinCAT add-route requests over UDP
+type fakeRouteService struct {
+	conn     net.PacketConn
+	password string
+
+	mu     sync.Mutex
+	routes map[AmsNetID]string // route name per source AMS Net ID
+}
+
+func newFakeRouteService(t *testing.T, addr, password string) *fakeRouteService {
+	conn, err := net.ListenPacket("udp", addr)
+	require.NoError(t, err)
+	s := &fakeRouteService{conn: conn, password: password, routes: make(map[AmsNetID]string)}
+	t.Cleanup(func() { conn.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeRouteService) serve() {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := buf[:n]
+		var source AmsNetID
+		copy(source[:], req[12:18])
+
+		tags := map[uint16]string{}
+		rest := req[24:]
+		for len(rest) >= 4 {
+			tag := binary.LittleEndian.Uint16(rest)
+			size := int(binary.LittleEndian.Uint16(rest[2:]))
+			tags[tag] = string(bytes.TrimRight(rest[4:4+size], "\x00"))
+			rest = rest[4+size:]
+		}
+
+		status := ADSErrNoError
+		if tags[routeTagPassword] != s.password {
+			status = ADSErrInvalidAccess
+		} else {
+			s.mu.Lock()
+			s.routes[source] = tags[routeTagName]
+			s.mu.Unlock()
+		}
+
+		var resp bytes.Buffer
+		resp.Write(routeMagic)
+		resp.Write(req[4:8])
+		binary.Write(&resp, binary.LittleEndian, routeServiceAddRoute|routeServiceResponse)
+		resp.Write(source[:])
+		binary.Write(&resp, binary.LittleEndian, uint16(10000))
+		binary.Write(&resp, binary.LittleEndian, uint32(1))
+		binary.Write(&resp, binary.LittleEndian, routeTagStatus)
+		binary.Write(&resp, binary.LittleEndian, uint16(4))
+		binary.Write(&resp, binary.LittleEndian, status)
+		s.conn.WriteTo(resp.Bytes(), addr)
+	}
+}
+
+func (s *fakeRouteService) routeNames() map[AmsNetID]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[AmsNetID]string, len(s.routes))
+	for k, v := range s.routes {
+		out[k] = v
+	}
+	return out
+}
+
+func TestAddRoute(t *testing.T) {
+	svc := newFakeRouteService(t, "127.0.0.1:0", "secret")
+	source, err := ParseAmsNetID("10.1.2.3.1.1")
+	require.NoError(t, err)
+
+	err = AddRoute(t.Context(), svc.conn.LocalAddr().String(), source, RouteConfig{Username: "Administrator", Password: "wrong"})
+	assert.True(t, IsADSError(err, ADSErrInvalidAccess), "got %v", err)
+
+	require.NoError(t, AddRoute(t.Context(), svc.conn.LocalAddr().String(), source, RouteConfig{Username: "Administrator", Password: "secret"}))
+	assert.Equal(t, map[AmsNetID]string{source: "10.1.2.3.1.1"}, svc.routeNames())
+}
+
+func TestEngine_ConnectionPerLane(t *testing.T) {
+	r := newTestRouter(t)
+	svc := newFakeRouteService(t, r.Addr(), "1")
+
+	e := NewEngine(make(chan PLCValue, 10))
+	e.PoolConfig = PoolConfig{ConnectionPerLane: true, BulkThreshold: 8}
+	e.SourceNetID = "10.9.8.7.1.1"
+	e.Route = &RouteConfig{Name: "fab7-backend", Username: "Administrator", Password: "1"}
+	require.NoError(t, e.Start([]MachineConfig{{ID: "m1", IP: "192.0.2.10", Gateway: r.Addr(), AmsNetID: r.NetID(), Port: 851}}))
+	defer e.Stop()
+
+	require.Eventually(t, func() bool {
+		conn, err := e.getConnection("m1")
+		return err == nil && conn.Symbols() != nil
+	}, time.Second, 10*time.Millisecond)
+
+	// A route and a TCP connection per lane
+	assert.Equal(t, map[AmsNetID]string{
+		{10, 9, 8, 7, 1, 1}: "fab7-backend",
+		{10, 9, 8, 7, 1, 2}: "fab7-backend",
+		{10, 9, 8, 7, 1, 3}: "fab7-backend",
+	}, svc.routeNames())
+	r.mu.Lock()
+	assert.Len(t, r.conns, 3)
+	r.mu.Unlock()
+
+	_, err := e.ReadSymbol("m1", "GVL.Temperature")
+	require.NoError(t, err)
+	_, err = e.ReadSymbols("m1", []string{"GVL.Temperature", "GVL.Pressure", "GVL.Step"})
+	require.NoError(t, err)
+	require.NoError(t, e.WriteSymbol("m1", "GVL.Step", 4))
+
+	lanes := e.GetStatus()["m1"].Lanes
+	require.Len(t, lanes, 3)
+	assert.Equal(t, LanePolling, lanes[0].Lane)
+	assert.Equal(t, uint64(1), lanes[0].Requests)
+	assert.Equal(t, LaneBulk, lanes[1].Lane)
+	assert.Equal(t, uint64(1), lanes[1].Requests)
+	assert.Equal(t, LaneWrite, lanes[2].Lane)
+	assert.Equal(t, uint64(1), lanes[2].Requests)
+}
+
+func TestEngine_LanesRunInParallel(t *testing.T) {
+	client := &blockingClient{MockADSClient: NewMockADSClient("m1"), release: make(chan struct{})}
+	e := NewEngine(make(chan PLCValue, 10))
+	e.PoolConfig = PoolConfig{Workers: map[Lane]int{LanePolling: 2}, UtilisationWindow: 20 * time.Millisecond}
+	e.ClientFactory = func(ip, amsID string, port int) (ADSClient, error) { return client, nil }
+	require.NoError(t, e.Start([]MachineConfig{{ID: "m1", IP: "10.0.0.1"}}))
+	defer e.Stop()
+	require.Eventually(t, func() bool { return e.GetStatus()["m1"].Connected }, time.Second, 5*time.Millisecond)
+
+	// Both polling workers hang on the PLC, writes are not held up
+	go e.ReadSymbol("m1", "GVL.A")
+	go e.ReadSymbol("m1", "GVL.B")
+	require.Eventually(t, func() bool { return e.GetStatus()["m1"].Lanes[0].Busy == 2 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, e.WriteSymbol("m1", "GVL.Setpoint", 1))
+	assert.Equal(t, int32(1), client.writes.Load())
+
+	// Both workers were busy for the whole last window, which starts with
+	// the first status call after a full window
+	time.Sleep(25 * time.Millisecond)
+	e.GetStatus()
+	time.Sleep(25 * time.Millisecond)
+	polling := e.GetStatus()["m1"].Lanes[0]
+	assert.Equal(t, 2, polling.Workers)
+	assert.InDelta(t, 1, polling.Utilisation, 0.01)
+
+	close(client.release)
+	require.Eventually(t, func() bool {
+		lane := e.GetStatus()["m1"].Lanes[0]
+		return lane.Requests == 2 && lane.Busy == 0
+	}, time.Second, 5*time.Millisecond)
+	assert.GreaterOrEqual(t, e.GetStatus()["m1"].Lanes[0].AvgLatency, 40*time.Millisecond)
+}
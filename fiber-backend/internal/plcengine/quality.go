@@ -0,0 +1,90 @@
+package plcengine
+
+import (
+	"errors"
+	"math"
+	"strings"
+)
+
+// Quality is the OPC style quality of a PLCValue. Dashboards show values
+// that are not good or substituted greyed out.
+type Quality string
+
+const (
+	QualityGood        Quality = "good"
+	QualityUncertain   Quality = "uncertain"   // stale, the last known value
+	QualityBad         Quality = "bad"         // the value must not be used
+	QualitySubstituted Quality = "substituted" // set by an operator instead of read from the PLC
+)
+
+// Usable reports whether the value reflects the process or a deliberate
+// substitute
+func (q Quality) Usable() bool {
+	return q == QualityGood || q == QualitySubstituted
+}
+
+// QualityReason explains a quality other than good
+type QualityReason string
+
+const (
+	ReasonStale        QualityReason = "stale"         // the read failed, the value is the last one read
+	ReasonNotConnected QualityReason = "not_connected" // the PLC is offline
+	ReasonCommFailure  QualityReason = "comm_failure"  // the read of the symbol failed
+	ReasonTypeMismatch QualityReason = "type_mismatch" // the PLC type differs from the configured one
+	ReasonOutOfRange   QualityReason = "out_of_range"  // outside the configured MinValue/MaxValue
+	ReasonSubstituted  QualityReason = "substituted"   // see SetSubstitute
+)
+
+// AssessQuality downgrades a good value read from the PLC that does not fit
+// its configuration: a PLC type other than cfg.TypeName is a type mismatch, a
+// numeric value outside [MinValue, MaxValue] is out of range. Symbols with
+// MinValue == MaxValue have no limits.
+func AssessQuality(v *PLCValue, cfg SymbolInfo) {
+	if v.Quality != QualityGood {
+		return
+	}
+	if !TypeMatches(cfg.TypeName, v.Type) {
+		v.Quality, v.QualityReason = QualityBad, ReasonTypeMismatch
+		return
+	}
+	if cfg.MinValue == cfg.MaxValue {
+		return
+	}
+	f, err := toFloat64(v.Value)
+	if err != nil {
+		return
+	}
+	if math.IsNaN(f) || f < cfg.MinValue || f > cfg.MaxValue {
+		v.Quality, v.QualityReason = QualityBad, ReasonOutOfRange
+	}
+}
+
+// TypeMatches reports whether a value of PLC type t fits the configured type
+// name. Besides PLC names such as "LREAL" the generic names float, int, bool
+// and string are accepted; unknown names match every type.
+func TypeMatches(name string, t PLCType) bool {
+	if name == "" || t == TypeUnknown {
+		return true
+	}
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "float", "double", "number":
+		return t == TypeReal || t == TypeLReal
+	case "int", "integer":
+		return isNumeric(t) && t != TypeReal && t != TypeLReal
+	case "bool", "boolean":
+		return t == TypeBool
+	case "string", "text":
+		return t == TypeString || t == TypeWString
+	}
+	configured := ParsePLCType(name)
+	return configured == TypeUnknown || configured == t
+}
+
+// ReadFailure returns the quality of the last known value of a symbol whose
+// read failed with err: bad while the PLC is offline, otherwise uncertain
+func ReadFailure(err error) (Quality, QualityReason) {
+	if errors.Is(err, ErrNotConnected) || errors.Is(err, ErrConnectionStopped) || errors.Is(err, ErrClientClosed) {
+		return QualityBad, ReasonNotConnected
+	}
+	return QualityUncertain, ReasonStale
+}
@@ -0,0 +1,92 @@
+package plcengine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssessQuality(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  PLCValue
+		cfg    SymbolInfo
+		want   Quality
+		reason QualityReason
+	}{
+		{"unconfigured", PLCValue{Value: 1.5, Type: TypeLReal}, SymbolInfo{}, QualityGood, ""},
+		{"in range", PLCValue{Value: float32(50), Type: TypeReal}, SymbolInfo{TypeName: "REAL", MinValue: 0, MaxValue: 100}, QualityGood, ""},
+		{"above range", PLCValue{Value: 101.0, Type: TypeLReal}, SymbolInfo{MinValue: 0, MaxValue: 100}, QualityBad, ReasonOutOfRange},
+		{"NaN", PLCValue{Value: math.NaN(), Type: TypeLReal}, SymbolInfo{MinValue: 0, MaxValue: 100}, QualityBad, ReasonOutOfRange},
+		{"PLC type differs", PLCValue{Value: int16(3), Type: TypeInt16}, SymbolInfo{TypeName: "REAL"}, QualityBad, ReasonTypeMismatch},
+		{"generic type name", PLCValue{Value: int32(3), Type: TypeInt32}, SymbolInfo{TypeName: "int"}, QualityGood, ""},
+		{"generic type differs", PLCValue{Value: true, Type: TypeBool}, SymbolInfo{TypeName: "float"}, QualityBad, ReasonTypeMismatch},
+		{"unknown type name", PLCValue{Value: true, Type: TypeBool}, SymbolInfo{TypeName: "ST_Valve"}, QualityGood, ""},
+		{"already stale", PLCValue{Value: 101.0, Type: TypeLReal, Quality: QualityUncertain, QualityReason: ReasonStale}, SymbolInfo{MinValue: 0, MaxValue: 100}, QualityUncertain, ReasonStale},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := tt.value
+			if v.Quality == "" {
+				v.Quality = QualityGood
+			}
+			AssessQuality(&v, tt.cfg)
+			assert.Equal(t, tt.want, v.Quality)
+			assert.Equal(t, tt.reason, v.QualityReason)
+		})
+	}
+}
+
+func TestReadFailure(t *testing.T) {
+	q, r := ReadFailure(fmt.Errorf("read: %w", ErrNotConnected))
+	assert.Equal(t, QualityBad, q)
+	assert.Equal(t, ReasonNotConnected, r)
+
+	q, r = ReadFailure(errors.New("device busy"))
+	assert.Equal(t, QualityUncertain, q)
+	assert.Equal(t, ReasonStale, r)
+	assert.False(t, q.Usable())
+	assert.True(t, QualitySubstituted.Usable())
+}
+
+func TestEngine_Quality(t *testing.T) {
+	e := NewEngine(nil)
+	e.ClientFactory = func(ip, amsID string, port int) (ADSClient, error) { return NewMockADSClient(ip), nil }
+	require.NoError(t, e.Start([]MachineConfig{{ID: "m1", IP: "10.0.0.1", Symbols: []SymbolInfo{
+		{Name: "GVL.Pressure", TypeName: "LREAL", MinValue: 0, MaxValue: 10},
+	}}}))
+	defer e.Stop()
+	require.Eventually(t, func() bool { return e.GetStatus()["m1"].Connected }, time.Second, 5*time.Millisecond)
+
+	// The mock answers 42
+	v, err := e.ReadSymbol("m1", "GVL.Temperature")
+	require.NoError(t, err)
+	assert.Equal(t, QualityGood, v.Quality)
+	v, err = e.ReadSymbol("m1", "GVL.Pressure")
+	require.NoError(t, err)
+	assert.Equal(t, QualityBad, v.Quality)
+	assert.Equal(t, ReasonOutOfRange, v.QualityReason)
+
+	require.NoError(t, e.SetSubstitute("m1", "gvl.pressure", 1.0))
+	assert.Error(t, e.SetSubstitute("m2", "GVL.Pressure", 1.0))
+	values, err := e.ReadSymbols("m1", []string{"GVL.Pressure", "GVL.Temperature"})
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, values["GVL.Pressure"].Value)
+	assert.Equal(t, QualitySubstituted, values["GVL.Pressure"].Quality)
+	assert.Equal(t, QualityGood, values["GVL.Temperature"].Quality)
+
+	// Pushed values are rated the same way
+	stream := e.Subscribe(ValueFilter{})
+	e.dataChan <- PLCValue{Source: "m1", Symbol: "GVL.Pressure", Value: 5.0, Quality: QualityGood}
+	assert.Equal(t, QualitySubstituted, receive(t, stream).Quality)
+
+	e.ClearSubstitute("m1", "GVL.Pressure")
+	v, err = e.ReadSymbol("m1", "GVL.Pressure")
+	require.NoError(t, err)
+	assert.Equal(t, QualityBad, v.Quality)
+}
@@ -1,7 +1,10 @@
 package plcengine
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"sync"
 	"time"
 )
 
@@ -50,42 +53,139 @@ func (r *BatchReader) run() {
 
 			now := time.Now()
 			for sym, val := range values {
-				r.dataChan <- PLCValue{
+				select {
+				case r.dataChan <- PLCValue{
 					Symbol:    sym,
 					Value:     val,
+					Type:      r.conn.valueType(sym, val),
 					Timestamp: now,
 					Source:    r.conn.MachineID,
-					Quality:   100,
+					Quality:   QualityGood,
+				}:
+				case <-r.stopChan:
+					return
 				}
 			}
 		}
 	}
 }
 
-// Subscription handles ADS notification based updates (Push instead of Pull)
+// SubscriptionSymbol configures the notification for one subscribed symbol
+type SubscriptionSymbol struct {
+	Name string
+	NotificationAttrib
+}
+
+// Subscription handles ADS notification based updates (Push instead of Pull).
+// The PLC pushes samples through AddDeviceNotification; they are decoded by the
+// client and delivered as PLCValue on the data channel, from where the engine
+// fans them out to its Subscribe streams.
 type Subscription struct {
 	conn     *PLCConnection
-	symbols  []string
+	symbols  []SubscriptionSymbol
 	dataChan chan<- PLCValue
 	stopChan chan struct{}
+
+	mu      sync.Mutex
+	handles map[string]uint32
+	dropped uint64
 }
 
-// In a real implementation, this would use ADS DLL/Library hooks for callbacks.
-// For now, we simulate the interface needed for notification handling.
-func NewSubscription(conn *PLCConnection, symbols []string, dataChan chan<- PLCValue) *Subscription {
+func NewSubscription(conn *PLCConnection, symbols []SubscriptionSymbol, dataChan chan<- PLCValue) *Subscription {
 	return &Subscription{
 		conn:     conn,
 		symbols:  symbols,
 		dataChan: dataChan,
 		stopChan: make(chan struct{}),
+		handles:  make(map[string]uint32),
 	}
 }
 
-func (s *Subscription) Start() {
-	// In real ADS, this would call AdsAddDeviceNotification
+// Start registers the notifications. If the PLC is offline they are
+// registered as soon as the connection comes up, and again after every
+// reconnect.
+func (s *Subscription) Start() error {
+	if err := s.conn.addSubscription(s); err != nil {
+		return err
+	}
 	log.Printf("Subscription started for %d symbols on machine %s", len(s.symbols), s.conn.MachineID)
+	return nil
 }
 
 func (s *Subscription) Stop() {
 	close(s.stopChan)
+	s.conn.removeSubscription(s)
+}
+
+// Dropped returns the number of samples discarded because dataChan was full
+func (s *Subscription) Dropped() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// register adds all notifications on client. Handles of a previous client are
+// discarded, the PLC drops them together with the old connection.
+func (s *Subscription) register(client ADSClient) error {
+	nc, ok := client.(NotificationClient)
+	if !ok {
+		return fmt.Errorf("client for machine %s does not support notifications", s.conn.MachineID)
+	}
+
+	s.mu.Lock()
+	s.handles = make(map[string]uint32)
+	s.mu.Unlock()
+
+	var errs []error
+	for _, sym := range s.symbols {
+		h, err := nc.AddNotification(sym.Name, sym.NotificationAttrib, s.deliver)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.mu.Lock()
+		s.handles[sym.Name] = h
+		s.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Subscription) unregister(client ADSClient) {
+	nc, ok := client.(NotificationClient)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	handles := s.handles
+	s.handles = make(map[string]uint32)
+	s.mu.Unlock()
+
+	for _, h := range handles {
+		_ = nc.DeleteNotification(h)
+	}
+}
+
+// deliver runs on the client's receive goroutine and therefore never blocks
+func (s *Subscription) deliver(sample NotificationSample) {
+	select {
+	case <-s.stopChan:
+		return
+	default:
+	}
+
+	select {
+	case s.dataChan <- PLCValue{
+		Symbol:    sample.Symbol,
+		Value:     sample.Value,
+		Type:      s.conn.valueType(sample.Symbol, sample.Value),
+		Timestamp: sample.Timestamp,
+		Source:    s.conn.MachineID,
+		Quality:   QualityGood,
+	}:
+	default:
+		s.mu.Lock()
+		s.dropped++
+		s.mu.Unlock()
+	}
 }
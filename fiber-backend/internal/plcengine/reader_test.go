@@ -0,0 +1,73 @@
+package plcengine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func waitForValue(t *testing.T, ch <-chan PLCValue, want interface{}) PLCValue {
+	t.Helper()
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case v := <-ch:
+			if v.Value == want {
+				return v
+			}
+		case <-timeout:
+			t.Fatalf("no value %v received", want)
+		}
+	}
+}
+
+func TestSubscription_DeliversAndSurvivesReconnect(t *testing.T) {
+	r := newTestRouter(t)
+
+	e := NewEngine(make(chan PLCValue, 100))
+	values := e.Subscribe(ValueFilter{})
+	require.NoError(t, e.Start([]MachineConfig{{ID: "m1", IP: r.Addr(), AmsNetID: r.NetID(), Port: 851}}))
+	defer e.Stop()
+
+	require.Eventually(t, func() bool { return e.GetStatus()["m1"].Connected }, time.Second, 10*time.Millisecond)
+
+	sub, err := e.AddSubscription("m1", []SubscriptionSymbol{
+		{Name: "GVL.Pressure", NotificationAttrib: NotificationAttrib{Mode: NotifyOnChange, CycleTime: time.Millisecond}},
+	})
+	require.NoError(t, err)
+
+	v := waitForValue(t, values, float32(0.75))
+	assert.Equal(t, "GVL.Pressure", v.Symbol)
+	assert.Equal(t, "m1", v.Source)
+
+	r.setValue("GVL.Pressure", real32(1.5))
+	waitForValue(t, values, float32(1.5))
+
+	// Lose the connection; the engine reconnects and registers again
+	r.dropConnections()
+	require.Eventually(t, func() bool { return r.notificationCount() == 1 }, 5*time.Second, 20*time.Millisecond)
+
+	r.setValue("GVL.Pressure", real32(2.5))
+	waitForValue(t, values, float32(2.5))
+
+	sub.Stop()
+	assert.Equal(t, 0, r.notificationCount())
+}
+
+func TestSubscription_UnknownSymbol(t *testing.T) {
+	r := newTestRouter(t)
+
+	e := NewEngine(make(chan PLCValue, 10))
+	require.NoError(t, e.Start([]MachineConfig{{ID: "m1", IP: r.Addr(), AmsNetID: r.NetID(), Port: 851}}))
+	defer e.Stop()
+
+	require.Eventually(t, func() bool { return e.GetStatus()["m1"].Connected }, time.Second, 10*time.Millisecond)
+
+	_, err := e.AddSubscription("m1", []SubscriptionSymbol{{Name: "GVL.Missing"}})
+	assert.True(t, IsADSError(err, ADSErrSymbolNotFound))
+
+	_, err = e.AddSubscription("m2", nil)
+	assert.Error(t, err)
+}
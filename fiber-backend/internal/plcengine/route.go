@@ -0,0 +1,158 @@
+package plcengine
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// AMSUDPPort is the UDP port of the TwinCAT route and discovery service
+const AMSUDPPort = 48899
+
+// Route service commands and tags of the TwinCAT UDP protocol
+const (
+	routeServiceAddRoute uint32 = 0x00000006
+	routeServiceResponse uint32 = 0x80000000
+
+	routeTagStatus   uint16 = 0x0001
+	routeTagPassword uint16 = 0x0002
+	routeTagHost     uint16 = 0x0005
+	routeTagNetID    uint16 = 0x0007
+	routeTagName     uint16 = 0x000C
+	routeTagUsername uint16 = 0x000D
+)
+
+var routeMagic = []byte{0x03, 0x66, 0x14, 0x71}
+
+// RouteConfig registers this host as a static route on a controller, which
+// TwinCAT requires before it answers ADS requests from a new AMS Net ID. The
+// route is added on the AMS router, i.e. the gateway of controllers behind
+// one.
+type RouteConfig struct {
+	Name     string // route name shown in TwinCAT, defaults to the source AMS Net ID
+	Host     string // address the router uses to reach this host, defaults to the local IP towards it
+	Username string // Windows/TwinCAT user of the router, e.g. "Administrator"
+	Password string
+	Timeout  time.Duration // defaults to 2s
+}
+
+// AddRoute adds a route for sourceNetID on the AMS router at address, which is
+// host or host:port with the port defaulting to AMSUDPPort
+func AddRoute(ctx context.Context, address string, sourceNetID AmsNetID, route RouteConfig) error {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, fmt.Sprint(AMSUDPPort))
+	}
+	timeout := route.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if route.Host == "" {
+		route.Host = conn.LocalAddr().(*net.UDPAddr).IP.String()
+	}
+	if route.Name == "" {
+		route.Name = sourceNetID.String()
+	}
+
+	if _, err := conn.Write(encodeAddRoute(sourceNetID, route)); err != nil {
+		return err
+	}
+	buf := make([]byte, 2048)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return fmt.Errorf("add route on %s: %w", address, err)
+	}
+	if err := parseAddRouteResponse(buf[:n]); err != nil {
+		return fmt.Errorf("add route on %s: %w", address, err)
+	}
+	return nil
+}
+
+func encodeAddRoute(source AmsNetID, route RouteConfig) []byte {
+	var tags bytes.Buffer
+	putTag := func(tag uint16, data []byte) {
+		binary.Write(&tags, binary.LittleEndian, tag)
+		binary.Write(&tags, binary.LittleEndian, uint16(len(data)))
+		tags.Write(data)
+	}
+	cstring := func(s string) []byte { return append([]byte(s), 0) }
+
+	putTag(routeTagName, cstring(route.Name))
+	putTag(routeTagNetID, source[:])
+	putTag(routeTagUsername, cstring(route.Username))
+	putTag(routeTagPassword, cstring(route.Password))
+	putTag(routeTagHost, cstring(route.Host))
+
+	var b bytes.Buffer
+	b.Write(routeMagic)
+	binary.Write(&b, binary.LittleEndian, uint32(0)) // invoke ID
+	binary.Write(&b, binary.LittleEndian, routeServiceAddRoute)
+	b.Write(source[:])
+	binary.Write(&b, binary.LittleEndian, uint16(10000)) // AMS port of the route service
+	binary.Write(&b, binary.LittleEndian, uint32(5))     // tag count
+	b.Write(tags.Bytes())
+	return b.Bytes()
+}
+
+// parseAddRouteResponse returns the ADS error of the status tag, e.g.
+// ADSErrInvalidAccess for wrong credentials
+func parseAddRouteResponse(b []byte) error {
+	const headerLen = 4 + 4 + 4 + 6 + 2 + 4
+	if len(b) < headerLen || !bytes.Equal(b[:4], routeMagic) {
+		return ErrInvalidResponse
+	}
+	if binary.LittleEndian.Uint32(b[8:]) != routeServiceAddRoute|routeServiceResponse {
+		return ErrInvalidResponse
+	}
+
+	count := binary.LittleEndian.Uint32(b[20:])
+	tags := b[headerLen:]
+	for i := uint32(0); i < count && len(tags) >= 4; i++ {
+		tag := binary.LittleEndian.Uint16(tags)
+		size := int(binary.LittleEndian.Uint16(tags[2:]))
+		if len(tags) < 4+size {
+			break
+		}
+		if tag == routeTagStatus && size >= 4 {
+			return adsErr(binary.LittleEndian.Uint32(tags[4:]))
+		}
+		tags = tags[4+size:]
+	}
+	return errors.New("route response without status")
+}
+
+// localNetID derives an AMS Net ID from the local IP address used to reach
+// address, the TwinCAT convention for hosts without a configured ID
+func localNetID(address string) (AmsNetID, error) {
+	host := address
+	if h, _, err := net.SplitHostPort(address); err == nil {
+		host = h
+	}
+	// A UDP "connection" only picks the route, nothing is sent
+	conn, err := net.Dial("udp", net.JoinHostPort(host, fmt.Sprint(AMSUDPPort)))
+	if err != nil {
+		return AmsNetID{}, err
+	}
+	defer conn.Close()
+	ip := conn.LocalAddr().(*net.UDPAddr).IP.To4()
+	if ip == nil {
+		return AmsNetID{}, errors.New("cannot derive source AMS net ID, set SourceNetID")
+	}
+	return AmsNetID{ip[0], ip[1], ip[2], ip[3], 1, 1}, nil
+}
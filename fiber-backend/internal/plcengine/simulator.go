@@ -0,0 +1,673 @@
+package plcengine
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SignalKind selects how a simulated symbol changes over time
+type SignalKind string
+
+const (
+	SignalConstant SignalKind = "constant" // Value, or Offset for numbers
+	SignalSine     SignalKind = "sine"     // Offset + Amplitude*sin(2π t/Period)
+	SignalRamp     SignalKind = "ramp"     // Min to Max over Period, then again from Min
+	SignalNoise    SignalKind = "noise"    // Offset ± Amplitude, a new sample every Period
+	SignalStep     SignalKind = "step"     // Min for Period, then Max for Period, and so on
+)
+
+// Signal generates the value of a simulated symbol from the time since the
+// simulator started. Numbers are converted to the symbol type, e.g. rounded
+// for INT or compared with zero for BOOL.
+type Signal struct {
+	Kind      SignalKind    `yaml:"kind"`
+	Value     interface{}   `yaml:"value"`
+	Offset    float64       `yaml:"offset"`
+	Amplitude float64       `yaml:"amplitude"`
+	Min       float64       `yaml:"min"`
+	Max       float64       `yaml:"max"`
+	Period    time.Duration `yaml:"period"`
+
+	// Type of symbols that only exist in the simulation file, e.g. "REAL"
+	Type string `yaml:"type"`
+}
+
+// at returns the numeric signal value after elapsed, seed makes noise
+// reproducible
+func (s Signal) at(elapsed time.Duration, seed uint64) float64 {
+	period := s.Period
+	if period <= 0 {
+		period = time.Second
+	}
+	cycles := float64(elapsed) / float64(period)
+
+	switch s.Kind {
+	case SignalSine:
+		return s.Offset + s.Amplitude*math.Sin(2*math.Pi*cycles)
+	case SignalRamp:
+		return s.Min + (s.Max-s.Min)*(cycles-math.Floor(cycles))
+	case SignalNoise:
+		sample := splitmix64(seed ^ uint64(elapsed/period))
+		return s.Offset + s.Amplitude*(2*float64(sample>>11)/(1<<53)-1)
+	case SignalStep:
+		if int64(cycles)%2 == 0 {
+			return s.Min
+		}
+		return s.Max
+	}
+	if s.Value != nil {
+		if f, err := toFloat64(s.Value); err == nil {
+			return f
+		}
+	}
+	return s.Offset
+}
+
+func splitmix64(x uint64) uint64 {
+	x += 0x9E3779B97F4A7C15
+	x = (x ^ (x >> 30)) * 0xBF58476D1CE4E5B9
+	x = (x ^ (x >> 27)) * 0x94D049BB133111EB
+	return x ^ (x >> 31)
+}
+
+// SimRecipe is a sequence of steps played from the start of the simulation,
+// like a recipe running on the PLC
+type SimRecipe struct {
+	Loop bool `yaml:"loop"`
+
+	// StepSymbol receives the 1-based number of the running step, 0 once the
+	// recipe has finished. ActiveSymbol is true while a step runs.
+	StepSymbol   string          `yaml:"step_symbol"`
+	ActiveSymbol string          `yaml:"active_symbol"`
+	Steps        []SimRecipeStep `yaml:"steps"`
+
+	// Idle is the pause after the last step before the recipe starts again
+	Idle time.Duration `yaml:"idle"`
+}
+
+// SimRecipeStep holds symbol values for Duration
+type SimRecipeStep struct {
+	Name     string                 `yaml:"name"`
+	Duration time.Duration          `yaml:"duration"`
+	Values   map[string]interface{} `yaml:"values"`
+}
+
+// step returns the step running after elapsed and its number, or nil and 0
+// once a recipe without Loop has finished
+func (r *SimRecipe) step(elapsed time.Duration) (*SimRecipeStep, int) {
+	var total time.Duration
+	for _, st := range r.Steps {
+		total += st.Duration
+	}
+	if total <= 0 {
+		return nil, 0
+	}
+	total += r.Idle
+	if elapsed >= total {
+		if !r.Loop {
+			return nil, 0
+		}
+		elapsed %= total
+	}
+	for i := range r.Steps {
+		if elapsed < r.Steps[i].Duration {
+			return &r.Steps[i], i + 1
+		}
+		elapsed -= r.Steps[i].Duration
+	}
+	return nil, 0
+}
+
+// FaultConfig injects failures into a simulated PLC
+type FaultConfig struct {
+	Latency       time.Duration `yaml:"latency"`        // added to every request
+	LatencyJitter time.Duration `yaml:"latency_jitter"` // random extra latency up to this value
+
+	// ErrorRate is the fraction of requests failing with ErrorCode, which
+	// defaults to ADSErrDeviceError
+	ErrorRate float64 `yaml:"error_rate"`
+	ErrorCode uint32  `yaml:"error_code"`
+
+	// DisconnectEvery drops the connection after it was up this long and
+	// refuses connects for DisconnectFor
+	DisconnectEvery time.Duration `yaml:"disconnect_every"`
+	DisconnectFor   time.Duration `yaml:"disconnect_for"`
+}
+
+// SimSymbol is a symbol of a simulated PLC
+type SimSymbol struct {
+	Name     string
+	TypeName string // IEC type name, e.g. "REAL" or "STRING(80)"
+	ReadOnly bool
+	Signal   Signal
+}
+
+// SimulatorConfig describes one simulated PLC
+type SimulatorConfig struct {
+	Seed    uint64
+	Symbols []SimSymbol
+	Recipe  *SimRecipe
+	Faults  FaultConfig
+
+	// Clock replaces time.Now, e.g. to step time in tests
+	Clock func() time.Time
+}
+
+type simSymbol struct {
+	info    SymbolInfo
+	signal  Signal
+	seed    uint64
+	written interface{} // last written value, overrides the signal
+}
+
+// Simulator is a deterministic PLC for development and tests. It keeps a
+// symbol table, stores written values and generates the other values from
+// signals and a recipe. Clients are created with Connect.
+type Simulator struct {
+	mu      sync.Mutex
+	symbols map[string]*simSymbol // keyed by lower-case name
+	recipe  *SimRecipe
+	faults  FaultConfig
+	symErrs map[string]uint32 // per-symbol ADS errors, keyed by lower-case name
+	rng     *rand.Rand
+	clock   func() time.Time
+	start   time.Time
+
+	generation  int       // increased by every disconnect
+	connectedAt time.Time // start of the current connection
+	downUntil   time.Time // connects fail until then
+}
+
+func NewSimulator(cfg SimulatorConfig) *Simulator {
+	clock := cfg.Clock
+	if clock == nil {
+		clock = time.Now
+	}
+	s := &Simulator{
+		symbols: make(map[string]*simSymbol, len(cfg.Symbols)),
+		recipe:  cfg.Recipe,
+		faults:  cfg.Faults,
+		symErrs: make(map[string]uint32),
+		rng:     rand.New(rand.NewPCG(cfg.Seed, 0x5eed)),
+		clock:   clock,
+		start:   clock(),
+	}
+	for _, sym := range cfg.Symbols {
+		s.addSymbol(sym, cfg.Seed)
+	}
+	// Recipe symbols exist even if the machine config does not list them
+	if r := cfg.Recipe; r != nil {
+		if r.StepSymbol != "" {
+			s.addSymbol(SimSymbol{Name: r.StepSymbol, TypeName: "INT", ReadOnly: true}, cfg.Seed)
+		}
+		if r.ActiveSymbol != "" {
+			s.addSymbol(SimSymbol{Name: r.ActiveSymbol, TypeName: "BOOL", ReadOnly: true}, cfg.Seed)
+		}
+		for _, st := range r.Steps {
+			for name, v := range st.Values {
+				s.addSymbol(SimSymbol{Name: name, TypeName: typeOfValue(v).String()}, cfg.Seed)
+			}
+		}
+	}
+	return s
+}
+
+// addSymbol registers a symbol unless it exists
+func (s *Simulator) addSymbol(sym SimSymbol, seed uint64) {
+	key := strings.ToLower(sym.Name)
+	if _, ok := s.symbols[key]; ok {
+		return
+	}
+	typeName := sym.TypeName
+	if typeName == "" {
+		typeName = sym.Signal.Type
+	}
+	if typeName == "" {
+		typeName = "LREAL"
+	}
+	t := ParsePLCType(typeName)
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	s.symbols[key] = &simSymbol{
+		info: SymbolInfo{
+			Name:       sym.Name,
+			Type:       t,
+			TypeName:   strings.ToUpper(typeName),
+			Size:       simTypeSize(t),
+			IsWritable: !sym.ReadOnly,
+		},
+		signal: sym.Signal,
+		seed:   seed ^ h.Sum64(),
+	}
+}
+
+func simTypeSize(t PLCType) int {
+	switch t {
+	case TypeBool, TypeInt8, TypeUInt8:
+		return 1
+	case TypeInt16, TypeUInt16:
+		return 2
+	case TypeInt32, TypeUInt32, TypeReal:
+		return 4
+	case TypeInt64, TypeUInt64, TypeLReal:
+		return 8
+	case TypeString:
+		return 81
+	case TypeWString:
+		return 162
+	}
+	return 0
+}
+
+// SetFaults replaces the injected faults
+func (s *Simulator) SetFaults(f FaultConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = f
+}
+
+// FailSymbol makes every access to a symbol fail with an ADS error code,
+// code 0 clears the fault
+func (s *Simulator) FailSymbol(name string, code uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if code == ADSErrNoError {
+		delete(s.symErrs, strings.ToLower(name))
+		return
+	}
+	s.symErrs[strings.ToLower(name)] = code
+}
+
+// Disconnect drops all clients and refuses connects for d
+func (s *Simulator) Disconnect(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disconnectLocked(s.clock(), d)
+}
+
+func (s *Simulator) disconnectLocked(now time.Time, d time.Duration) {
+	s.generation++
+	s.downUntil = now.Add(d)
+}
+
+// Connect returns a new client of the simulated PLC
+func (s *Simulator) Connect() (ADSClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock()
+	if now.Before(s.downUntil) {
+		return nil, fmt.Errorf("simulated PLC unreachable for %v", s.downUntil.Sub(now).Round(time.Millisecond))
+	}
+	s.connectedAt = now
+	return &simClient{sim: s, generation: s.generation}, nil
+}
+
+// Value returns the current value of a symbol
+func (s *Simulator) Value(name string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.valueLocked(name, s.clock())
+}
+
+func (s *Simulator) valueLocked(name string, now time.Time) (interface{}, error) {
+	key := strings.ToLower(name)
+	sym, ok := s.symbols[key]
+	if !ok {
+		return nil, adsErr(ADSErrSymbolNotFound)
+	}
+	if code, ok := s.symErrs[key]; ok {
+		return nil, adsErr(code)
+	}
+	if sym.written != nil {
+		return sym.written, nil
+	}
+
+	elapsed := now.Sub(s.start)
+	if s.recipe != nil {
+		st, number := s.recipe.step(elapsed)
+		if strings.EqualFold(name, s.recipe.StepSymbol) {
+			return simValue(sym.info.Type, number)
+		}
+		if strings.EqualFold(name, s.recipe.ActiveSymbol) {
+			return simValue(sym.info.Type, st != nil)
+		}
+		if st != nil {
+			for n, v := range st.Values {
+				if strings.EqualFold(n, name) {
+					return simValue(sym.info.Type, v)
+				}
+			}
+		}
+	}
+
+	sig := sym.signal
+	if (sig.Kind == "" || sig.Kind == SignalConstant) && sig.Value != nil {
+		return simValue(sym.info.Type, sig.Value)
+	}
+	return simValue(sym.info.Type, sig.at(elapsed, sym.seed))
+}
+
+// simValue converts a signal or recipe value to the Go type of t
+func simValue(t PLCType, v interface{}) (interface{}, error) {
+	switch t {
+	case TypeString, TypeWString:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		return fmt.Sprint(v), nil
+	case TypeInt8, TypeInt16, TypeInt32, TypeInt64, TypeUInt8, TypeUInt16, TypeUInt32, TypeUInt64:
+		if f, ok := v.(float64); ok {
+			v = math.Round(f)
+		}
+	case TypeBool:
+		if s, ok := v.(string); ok {
+			if _, err := strconv.ParseBool(s); err != nil {
+				return s != "", nil
+			}
+		}
+	}
+	val, err := coerceValue(t, v)
+	if err != nil {
+		return nil, &ADSError{Code: ADSErrInvalidData}
+	}
+	return val, nil
+}
+
+func (s *Simulator) write(name string, value interface{}) error {
+	key := strings.ToLower(name)
+	sym, ok := s.symbols[key]
+	if !ok {
+		return adsErr(ADSErrSymbolNotFound)
+	}
+	if code, ok := s.symErrs[key]; ok {
+		return adsErr(code)
+	}
+	if !sym.info.IsWritable {
+		return adsErr(ADSErrInvalidAccess)
+	}
+	v, err := coerceValue(sym.info.Type, value)
+	if err != nil {
+		return adsErr(ADSErrInvalidData)
+	}
+	sym.written = v
+	return nil
+}
+
+// request applies the injected faults to one request of a client and returns
+// the latency to wait outside the lock
+func (s *Simulator) request(c *simClient) (time.Duration, error) {
+	now := s.clock()
+	f := s.faults
+	if c.closed || c.generation != s.generation {
+		return 0, ErrClientClosed
+	}
+	if f.DisconnectEvery > 0 && now.Sub(s.connectedAt) >= f.DisconnectEvery {
+		s.disconnectLocked(now, f.DisconnectFor)
+		return 0, ErrClientClosed
+	}
+
+	latency := f.Latency
+	if f.LatencyJitter > 0 {
+		latency += time.Duration(s.rng.Int64N(int64(f.LatencyJitter)))
+	}
+	if f.ErrorRate > 0 && s.rng.Float64() < f.ErrorRate {
+		code := f.ErrorCode
+		if code == ADSErrNoError {
+			code = ADSErrDeviceError
+		}
+		return latency, adsErr(code)
+	}
+	return latency, nil
+}
+
+// simClient is one connection to a Simulator
+type simClient struct {
+	sim        *Simulator
+	generation int
+	closed     bool // guarded by sim.mu
+}
+
+// do runs fn under the simulator lock after the injected latency
+func (c *simClient) do(fn func(now time.Time) error) error {
+	c.sim.mu.Lock()
+	latency, err := c.sim.request(c)
+	c.sim.mu.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.sim.mu.Lock()
+	defer c.sim.mu.Unlock()
+	return fn(c.sim.clock())
+}
+
+func (c *simClient) ReadSymbol(name string) (interface{}, error) {
+	var v interface{}
+	err := c.do(func(now time.Time) error {
+		var err error
+		v, err = c.sim.valueLocked(name, now)
+		return err
+	})
+	return v, err
+}
+
+func (c *simClient) WriteSymbol(name string, value interface{}) error {
+	return c.do(func(time.Time) error {
+		return c.sim.write(name, value)
+	})
+}
+
+// ReadSymbols leaves out symbols that fail, like a sum-read
+func (c *simClient) ReadSymbols(names []string) (map[string]interface{}, error) {
+	res := make(map[string]interface{}, len(names))
+	err := c.do(func(now time.Time) error {
+		for _, n := range names {
+			if v, err := c.sim.valueLocked(n, now); err == nil {
+				res[n] = v
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *simClient) WriteSymbols(values map[string]interface{}) (map[string]error, error) {
+	errs := make(map[string]error, len(values))
+	err := c.do(func(time.Time) error {
+		for n, v := range values {
+			if err := c.sim.write(n, v); err != nil {
+				errs[n] = fmt.Errorf("write %s: %w", n, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+func (c *simClient) UploadSymbols() (*SymbolRegistry, error) {
+	var symbols []SymbolInfo
+	err := c.do(func(time.Time) error {
+		for _, sym := range c.sim.symbols {
+			symbols = append(symbols, sym.info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewSymbolRegistry(symbols, nil), nil
+}
+
+// Closed reports a client closed by Close or by an injected disconnect
+func (c *simClient) Closed() bool {
+	c.sim.mu.Lock()
+	defer c.sim.mu.Unlock()
+	return c.closed || c.generation != c.sim.generation
+}
+
+func (c *simClient) Close() error {
+	c.sim.mu.Lock()
+	defer c.sim.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+// SimulationFile is the YAML description of signals, recipes and faults of
+// all simulated machines. Machines entries override the defaults per machine
+// ID.
+type SimulationFile struct {
+	Seed     uint64                       `yaml:"seed"`
+	Faults   FaultConfig                  `yaml:"faults"`
+	Signals  map[string]Signal            `yaml:"signals"`
+	Recipe   *SimRecipe                   `yaml:"recipe"`
+	Machines map[string]SimulationMachine `yaml:"machines"`
+}
+
+// SimulationMachine overrides the SimulationFile defaults for one machine
+type SimulationMachine struct {
+	Faults  *FaultConfig      `yaml:"faults"`
+	Signals map[string]Signal `yaml:"signals"`
+	Recipe  *SimRecipe        `yaml:"recipe"`
+}
+
+// LoadSimulationFile reads a SimulationFile, an empty path returns the
+// defaults
+func LoadSimulationFile(path string) (SimulationFile, error) {
+	var f SimulationFile
+	if path == "" {
+		return f, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return f, err
+	}
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return f, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Simulation runs a Simulator per configured machine. Its ClientFactory
+// replaces the ADS client factory of the engine so that the stack runs
+// without hardware.
+type Simulation struct {
+	file SimulationFile
+
+	mu         sync.RWMutex
+	simulators map[string]*Simulator // keyed by AMS Net ID, or IP without one
+}
+
+func NewSimulation(file SimulationFile) *Simulation {
+	return &Simulation{
+		file:       file,
+		simulators: make(map[string]*Simulator),
+	}
+}
+
+func simulationKey(ip, amsNetID string) string {
+	if amsNetID != "" {
+		return amsNetID
+	}
+	return ip
+}
+
+// SetMachine creates the simulated PLC of a machine from its symbols and the
+// simulation file. The simulator of a known machine keeps running, it only
+// gets the symbols it does not have yet.
+func (s *Simulation) SetMachine(cfg MachineConfig, symbols []SimSymbol) *Simulator {
+	m := s.file.Machines[cfg.ID]
+	signals := make(map[string]SimSymbol, len(s.file.Signals)+len(m.Signals))
+	for _, set := range []map[string]Signal{s.file.Signals, m.Signals} {
+		for name, sig := range set {
+			signals[strings.ToLower(name)] = SimSymbol{Name: name, TypeName: sig.Type, Signal: sig}
+		}
+	}
+
+	// Write allowlist entries are symbols as well
+	for _, info := range cfg.Symbols {
+		symbols = append(symbols, SimSymbol{Name: info.Name, TypeName: info.TypeName})
+	}
+	var all []SimSymbol
+	for _, sym := range symbols {
+		key := strings.ToLower(sym.Name)
+		if sig, ok := signals[key]; ok {
+			sym.Signal = sig.Signal
+			delete(signals, key)
+		}
+		all = append(all, sym)
+	}
+	// Symbols that only exist in the simulation file
+	for _, key := range slices.Sorted(maps.Keys(signals)) {
+		all = append(all, signals[key])
+	}
+
+	key := simulationKey(cfg.IP, cfg.AmsNetID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sim, ok := s.simulators[key]; ok {
+		sim.mu.Lock()
+		for _, sym := range all {
+			sim.addSymbol(sym, s.file.Seed)
+		}
+		sim.mu.Unlock()
+		return sim
+	}
+
+	simCfg := SimulatorConfig{
+		Seed:    s.file.Seed,
+		Symbols: all,
+		Recipe:  s.file.Recipe,
+		Faults:  s.file.Faults,
+	}
+	if m.Faults != nil {
+		simCfg.Faults = *m.Faults
+	}
+	if m.Recipe != nil {
+		simCfg.Recipe = m.Recipe
+	}
+	sim := NewSimulator(simCfg)
+	s.simulators[key] = sim
+	return sim
+}
+
+// Simulator returns the simulated PLC at an address
+func (s *Simulation) Simulator(ip, amsNetID string) (*Simulator, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sim, ok := s.simulators[simulationKey(ip, amsNetID)]
+	return sim, ok
+}
+
+// ClientFactory connects to the simulated PLC at an address, see
+// PLCReadWriteEngine.ClientFactory
+func (s *Simulation) ClientFactory(ip, amsNetID string, port int) (ADSClient, error) {
+	sim, ok := s.Simulator(ip, amsNetID)
+	if !ok {
+		return nil, errors.New("no simulated PLC at " + simulationKey(ip, amsNetID))
+	}
+	return sim.Connect()
+}
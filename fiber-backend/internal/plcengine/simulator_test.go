@@ -0,0 +1,256 @@
+package plcengine
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClock is a clock that only moves when advanced
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestSimulator_Signals(t *testing.T) {
+	clock := newTestClock()
+	sim := NewSimulator(SimulatorConfig{
+		Seed:  7,
+		Clock: clock.Now,
+		Symbols: []SimSymbol{
+			{Name: "GVL.Sine", TypeName: "LREAL", Signal: Signal{Kind: SignalSine, Offset: 100, Amplitude: 10, Period: 4 * time.Second}},
+			{Name: "GVL.Ramp", TypeName: "REAL", Signal: Signal{Kind: SignalRamp, Min: 0, Max: 50, Period: 10 * time.Second}},
+			{Name: "GVL.Step", TypeName: "INT", Signal: Signal{Kind: SignalStep, Min: 1, Max: 3, Period: 5 * time.Second}},
+			{Name: "GVL.Noise", TypeName: "LREAL", Signal: Signal{Kind: SignalNoise, Offset: 20, Amplitude: 2, Period: time.Second}},
+			{Name: "GVL.Enabled", TypeName: "BOOL", Signal: Signal{Value: true}},
+			{Name: "GVL.Name", TypeName: "STRING(80)", Signal: Signal{Value: "chamber A"}},
+			{Name: "GVL.Unset", TypeName: "DINT"},
+		},
+	})
+
+	value := func(name string) interface{} {
+		v, err := sim.Value(name)
+		require.NoError(t, err, name)
+		return v
+	}
+
+	assert.InDelta(t, 100.0, value("GVL.Sine"), 1e-9)
+	assert.Equal(t, float32(0), value("GVL.Ramp"))
+	assert.Equal(t, int16(1), value("GVL.Step"))
+	assert.Equal(t, true, value("GVL.Enabled"))
+	assert.Equal(t, "chamber A", value("GVL.Name"))
+	assert.Equal(t, int32(0), value("GVL.Unset"))
+	noise := value("GVL.Noise")
+
+	clock.Advance(time.Second)
+	assert.InDelta(t, 110.0, value("gvl.sine"), 1e-9)
+	assert.Equal(t, float32(5), value("GVL.Ramp"))
+	assert.NotEqual(t, noise, value("GVL.Noise"))
+
+	clock.Advance(5 * time.Second)
+	assert.InDelta(t, 100.0, value("GVL.Sine"), 1e-9)
+	assert.Equal(t, float32(30), value("GVL.Ramp"))
+	assert.Equal(t, int16(3), value("GVL.Step"))
+	assert.InDelta(t, 20.0, value("GVL.Noise"), 2)
+
+	// The same seed and time give the same noise
+	twin := NewSimulator(SimulatorConfig{Seed: 7, Clock: clock.Now, Symbols: []SimSymbol{
+		{Name: "GVL.Noise", TypeName: "LREAL", Signal: Signal{Kind: SignalNoise, Offset: 20, Amplitude: 2, Period: time.Second}},
+	}})
+	twin.start = sim.start
+	v, err := twin.Value("GVL.Noise")
+	require.NoError(t, err)
+	assert.Equal(t, value("GVL.Noise"), v)
+}
+
+func TestSimulator_Recipe(t *testing.T) {
+	clock := newTestClock()
+	sim := NewSimulator(SimulatorConfig{
+		Clock:   clock.Now,
+		Symbols: []SimSymbol{{Name: "GVL.Pressure", TypeName: "REAL", Signal: Signal{Offset: 1}}},
+		Recipe: &SimRecipe{
+			StepSymbol:   "Recipe.Step",
+			ActiveSymbol: "Recipe.Active",
+			Loop:         true,
+			Idle:         5 * time.Second,
+			Steps: []SimRecipeStep{
+				{Name: "pump", Duration: 10 * time.Second, Values: map[string]interface{}{"GVL.Pressure": 0.5, "Recipe.Name": "etch"}},
+				{Name: "etch", Duration: 20 * time.Second, Values: map[string]interface{}{"GVL.Pressure": 0.1}},
+			},
+		},
+	})
+
+	read := func(name string) interface{} {
+		v, err := sim.Value(name)
+		require.NoError(t, err, name)
+		return v
+	}
+
+	assert.Equal(t, int16(1), read("Recipe.Step"))
+	assert.Equal(t, true, read("Recipe.Active"))
+	assert.Equal(t, float32(0.5), read("GVL.Pressure"))
+	assert.Equal(t, "etch", read("Recipe.Name"))
+
+	clock.Advance(15 * time.Second)
+	assert.Equal(t, int16(2), read("Recipe.Step"))
+	assert.Equal(t, float32(0.1), read("GVL.Pressure"))
+
+	// After the last step the signals take over again until the idle time
+	// has passed
+	clock.Advance(15 * time.Second)
+	assert.Equal(t, int16(0), read("Recipe.Step"))
+	assert.Equal(t, false, read("Recipe.Active"))
+	assert.Equal(t, float32(1), read("GVL.Pressure"))
+
+	clock.Advance(5 * time.Second)
+	assert.Equal(t, int16(1), read("Recipe.Step"))
+	assert.Equal(t, true, read("Recipe.Active"))
+}
+
+func TestSimulator_ClientAndFaults(t *testing.T) {
+	clock := newTestClock()
+	sim := NewSimulator(SimulatorConfig{
+		Clock: clock.Now,
+		Symbols: []SimSymbol{
+			{Name: "GVL.Setpoint", TypeName: "REAL"},
+			{Name: "GVL.Actual", TypeName: "REAL", ReadOnly: true},
+		},
+	})
+
+	client, err := sim.Connect()
+	require.NoError(t, err)
+
+	// Written values are stored with the symbol type
+	require.NoError(t, client.WriteSymbol("GVL.Setpoint", 42.5))
+	v, err := client.ReadSymbol("GVL.Setpoint")
+	require.NoError(t, err)
+	assert.Equal(t, float32(42.5), v)
+
+	assert.True(t, IsADSError(client.WriteSymbol("GVL.Actual", 1), ADSErrInvalidAccess))
+	assert.True(t, IsADSError(client.WriteSymbol("GVL.Setpoint", "hot"), ADSErrInvalidData))
+	_, err = client.ReadSymbol("GVL.Missing")
+	assert.True(t, IsADSError(err, ADSErrSymbolNotFound))
+
+	values, err := client.ReadSymbols([]string{"GVL.Setpoint", "GVL.Missing"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"GVL.Setpoint": float32(42.5)}, values)
+
+	reg, err := client.(SymbolUploader).UploadSymbols()
+	require.NoError(t, err)
+	info, ok := reg.Symbol("gvl.actual")
+	require.True(t, ok)
+	assert.Equal(t, TypeReal, info.Type)
+	assert.False(t, info.IsWritable)
+
+	// ADS errors
+	sim.FailSymbol("GVL.Setpoint", ADSErrNotReady)
+	_, err = client.ReadSymbol("GVL.Setpoint")
+	assert.True(t, IsADSError(err, ADSErrNotReady))
+	sim.FailSymbol("GVL.Setpoint", ADSErrNoError)
+
+	sim.SetFaults(FaultConfig{ErrorRate: 1})
+	_, err = client.ReadSymbol("GVL.Setpoint")
+	assert.True(t, IsADSError(err, ADSErrDeviceError))
+	sim.SetFaults(FaultConfig{})
+
+	// Disconnects close the client and refuse connects for a while
+	sim.Disconnect(10 * time.Second)
+	assert.True(t, client.(closeNotifier).Closed())
+	_, err = client.ReadSymbol("GVL.Setpoint")
+	assert.ErrorIs(t, err, ErrClientClosed)
+	_, err = sim.Connect()
+	assert.Error(t, err)
+
+	clock.Advance(10 * time.Second)
+	client, err = sim.Connect()
+	require.NoError(t, err)
+	v, err = client.ReadSymbol("GVL.Setpoint")
+	require.NoError(t, err)
+	assert.Equal(t, float32(42.5), v)
+
+	// Periodic disconnects
+	sim.SetFaults(FaultConfig{DisconnectEvery: time.Minute, DisconnectFor: time.Second})
+	clock.Advance(time.Minute)
+	_, err = client.ReadSymbol("GVL.Setpoint")
+	assert.ErrorIs(t, err, ErrClientClosed)
+	_, err = sim.Connect()
+	assert.Error(t, err)
+}
+
+func TestSimulation_Engine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sim.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+seed: 3
+signals:
+  GVL.Temperature: {kind: sine, offset: 200, amplitude: 5, period: 1m}
+machines:
+  m1:
+    signals:
+      GVL.Flow: {kind: constant, value: 12, type: INT}
+    recipe:
+      step_symbol: Recipe.Step
+      loop: true
+      steps:
+        - {name: heat, duration: 1h, values: {Recipe.ID: "R-42"}}
+`), 0o644))
+
+	file, err := LoadSimulationFile(path)
+	require.NoError(t, err)
+	sims := NewSimulation(file)
+
+	cfg := MachineConfig{
+		ID: "m1", IP: "127.0.0.1", AmsNetID: "10.0.0.1.1.1", Port: 851,
+		Symbols: []SymbolInfo{{Name: "GVL.Setpoint", TypeName: "LREAL", IsWritable: true, MinValue: 0, MaxValue: 100}},
+	}
+	sims.SetMachine(cfg, []SimSymbol{{Name: "GVL.Temperature", TypeName: "REAL"}})
+
+	e := NewEngine(make(chan PLCValue, 10))
+	e.ClientFactory = sims.ClientFactory
+	require.NoError(t, e.Start([]MachineConfig{cfg}))
+	defer e.Stop()
+
+	require.Eventually(t, func() bool {
+		_, err := e.ReadSymbol("m1", "GVL.Temperature")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	values, err := e.ReadSymbols("m1", []string{"GVL.Temperature", "GVL.Flow", "Recipe.Step", "Recipe.ID"})
+	require.NoError(t, err)
+	assert.InDelta(t, 200, values["GVL.Temperature"].Value, 5)
+	assert.Equal(t, TypeReal, values["GVL.Temperature"].Type)
+	assert.Equal(t, int16(12), values["GVL.Flow"].Value)
+	assert.Equal(t, int16(1), values["Recipe.Step"].Value)
+	assert.Equal(t, "R-42", values["Recipe.ID"].Value)
+
+	resp := <-e.WriteAsync(WriteRequest{ID: "w1", MachineID: "m1", Symbol: "GVL.Setpoint", Value: 55.0, RequireAck: true})
+	require.True(t, resp.Success, resp.Error)
+	sim, ok := sims.Simulator(cfg.IP, cfg.AmsNetID)
+	require.True(t, ok)
+	v, err := sim.Value("GVL.Setpoint")
+	require.NoError(t, err)
+	assert.Equal(t, 55.0, v)
+
+	_, err = sims.ClientFactory("127.0.0.2", "", 851)
+	assert.Error(t, err)
+}
@@ -0,0 +1,344 @@
+package plcengine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Index groups for the symbol and data type upload
+const (
+	ADSIGrpSymUpload      uint32 = 0xF00B
+	ADSIGrpSymDTUpload    uint32 = 0xF00E
+	ADSIGrpSymUploadInfo2 uint32 = 0xF00F
+)
+
+// adsSymbolFlagReadOnly marks symbols that reject writes
+const adsSymbolFlagReadOnly uint32 = 0x0020
+
+// maxTypeDepth guards decoding against alias cycles in a malformed type table
+const maxTypeDepth = 16
+
+// SymbolUploader is implemented by clients that can upload the PLC symbol and
+// data type tables.
+type SymbolUploader interface {
+	UploadSymbols() (*SymbolRegistry, error)
+}
+
+// SymbolRegistry holds the uploaded symbol and data type tables of one PLC
+// and decodes raw symbol bytes into Go values. It is read-only once built.
+// Names are matched case-insensitively like in TwinCAT.
+type SymbolRegistry struct {
+	symbols map[string]*SymbolInfo
+	types   map[string]*DataTypeInfo
+}
+
+func NewSymbolRegistry(symbols []SymbolInfo, types []DataTypeInfo) *SymbolRegistry {
+	r := &SymbolRegistry{
+		symbols: make(map[string]*SymbolInfo, len(symbols)),
+		types:   make(map[string]*DataTypeInfo, len(types)),
+	}
+	for i := range types {
+		t := types[i]
+		r.types[strings.ToLower(t.Name)] = &t
+	}
+	for i := range symbols {
+		s := symbols[i]
+		r.resolve(&s)
+		r.symbols[strings.ToLower(s.Name)] = &s
+	}
+	return r
+}
+
+// resolve fills type, array and struct details of a symbol from the type table
+func (r *SymbolRegistry) resolve(s *SymbolInfo) {
+	t, ok := r.DataType(s.TypeName)
+	for depth := 0; ok && depth < maxTypeDepth; depth++ {
+		if len(t.ArrayBounds) > 0 {
+			s.Type = TypeArray
+			s.ArrayBounds = t.ArrayBounds
+			return
+		}
+		if len(t.Fields) > 0 {
+			s.Type = TypeStruct
+			s.Fields = t.Fields
+			return
+		}
+		// Alias (e.g. T_Pressure : REAL), follow it to the underlying type
+		if t.BaseType == "" || strings.EqualFold(t.BaseType, t.Name) {
+			break
+		}
+		t, ok = r.DataType(t.BaseType)
+	}
+}
+
+// Symbol returns the metadata of a symbol
+func (r *SymbolRegistry) Symbol(name string) (*SymbolInfo, bool) {
+	s, ok := r.symbols[strings.ToLower(name)]
+	return s, ok
+}
+
+// DataType returns a data type by name
+func (r *SymbolRegistry) DataType(name string) (*DataTypeInfo, bool) {
+	t, ok := r.types[strings.ToLower(name)]
+	return t, ok
+}
+
+// Symbols returns all symbols sorted by name
+func (r *SymbolRegistry) Symbols() []SymbolInfo {
+	out := make([]SymbolInfo, 0, len(r.symbols))
+	for _, s := range r.symbols {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (r *SymbolRegistry) Len() int {
+	return len(r.symbols)
+}
+
+// Decode converts the raw bytes of a symbol into a Go value. Arrays become
+// (nested) []interface{}, structs become map[string]interface{}.
+func (r *SymbolRegistry) Decode(name string, b []byte) (interface{}, error) {
+	s, ok := r.Symbol(name)
+	if !ok {
+		return nil, fmt.Errorf("symbol %s not in registry", name)
+	}
+	return r.decode(s.TypeName, s.adsType, b, 0), nil
+}
+
+func (r *SymbolRegistry) decode(typeName string, adsType uint32, b []byte, depth int) interface{} {
+	t, ok := r.DataType(typeName)
+	if !ok || depth >= maxTypeDepth {
+		return decodeADSValue(adsType, b)
+	}
+
+	switch {
+	case len(t.ArrayBounds) > 0:
+		total := 1
+		for _, d := range t.ArrayBounds {
+			total *= d.Elements
+		}
+		if total == 0 || len(b) < t.Size {
+			return decodeADSValue(adsTypeBigType, b)
+		}
+		return r.decodeArray(t.ArrayBounds, t.BaseType, t.adsType, t.Size/total, b, depth)
+
+	case len(t.Fields) > 0:
+		out := make(map[string]interface{}, len(t.Fields))
+		for _, f := range t.Fields {
+			if f.Offset+f.Size > len(b) {
+				continue
+			}
+			out[f.Name] = r.decode(f.TypeName, f.adsType, b[f.Offset:f.Offset+f.Size], depth+1)
+		}
+		return out
+
+	case t.BaseType != "" && !strings.EqualFold(t.BaseType, t.Name):
+		return r.decode(t.BaseType, t.adsType, b, depth+1)
+	}
+	return decodeADSValue(t.adsType, b)
+}
+
+func (r *SymbolRegistry) decodeArray(bounds []ArrayBound, elemType string, adsType uint32, elemSize int, b []byte, depth int) []interface{} {
+	stride := elemSize
+	for _, d := range bounds[1:] {
+		stride *= d.Elements
+	}
+
+	out := make([]interface{}, bounds[0].Elements)
+	for i := range out {
+		chunk := b[i*stride : (i+1)*stride]
+		if len(bounds) > 1 {
+			out[i] = r.decodeArray(bounds[1:], elemType, adsType, elemSize, chunk, depth)
+		} else {
+			out[i] = r.decode(elemType, adsType, chunk, depth+1)
+		}
+	}
+	return out
+}
+
+func plcTypeFromADS(adsType uint32) PLCType {
+	switch adsType {
+	case adsTypeBit:
+		return TypeBool
+	case adsTypeInt8:
+		return TypeInt8
+	case adsTypeUInt8:
+		return TypeUInt8
+	case adsTypeInt16:
+		return TypeInt16
+	case adsTypeUInt16:
+		return TypeUInt16
+	case adsTypeInt32:
+		return TypeInt32
+	case adsTypeUInt32:
+		return TypeUInt32
+	case adsTypeInt64:
+		return TypeInt64
+	case adsTypeUInt64:
+		return TypeUInt64
+	case adsTypeReal32:
+		return TypeReal
+	case adsTypeReal64:
+		return TypeLReal
+	case adsTypeString:
+		return TypeString
+	case adsTypeWString:
+		return TypeWString
+	}
+	return TypeUnknown
+}
+
+// typeOfValue infers the PLC type of a decoded value for clients without a
+// symbol table
+func typeOfValue(v interface{}) PLCType {
+	switch v.(type) {
+	case bool:
+		return TypeBool
+	case int8:
+		return TypeInt8
+	case uint8:
+		return TypeUInt8
+	case int16:
+		return TypeInt16
+	case uint16:
+		return TypeUInt16
+	case int32:
+		return TypeInt32
+	case uint32:
+		return TypeUInt32
+	case int64, int:
+		return TypeInt64
+	case uint64, uint:
+		return TypeUInt64
+	case float32:
+		return TypeReal
+	case float64:
+		return TypeLReal
+	case string:
+		return TypeString
+	case []interface{}:
+		return TypeArray
+	case map[string]interface{}:
+		return TypeStruct
+	}
+	return TypeUnknown
+}
+
+// parseSymbolTable decodes the concatenated entries of ADSIGRP_SYM_UPLOAD
+func parseSymbolTable(b []byte) ([]SymbolInfo, error) {
+	var out []SymbolInfo
+	for len(b) >= 4 {
+		n := int(binary.LittleEndian.Uint32(b))
+		if n < 30 || n > len(b) {
+			return nil, ErrInvalidResponse
+		}
+		sym, err := parseSymbolEntry(b[:n])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, SymbolInfo{
+			Name:        sym.Name,
+			Type:        plcTypeFromADS(sym.DataType),
+			Size:        int(sym.Size),
+			IsWritable:  sym.Flags&adsSymbolFlagReadOnly == 0,
+			Comment:     sym.Comment,
+			TypeName:    sym.Type,
+			IndexGroup:  sym.Group,
+			IndexOffset: sym.Offset,
+			adsType:     sym.DataType,
+		})
+		b = b[n:]
+	}
+	return out, nil
+}
+
+// parseDataTypeTable decodes the concatenated entries of the data type upload
+func parseDataTypeTable(b []byte) ([]DataTypeInfo, error) {
+	var out []DataTypeInfo
+	for len(b) >= 4 {
+		t, n, err := parseDataTypeEntry(b)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+		b = b[n:]
+	}
+	return out, nil
+}
+
+// dataTypeHeaderLen is the fixed part of an AdsDatatypeEntry
+const dataTypeHeaderLen = 42
+
+// parseDataTypeEntry decodes one AdsDatatypeEntry including its array
+// dimensions and sub items and returns the number of bytes consumed.
+func parseDataTypeEntry(b []byte) (DataTypeInfo, int, error) {
+	var t DataTypeInfo
+	if len(b) < dataTypeHeaderLen {
+		return t, 0, ErrInvalidResponse
+	}
+	n := int(binary.LittleEndian.Uint32(b))
+	if n < dataTypeHeaderLen || n > len(b) {
+		return t, 0, ErrInvalidResponse
+	}
+	e := b[:n]
+
+	t.Size = int(binary.LittleEndian.Uint32(e[16:]))
+	t.adsType = binary.LittleEndian.Uint32(e[24:])
+	nameLen := int(binary.LittleEndian.Uint16(e[32:]))
+	typeLen := int(binary.LittleEndian.Uint16(e[34:]))
+	commentLen := int(binary.LittleEndian.Uint16(e[36:]))
+	arrayDim := int(binary.LittleEndian.Uint16(e[38:]))
+	subItems := int(binary.LittleEndian.Uint16(e[40:]))
+
+	pos := dataTypeHeaderLen
+	if pos+nameLen+typeLen+commentLen+3+8*arrayDim > n {
+		return t, 0, ErrInvalidResponse
+	}
+	t.Name = string(e[pos : pos+nameLen])
+	pos += nameLen + 1
+	t.BaseType = string(e[pos : pos+typeLen])
+	pos += typeLen + 1 + commentLen + 1
+
+	for i := 0; i < arrayDim; i++ {
+		t.ArrayBounds = append(t.ArrayBounds, ArrayBound{
+			Lower:    int(int32(binary.LittleEndian.Uint32(e[pos:]))),
+			Elements: int(binary.LittleEndian.Uint32(e[pos+4:])),
+		})
+		pos += 8
+	}
+
+	for i := 0; i < subItems; i++ {
+		if pos >= n {
+			return t, 0, ErrInvalidResponse
+		}
+		sub, m, err := parseDataTypeEntry(e[pos:])
+		if err != nil {
+			return t, 0, err
+		}
+		// For sub items the entry name is the member name and the type name
+		// is the member's data type; the offset sits in the header.
+		subOffset := int(binary.LittleEndian.Uint32(e[pos+20:]))
+		t.Fields = append(t.Fields, FieldInfo{
+			Name:     sub.Name,
+			TypeName: sub.BaseType,
+			Type:     plcTypeFromADS(sub.adsType),
+			Offset:   subOffset,
+			Size:     sub.Size,
+			adsType:  sub.adsType,
+		})
+		pos += m
+	}
+
+	if len(t.ArrayBounds) > 0 {
+		t.Type = TypeArray
+	} else if len(t.Fields) > 0 {
+		t.Type = TypeStruct
+	} else {
+		t.Type = plcTypeFromADS(t.adsType)
+	}
+	return t, n, nil
+}
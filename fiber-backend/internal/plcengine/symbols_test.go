@@ -0,0 +1,143 @@
+package plcengine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTypedRouter serves a struct, arrays and an alias on top of newTestRouter
+func newTypedRouter(t *testing.T) *fakeRouter {
+	r := newTestRouter(t)
+
+	r.addDataType(DataTypeInfo{Name: "ST_Chamber", Size: 8, adsType: adsTypeBigType, Fields: []FieldInfo{
+		{Name: "Temperature", TypeName: "REAL", Offset: 0, Size: 4, adsType: adsTypeReal32},
+		{Name: "Step", TypeName: "INT", Offset: 4, Size: 2, adsType: adsTypeInt16},
+		{Name: "Running", TypeName: "BOOL", Offset: 6, Size: 1, adsType: adsTypeBit},
+	}})
+	r.addDataType(DataTypeInfo{Name: "ARRAY [1..3] OF REAL", BaseType: "REAL", Size: 12, adsType: adsTypeReal32,
+		ArrayBounds: []ArrayBound{{Lower: 1, Elements: 3}}})
+	r.addDataType(DataTypeInfo{Name: "ARRAY [0..1,0..2] OF INT", BaseType: "INT", Size: 12, adsType: adsTypeInt16,
+		ArrayBounds: []ArrayBound{{Lower: 0, Elements: 2}, {Lower: 0, Elements: 3}}})
+	r.addDataType(DataTypeInfo{Name: "T_Setpoint", BaseType: "REAL", Size: 4, adsType: adsTypeReal32})
+
+	chamber := append(append(real32(65.5), int16Bytes(4)...), 1, 0)
+	r.addSymbol("GVL.Chamber", "ST_Chamber", adsTypeBigType, chamber)
+	zones := append(append(real32(1.5), real32(2.5)...), real32(3.5)...)
+	r.addSymbol("GVL.Zones", "ARRAY [1..3] OF REAL", adsTypeReal32, zones)
+	var matrix []byte
+	for i := int16(0); i < 6; i++ {
+		matrix = append(matrix, int16Bytes(i)...)
+	}
+	r.addSymbol("GVL.Matrix", "ARRAY [0..1,0..2] OF INT", adsTypeInt16, matrix)
+	r.addSymbol("GVL.Setpoint", "T_Setpoint", adsTypeReal32, real32(12.5))
+	r.setReadOnly("GVL.Recipe")
+	return r
+}
+
+func TestAMSClient_UploadSymbols(t *testing.T) {
+	r := newTypedRouter(t)
+	c := r.client(t)
+
+	reg, err := c.UploadSymbols()
+	require.NoError(t, err)
+	assert.Equal(t, 9, reg.Len())
+
+	chamber, ok := reg.Symbol("gvl.chamber")
+	require.True(t, ok)
+	assert.Equal(t, TypeStruct, chamber.Type)
+	assert.Equal(t, "ST_Chamber", chamber.TypeName)
+	require.Len(t, chamber.Fields, 3)
+	assert.Equal(t, FieldInfo{Name: "Step", TypeName: "INT", Type: TypeInt16, Offset: 4, Size: 2, adsType: adsTypeInt16}, chamber.Fields[1])
+
+	zones, _ := reg.Symbol("GVL.Zones")
+	assert.Equal(t, TypeArray, zones.Type)
+	assert.Equal(t, []ArrayBound{{Lower: 1, Elements: 3}}, zones.ArrayBounds)
+
+	setpoint, _ := reg.Symbol("GVL.Setpoint")
+	assert.Equal(t, TypeReal, setpoint.Type)
+
+	recipe, _ := reg.Symbol("GVL.Recipe")
+	assert.False(t, recipe.IsWritable)
+	temp, _ := reg.Symbol("GVL.Temperature")
+	assert.True(t, temp.IsWritable)
+	assert.Equal(t, 4, temp.Size)
+}
+
+func TestAMSClient_DecodesStructsAndArrays(t *testing.T) {
+	r := newTypedRouter(t)
+	c := r.client(t)
+	_, err := c.UploadSymbols()
+	require.NoError(t, err)
+
+	val, err := c.ReadSymbol("GVL.Chamber")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"Temperature": float32(65.5),
+		"Step":        int16(4),
+		"Running":     true,
+	}, val)
+
+	val, err = c.ReadSymbol("GVL.Zones")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{float32(1.5), float32(2.5), float32(3.5)}, val)
+
+	vals, err := c.ReadSymbols([]string{"GVL.Matrix", "GVL.Setpoint"})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		[]interface{}{int16(0), int16(1), int16(2)},
+		[]interface{}{int16(3), int16(4), int16(5)},
+	}, vals["GVL.Matrix"])
+	assert.Equal(t, float32(12.5), vals["GVL.Setpoint"])
+
+	// Symbol info comes from the upload, only handles are requested
+	assert.Equal(t, 0, r.callCount(ADSIGrpSymInfoByNameEx))
+}
+
+func TestSymbolRegistry_UnknownTypeFallsBackToRaw(t *testing.T) {
+	reg := NewSymbolRegistry([]SymbolInfo{{Name: "GVL.Blob", TypeName: "ST_Missing", Size: 3, adsType: adsTypeBigType}}, nil)
+
+	val, err := reg.Decode("GVL.Blob", []byte{1, 2, 3})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, val)
+
+	_, err = reg.Decode("GVL.Other", nil)
+	assert.Error(t, err)
+}
+
+func TestEngine_TypedValues(t *testing.T) {
+	r := newTypedRouter(t)
+
+	e := NewEngine(make(chan PLCValue, 10))
+	require.NoError(t, e.Start([]MachineConfig{{ID: "m1", IP: r.Addr(), AmsNetID: r.NetID(), Port: 851}}))
+	defer e.Stop()
+
+	require.Eventually(t, func() bool {
+		_, err := e.GetSymbolInfo("m1", "GVL.Chamber")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	val, err := e.ReadSymbol("m1", "GVL.Chamber")
+	require.NoError(t, err)
+	assert.Equal(t, TypeStruct, val.Type)
+
+	var chamber struct {
+		Temperature float64
+		Step        int
+		Running     bool
+	}
+	require.NoError(t, e.ReadStruct("m1", "GVL.Chamber", &chamber))
+	assert.Equal(t, 65.5, chamber.Temperature)
+	assert.Equal(t, 4, chamber.Step)
+	assert.True(t, chamber.Running)
+
+	vals, err := e.ReadSymbols("m1", []string{"GVL.Step", "GVL.Zones"})
+	require.NoError(t, err)
+	assert.Equal(t, TypeInt16, vals["GVL.Step"].Type)
+	assert.Equal(t, TypeArray, vals["GVL.Zones"].Type)
+
+	_, err = e.GetSymbolInfo("m1", "GVL.Missing")
+	assert.Error(t, err)
+}
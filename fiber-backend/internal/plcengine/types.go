@@ -14,8 +14,43 @@ const (
 	TypeInt32
 	TypeReal
 	TypeString
+	TypeUInt8
+	TypeUInt16
+	TypeUInt32
+	TypeInt64
+	TypeUInt64
+	TypeLReal
+	TypeWString
+	TypeArray
+	TypeStruct
+	TypeUnknown
 )
 
+var plcTypeNames = map[PLCType]string{
+	TypeBool:    "BOOL",
+	TypeInt8:    "SINT",
+	TypeInt16:   "INT",
+	TypeInt32:   "DINT",
+	TypeReal:    "REAL",
+	TypeString:  "STRING",
+	TypeUInt8:   "USINT",
+	TypeUInt16:  "UINT",
+	TypeUInt32:  "UDINT",
+	TypeInt64:   "LINT",
+	TypeUInt64:  "ULINT",
+	TypeLReal:   "LREAL",
+	TypeWString: "WSTRING",
+	TypeArray:   "ARRAY",
+	TypeStruct:  "STRUCT",
+}
+
+func (t PLCType) String() string {
+	if name, ok := plcTypeNames[t]; ok {
+		return name
+	}
+	return "UNKNOWN"
+}
+
 // SymbolInfo contains metadata about a PLC symbol
 type SymbolInfo struct {
 	Name       string
@@ -26,6 +61,70 @@ type SymbolInfo struct {
 	MaxValue   float64
 	Unit       string
 	Comment    string
+
+	// WriteMin and WriteMax bound writes instead of MinValue and MaxValue,
+	// unless they are equal
+	WriteMin float64
+	WriteMax float64
+
+	TypeName    string // PLC type name, e.g. "LREAL" or "ST_ChamberState"
+	IndexGroup  uint32
+	IndexOffset uint32
+	ArrayBounds []ArrayBound // set for arrays, outermost dimension first
+	Fields      []FieldInfo  // set for structs
+
+	adsType uint32
+}
+
+// ArrayBound is one dimension of a PLC array, e.g. [1..10] is {Lower: 1, Elements: 10}
+type ArrayBound struct {
+	Lower    int
+	Elements int
+}
+
+// FieldInfo is a member of a PLC struct
+type FieldInfo struct {
+	Name     string
+	TypeName string
+	Type     PLCType
+	Offset   int
+	Size     int
+
+	adsType uint32
+}
+
+// DataTypeInfo describes a PLC data type uploaded from the type table
+type DataTypeInfo struct {
+	Name        string
+	BaseType    string // element type for arrays, aliased type otherwise
+	Type        PLCType
+	Size        int
+	ArrayBounds []ArrayBound
+	Fields      []FieldInfo
+
+	adsType uint32
+}
+
+// NotificationMode selects when the PLC pushes a notification sample
+type NotificationMode int
+
+const (
+	NotifyOnChange NotificationMode = iota // send when the value changes, checked every CycleTime
+	NotifyCyclic                           // send every CycleTime
+)
+
+// NotificationAttrib configures an ADS device notification
+type NotificationAttrib struct {
+	Mode      NotificationMode
+	CycleTime time.Duration // PLC check/send interval
+	MaxDelay  time.Duration // max time the PLC may buffer samples before sending
+}
+
+// NotificationSample is a decoded value pushed by the PLC
+type NotificationSample struct {
+	Symbol    string
+	Value     interface{}
+	Timestamp time.Time
 }
 
 // PLCValue represents a data point read from a PLC
@@ -33,9 +132,15 @@ type PLCValue struct {
 	Symbol    string      `json:"symbol"`
 	Value     interface{} `json:"value"`
 	Type      PLCType     `json:"type"`
-	Quality   int         `json:"quality"` // 0-100
+	Quality   Quality     `json:"quality"`
 	Timestamp time.Time   `json:"timestamp"`
 	Source    string      `json:"source"` // Machine ID
+
+	// QualityReason is set when Quality is not good
+	QualityReason QualityReason `json:"quality_reason,omitempty"`
+
+	// Tags are set by the collector, e.g. the recipe context of the value
+	Tags map[string]string `json:"tags,omitempty"`
 }
 
 // WriteRequest defines a request to change a PLC field
@@ -44,18 +149,54 @@ type WriteRequest struct {
 	MachineID    string             `json:"machine_id"`
 	Symbol       string             `json:"symbol"`
 	Value        interface{}        `json:"value"`
-	Priority     int                `json:"priority"` // 0=low, 10=high
+	UserID       string             `json:"user_id,omitempty"` // requesting user, recorded in the write journal
+	Priority     int                `json:"priority"`          // 0=low, 10=high
 	RequireAck   bool               `json:"require_ack"`
-	Timeout      time.Duration      `json:"timeout"`
+	Timeout      time.Duration      `json:"timeout"` // limits queueing, writing and verification, 0 = no limit
 	ResponseChan chan WriteResponse `json:"-"`
+
+	queuedAt time.Time   // set on submission
+	deadline time.Time   // set from Timeout on submission
+	batch    *batchWrite // set for batch writes, which ignore Symbol and Value
 }
 
 // WriteResponse confirms the result of a write operation
 type WriteResponse struct {
 	ID        string    `json:"id"`
+	MachineID string    `json:"machine_id,omitempty"`
+	Symbol    string    `json:"symbol,omitempty"`
 	Success   bool      `json:"success"`
 	Error     string    `json:"error,omitempty"`
 	Timestamp time.Time `json:"timestamp"`
+
+	// Rejection is set when a write guard refused the write
+	Rejection *WriteRejection `json:"rejection,omitempty"`
+
+	// Verification is the read-after-write result of writes with RequireAck
+	Verification *WriteVerification `json:"verification,omitempty"`
+}
+
+// WriteRecord is the journal entry of one write attempt, including writes
+// rejected by a guard
+type WriteRecord struct {
+	RequestID      string
+	UserID         string
+	MachineID      string
+	Symbol         string
+	PreviousValue  interface{} // nil if the write was rejected or the read failed
+	RequestedValue interface{}
+	ReadbackValue  interface{} // nil if the write failed or the read failed
+	Success        bool
+	Error          string
+	RejectReason   RejectReason
+	Latency        time.Duration
+	Timestamp      time.Time
+}
+
+// WriteRecorder receives a record of every write attempt handled by the
+// engine. RecordWrite is called on the writer goroutine and must not block.
+type WriteRecorder interface {
+	RecordWrite(rec WriteRecord)
 }
 
 // ConnectionStatus represents the health of a PLC connection
@@ -65,4 +206,27 @@ type ConnectionStatus struct {
 	LastSeen       time.Time `json:"last_seen"`
 	ErrorCount     int       `json:"error_count"`
 	ReconnectCount int       `json:"reconnect_count"`
+
+	State               ConnectionState `json:"state"`
+	Circuit             CircuitState    `json:"circuit"`
+	ConsecutiveFailures int             `json:"consecutive_failures"`
+	LastError           string          `json:"last_error,omitempty"`
+	LastErrorAt         time.Time       `json:"last_error_at,omitzero"`
+	NextRetry           time.Time       `json:"next_retry,omitzero"` // next connect attempt while disconnected
+
+	// Requests waiting on all lanes
+	QueueDepth    int `json:"queue_depth"`
+	QueueCapacity int `json:"queue_capacity"`
+
+	Lanes []LaneStatus `json:"lanes"`
+}
+
+// ConnectionEvent is published when a connection goes on- or offline or its
+// circuit breaker changes state
+type ConnectionEvent struct {
+	MachineID string          `json:"machine_id"`
+	State     ConnectionState `json:"state"`
+	Circuit   CircuitState    `json:"circuit"`
+	Reason    string          `json:"reason,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
 }
@@ -0,0 +1,163 @@
+package plcengine
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// VerifyPolicy controls the read-after-write check of writes with RequireAck.
+// Zero fields take the values of DefaultVerifyPolicy.
+type VerifyPolicy struct {
+	// SettleTime is the wait between write and readback. If zero, the writer
+	// waits Cycles PLC task cycles of CycleTime.
+	SettleTime time.Duration
+	CycleTime  time.Duration
+	Cycles     int
+
+	// REAL/LREAL values match if they differ by at most
+	// max(AbsTolerance, RelTolerance*|expected|)
+	AbsTolerance float64
+	RelTolerance float64
+
+	// Retries is how often a write is repeated after a mismatch
+	Retries int
+}
+
+func DefaultVerifyPolicy() VerifyPolicy {
+	return VerifyPolicy{
+		CycleTime:    10 * time.Millisecond,
+		Cycles:       2,
+		RelTolerance: 1e-6,
+	}
+}
+
+func (p VerifyPolicy) withDefaults() VerifyPolicy {
+	d := DefaultVerifyPolicy()
+	if p.CycleTime <= 0 {
+		p.CycleTime = d.CycleTime
+	}
+	if p.Cycles <= 0 {
+		p.Cycles = d.Cycles
+	}
+	if p.AbsTolerance <= 0 && p.RelTolerance <= 0 {
+		p.RelTolerance = d.RelTolerance
+	}
+	return p
+}
+
+func (p VerifyPolicy) settle() time.Duration {
+	if p.SettleTime > 0 {
+		return p.SettleTime
+	}
+	return p.CycleTime * time.Duration(p.Cycles)
+}
+
+// WriteVerification is the outcome of the read-after-write check
+type WriteVerification struct {
+	Verified  bool        `json:"verified"`
+	Expected  interface{} `json:"expected"`
+	Actual    interface{} `json:"actual,omitempty"`
+	Delta     float64     `json:"delta,omitempty"`     // |actual - expected| of numeric values
+	Tolerance float64     `json:"tolerance,omitempty"` // allowed delta of REAL/LREAL values
+	Attempts  int         `json:"attempts"`            // writes sent, including retries
+	Error     string      `json:"error,omitempty"`     // why the readback failed
+}
+
+// verify waits for the PLC to settle, reads the symbol back and compares it
+// with the written value
+func (w *PrioritizedWriter) verify(ctx context.Context, req WriteRequest, policy VerifyPolicy) *WriteVerification {
+	v := &WriteVerification{Expected: req.Value}
+
+	select {
+	case <-time.After(policy.settle()):
+	case <-ctx.Done():
+	}
+
+	val, err := w.engine.ReadSymbolCtx(ctx, req.MachineID, req.Symbol)
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+	v.Actual = val.Value
+	if val.Type == TypeArray || val.Type == TypeStruct {
+		v.Expected = w.decodeRaw(req.MachineID, req.Symbol, req.Value)
+	}
+	v.Verified, v.Delta, v.Tolerance = compareValues(val.Type, v.Expected, val.Value, policy)
+	return v
+}
+
+// decodeRaw decodes the raw bytes arrays and structs are written as with the
+// symbol table of the machine, like the readback. Without one the bytes are
+// compared as they are.
+func (w *PrioritizedWriter) decodeRaw(machineID, symbol string, value interface{}) interface{} {
+	raw, ok := value.([]byte)
+	if !ok {
+		return value
+	}
+	conn, err := w.engine.getConnection(machineID)
+	if err != nil {
+		return value
+	}
+	reg := conn.Symbols()
+	if reg == nil {
+		return value
+	}
+	decoded, err := reg.Decode(symbol, raw)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// compareValues reports whether the readback actual matches the written
+// expected value of PLC type t, with the numeric delta and float tolerance
+func compareValues(t PLCType, expected, actual interface{}, p VerifyPolicy) (bool, float64, float64) {
+	switch t {
+	case TypeReal, TypeLReal:
+		return compareFloats(t, expected, actual, p)
+	case TypeInt8, TypeInt16, TypeInt32, TypeInt64, TypeUInt8, TypeUInt16, TypeUInt32, TypeUInt64:
+		a, errA := toInt64(actual)
+		e, errE := toInt64(expected)
+		if errA != nil || errE != nil {
+			return false, 0, 0
+		}
+		return a == e, math.Abs(float64(a - e)), 0
+	case TypeBool:
+		a, errA := toBool(actual)
+		e, errE := toBool(expected)
+		return errA == nil && errE == nil && a == e, 0, 0
+	case TypeString, TypeWString:
+		a, okA := actual.(string)
+		e, okE := expected.(string)
+		return okA && okE && strings.TrimRight(a, "\x00") == e, 0, 0
+	case TypeArray, TypeStruct:
+		return reflect.DeepEqual(expected, actual), 0, 0
+	}
+
+	// Without type information compare numbers as floats and the rest as text
+	if _, err := toFloat64(actual); err == nil {
+		if _, err := toFloat64(expected); err == nil {
+			return compareFloats(TypeLReal, expected, actual, p)
+		}
+	}
+	return fmt.Sprintf("%v", expected) == fmt.Sprintf("%v", actual), 0, 0
+}
+
+func compareFloats(t PLCType, expected, actual interface{}, p VerifyPolicy) (bool, float64, float64) {
+	a, errA := toFloat64(actual)
+	e, errE := toFloat64(expected)
+	if errA != nil || errE != nil {
+		return false, 0, 0
+	}
+	// The PLC stores a REAL in single precision
+	if t == TypeReal {
+		e = float64(float32(e))
+	}
+	delta := math.Abs(a - e)
+	tol := math.Max(p.AbsTolerance, p.RelTolerance*math.Abs(e))
+	return delta <= tol, delta, tol
+}
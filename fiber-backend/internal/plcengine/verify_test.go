@@ -0,0 +1,145 @@
+package plcengine
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareValues(t *testing.T) {
+	p := DefaultVerifyPolicy()
+	tests := []struct {
+		typ      PLCType
+		expected interface{}
+		actual   interface{}
+		match    bool
+	}{
+		{TypeReal, float32(21.3), float32(21.3), true},
+		{TypeReal, 21.3, float32(21.3), true}, // rounded to single precision by the PLC
+		{TypeReal, float32(21.3), float32(21.4), false},
+		{TypeLReal, 0.1 + 0.2, 0.3, true},
+		{TypeLReal, 100.0, 100.001, false},
+		{TypeInt16, int16(5), int16(5), true},
+		{TypeInt16, 5.0, int16(5), true},
+		{TypeUInt8, uint8(5), uint8(6), false},
+		{TypeBool, true, true, true},
+		{TypeBool, false, true, false},
+		{TypeString, "ETCH_01", "ETCH_01\x00\x00", true},
+		{TypeString, "ETCH_01", "ETCH_02", false},
+		{TypeArray, []interface{}{float32(1)}, []interface{}{float32(1)}, true},
+		{TypeUnknown, 42, 42.0, true},
+		{TypeUnknown, "on", "on", true},
+	}
+	for _, tt := range tests {
+		match, _, _ := compareValues(tt.typ, tt.expected, tt.actual, p)
+		assert.Equal(t, tt.match, match, "%s %v %v", tt.typ, tt.expected, tt.actual)
+	}
+
+	_, delta, tol := compareValues(TypeLReal, 100.0, 100.5, VerifyPolicy{AbsTolerance: 1})
+	assert.Equal(t, 0.5, delta)
+	assert.Equal(t, 1.0, tol)
+}
+
+// lossyClient is a PLC that loses the first writes it receives
+type lossyClient struct {
+	*MockADSClient
+	mu     sync.Mutex
+	lose   int
+	values map[string]interface{}
+}
+
+func (c *lossyClient) loseWrites(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lose = n
+}
+
+func (c *lossyClient) ReadSymbol(name string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[name], nil
+}
+
+func (c *lossyClient) WriteSymbol(name string, value interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lose > 0 {
+		c.lose--
+		return nil
+	}
+	c.values[name] = value
+	return nil
+}
+
+func TestWriter_VerifyRetries(t *testing.T) {
+	client := &lossyClient{MockADSClient: NewMockADSClient("m1"), values: map[string]interface{}{"GVL.Setpoint": 20.0}}
+	e := NewEngine(make(chan PLCValue, 10))
+	e.ClientFactory = func(ip, amsID string, port int) (ADSClient, error) { return client, nil }
+	e.VerifyPolicy = VerifyPolicy{SettleTime: time.Millisecond, Retries: 2}
+	require.NoError(t, e.Start([]MachineConfig{{
+		ID: "m1", IP: "10.0.0.1",
+		Symbols: []SymbolInfo{{Name: "GVL.Setpoint", TypeName: "LREAL", IsWritable: true}},
+	}}))
+	defer e.Stop()
+	require.Eventually(t, func() bool { return e.GetStatus()["m1"].Connected }, time.Second, 5*time.Millisecond)
+
+	write := func(value float64) WriteResponse {
+		return <-e.WriteAsync(WriteRequest{MachineID: "m1", Symbol: "GVL.Setpoint", Value: value, Priority: 9, RequireAck: true})
+	}
+
+	client.loseWrites(2)
+	resp := write(21.5)
+	assert.True(t, resp.Success, resp.Error)
+	require.NotNil(t, resp.Verification)
+	assert.True(t, resp.Verification.Verified)
+	assert.Equal(t, 3, resp.Verification.Attempts)
+	assert.Equal(t, 21.5, resp.Verification.Actual)
+
+	client.loseWrites(3)
+	resp = write(22.5)
+	assert.False(t, resp.Success)
+	assert.Contains(t, resp.Error, "verification failed")
+	require.NotNil(t, resp.Verification)
+	assert.False(t, resp.Verification.Verified)
+	assert.Equal(t, 21.5, resp.Verification.Actual)
+	assert.Equal(t, 1.0, resp.Verification.Delta)
+	assert.InDelta(t, 22.5e-6, resp.Verification.Tolerance, 1e-12)
+	assert.Equal(t, 3, resp.Verification.Attempts)
+
+	resp = <-e.WriteAsync(WriteRequest{MachineID: "m1", Symbol: "GVL.Setpoint", Value: 23.0, Priority: 9})
+	assert.True(t, resp.Success)
+	assert.Nil(t, resp.Verification)
+}
+
+func TestWriter_VerifyArrayAndStruct(t *testing.T) {
+	r := newTypedRouter(t)
+	e := NewEngine(make(chan PLCValue, 10))
+	e.VerifyPolicy = VerifyPolicy{SettleTime: time.Millisecond}
+	require.NoError(t, e.Start([]MachineConfig{{
+		ID: "m1", IP: r.Addr(), AmsNetID: r.NetID(), Port: 851,
+		Symbols: []SymbolInfo{{Name: "GVL.Zones", IsWritable: true}, {Name: "GVL.Chamber", IsWritable: true}},
+	}}))
+	defer e.Stop()
+	require.Eventually(t, func() bool {
+		_, err := e.GetSymbolInfo("m1", "GVL.Zones")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// Arrays and structs are written as raw bytes and read back decoded
+	zones := append(append(real32(4.5), real32(5.5)...), real32(6.5)...)
+	resp := <-e.WriteAsync(WriteRequest{MachineID: "m1", Symbol: "GVL.Zones", Value: zones, Priority: 9, RequireAck: true})
+	require.True(t, resp.Success, resp.Error)
+	require.NotNil(t, resp.Verification)
+	assert.True(t, resp.Verification.Verified)
+	assert.Equal(t, []interface{}{float32(4.5), float32(5.5), float32(6.5)}, resp.Verification.Actual)
+	assert.Equal(t, zones, r.value("GVL.Zones"))
+
+	chamber := append(append(real32(70), int16Bytes(5)...), 0, 0)
+	resp = <-e.WriteAsync(WriteRequest{MachineID: "m1", Symbol: "GVL.Chamber", Value: chamber, Priority: 9, RequireAck: true})
+	require.True(t, resp.Success, resp.Error)
+	assert.True(t, resp.Verification.Verified)
+	assert.Equal(t, map[string]interface{}{"Temperature": float32(70), "Step": int16(5), "Running": false}, resp.Verification.Expected)
+}
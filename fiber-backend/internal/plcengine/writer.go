@@ -1,113 +1,429 @@
 package plcengine
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 )
 
-// PrioritizedWriter handles asynchronous write requests with priority levels
+var (
+	ErrWriterStopped  = errors.New("PLC writer stopped")
+	ErrMachineRemoved = errors.New("machine removed")
+)
+
+// Write priority levels, see WriteRequest.Priority
+const (
+	PriorityHigh   = iota // Priority 8-10
+	PriorityMedium        // Priority 4-7
+	PriorityLow           // Priority 0-3
+	numPriorities
+)
+
+var priorityNames = [numPriorities]string{"high", "medium", "low"}
+
+// SchedulingMode selects how the writer picks between priority levels
+type SchedulingMode string
+
+const (
+	// SchedulingStrict always executes the highest queued priority first.
+	// A steady stream of high priority writes starves the lower levels.
+	SchedulingStrict SchedulingMode = "strict"
+	// SchedulingWeighted shares the writes of a machine between the queued
+	// priorities in proportion to their weights, so no level starves
+	SchedulingWeighted SchedulingMode = "weighted"
+)
+
+// WriterConfig configures the PrioritizedWriter. Zero fields take the values
+// of DefaultWriterConfig.
+type WriterConfig struct {
+	Scheduling SchedulingMode
+	Weights    [numPriorities]int // high, medium, low share for SchedulingWeighted
+	QueueSizes [numPriorities]int // per machine
+}
+
+func DefaultWriterConfig() WriterConfig {
+	return WriterConfig{
+		Scheduling: SchedulingStrict,
+		Weights:    [numPriorities]int{8, 4, 1},
+		QueueSizes: [numPriorities]int{100, 500, 1000},
+	}
+}
+
+func (c WriterConfig) withDefaults() WriterConfig {
+	d := DefaultWriterConfig()
+	if c.Scheduling == "" {
+		c.Scheduling = d.Scheduling
+	}
+	for p := 0; p < numPriorities; p++ {
+		if c.Weights[p] <= 0 {
+			c.Weights[p] = d.Weights[p]
+		}
+		if c.QueueSizes[p] <= 0 {
+			c.QueueSizes[p] = d.QueueSizes[p]
+		}
+	}
+	return c
+}
+
+// WriteQueueStats are the metrics of one priority level across all machines
+type WriteQueueStats struct {
+	Priority  string        `json:"priority"`
+	Depth     int           `json:"depth"`     // queued writes
+	Capacity  int           `json:"capacity"`  // per machine
+	Submitted uint64        `json:"submitted"` // accepted into the queue
+	Executed  uint64        `json:"executed"`
+	Rejected  uint64        `json:"rejected"` // refused because the queue was full
+	AvgWait   time.Duration `json:"avg_wait"` // time from submission to execution
+	MaxWait   time.Duration `json:"max_wait"`
+}
+
+// PrioritizedWriter handles asynchronous write requests with priority levels.
+// Every machine has its own queues and executes its writes in order of
+// priority, independently of and in parallel with the other machines.
 type PrioritizedWriter struct {
 	engine *PLCReadWriteEngine
+	config WriterConfig
 
-	// Channels for each priority level (0=High, 1=Medium, 2=Low)
-	queues [3]chan WriteRequest
+	mu      sync.Mutex
+	lanes   map[string]*writeLane
+	stats   [numPriorities]WriteQueueStats
+	waited  [numPriorities]time.Duration
+	stopped bool
+	running sync.WaitGroup // lane goroutines
 
 	stopChan chan struct{}
 }
 
+// writeLane holds the queued writes of one machine. A goroutine executes them
+// while the lane is not empty, an empty lane is dropped.
+type writeLane struct {
+	machineID string
+	queues    [numPriorities][]WriteRequest
+	current   [numPriorities]int // smooth weighted round robin state
+	running   bool
+}
+
 func NewPrioritizedWriter(engine *PLCReadWriteEngine) *PrioritizedWriter {
 	return &PrioritizedWriter{
-		engine: engine,
-		queues: [3]chan WriteRequest{
-			make(chan WriteRequest, 100),  // High
-			make(chan WriteRequest, 500),  // Medium
-			make(chan WriteRequest, 1000), // Low
-		},
+		engine:   engine,
+		config:   DefaultWriterConfig(),
+		lanes:    make(map[string]*writeLane),
 		stopChan: make(chan struct{}),
 	}
 }
 
+// Start applies the engine's WriterConfig and accepts writes
 func (w *PrioritizedWriter) Start() {
-	go w.processor()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.config = w.engine.WriterConfig.withDefaults()
 }
 
+// Stop fails all queued writes. Writes already executing complete.
 func (w *PrioritizedWriter) Stop() {
+	w.mu.Lock()
+	if w.stopped {
+		w.mu.Unlock()
+		return
+	}
+	w.stopped = true
 	close(w.stopChan)
+
+	var pending []WriteRequest
+	for _, lane := range w.lanes {
+		pending = append(pending, lane.drain()...)
+	}
+	w.mu.Unlock()
+
+	w.fail(pending, ErrWriterStopped)
+}
+
+// wait returns once the writes executing at Stop completed and were
+// recorded. It must not be called with the engine's lock held.
+func (w *PrioritizedWriter) wait() {
+	w.running.Wait()
+}
+
+// RemoveMachine drops the lane of a machine and fails its queued writes.
+// Writes already executing complete.
+func (w *PrioritizedWriter) RemoveMachine(machineID string) {
+	w.mu.Lock()
+	lane, ok := w.lanes[machineID]
+	if !ok {
+		w.mu.Unlock()
+		return
+	}
+	delete(w.lanes, machineID)
+	pending := lane.drain()
+	w.mu.Unlock()
+
+	w.fail(pending, ErrMachineRemoved)
+}
+
+// drain removes and returns the queued writes. w.mu must be held.
+func (l *writeLane) drain() []WriteRequest {
+	var pending []WriteRequest
+	for p := range l.queues {
+		pending = append(pending, l.queues[p]...)
+		l.queues[p] = nil
+	}
+	return pending
 }
 
+// fail answers writes that will not be executed
+func (w *PrioritizedWriter) fail(pending []WriteRequest, err error) {
+	for _, req := range pending {
+		if req.batch != nil {
+			req.batch.failed(err)
+			continue
+		}
+		w.respond(req, WriteResponse{
+			ID:        req.ID,
+			MachineID: req.MachineID,
+			Symbol:    req.Symbol,
+			Error:     err.Error(),
+			Timestamp: time.Now(),
+		})
+	}
+}
+
+func priorityLevel(priority int) int {
+	if priority >= 8 {
+		return PriorityHigh
+	} else if priority >= 4 {
+		return PriorityMedium
+	}
+	return PriorityLow
+}
+
+// Submit queues req. It fails fast if the machine is unknown, the queue is
+// full or the writer is stopped. req.Timeout starts counting here.
 func (w *PrioritizedWriter) Submit(req WriteRequest) error {
-	var qIdx int
-	if req.Priority >= 8 {
-		qIdx = 0 // High
-	} else if req.Priority >= 4 {
-		qIdx = 1 // Medium
-	} else {
-		qIdx = 2 // Low
+	req.queuedAt = time.Now()
+	if req.Timeout > 0 {
+		req.deadline = req.queuedAt.Add(req.Timeout)
 	}
+	p := priorityLevel(req.Priority)
 
-	select {
-	case w.queues[qIdx] <- req:
-		return nil
-	default:
-		return fmt.Errorf("priority queue %d is full", qIdx)
+	// Checked before w.mu, the engine stops the writer holding its lock
+	if _, err := w.engine.getConnection(req.MachineID); err != nil {
+		return err
 	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stopped {
+		return ErrWriterStopped
+	}
+	lane, ok := w.lanes[req.MachineID]
+	if !ok {
+		lane = &writeLane{machineID: req.MachineID}
+		w.lanes[req.MachineID] = lane
+	}
+	if len(lane.queues[p]) >= w.config.QueueSizes[p] {
+		w.stats[p].Rejected++
+		return fmt.Errorf("%s priority queue of machine %s is full", priorityNames[p], req.MachineID)
+	}
+
+	lane.queues[p] = append(lane.queues[p], req)
+	w.stats[p].Submitted++
+	if !lane.running {
+		lane.running = true
+		w.running.Add(1)
+		go w.run(lane)
+	}
+	return nil
 }
 
-func (w *PrioritizedWriter) processor() {
+// run executes the writes of a lane until it is empty
+func (w *PrioritizedWriter) run(lane *writeLane) {
+	defer w.running.Done()
 	for {
-		select {
-		case <-w.stopChan:
+		w.mu.Lock()
+		p := w.next(lane)
+		if p < 0 || w.stopped {
+			lane.running = false
+			if p < 0 && w.lanes[lane.machineID] == lane {
+				delete(w.lanes, lane.machineID)
+			}
+			w.mu.Unlock()
 			return
-		default:
-			// Check priorities in strict order: High > Medium > Low
-			select {
-			case req := <-w.queues[0]:
-				w.execute(req)
-			case req := <-w.queues[1]:
-				w.execute(req)
-			case req := <-w.queues[2]:
-				w.execute(req)
-			case <-time.After(10 * time.Millisecond):
-				// No requests, idle loop
+		}
+		req := lane.queues[p][0]
+		lane.queues[p][0] = WriteRequest{}
+		lane.queues[p] = lane.queues[p][1:]
+
+		wait := time.Since(req.queuedAt)
+		w.stats[p].Executed++
+		w.waited[p] += wait
+		if wait > w.stats[p].MaxWait {
+			w.stats[p].MaxWait = wait
+		}
+		w.mu.Unlock()
+
+		if req.batch != nil {
+			w.executeBatch(req)
+		} else {
+			w.execute(req)
+		}
+	}
+}
+
+// next picks the priority level to serve, or -1 if the lane is empty.
+// w.mu must be held.
+func (w *PrioritizedWriter) next(lane *writeLane) int {
+	if w.config.Scheduling != SchedulingWeighted {
+		for p := range lane.queues {
+			if len(lane.queues[p]) > 0 {
+				return p
 			}
 		}
+		return -1
+	}
+
+	// Smooth weighted round robin over the non-empty levels
+	best, total := -1, 0
+	for p := range lane.queues {
+		if len(lane.queues[p]) == 0 {
+			continue
+		}
+		lane.current[p] += w.config.Weights[p]
+		total += w.config.Weights[p]
+		if best < 0 || lane.current[p] > lane.current[best] {
+			best = p
+		}
+	}
+	if best >= 0 {
+		lane.current[best] -= total
+	}
+	return best
+}
+
+// Stats returns the queue metrics per priority level, highest first
+func (w *PrioritizedWriter) Stats() []WriteQueueStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	out := make([]WriteQueueStats, numPriorities)
+	for p := range out {
+		st := w.stats[p]
+		st.Priority = priorityNames[p]
+		st.Capacity = w.config.QueueSizes[p]
+		for _, lane := range w.lanes {
+			st.Depth += len(lane.queues[p])
+		}
+		if st.Executed > 0 {
+			st.AvgWait = w.waited[p] / time.Duration(st.Executed)
+		}
+		out[p] = st
+	}
+	return out
+}
+
+// requestContext returns the context bounding a request by its deadline
+func requestContext(req WriteRequest) (context.Context, context.CancelFunc) {
+	if req.deadline.IsZero() {
+		return context.WithCancel(context.Background())
 	}
+	return context.WithDeadline(context.Background(), req.deadline)
 }
 
 func (w *PrioritizedWriter) execute(req WriteRequest) {
+	ctx, cancel := requestContext(req)
+	defer cancel()
+
+	start := time.Now()
 	resp := WriteResponse{
 		ID:        req.ID,
-		Timestamp: time.Now(),
+		MachineID: req.MachineID,
+		Symbol:    req.Symbol,
+		Timestamp: start,
+	}
+	recorder := w.engine.writeRecorder()
+	rec := WriteRecord{
+		RequestID:      req.ID,
+		UserID:         req.UserID,
+		MachineID:      req.MachineID,
+		Symbol:         req.Symbol,
+		RequestedValue: req.Value,
+		Timestamp:      start,
 	}
 
-	// 1. Execute Write
-	err := w.engine.WriteSymbol(req.MachineID, req.Symbol, req.Value)
-	if err != nil {
+	// 1. Allowlist, type and range checks
+	req, rej := w.engine.CheckWrite(req)
+	rec.RequestedValue = req.Value
+	if rej != nil {
+		resp.Success = false
+		resp.Error = rej.Error()
+		resp.Rejection = rej
+		rec.RejectReason = rej.Reason
+	} else if ctx.Err() != nil {
+		// Timed out while queued
 		resp.Success = false
-		resp.Error = err.Error()
+		resp.Error = fmt.Sprintf("write not sent: %v", ctx.Err())
 	} else {
-		// 2. Read-after-write verification (if enabled)
-		if req.RequireAck {
-			time.Sleep(20 * time.Millisecond) // Wait for PLC cycle
-			val, rErr := w.engine.ReadSymbol(req.MachineID, req.Symbol)
-			if rErr != nil {
+		// 2. Snapshot the previous value for the journal
+		if recorder != nil {
+			if prev, err := w.engine.ReadSymbolCtx(ctx, req.MachineID, req.Symbol); err == nil {
+				rec.PreviousValue = prev.Value
+			}
+		}
+
+		// 3. Execute Write, repeated while verification fails
+		policy := w.engine.VerifyPolicy.withDefaults()
+		for attempt := 1; ; attempt++ {
+			if err := w.engine.WriteSymbolCtx(ctx, req.MachineID, req.Symbol, req.Value); err != nil {
 				resp.Success = false
-				resp.Error = "verification read failed: " + rErr.Error()
-			} else {
-				// Simple equality check (could be improved with type-specific comparison)
-				if fmt.Sprintf("%v", val.Value) == fmt.Sprintf("%v", req.Value) {
-					resp.Success = true
-				} else {
-					resp.Success = false
-					resp.Error = fmt.Sprintf("verification failed: expected %v, got %v", req.Value, val.Value)
+				resp.Error = err.Error()
+				break
+			}
+			if !req.RequireAck {
+				resp.Success = true
+				if recorder != nil {
+					if val, err := w.engine.ReadSymbolCtx(ctx, req.MachineID, req.Symbol); err == nil {
+						rec.ReadbackValue = val.Value
+					}
 				}
+				break
+			}
+
+			// 4. Read-after-write verification (if enabled)
+			v := w.verify(ctx, req, policy)
+			v.Attempts = attempt
+			resp.Verification = v
+			rec.ReadbackValue = v.Actual
+			resp.Success = v.Verified
+			switch {
+			case v.Verified:
+				resp.Error = ""
+			case v.Error != "":
+				resp.Error = "verification read failed: " + v.Error
+			default:
+				resp.Error = fmt.Sprintf("verification failed: expected %v, got %v", req.Value, v.Actual)
+			}
+			if v.Verified || attempt > policy.Retries || ctx.Err() != nil {
+				break
 			}
-		} else {
-			resp.Success = true
 		}
 	}
 
-	// 3. Notify caller
+	// 5. Journal the attempt
+	if recorder != nil {
+		rec.Success = resp.Success
+		rec.Error = resp.Error
+		rec.Latency = time.Since(start)
+		recorder.RecordWrite(rec)
+	}
+
+	// 6. Notify caller
+	w.respond(req, resp)
+}
+
+// respond delivers resp to the caller and the engine confirm channel
+func (w *PrioritizedWriter) respond(req WriteRequest, resp WriteResponse) {
 	if req.ResponseChan != nil {
 		select {
 		case req.ResponseChan <- resp:
@@ -116,7 +432,7 @@ func (w *PrioritizedWriter) execute(req WriteRequest) {
 		}
 	}
 
-	// Also send to global engine confirm channel (if defined)
+	// Also publish to the engine's write subscribers
 	select {
 	case w.engine.writeConfirm <- resp:
 	default:
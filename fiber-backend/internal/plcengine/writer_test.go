@@ -0,0 +1,196 @@
+package plcengine
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type chanRecorder chan WriteRecord
+
+func (c chanRecorder) RecordWrite(rec WriteRecord) { c <- rec }
+
+func TestWriter_RecordsWrites(t *testing.T) {
+	e, r := startGuardedEngine(t)
+	records := make(chanRecorder, 10)
+	e.SetWriteRecorder(records)
+
+	resp := <-e.WriteAsync(WriteRequest{ID: "w1", UserID: "u1", MachineID: "m1", Symbol: "GVL.Temperature", Value: 55, Priority: 9})
+	require.True(t, resp.Success, resp.Error)
+
+	rec := <-records
+	assert.Equal(t, "w1", rec.RequestID)
+	assert.Equal(t, "u1", rec.UserID)
+	assert.Equal(t, "m1", rec.MachineID)
+	assert.Equal(t, "GVL.Temperature", rec.Symbol)
+	assert.Equal(t, float32(21.5), rec.PreviousValue)
+	assert.Equal(t, float32(55), rec.RequestedValue)
+	assert.Equal(t, float32(55), rec.ReadbackValue)
+	assert.True(t, rec.Success)
+	assert.Positive(t, rec.Latency)
+	assert.WithinDuration(t, time.Now(), rec.Timestamp, time.Second)
+
+	// Rejected writes are journaled without touching the PLC
+	resp = <-e.WriteAsync(WriteRequest{ID: "w2", UserID: "u1", MachineID: "m1", Symbol: "GVL.Temperature", Value: 500, Priority: 9})
+	require.NotNil(t, resp.Rejection)
+
+	rec = <-records
+	assert.Equal(t, "w2", rec.RequestID)
+	assert.False(t, rec.Success)
+	assert.Equal(t, RejectOutOfRange, rec.RejectReason)
+	assert.Equal(t, resp.Error, rec.Error)
+	assert.Nil(t, rec.PreviousValue)
+	assert.Nil(t, rec.ReadbackValue)
+	assert.Equal(t, real32(55), r.value("GVL.Temperature"))
+}
+
+// gateClient logs written values and holds writes to GVL.Block until released
+type gateClient struct {
+	*MockADSClient
+	release chan struct{}
+	mu      sync.Mutex
+	log     []interface{}
+}
+
+func (c *gateClient) WriteSymbol(name string, value interface{}) error {
+	if name == "GVL.Block" {
+		<-c.release
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.log = append(c.log, value)
+	return nil
+}
+
+func (c *gateClient) written() []interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]interface{}(nil), c.log...)
+}
+
+func startGateEngine(t *testing.T, cfg WriterConfig) (*PLCReadWriteEngine, map[string]*gateClient) {
+	clients := map[string]*gateClient{}
+	var configs []MachineConfig
+	for _, id := range []string{"m1", "m2"} {
+		clients[id] = &gateClient{MockADSClient: NewMockADSClient(id), release: make(chan struct{})}
+		configs = append(configs, MachineConfig{ID: id, IP: id, Symbols: []SymbolInfo{
+			{Name: "GVL.Block", IsWritable: true},
+			{Name: "GVL.Value", IsWritable: true},
+		}})
+	}
+
+	e := NewEngine(make(chan PLCValue, 10))
+	e.ClientFactory = func(ip, amsID string, port int) (ADSClient, error) { return clients[ip], nil }
+	e.WriterConfig = cfg
+	require.NoError(t, e.Start(configs))
+	t.Cleanup(func() { e.Stop() })
+	require.Eventually(t, func() bool {
+		return len(e.GetStatus()) == 2 && e.GetStatus()["m1"].Connected && e.GetStatus()["m2"].Connected
+	},
+		time.Second, 5*time.Millisecond)
+	return e, clients
+}
+
+// queueWrites blocks machine m1 and queues the given values behind the
+// blocking write. Values starting with "H" are high priority.
+func queueWrites(t *testing.T, e *PLCReadWriteEngine, values ...string) {
+	require.NoError(t, e.SubmitWrite(WriteRequest{MachineID: "m1", Symbol: "GVL.Block", Value: "block", Priority: 0}))
+	require.Eventually(t, func() bool { return e.WriteQueueStats()[PriorityLow].Depth == 0 }, time.Second, time.Millisecond)
+	for _, v := range values {
+		priority := 0
+		if v[0] == 'H' {
+			priority = 9
+		}
+		require.NoError(t, e.SubmitWrite(WriteRequest{MachineID: "m1", Symbol: "GVL.Value", Value: v, Priority: priority}))
+	}
+}
+
+func TestWriter_StrictPriority(t *testing.T) {
+	e, clients := startGateEngine(t, WriterConfig{})
+	queueWrites(t, e, "L1", "L2", "H1", "L3", "H2")
+
+	close(clients["m1"].release)
+	require.Eventually(t, func() bool { return len(clients["m1"].written()) == 6 }, time.Second, time.Millisecond)
+	assert.Equal(t, []interface{}{"block", "H1", "H2", "L1", "L2", "L3"}, clients["m1"].written())
+}
+
+func TestWriter_WeightedFair(t *testing.T) {
+	e, clients := startGateEngine(t, WriterConfig{Scheduling: SchedulingWeighted, Weights: [3]int{2, 1, 1}})
+	queueWrites(t, e, "H1", "H2", "H3", "H4", "L1", "L2", "L3", "L4")
+
+	close(clients["m1"].release)
+	require.Eventually(t, func() bool { return len(clients["m1"].written()) == 9 }, time.Second, time.Millisecond)
+	assert.Equal(t, []interface{}{"block", "H1", "L1", "H2", "H3", "L2", "H4", "L3", "L4"}, clients["m1"].written())
+}
+
+func TestWriter_MachinesRunInParallel(t *testing.T) {
+	e, clients := startGateEngine(t, WriterConfig{QueueSizes: [3]int{1, 1, 1}})
+	queueWrites(t, e, "H1")
+
+	// m1 is stuck on a slow write, m2 is not affected
+	resp := <-e.WriteAsync(WriteRequest{MachineID: "m2", Symbol: "GVL.Value", Value: "m2", Priority: 9})
+	assert.True(t, resp.Success, resp.Error)
+
+	err := e.SubmitWrite(WriteRequest{MachineID: "m1", Symbol: "GVL.Value", Value: "H2", Priority: 9})
+	assert.EqualError(t, err, "high priority queue of machine m1 is full")
+
+	stats := e.WriteQueueStats()
+	require.Len(t, stats, 3)
+	high := stats[PriorityHigh]
+	assert.Equal(t, "high", high.Priority)
+	assert.Equal(t, 1, high.Depth)
+	assert.Equal(t, 1, high.Capacity)
+	assert.Equal(t, uint64(2), high.Submitted)
+	assert.Equal(t, uint64(1), high.Executed)
+	assert.Equal(t, uint64(1), high.Rejected)
+	assert.Equal(t, uint64(1), stats[PriorityLow].Executed)
+
+	close(clients["m1"].release)
+	require.Eventually(t, func() bool { return e.WriteQueueStats()[PriorityHigh].Depth == 0 }, time.Second, time.Millisecond)
+}
+
+func TestWriter_Lanes(t *testing.T) {
+	e, clients := startGateEngine(t, WriterConfig{})
+
+	err := e.SubmitWrite(WriteRequest{MachineID: "m3", Symbol: "GVL.Value", Value: "x"})
+	assert.EqualError(t, err, "machine m3 not found")
+
+	resp := <-e.WriteAsync(WriteRequest{MachineID: "m2", Symbol: "GVL.Value", Value: "m2"})
+	assert.True(t, resp.Success, resp.Error)
+
+	// queued writes of a removed machine fail, its lane is dropped
+	queueWrites(t, e, "L1")
+	queued := e.WriteAsync(WriteRequest{MachineID: "m1", Symbol: "GVL.Value", Value: "L2"})
+	require.NoError(t, e.RemoveMachine("m1"))
+	resp = <-queued
+	assert.False(t, resp.Success)
+	assert.Equal(t, ErrMachineRemoved.Error(), resp.Error)
+	close(clients["m1"].release)
+
+	require.Eventually(t, func() bool {
+		e.writer.mu.Lock()
+		defer e.writer.mu.Unlock()
+		return len(e.writer.lanes) == 0
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 0, e.WriteQueueStats()[PriorityLow].Depth)
+}
+
+func TestWriter_StopWaitsForExecutingWrites(t *testing.T) {
+	e, clients := startGateEngine(t, WriterConfig{})
+	records := make(chanRecorder, 10)
+	e.SetWriteRecorder(records)
+	queueWrites(t, e, "L1")
+
+	// the blocked write fails on the stopped connection and is recorded
+	// before Stop returns, the queued one is failed without being executed
+	require.NoError(t, e.Stop())
+	close(clients["m1"].release)
+	require.Len(t, records, 1)
+	rec := <-records
+	assert.Equal(t, "GVL.Block", rec.Symbol)
+	assert.False(t, rec.Success)
+	assert.Equal(t, ErrConnectionStopped.Error(), rec.Error)
+}
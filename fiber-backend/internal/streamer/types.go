@@ -13,6 +13,16 @@ const (
 	MsgTypeUnsub     MessageType = "unsubscribe"
 	MsgTypeHistory   MessageType = "history"
 	MsgTypeError     MessageType = "error"
+
+	// MsgTypeConnection reports a PLC connection state change
+	MsgTypeConnection MessageType = "connection"
+
+	// MsgTypeOverrun reports a chamber read group whose batch read took
+	// longer than its scan interval
+	MsgTypeOverrun MessageType = "overrun"
+
+	// MsgTypeSpool warns that the Kafka disk spool is close to its cap
+	MsgTypeSpool MessageType = "spool"
 )
 
 // BroadcastMsg is the JSON packet sent to browser clients
@@ -23,6 +33,21 @@ type BroadcastMsg struct {
 	Data      map[string]interface{} `json:"data,omitempty"`
 	Timestamp time.Time              `json:"timestamp"`
 	Error     string                 `json:"error,omitempty"`
+
+	// Quality of every symbol in Data, dashboards grey out values that are
+	// not good
+	Quality map[string]SymbolQuality `json:"quality,omitempty"`
+
+	// Tags of the values in Data: process_job, substrate_id, recipe_id and
+	// step while a recipe runs
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// SymbolQuality is the OPC style quality of one symbol in a data message:
+// good, uncertain, bad or substituted, with the reason if not good
+type SymbolQuality struct {
+	Quality string `json:"quality"`
+	Reason  string `json:"reason,omitempty"`
 }
 
 // ClientMessage is the JSON packet received from browser clients
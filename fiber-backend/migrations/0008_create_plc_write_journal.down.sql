@@ -0,0 +1 @@
+DROP TABLE IF EXISTS plc_write_journal;
@@ -0,0 +1,19 @@
+-- Journal of every PLC write attempt, including writes rejected by a guard
+CREATE TABLE IF NOT EXISTS plc_write_journal (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    request_id TEXT,
+    user_id TEXT,
+    machine_id TEXT NOT NULL,
+    symbol TEXT NOT NULL,
+    previous_value JSONB,
+    requested_value JSONB,
+    readback_value JSONB,
+    success BOOLEAN NOT NULL,
+    error_message TEXT,
+    reject_reason VARCHAR(50),
+    latency_ms DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_plc_write_journal_machine_symbol ON plc_write_journal(machine_id, symbol, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_plc_write_journal_created_at ON plc_write_journal(created_at DESC);
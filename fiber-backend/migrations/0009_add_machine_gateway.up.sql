@@ -0,0 +1,2 @@
+-- AMS router of controllers that are reached through another TwinCAT system
+ALTER TABLE machines ADD COLUMN IF NOT EXISTS gateway TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE symbols DROP COLUMN IF EXISTS max_value;
+ALTER TABLE symbols DROP COLUMN IF EXISTS min_value;
@@ -0,0 +1,3 @@
+-- Valid range of a symbol, values outside are collected with bad quality
+ALTER TABLE symbols ADD COLUMN IF NOT EXISTS min_value DOUBLE PRECISION;
+ALTER TABLE symbols ADD COLUMN IF NOT EXISTS max_value DOUBLE PRECISION;
@@ -0,0 +1 @@
+ALTER TABLE machines DROP COLUMN IF EXISTS protocol;
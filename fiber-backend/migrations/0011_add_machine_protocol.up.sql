@@ -0,0 +1,2 @@
+-- Driver of the machine: TwinCAT ADS or OPC UA
+ALTER TABLE machines ADD COLUMN IF NOT EXISTS protocol TEXT NOT NULL DEFAULT 'ads';
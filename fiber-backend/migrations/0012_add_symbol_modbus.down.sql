@@ -0,0 +1 @@
+ALTER TABLE symbols DROP COLUMN IF EXISTS modbus;
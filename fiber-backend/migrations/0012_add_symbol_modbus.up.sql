@@ -0,0 +1,2 @@
+-- Modbus register map of a symbol: table, address, type, word order and scaling
+ALTER TABLE symbols ADD COLUMN IF NOT EXISTS modbus JSONB;
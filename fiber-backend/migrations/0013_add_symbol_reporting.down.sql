@@ -0,0 +1,4 @@
+ALTER TABLE symbols DROP COLUMN IF EXISTS heartbeat_ms;
+ALTER TABLE symbols DROP COLUMN IF EXISTS change_only;
+ALTER TABLE symbols DROP COLUMN IF EXISTS deadband_percent;
+ALTER TABLE symbols DROP COLUMN IF EXISTS deadband;
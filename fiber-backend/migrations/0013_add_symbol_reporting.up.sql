@@ -0,0 +1,5 @@
+-- Reporting of polled values: deadband, change-only and heartbeat
+ALTER TABLE symbols ADD COLUMN IF NOT EXISTS deadband DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE symbols ADD COLUMN IF NOT EXISTS deadband_percent DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE symbols ADD COLUMN IF NOT EXISTS change_only BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE symbols ADD COLUMN IF NOT EXISTS heartbeat_ms INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+ALTER TABLE symbols DROP COLUMN IF EXISTS scan_class;
+ALTER TABLE symbols DROP COLUMN IF EXISTS scan_rate_ms;
+ALTER TABLE chambers DROP COLUMN IF EXISTS scan_class;
+ALTER TABLE chambers DROP COLUMN IF EXISTS scan_rate_ms;
@@ -0,0 +1,5 @@
+-- Scan rate of chambers and symbols: an interval in ms or a class (fast, medium, slow)
+ALTER TABLE chambers ADD COLUMN IF NOT EXISTS scan_rate_ms INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chambers ADD COLUMN IF NOT EXISTS scan_class TEXT NOT NULL DEFAULT '';
+ALTER TABLE symbols ADD COLUMN IF NOT EXISTS scan_rate_ms INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE symbols ADD COLUMN IF NOT EXISTS scan_class TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Direct InfluxDB writes of the collected values, enabled per machine
+ALTER TABLE machines ADD COLUMN IF NOT EXISTS influx_enabled BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,18 @@
+-- Recipe runs tracked by the collector, end_time is NULL while a run is active
+CREATE TABLE IF NOT EXISTS recipe_runs (
+    id UUID PRIMARY KEY,
+    machine_id TEXT NOT NULL,
+    chamber_id TEXT NOT NULL,
+    recipe_id TEXT NOT NULL DEFAULT '',
+    process_job TEXT NOT NULL DEFAULT '',
+    substrate_id TEXT NOT NULL DEFAULT '',
+    status VARCHAR(50) NOT NULL,
+    start_time TIMESTAMPTZ NOT NULL,
+    end_time TIMESTAMPTZ,
+    fields JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_recipe_runs_chamber ON recipe_runs(machine_id, chamber_id, start_time DESC);
+CREATE INDEX IF NOT EXISTS idx_recipe_runs_start_time ON recipe_runs(start_time DESC);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_recipe_runs_substrate;
+DROP INDEX IF EXISTS idx_recipe_runs_recipe;
@@ -0,0 +1,3 @@
+-- Run history is browsed by recipe and by substrate
+CREATE INDEX IF NOT EXISTS idx_recipe_runs_recipe ON recipe_runs(recipe_id, start_time DESC);
+CREATE INDEX IF NOT EXISTS idx_recipe_runs_substrate ON recipe_runs(substrate_id, start_time DESC);
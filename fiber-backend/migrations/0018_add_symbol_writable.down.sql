@@ -0,0 +1,3 @@
+ALTER TABLE symbols DROP COLUMN IF EXISTS write_max;
+ALTER TABLE symbols DROP COLUMN IF EXISTS write_min;
+ALTER TABLE symbols DROP COLUMN IF EXISTS writable;
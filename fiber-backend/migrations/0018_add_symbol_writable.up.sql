@@ -0,0 +1,4 @@
+-- Write allowlist of a machine: writable symbols and their write bounds
+ALTER TABLE symbols ADD COLUMN IF NOT EXISTS writable BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE symbols ADD COLUMN IF NOT EXISTS write_min DOUBLE PRECISION;
+ALTER TABLE symbols ADD COLUMN IF NOT EXISTS write_max DOUBLE PRECISION;